@@ -1,32 +1,61 @@
 module github.com/tkellen/aevitas
 
-go 1.14
+go 1.20
 
 require (
-	github.com/Masterminds/goutils v1.1.0 // indirect
-	github.com/Masterminds/semver v1.5.0 // indirect
+	github.com/BurntSushi/toml v1.3.2
 	github.com/Masterminds/sprig v2.22.0+incompatible
+	github.com/alecthomas/chroma/v2 v2.2.0
 	github.com/alecthomas/kong v0.2.11
+	github.com/allegro/bigcache v1.2.1
+	github.com/bmatcuk/doublestar/v4 v4.6.1
 	github.com/disintegration/gift v1.2.1
-	github.com/fastly/go-utils v0.0.0-20180712184237-d95a45783239 // indirect
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/ghodss/yaml v1.0.0
 	github.com/go-git/go-billy/v5 v5.0.0
-	github.com/google/uuid v1.1.1 // indirect
-	github.com/huandu/xstrings v1.3.2 // indirect
-	github.com/imdario/mergo v0.3.10 // indirect
-	github.com/jehiah/go-strftime v0.0.0-20171201141054-1d33003b3869 // indirect
-	github.com/json-iterator/go v1.1.6
+	github.com/json-iterator/go v1.1.10
 	github.com/lestrrat-go/strftime v1.0.3
+	github.com/minio/minio-go/v7 v7.0.5
 	github.com/minio/sha256-simd v0.1.1
-	github.com/mitchellh/copystructure v1.0.0 // indirect
-	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
-	github.com/modern-go/reflect2 v1.0.1 // indirect
+	github.com/mitchellh/copystructure v1.0.0
 	github.com/pixiv/go-libjpeg v0.0.0-20190822045933-3da21a74767d
-	github.com/stretchr/testify v1.5.1 // indirect
-	github.com/tebeka/strftime v0.1.5 // indirect
+	github.com/tdewolff/minify/v2 v2.7.6
+	github.com/tidwall/gjson v1.6.0
+	github.com/tidwall/pretty v1.0.1
 	github.com/tidwall/sjson v1.1.1
 	github.com/vbauerster/mpb/v5 v5.2.4
-	golang.org/x/crypto v0.0.0-20200728195943-123391ffb6de // indirect
+	go.uber.org/goleak v1.3.0
 	golang.org/x/sync v0.0.0-20200317015054-43a5402ce75a
 	moul.io/number-to-words v0.6.0
 )
+
+require (
+	github.com/Masterminds/goutils v1.1.0 // indirect
+	github.com/Masterminds/semver v1.5.0 // indirect
+	github.com/VividCortex/ewma v1.1.1 // indirect
+	github.com/acarl005/stripansi v0.0.0-20180116102854-5a71ef0e047d // indirect
+	github.com/dlclark/regexp2 v1.4.0 // indirect
+	github.com/fastly/go-utils v0.0.0-20180712184237-d95a45783239 // indirect
+	github.com/google/uuid v1.1.1 // indirect
+	github.com/huandu/xstrings v1.3.2 // indirect
+	github.com/imdario/mergo v0.3.10 // indirect
+	github.com/jehiah/go-strftime v0.0.0-20171201141054-1d33003b3869 // indirect
+	github.com/klauspost/cpuid v1.3.1 // indirect
+	github.com/mattn/go-runewidth v0.0.9 // indirect
+	github.com/minio/md5-simd v1.1.0 // indirect
+	github.com/mitchellh/go-homedir v1.1.0 // indirect
+	github.com/mitchellh/reflectwalk v1.0.0 // indirect
+	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
+	github.com/modern-go/reflect2 v1.0.2 // indirect
+	github.com/pkg/errors v0.8.1 // indirect
+	github.com/rs/xid v1.2.1 // indirect
+	github.com/tdewolff/parse/v2 v2.4.3 // indirect
+	github.com/tebeka/strftime v0.1.5 // indirect
+	github.com/tidwall/match v1.0.1 // indirect
+	golang.org/x/crypto v0.0.0-20200728195943-123391ffb6de // indirect
+	golang.org/x/net v0.0.0-20200707034311-ab3426394381 // indirect
+	golang.org/x/sys v0.0.0-20200625212154-ddb9806d33ae // indirect
+	golang.org/x/text v0.3.3 // indirect
+	gopkg.in/ini.v1 v1.57.0 // indirect
+	gopkg.in/yaml.v2 v2.3.0 // indirect
+)
@@ -0,0 +1,124 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/alecthomas/kong"
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"sort"
+	"strings"
+)
+
+type CompletionCmd struct {
+	Bash   CompletionBashCmd   `cmd:"" help:"Write a bash completion script. Load it with: source <(aevitas completion bash)"`
+	Values CompletionValuesCmd `cmd:"" help:"List the selectors of loaded manifests, one per line."`
+}
+
+type CompletionBashCmd struct{}
+
+type CompletionValuesCmd struct {
+	Load   []string `name:"load" short:"l" type:"existingdir" help:"Directory containing manifests."`
+	Glob   []string `name:"glob" sep:"none" help:"Load manifests from files matching this pattern."`
+	Prefix string   `name:"prefix" help:"Only list selectors starting with this."`
+}
+
+// bashCompletion completes the flags of render and its selectors from the
+// manifests in the directories and globs supplied with --load and --glob.
+// Arguments to flags fall back to completing file names. Standard in is closed
+// so manifests are never read from it. The flags are filled in from the
+// command line model by Run.
+const bashCompletion = `_aevitas() {
+  local cur="${COMP_WORDS[COMP_CWORD]}" loads=() i
+  [[ " ${COMP_WORDS[*]} " == *" render "* ]] || return
+  if [[ "$cur" == -* ]]; then
+    COMPREPLY=($(compgen -W "%s" -- "$cur"))
+    return
+  fi
+  case "${COMP_WORDS[COMP_CWORD-1]}" in
+    %s) return ;;
+  esac
+  for ((i = 1; i < COMP_CWORD; i++)); do
+    case "${COMP_WORDS[i]}" in
+      -l|--load|--glob) loads+=("${COMP_WORDS[i]}" "${COMP_WORDS[i+1]}") ;;
+      --load=*|--glob=*) loads+=("${COMP_WORDS[i]}") ;;
+    esac
+  done
+  COMPREPLY=($(aevitas completion values "${loads[@]}" --prefix "$cur" </dev/null 2>/dev/null))
+}
+complete -o default -F _aevitas aevitas
+`
+
+// Run writes the bash completion script.
+func (cb *CompletionBashCmd) Run(ctx *Context, cli *kong.Context) error {
+	var render *kong.Node
+	for _, node := range cli.Model.Children {
+		if node.Name == "render" {
+			render = node
+		}
+	}
+	if render == nil {
+		return fmt.Errorf("no render command to complete")
+	}
+	flags, valueFlags := completionFlags(render)
+	_, err := fmt.Fprintf(ctx.Logger.Stdout.Writer(), bashCompletion, strings.Join(flags, " "), strings.Join(valueFlags, "|"))
+	return err
+}
+
+// completionFlags lists every flag of the command and, separately, those of
+// them which take a value.
+func completionFlags(node *kong.Node) ([]string, []string) {
+	var flags, valueFlags []string
+	for _, group := range node.AllFlags(true) {
+		for _, flag := range group {
+			names := []string{"--" + flag.Name}
+			if flag.Short != 0 {
+				names = append(names, "-"+string(flag.Short))
+			}
+			flags = append(flags, names...)
+			if !flag.IsBool() {
+				valueFlags = append(valueFlags, names...)
+			}
+		}
+	}
+	return flags, valueFlags
+}
+
+// Run lists the selectors that can be rendered from the loaded manifests.
+func (cv *CompletionValuesCmd) Run(ctx *Context) error {
+	manifests, loadErr := loadManifests(ctx, cv.Load, nil)
+	if loadErr != nil {
+		return loadErr
+	}
+	if len(cv.Glob) > 0 {
+		matched, err := manifest.NewFromGlob(cv.Glob, nil)
+		if err != nil {
+			return err
+		}
+		manifests = append(manifests, matched...)
+	}
+	index := manifest.NewIndex()
+	if err := index.Insert(manifests...); err != nil {
+		return err
+	}
+	for _, id := range (&selectorPredictor{index}).Predict(cv.Prefix) {
+		ctx.Logger.Stdout.Println(id)
+	}
+	return nil
+}
+
+// selectorPredictor completes selectors from an index.
+type selectorPredictor struct {
+	index *manifest.Index
+}
+
+// Predict returns the sorted selector IDs of every live manifest starting with
+// prefix.
+func (sp *selectorPredictor) Predict(prefix string) []string {
+	var ids []string
+	for _, m := range sp.index.Manifests() {
+		if id := m.Selector.ID(); m.IsLive() && strings.HasPrefix(id, prefix) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
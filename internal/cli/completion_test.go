@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"github.com/tkellen/aevitas/internal/testhelper"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSelectorPredictor_Predict(t *testing.T) {
+	index := testhelper.MakeIndex(t,
+		testhelper.MakeManifest(t, "website/content/v1/post/two", "", ""),
+		testhelper.MakeManifest(t, "website/content/v1/post/one", "", ""),
+		testhelper.MakeManifest(t, "website/content/v1/page/index", "", ""),
+	)
+	predictor := &selectorPredictor{index}
+	table := map[string]struct {
+		prefix   string
+		expected []string
+	}{
+		"everything": {
+			expected: []string{"website/content/v1/page/index", "website/content/v1/post/one", "website/content/v1/post/two"},
+		},
+		"partial input": {
+			prefix:   "website/content/v1/post/",
+			expected: []string{"website/content/v1/post/one", "website/content/v1/post/two"},
+		},
+		"no matches": {prefix: "html/", expected: nil},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			if actual := predictor.Predict(test.prefix); !reflect.DeepEqual(actual, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestCompletionCmd_Run(t *testing.T) {
+	output := run(t, "test completion values -l ../../testdata/blog --prefix website/content/v1/post/")
+	if expected := "website/content/v1/post/one\nwebsite/content/v1/post/two\n"; output != expected {
+		t.Fatalf("expected %q, got %q", expected, output)
+	}
+	output = run(t, "test completion values --glob ../../testdata/blog/*.html --prefix website/content/v1/post/o")
+	if expected := "website/content/v1/post/one\n"; output != expected {
+		t.Fatalf("expected %q, got %q", expected, output)
+	}
+	output = run(t, "test completion bash")
+	if !strings.Contains(output, "complete -o default -F _aevitas aevitas") {
+		t.Fatalf("expected a bash completion script, got %s", output)
+	}
+	between := func(start, end string) []string {
+		from := strings.Index(output, start) + len(start)
+		return strings.FieldsFunc(output[from:from+strings.Index(output[from:], end)], func(r rune) bool { return r == ' ' || r == '|' })
+	}
+	flags, valueFlags := between(`compgen -W "`, `"`), between("-1]}\" in\n    ", ")")
+	for _, flag := range []string{"--output-tar", "--glob", "--s3-bucket", "--s3-deploy-prefix", "--webhook-url", "--webhook-secret", "--html-audit"} {
+		if !contains(flags, flag) {
+			t.Fatalf("expected %s to be completed, got %v", flag, flags)
+		}
+	}
+	if !contains(valueFlags, "--webhook-secret") || contains(valueFlags, "--html-audit") {
+		t.Fatalf("expected only flags taking a value to complete file names, got %v", valueFlags)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, item := range values {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	json "github.com/json-iterator/go"
+	hash "github.com/minio/sha256-simd"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type DiffCmd struct {
+	Baseline string `required:"" name:"baseline" type:"existingdir" help:"Path to output of a previous render."`
+	Compare  string `required:"" name:"compare" type:"existingdir" help:"Path to output of a render to compare with the baseline."`
+	Format   string `name:"format" enum:"text,json,patch" default:"text" help:"Format of the diff (text, json or patch)."`
+}
+
+// Diff summarizes how the files of one render output differ from another.
+// Each entry is a slash separated path relative to the output root.
+type Diff struct {
+	Added     []string `json:"added"`
+	Deleted   []string `json:"deleted"`
+	Modified  []string `json:"modified"`
+	Unchanged []string `json:"unchanged"`
+}
+
+func (d *DiffCmd) Run(ctx *Context) error {
+	baseline, baselineErr := hashFiles(d.Baseline)
+	if baselineErr != nil {
+		return baselineErr
+	}
+	compare, compareErr := hashFiles(d.Compare)
+	if compareErr != nil {
+		return compareErr
+	}
+	diff := newDiff(baseline, compare)
+	switch d.Format {
+	case "json":
+		data, err := json.MarshalIndent(diff, "", "  ")
+		if err != nil {
+			return err
+		}
+		ctx.Logger.Stdout.Printf("%s", data)
+	case "patch":
+		patch, err := d.patch(diff)
+		if err != nil {
+			return err
+		}
+		ctx.Logger.Stdout.Print(patch)
+	default:
+		for _, group := range []struct {
+			label string
+			files []string
+		}{
+			{"added", diff.Added},
+			{"deleted", diff.Deleted},
+			{"modified", diff.Modified},
+			{"unchanged", diff.Unchanged},
+		} {
+			for _, file := range group.files {
+				ctx.Logger.Stdout.Printf("%-9s %s", group.label, file)
+			}
+		}
+	}
+	return nil
+}
+
+// patch produces a unified diff of every changed file. Each changed file is
+// represented by a single hunk replacing its entire content.
+func (d *DiffCmd) patch(diff *Diff) (string, error) {
+	changed := append(append(append([]string{}, diff.Deleted...), diff.Added...), diff.Modified...)
+	sort.Strings(changed)
+	var patch strings.Builder
+	for _, file := range changed {
+		before, beforeErr := readLines(filepath.Join(d.Baseline, filepath.FromSlash(file)))
+		if beforeErr != nil {
+			return "", beforeErr
+		}
+		after, afterErr := readLines(filepath.Join(d.Compare, filepath.FromSlash(file)))
+		if afterErr != nil {
+			return "", afterErr
+		}
+		fmt.Fprintf(&patch, "--- a/%s\n+++ b/%s\n", file, file)
+		fmt.Fprintf(&patch, "@@ -%s +%s @@\n", hunkRange(len(before)), hunkRange(len(after)))
+		for _, line := range before {
+			patch.WriteString("-" + line + "\n")
+		}
+		for _, line := range after {
+			patch.WriteString("+" + line + "\n")
+		}
+	}
+	return patch.String(), nil
+}
+
+// newDiff classifies files by comparing the content hashes of two renders.
+func newDiff(baseline map[string]string, compare map[string]string) *Diff {
+	diff := &Diff{
+		Added:     []string{},
+		Deleted:   []string{},
+		Modified:  []string{},
+		Unchanged: []string{},
+	}
+	for file, sum := range baseline {
+		compareSum, ok := compare[file]
+		switch {
+		case !ok:
+			diff.Deleted = append(diff.Deleted, file)
+		case sum != compareSum:
+			diff.Modified = append(diff.Modified, file)
+		default:
+			diff.Unchanged = append(diff.Unchanged, file)
+		}
+	}
+	for file := range compare {
+		if _, ok := baseline[file]; !ok {
+			diff.Added = append(diff.Added, file)
+		}
+	}
+	for _, files := range [][]string{diff.Added, diff.Deleted, diff.Modified, diff.Unchanged} {
+		sort.Strings(files)
+	}
+	return diff
+}
+
+// hashFiles computes the sha256 of every file beneath root, keyed by its
+// slash separated path relative to root.
+func hashFiles(root string) (map[string]string, error) {
+	sums := map[string]string{}
+	return sums, filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		content, readErr := ioutil.ReadFile(path)
+		if readErr != nil {
+			return readErr
+		}
+		rel, relErr := filepath.Rel(root, path)
+		if relErr != nil {
+			return relErr
+		}
+		digest := hash.Sum256(content)
+		sums[filepath.ToSlash(rel)] = hex.EncodeToString(digest[:])
+		return nil
+	})
+}
+
+// readLines reads a file as lines, treating a missing file as empty.
+func readLines(path string) ([]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	content = bytes.TrimSuffix(content, []byte("\n"))
+	if len(content) == 0 {
+		return nil, nil
+	}
+	return strings.Split(string(content), "\n"), nil
+}
+
+// hunkRange formats the line range of a hunk replacing an entire file.
+func hunkRange(lines int) string {
+	if lines == 0 {
+		return "0,0"
+	}
+	return fmt.Sprintf("1,%d", lines)
+}
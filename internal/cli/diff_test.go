@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"fmt"
+	json "github.com/json-iterator/go"
+	"github.com/tkellen/aevitas/internal/testhelper"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiffCmd_Run(t *testing.T) {
+	manifests := testhelper.CopyFixture(t, "../../testdata/blog")
+	render := "test render -a ../../testdata -l %s --cache-dir %s -o %s website/content/v1/domain/blog"
+	baseline := tempDir(t)
+	run(t, fmt.Sprintf(render, manifests, tempDir(t), baseline))
+	post := filepath.Join(manifests, "one.html")
+	content, readErr := ioutil.ReadFile(post)
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	changed := strings.Replace(string(content), "Post numero uno.", "Post number one.", 1)
+	if err := ioutil.WriteFile(post, []byte(changed), 0644); err != nil {
+		t.Fatal(err)
+	}
+	compare := tempDir(t)
+	run(t, fmt.Sprintf(render, manifests, tempDir(t), compare))
+	var diff Diff
+	output := run(t, fmt.Sprintf("test diff --baseline %s --compare %s --format json", baseline, compare))
+	if err := json.Unmarshal([]byte(output), &diff); err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Modified) != 1 || diff.Modified[0] != "2018/07/one.html" {
+		t.Fatalf("expected one modified file, got %v", diff.Modified)
+	}
+	if len(diff.Added) != 0 || len(diff.Deleted) != 0 || len(diff.Unchanged) != 2 {
+		t.Fatalf("expected only one change, got %+v", diff)
+	}
+	patch := run(t, fmt.Sprintf("test diff --baseline %s --compare %s --format patch", baseline, compare))
+	if !strings.Contains(patch, "--- a/2018/07/one.html") || !strings.Contains(patch, "+<p>Post number one.</p>") {
+		t.Fatalf("expected patch of modified file, got %s", patch)
+	}
+}
@@ -0,0 +1,89 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ghodss/yaml"
+	"github.com/tidwall/sjson"
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type ExportCmd struct {
+	Load      []string `name:"load" short:"l" type:"existingdir" help:"Directory containing manifests."`
+	OutputDir string   `required:"" name:"output-dir" short:"o" help:"Path for exported manifests."`
+	Format    string   `name:"format" enum:"json,yaml,md" default:"json" help:"Format of exported manifests (json, yaml or md)."`
+	Structure string   `name:"structure" enum:"flat,kgvn" default:"flat" help:"Layout of exported manifests (flat or kgvn)."`
+}
+
+func (e *ExportCmd) Run(ctx *Context) error {
+	manifests, loadErr := loadManifests(ctx, e.Load, nil)
+	if loadErr != nil {
+		return loadErr
+	}
+	for _, m := range manifests {
+		// Generated manifests are recreated by the manifest that generated
+		// them when the export is loaded.
+		if m.IsGenerated() {
+			continue
+		}
+		data, encodeErr := e.encode(m)
+		if encodeErr != nil {
+			return fmt.Errorf("%s: %w", m, encodeErr)
+		}
+		target := filepath.Join(e.OutputDir, e.path(m))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(target, data, 0644); err != nil {
+			return err
+		}
+		ctx.Logger.Verbose.Printf("exported %s to %s", m.Selector, target)
+	}
+	return nil
+}
+
+// path computes where a manifest should be written within the output dir.
+func (e *ExportCmd) path(m *manifest.Manifest) string {
+	ext := "." + e.Format
+	if e.Format == "yaml" {
+		// Manifests are only converted from yaml when they use this extension.
+		ext = ".yml"
+	}
+	if e.Structure == "kgvn" {
+		return filepath.FromSlash(m.Selector.ID()) + ext
+	}
+	return strings.ReplaceAll(m.Selector.ID(), "/", "_") + ext
+}
+
+// encode converts a manifest to the requested format.
+func (e *ExportCmd) encode(m *manifest.Manifest) ([]byte, error) {
+	data, err := m.JSON()
+	if err != nil {
+		return nil, err
+	}
+	switch e.Format {
+	case "yaml":
+		return yaml.JSONToYAML(data)
+	case "md":
+		for _, key := range []string{"body", "Body"} {
+			if data, err = sjson.DeleteBytes(data, key); err != nil {
+				return nil, err
+			}
+		}
+		frontmatter, err := yaml.JSONToYAML(data)
+		if err != nil {
+			return nil, err
+		}
+		var doc bytes.Buffer
+		doc.WriteString("---\n")
+		doc.Write(frontmatter)
+		doc.WriteString("---\n")
+		doc.WriteString(m.Body)
+		return doc.Bytes(), nil
+	}
+	return data, nil
+}
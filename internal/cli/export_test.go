@@ -0,0 +1,47 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"sort"
+	"testing"
+)
+
+func ids(t *testing.T, dirs ...string) []string {
+	manifests, err := manifest.NewFromDirs(dirs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result []string
+	for _, m := range manifests {
+		result = append(result, m.Selector.ID())
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestExportCmd_Run(t *testing.T) {
+	sources := []string{"../../example/website", "../../example/core"}
+	expected := ids(t, sources...)
+	for _, format := range []string{"json", "yaml", "md"} {
+		for _, structure := range []string{"flat", "kgvn"} {
+			format, structure := format, structure
+			t.Run(fmt.Sprintf("%s/%s", format, structure), func(t *testing.T) {
+				output := tempDir(t)
+				run(t, fmt.Sprintf(
+					"test export -l %s -l %s --format %s --structure %s -o %s",
+					sources[0], sources[1], format, structure, output,
+				))
+				actual := ids(t, output)
+				if len(expected) != len(actual) {
+					t.Fatalf("expected %d manifests, got %d", len(expected), len(actual))
+				}
+				for idx := range expected {
+					if expected[idx] != actual[idx] {
+						t.Fatalf("expected %s, got %s", expected[idx], actual[idx])
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package cli
+
+import (
+	"github.com/tkellen/aevitas/pkg/manifest"
+)
+
+type GraphCmd struct {
+	Load []string `name:"load" short:"l" type:"existingdir" help:"Directory containing manifests."`
+	KGVN string   `name:"kgvn" help:"Only graph the relations of this kind/group/version/namespace."`
+}
+
+// Run writes the relation graph of the loaded manifests in Graphviz DOT format.
+func (gc *GraphCmd) Run(ctx *Context) error {
+	manifests, loadErr := loadManifests(ctx, gc.Load, nil)
+	if loadErr != nil {
+		return loadErr
+	}
+	index := manifest.NewIndex()
+	if err := index.Insert(manifests...); err != nil {
+		return err
+	}
+	if err := index.CollateContext(ctx.Background); err != nil {
+		return err
+	}
+	if gc.KGVN != "" {
+		return index.WriteDOT(ctx.Logger.Stdout.Writer(), gc.KGVN)
+	}
+	return index.WriteFullDOT(ctx.Logger.Stdout.Writer())
+}
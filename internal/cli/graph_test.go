@@ -0,0 +1,25 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGraphCmd_Run(t *testing.T) {
+	output := run(t, "test graph -l ../../testdata/blog")
+	if !strings.HasPrefix(output, "digraph") {
+		t.Fatalf("expected a digraph, got %s", output)
+	}
+	for _, edge := range []string{
+		`"website/content/v1/domain/blog" -> "website/content/v1/post/one" [label="posts"];`,
+		`"website/content/v1/domain/blog" -> "website/content/v1/post/two" [label="posts"];`,
+	} {
+		if !strings.Contains(output, edge) {
+			t.Fatalf("expected %s in %s", edge, output)
+		}
+	}
+	output = run(t, "test graph -l ../../testdata/blog --kgvn html/template/v1/blog")
+	if strings.Contains(output, "->") {
+		t.Fatalf("expected no edges, got %s", output)
+	}
+}
@@ -3,6 +3,8 @@ package cli
 import (
 	"context"
 	"github.com/alecthomas/kong"
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"golang.org/x/sync/errgroup"
 	"io"
 	"io/ioutil"
 	"log"
@@ -13,8 +15,14 @@ import (
 )
 
 type Cli struct {
-	Debug  bool      `help:"Enable debug mode."`
-	Render RenderCmd `cmd help:"Render a target manifest."`
+	Debug      bool          `help:"Enable debug mode."`
+	Render     RenderCmd     `cmd:"" help:"Render target manifests."`
+	Export     ExportCmd     `cmd:"" help:"Export manifests to individual files."`
+	Tree       TreeCmd       `cmd:"" help:"Show the resource hierarchy of a target manifest."`
+	Diff       DiffCmd       `cmd:"" help:"Compare the output of two renders."`
+	Stats      StatsCmd      `cmd:"" help:"Report statistics about the last render."`
+	Graph      GraphCmd      `cmd:"" help:"Write the relation graph of manifests in Graphviz DOT format."`
+	Completion CompletionCmd `cmd:"" help:"Complete selectors in the shell."`
 }
 
 type Context struct {
@@ -28,7 +36,7 @@ func Run(args []string, stdin *os.File, stdout io.Writer, stderr io.Writer) int
 	logger := standardLogger(stdout, stderr)
 	background, cancel := context.WithCancel(context.Background())
 	// Start goroutine to capture user requesting early shutdown (CTRL+C).
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
@@ -70,6 +78,38 @@ func Run(args []string, stdin *os.File, stdout io.Writer, stderr io.Writer) int
 	return 0
 }
 
+// loadManifests collects manifests provided over standard in (when it is not a
+// terminal) and from all files in the supplied directories.
+func loadManifests(ctx *Context, dirs []string, bars map[string]func(count int, progress <-chan struct{})) ([]*manifest.Manifest, error) {
+	stat, _ := ctx.Stdin.Stat()
+	eg := errgroup.Group{}
+	collected := &manifest.SafeList{}
+	// Collect manifests provided over standard in.
+	if (stat.Mode() & os.ModeCharDevice) == 0 {
+		eg.Go(func() error {
+			list, err := manifest.NewFromReader(ctx.Stdin, bars["stdin"])
+			if err != nil {
+				return err
+			}
+			collected.Append(list...)
+			return nil
+		})
+	}
+	// Collect manifests in provided paths.
+	eg.Go(func() error {
+		list, err := manifest.NewFromDirs(dirs, bars["file"])
+		if err != nil {
+			return err
+		}
+		collected.Append(list...)
+		return nil
+	})
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	return collected.Slice(), nil
+}
+
 type Logger struct {
 	Stdout  *log.Logger
 	Stderr  *log.Logger
@@ -1,19 +1,191 @@
 package cli
 
 import (
+	"archive/tar"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	json "github.com/json-iterator/go"
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 )
 
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "aevitas-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func run(t *testing.T, command string) string {
+	stdin, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	var stdout bytes.Buffer
+	if code := Run(strings.Fields(command), stdin, &stdout, ioutil.Discard); code != 0 {
+		t.Fatalf("%s: exited with %d: %s", command, code, stdout.String())
+	}
+	return stdout.String()
+}
+
+func assertFiles(t *testing.T, root string, expected ...string) {
+	for _, file := range expected {
+		if _, err := os.Stat(filepath.Join(root, file)); err != nil {
+			t.Fatalf("expected %s in output: %s", file, err)
+		}
+	}
+}
+
 func Test_Run(t *testing.T) {
-	//cmd := strings.Fields("test render -a ../../example/asset -l ../../example/website -l ../../example/templates -o ../../build/ website/content/v1/domain/blog")
-	cmd := strings.Fields("test render -a ~/memorybox -l ../../../goingslowly/templates -l ../../../goingslowly/resources -o ../../../goingslowly/build/goingslowly.com website/content/v1/domain/goingslowly")
-	stdin, err := os.Open("../../../goingslowly/build/index")
+	output := tempDir(t)
+	run(t, fmt.Sprintf(
+		"test render -a ../../testdata -l ../../testdata/blog --cache-dir %s -o %s website/content/v1/domain/blog",
+		tempDir(t), output,
+	))
+	assertFiles(t, output, "index.html", "2018/07/one.html", "2018/08/two.html")
+}
+
+func Test_RunGlob(t *testing.T) {
+	output := tempDir(t)
+	run(t, fmt.Sprintf(
+		"test render -a ../../testdata --glob ../../testdata/blog/*.yml --glob ../../testdata/**/*.html --cache-dir %s -o %s website/content/v1/domain/blog",
+		tempDir(t), output,
+	))
+	assertFiles(t, output, "index.html", "2018/07/one.html", "2018/08/two.html")
+}
+
+func Test_RunMultipleSelectors(t *testing.T) {
+	output := tempDir(t)
+	run(t, fmt.Sprintf(
+		"test render -a ../../testdata -l ../../testdata/blog -l ../../testdata/portfolio --cache-dir %s -o %s website/content/v1/domain/blog website/content/v1/domain/portfolio",
+		tempDir(t), output,
+	))
+	assertFiles(t, filepath.Join(output, "blog"), "index.html", "2018/07/one.html", "2018/08/two.html")
+	assertFiles(t, filepath.Join(output, "portfolio"), "portfolio/index.html", "portfolio/alpha.html")
+}
+
+func Test_RunMergeOutput(t *testing.T) {
+	output := tempDir(t)
+	run(t, fmt.Sprintf(
+		"test render -a ../../testdata -l ../../testdata/blog -l ../../testdata/portfolio --merge-output --cache-dir %s -o %s website/content/v1/domain/blog website/content/v1/domain/portfolio",
+		tempDir(t), output,
+	))
+	assertFiles(t, output, "index.html", "2018/07/one.html", "portfolio/index.html", "portfolio/alpha.html")
+}
+
+func Test_RunOutputTar(t *testing.T) {
+	output := tempDir(t)
+	archive := filepath.Join(tempDir(t), "site.tar")
+	run(t, fmt.Sprintf(
+		"test render -a ../../testdata -l ../../testdata/blog -l ../../testdata/portfolio --output-tar %s --cache-dir %s -o %s website/content/v1/domain/blog website/content/v1/domain/portfolio",
+		archive, tempDir(t), output,
+	))
+	file, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	files := map[string]bool{}
+	reader := tar.NewReader(file)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			files[header.Name] = true
+		}
+	}
+	for _, name := range []string{"blog/index.html", "blog/2018/07/one.html", "portfolio/portfolio/alpha.html"} {
+		if !files[name] {
+			t.Fatalf("expected %s to be archived, got %v", name, files)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(output, "blog", "index.html")); !os.IsNotExist(err) {
+		t.Fatal("expected pages not to be written to the output path")
+	}
+}
+
+func Test_RunExportIndex(t *testing.T) {
+	output := tempDir(t)
+	export := filepath.Join(tempDir(t), "index.ndjson")
+	run(t, fmt.Sprintf(
+		"test render -a ../../testdata -l ../../testdata/blog --export-index %s --cache-dir %s -o %s website/content/v1/domain/blog",
+		export, tempDir(t), output,
+	))
+	file, err := os.Open(export)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if code := Run(cmd, stdin, os.Stdout, os.Stderr); code != 0 {
-		t.Fatalf("exited with %d", code)
+	defer file.Close()
+	manifests, readErr := manifest.NewFromReader(file, nil)
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	if len(manifests) != 4 {
+		t.Fatalf("expected 4 exported manifests, got %d", len(manifests))
+	}
+}
+
+func Test_RunWebhook(t *testing.T) {
+	var mu sync.Mutex
+	var bodies [][]byte
+	var signatures []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		bodies = append(bodies, body)
+		signatures = append(signatures, req.Header.Get("X-Aevitas-Signature"))
+		// Fail the first attempt to exercise retrying.
+		if len(bodies) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+	run(t, fmt.Sprintf(
+		"test render -a ../../testdata -l ../../testdata/blog --cache-dir %s -o %s --webhook-url %s --webhook-secret secret website/content/v1/domain/blog",
+		tempDir(t), tempDir(t), server.URL,
+	))
+	if len(bodies) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(bodies))
+	}
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(bodies[1])
+	if expected := "sha256=" + hex.EncodeToString(mac.Sum(nil)); signatures[1] != expected {
+		t.Fatalf("expected signature %s, got %s", expected, signatures[1])
+	}
+	var payload struct {
+		Selector   string `json:"selector"`
+		DurationMS int64  `json:"duration_ms"`
+		Pages      int    `json:"pages"`
+		Assets     int    `json:"assets"`
+	}
+	if err := json.Unmarshal(bodies[1], &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Selector != "website/content/v1/domain/blog" || payload.Pages != 3 || payload.Assets != 0 {
+		t.Fatalf("unexpected payload %s", bodies[1])
 	}
 }
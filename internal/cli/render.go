@@ -1,25 +1,52 @@
 package cli
 
 import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/tkellen/aevitas/internal/render"
+	"github.com/tkellen/aevitas/internal/selector"
 	"github.com/tkellen/aevitas/pkg/manifest"
 	"github.com/tkellen/aevitas/pkg/resource"
 	"github.com/vbauerster/mpb/v5"
 	"github.com/vbauerster/mpb/v5/decor"
 	"golang.org/x/sync/errgroup"
 	"os"
+	"path"
+	"path/filepath"
 	"sync"
 	"time"
 )
 
 type RenderCmd struct {
-	Load        []string `name:"load" short:"l" type:"existingdir" help:"Directory containing manifests."`
-	Concurrency int64    `help:"Control how many parallel renders can be run" default:10`
-	Progress    bool     `help:"Show progress during render operation"`
-	AssetRoot   string   `required name:"asset" short:"a" type:"existingdir" help:"RenderTree path to assets." default:"${cwd}"`
-	Output      string   `required name:"output" short:"o" help:"Path for output."`
-	Selector    string   `arg required name:"selector" help:"manifest to render."`
+	Load           []string `name:"load" short:"l" type:"existingdir" help:"Directory containing manifests."`
+	Glob           []string `name:"glob" sep:"none" help:"Load manifests from files matching this pattern, which may use ** to match any number of directories (e.g. content/posts/2024/**/*.md)."`
+	Concurrency    int64    `help:"Control how many parallel renders can be run" default:"10"`
+	Progress       bool     `help:"Show progress during render operation"`
+	AssetRoot      string   `required:"" name:"asset" short:"a" type:"existingdir" help:"RenderTree path to assets." default:"${cwd}"`
+	Output         string   `required:"" name:"output" short:"o" help:"Path for output."`
+	OutputTar      string   `name:"output-tar" help:"Write rendered pages to a tar archive at this path instead of the output path. Assets are still written to the output path."`
+	CacheDir       string   `name:"cache-dir" help:"Path for details about previous renders." default:".cache"`
+	SkipUnchanged  bool     `name:"skip-unchanged" help:"Skip rendering pages whose manifest, relations and layouts are unchanged since the last render. Pages linking to neighbours with prev, next, sameYear or yearsPastAndFuture may be left stale."`
+	MergeOutput    bool     `name:"merge-output" help:"Render all selectors to the same output path."`
+	ExportIndex    string   `name:"export-index" help:"Write all indexed manifests to this path as newline delimited json."`
+	HTMLAudit      bool     `name:"html-audit" help:"Fail on scripts passed to the html template function and warn about scripts interpolated into pages."`
+	LazyCollate    bool     `name:"lazy-collate" help:"Defer resolving relations between manifests until they are needed (experimental)."`
+	S3Bucket       string   `name:"s3-bucket" help:"Bucket containing manifests."`
+	S3Prefix       string   `name:"s3-prefix" help:"Only load manifests from the bucket with keys having this prefix."`
+	S3Endpoint     string   `name:"s3-endpoint" help:"Host of the S3 compatible storage." default:"s3.amazonaws.com"`
+	S3Region       string   `name:"s3-region" help:"Region of the bucket."`
+	S3Insecure     bool     `name:"s3-insecure" help:"Connect to the S3 compatible storage without TLS."`
+	S3AccessKey    string   `name:"s3-access-key" env:"AWS_ACCESS_KEY_ID" help:"Access key for the bucket."`
+	S3SecretKey    string   `name:"s3-secret-key" env:"AWS_SECRET_ACCESS_KEY" help:"Secret key for the bucket."`
+	S3Deploy       bool     `name:"s3-deploy" help:"Upload rendered output to S3 compatible storage."`
+	S3DeployBucket string   `name:"s3-deploy-bucket" help:"Bucket to upload rendered output to."`
+	S3DeployPrefix string   `name:"s3-deploy-prefix" help:"Prefix for the keys of uploaded output."`
+	WebhookURL     string   `name:"webhook-url" help:"URL to notify with a POST after a successful render."`
+	WebhookSecret  string   `name:"webhook-secret" help:"Secret used to sign webhook payloads."`
+	Selectors      []string `arg:"" required:"" name:"selectors" help:"manifests to render."`
 }
 
 func progress(ui *mpb.Progress, name string) func(count int, progress <-chan struct{}) {
@@ -40,7 +67,6 @@ func progress(ui *mpb.Progress, name string) func(count int, progress <-chan str
 }
 
 func (r *RenderCmd) Run(ctx *Context) error {
-	stat, _ := ctx.Stdin.Stat()
 	ui := mpb.New(
 		mpb.WithWidth(180),
 		mpb.WithRefreshRate(180*time.Millisecond),
@@ -50,76 +76,238 @@ func (r *RenderCmd) Run(ctx *Context) error {
 	if r.Progress {
 		bars["stdin"] = progress(ui, "reading stdin")
 		bars["file"] = progress(ui, "reading files")
-		bars["asset"] = progress(ui, "render assets")
-		bars["page"] = progress(ui, "render pages ")
-	}
-	eg := errgroup.Group{}
-	queue := make(chan *manifest.Manifest)
-	// Collect manifests provided over standard in.
-	if (stat.Mode() & os.ModeCharDevice) == 0 {
-		eg.Go(func() error {
-			list, err := manifest.NewFromReader(ctx.Stdin, bars["stdin"])
-			if err != nil {
-				return err
-			}
-			for _, manifest := range list {
-				queue <- manifest
-			}
-			return nil
-		})
+		bars["s3"] = progress(ui, "reading s3")
+		bars["glob"] = progress(ui, "reading globs")
 	}
-	// Collect manifests in provided paths.
-	eg.Go(func() error {
-		list, err := manifest.NewFromDirs(r.Load, bars["file"])
+	// Assets with s3:// files are read using the same credentials as
+	// manifests.
+	manifest.RegisterFileOpener("s3", r.s3Config().OpenFile)
+	manifests, loadErr := loadManifests(ctx, r.Load, bars)
+	if loadErr != nil {
+		return loadErr
+	}
+	if len(r.Glob) > 0 {
+		matched, err := manifest.NewFromGlob(r.Glob, bars["glob"])
 		if err != nil {
 			return err
 		}
-		for _, manifest := range list {
-			queue <- manifest
-		}
-		return nil
-	})
-	collect := errgroup.Group{}
-	var manifests []*manifest.Manifest
-	collect.Go(func() error {
-		for manifest := range queue {
-			manifests = append(manifests, manifest)
-		}
-		return nil
-	})
-	if err := eg.Wait(); err != nil {
-		return err
+		manifests = append(manifests, matched...)
 	}
-	close(queue)
-	if err := collect.Wait(); err != nil {
-		return err
+	if r.S3Bucket != "" {
+		remote, err := manifest.NewFromS3(ctx.Background, r.s3Config(), r.S3Prefix, bars["s3"])
+		if err != nil {
+			return err
+		}
+		manifests = append(manifests, remote...)
 	}
 	// Index manifests.
 	index := manifest.NewIndex()
 	if err := index.Insert(manifests...); err != nil {
 		return err
 	}
-	if err := index.Collate(); err != nil {
+	collate := index.CollateContext
+	if r.LazyCollate {
+		collate = func(context.Context) error { return index.LazyCollate() }
+	}
+	if err := collate(ctx.Background); err != nil {
 		return err
 	}
-	// Establish registry to locate assets.
-	inputRoot := osfs.New(r.AssetRoot)
-	outputRoot := osfs.New(r.Output)
-	factory := resource.DefaultFactory(inputRoot, outputRoot)
-	t, tErr := render.NewTree(r.Selector, index, factory)
-	if tErr != nil {
-		return tErr
-	}
-	if err := t.Render(
-		ctx.Background,
-		r.Concurrency,
-		bars["asset"],
-		bars["page"],
-	); err != nil {
+	ctx.Logger.Verbose.Printf("%-45s%-8s%s", "INDEX SHARD", "LIVE", "NOT LIVE")
+	for _, shard := range index.Stats() {
+		ctx.Logger.Verbose.Printf("%-45s%-8d%d", shard.Shard, shard.Live, shard.NotLive)
+	}
+	if r.ExportIndex != "" {
+		if err := r.exportIndex(index); err != nil {
+			return err
+		}
+	}
+	trees, treesErr := r.trees(index)
+	if treesErr != nil {
+		return treesErr
+	}
+	var closeTar func() error
+	if r.OutputTar != "" {
+		var err error
+		if closeTar, err = r.tarOutput(trees); err != nil {
+			return err
+		}
+		defer closeTar()
+	}
+	renders, renderCtx := errgroup.WithContext(ctx.Background)
+	for idx, t := range trees {
+		t := t
+		var watchAssets, watchPages func(count int, progress <-chan struct{})
+		if r.Progress {
+			watchAssets = progress(ui, "render assets "+r.Selectors[idx])
+			watchPages = progress(ui, "render pages  "+r.Selectors[idx])
+		}
+		renders.Go(func() error {
+			return t.Render(renderCtx, r.Concurrency, watchAssets, watchPages)
+		})
+	}
+	if err := renders.Wait(); err != nil {
 		return err
 	}
+	if closeTar != nil {
+		if err := closeTar(); err != nil {
+			return err
+		}
+	}
 	if r.Progress {
 		ui.Wait()
 	}
+	for idx, t := range trees {
+		stats := t.Stats()
+		ctx.Logger.Stdout.Printf(
+			"%s: %d pages (%d written), %d assets in %s",
+			r.Selectors[idx], stats.Pages, stats.Written, stats.Assets, stats.Elapsed,
+		)
+		for _, warning := range t.HTMLAuditWarnings() {
+			ctx.Logger.Stdout.Printf("%s: html audit: %s line %d: %s", r.Selectors[idx], warning.Resource, warning.Line, warning.Context)
+		}
+	}
+	if r.S3Deploy {
+		if err := r.deploy(ctx, trees); err != nil {
+			return err
+		}
+	}
+	if r.WebhookURL != "" {
+		for idx, t := range trees {
+			if err := r.notify(ctx.Background, r.Selectors[idx], t.Stats()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deploy uploads the output of every tree. Trees rendered to a directory
+// named for their selector are uploaded beneath a prefix of the same name.
+func (r *RenderCmd) deploy(ctx *Context, trees []*render.Tree) error {
+	if r.S3DeployBucket == "" {
+		return fmt.Errorf("--s3-deploy-bucket is required to deploy")
+	}
+	cfg := r.s3Config()
+	cfg.Bucket = r.S3DeployBucket
+	for idx, t := range trees {
+		prefix := r.S3DeployPrefix
+		if len(trees) > 1 && !r.MergeOutput {
+			s, err := selector.New(r.Selectors[idx])
+			if err != nil {
+				return err
+			}
+			prefix = path.Join(prefix, s.Name)
+		}
+		if err := t.WriteToS3(ctx.Background, cfg, prefix); err != nil {
+			return err
+		}
+		ctx.Logger.Stdout.Printf("%s: deployed to s3://%s/%s", r.Selectors[idx], cfg.Bucket, prefix)
+	}
 	return nil
 }
+
+// s3Config describes the storage manifests are loaded from.
+func (r *RenderCmd) s3Config() manifest.S3Config {
+	return manifest.S3Config{
+		Endpoint:  r.S3Endpoint,
+		Bucket:    r.S3Bucket,
+		Region:    r.S3Region,
+		AccessKey: r.S3AccessKey,
+		SecretKey: r.S3SecretKey,
+		UseSSL:    !r.S3Insecure,
+	}
+}
+
+// exportIndex writes the contents of the index to the export path.
+func (r *RenderCmd) exportIndex(index *manifest.Index) error {
+	file, err := os.Create(r.ExportIndex)
+	if err != nil {
+		return err
+	}
+	if err := index.WriteSorted(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// tarOutput directs the pages of every tree to a tar archive at the output tar
+// path. Pages of trees that would render to a directory named for their
+// selector are archived beneath one. The returned function completes the
+// archive.
+func (r *RenderCmd) tarOutput(trees []*render.Tree) (func() error, error) {
+	file, err := os.Create(r.OutputTar)
+	if err != nil {
+		return nil, err
+	}
+	archive := tar.NewWriter(file)
+	output := render.NewTarOutputAdapter(archive)
+	for idx, t := range trees {
+		if len(trees) > 1 && !r.MergeOutput {
+			s, err := selector.New(r.Selectors[idx])
+			if err != nil {
+				file.Close()
+				return nil, err
+			}
+			t.WithOutputAdapter(output.Within(s.Name))
+			continue
+		}
+		t.WithOutputAdapter(output)
+	}
+	closed := false
+	return func() error {
+		if closed {
+			return nil
+		}
+		closed = true
+		if err := archive.Close(); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	}, nil
+}
+
+// trees creates a render tree for each selector. When more than one selector
+// is supplied, each renders to a directory named for the selector within the
+// output path unless output merging is requested.
+func (r *RenderCmd) trees(index *manifest.Index) ([]*render.Tree, error) {
+	inputRoot := osfs.New(r.AssetRoot)
+	sharedOutput := render.NewOSFilesystem(r.Output)
+	multiple := len(r.Selectors) > 1
+	seen := map[string]string{}
+	trees := make([]*render.Tree, len(r.Selectors))
+	for idx, target := range r.Selectors {
+		s, selectorErr := selector.New(target)
+		if selectorErr != nil {
+			return nil, selectorErr
+		}
+		var outputRoot billy.Filesystem = sharedOutput
+		cacheDir := r.CacheDir
+		if multiple {
+			cacheDir = filepath.Join(r.CacheDir, filepath.FromSlash(s.ID()))
+			if !r.MergeOutput {
+				if other, ok := seen[s.Name]; ok {
+					return nil, fmt.Errorf("%s and %s render to the same output, use --merge-output", other, s)
+				}
+				seen[s.Name] = s.ID()
+				outputRoot = render.NewOSFilesystem(filepath.Join(r.Output, s.Name))
+			}
+		}
+		factory := resource.DefaultFactory(inputRoot, outputRoot)
+		t, tErr := render.NewTree(target, index, factory)
+		if tErr != nil {
+			return nil, tErr
+		}
+		trees[idx] = t.WithCacheDir(cacheDir)
+		if r.HTMLAudit {
+			if _, err := t.WithHTMLAudit(); err != nil {
+				return nil, err
+			}
+		}
+		if r.SkipUnchanged {
+			t.WithSkipUnchanged()
+		}
+	}
+	return trees, nil
+}
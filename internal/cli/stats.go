@@ -0,0 +1,149 @@
+package cli
+
+import (
+	"fmt"
+	json "github.com/json-iterator/go"
+	"github.com/tkellen/aevitas/internal/render"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+type StatsCmd struct {
+	CacheDir string `name:"cache-dir" help:"Path for details about previous renders." default:".cache"`
+	Format   string `name:"format" enum:"table,json" default:"table" help:"Format of the statistics (table or json)."`
+	Compare  string `name:"compare" type:"existingfile" help:"Path to statistics of a previous render in json format to compare with."`
+	Slowest  int    `name:"slowest" help:"Number of the slowest resources to show." default:"5"`
+}
+
+// RenderStats summarizes the state of a render.
+type RenderStats struct {
+	Resources    int                     `json:"resources"`
+	Assets       int                     `json:"assets"`
+	CacheHitRate float64                 `json:"cacheHitRate"`
+	Elapsed      time.Duration           `json:"elapsed"`
+	Slowest      []*render.ResourceState `json:"slowest"`
+	SizeByKGV    map[string]int          `json:"sizeByKGV"`
+	// Regressions describe how the statistics have worsened compared to
+	// those of a previous render, if any were supplied.
+	Regressions []string `json:"regressions,omitempty"`
+}
+
+func (s *StatsCmd) Run(ctx *Context) error {
+	state, loadErr := render.LoadState(s.CacheDir)
+	if loadErr != nil {
+		return loadErr
+	}
+	stats := s.summarize(state)
+	var previous *RenderStats
+	if s.Compare != "" {
+		data, readErr := ioutil.ReadFile(s.Compare)
+		if readErr != nil {
+			return readErr
+		}
+		if err := json.Unmarshal(data, &previous); err != nil {
+			return fmt.Errorf("%s: %w", s.Compare, err)
+		}
+		stats.Regressions = regressions(previous, stats)
+	}
+	if s.Format == "json" {
+		data, err := json.MarshalIndent(stats, "", "  ")
+		if err != nil {
+			return err
+		}
+		ctx.Logger.Stdout.Printf("%s", data)
+		return nil
+	}
+	ctx.Logger.Stdout.Print(s.table(stats))
+	if previous != nil {
+		if len(stats.Regressions) == 0 {
+			ctx.Logger.Stdout.Print("no regressions\n")
+		}
+		for _, regression := range stats.Regressions {
+			ctx.Logger.Stdout.Printf("REGRESSION %s\n", regression)
+		}
+	}
+	return nil
+}
+
+// summarize computes statistics from the state of a render.
+func (s *StatsCmd) summarize(state *render.State) *RenderStats {
+	stats := &RenderStats{
+		Resources: len(state.Resources),
+		Elapsed:   state.Elapsed,
+		SizeByKGV: map[string]int{},
+	}
+	pages, hits := 0, 0
+	for _, resource := range state.Resources {
+		if resource.Asset {
+			stats.Assets++
+			continue
+		}
+		pages++
+		if resource.Cached {
+			hits++
+		}
+		stats.SizeByKGV[resource.KGV] += resource.Size
+	}
+	if pages > 0 {
+		stats.CacheHitRate = float64(hits) / float64(pages)
+	}
+	slowest := append([]*render.ResourceState{}, state.Resources...)
+	sort.SliceStable(slowest, func(a, b int) bool {
+		return slowest[a].Duration > slowest[b].Duration
+	})
+	if len(slowest) > s.Slowest {
+		slowest = slowest[:s.Slowest]
+	}
+	stats.Slowest = slowest
+	return stats
+}
+
+// table formats statistics for humans.
+func (s *StatsCmd) table(stats *RenderStats) string {
+	format := "%-45s%v\n"
+	var table strings.Builder
+	fmt.Fprintf(&table, format, "RESOURCES", stats.Resources)
+	fmt.Fprintf(&table, format, "ASSETS", stats.Assets)
+	fmt.Fprintf(&table, format, "CACHE HIT RATE", fmt.Sprintf("%.1f%%", stats.CacheHitRate*100))
+	fmt.Fprintf(&table, format, "ELAPSED", stats.Elapsed)
+	table.WriteString("\n")
+	fmt.Fprintf(&table, format, "SLOWEST", "DURATION")
+	for _, resource := range stats.Slowest {
+		fmt.Fprintf(&table, format, resource.ID, resource.Duration)
+	}
+	table.WriteString("\n")
+	fmt.Fprintf(&table, format, "KGV", "OUTPUT SIZE")
+	for _, kgv := range sortedKeys(stats.SizeByKGV) {
+		fmt.Fprintf(&table, format, kgv, stats.SizeByKGV[kgv])
+	}
+	return table.String()
+}
+
+// regressions describes how the current statistics have worsened compared to
+// a previous render.
+func regressions(previous *RenderStats, current *RenderStats) []string {
+	var report []string
+	if current.Elapsed > previous.Elapsed {
+		report = append(report, fmt.Sprintf("elapsed %s -> %s", previous.Elapsed, current.Elapsed))
+	}
+	if current.CacheHitRate < previous.CacheHitRate {
+		report = append(report, fmt.Sprintf("cache hit rate %.1f%% -> %.1f%%", previous.CacheHitRate*100, current.CacheHitRate*100))
+	}
+	for _, kgv := range sortedKeys(current.SizeByKGV) {
+		if before, ok := previous.SizeByKGV[kgv]; ok && current.SizeByKGV[kgv] > before {
+			report = append(report, fmt.Sprintf("%s output size %d -> %d", kgv, before, current.SizeByKGV[kgv]))
+		}
+	}
+	return report
+}
+
+func sortedKeys(values map[string]int) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
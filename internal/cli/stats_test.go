@@ -0,0 +1,89 @@
+package cli
+
+import (
+	"fmt"
+	json "github.com/json-iterator/go"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const sampleRenderState = `{
+	"Target": "website/content/v1/domain/blog",
+	"Elapsed": 3000000000,
+	"Resources": [
+		{"ID": "website/content/v1/domain/blog", "KGV": "website/content/v1", "Href": "/index.html", "Cached": true, "Duration": 1000000, "Size": 100},
+		{"ID": "website/content/v1/post/one", "KGV": "website/content/v1", "Href": "/one.html", "Duration": 900000000, "Size": 200},
+		{"ID": "website/content/v1/post/two", "KGV": "website/content/v1", "Href": "/two.html", "Cached": true, "Duration": 2000000, "Size": 300},
+		{"ID": "asset/jpeg/v1/image/cows", "KGV": "asset/jpeg/v1", "Asset": true, "Duration": 5000000}
+	]
+}`
+
+func TestStatsCmd_Run(t *testing.T) {
+	cacheDir := tempDir(t)
+	if err := ioutil.WriteFile(filepath.Join(cacheDir, "render-state.json"), []byte(sampleRenderState), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output := run(t, fmt.Sprintf("test stats --cache-dir %s --slowest 1", cacheDir))
+	lines := strings.Split(output, "\n")
+	for _, expected := range []string{"RESOURCES", "4"} {
+		if !strings.Contains(lines[0], expected) {
+			t.Fatalf("expected %s in %s", expected, lines[0])
+		}
+	}
+	for _, expected := range []string{
+		"website/content/v1/post/one",
+		"66.7%",
+		"600",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Fatalf("expected %s in output, got %s", expected, output)
+		}
+	}
+	if strings.Contains(output, "asset/jpeg/v1/image/cows") {
+		t.Fatalf("expected only the slowest resource, got %s", output)
+	}
+}
+
+func TestStatsCmd_RunCompare(t *testing.T) {
+	cacheDir := tempDir(t)
+	if err := ioutil.WriteFile(filepath.Join(cacheDir, "render-state.json"), []byte(sampleRenderState), 0644); err != nil {
+		t.Fatal(err)
+	}
+	previous := filepath.Join(tempDir(t), "previous.json")
+	if err := ioutil.WriteFile(previous, []byte(`{"resources":4,"elapsed":1000000000,"cacheHitRate":1,"sizeByKGV":{"website/content/v1":600}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output := run(t, fmt.Sprintf("test stats --cache-dir %s --compare %s", cacheDir, previous))
+	if !strings.Contains(output, "REGRESSION elapsed 1s -> 3s") {
+		t.Fatalf("expected elapsed regression, got %s", output)
+	}
+	if !strings.Contains(output, "REGRESSION cache hit rate") {
+		t.Fatalf("expected cache hit rate regression, got %s", output)
+	}
+	if strings.Contains(output, "output size") {
+		t.Fatalf("expected no output size regression, got %s", output)
+	}
+}
+
+func TestStatsCmd_RunCompareJSON(t *testing.T) {
+	cacheDir := tempDir(t)
+	if err := ioutil.WriteFile(filepath.Join(cacheDir, "render-state.json"), []byte(sampleRenderState), 0644); err != nil {
+		t.Fatal(err)
+	}
+	previous := filepath.Join(tempDir(t), "previous.json")
+	if err := ioutil.WriteFile(previous, []byte(`{"resources":4,"elapsed":1000000000,"cacheHitRate":1,"sizeByKGV":{"website/content/v1":600}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output := run(t, fmt.Sprintf("test stats --cache-dir %s --compare %s --format json", cacheDir, previous))
+	var stats RenderStats
+	if err := json.Unmarshal([]byte(output), &stats); err != nil {
+		t.Fatalf("expected json, got %s: %s", output, err)
+	}
+	expected := []string{"elapsed 1s -> 3s", "cache hit rate 100.0% -> 66.7%"}
+	if !reflect.DeepEqual(expected, stats.Regressions) {
+		t.Fatalf("expected %v, got %v", expected, stats.Regressions)
+	}
+}
@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"github.com/tkellen/aevitas/pkg/resource"
+	"strings"
+)
+
+type TreeCmd struct {
+	Load      []string `name:"load" short:"l" type:"existingdir" help:"Directory containing manifests."`
+	AssetRoot string   `name:"asset" short:"a" type:"existingdir" help:"Path to assets." default:"${cwd}"`
+	Depth     int      `name:"depth" help:"Maximum depth to show (0 for unlimited)."`
+	OnlyKGV   string   `name:"only-kgv" help:"Only show resources of this kind/group/version."`
+	Selector  string   `arg:"" required:"" name:"selector" help:"Manifest to show."`
+}
+
+func (tc *TreeCmd) Run(ctx *Context) error {
+	manifests, loadErr := loadManifests(ctx, tc.Load, nil)
+	if loadErr != nil {
+		return loadErr
+	}
+	index := manifest.NewIndex()
+	if err := index.Insert(manifests...); err != nil {
+		return err
+	}
+	if err := index.CollateContext(ctx.Background); err != nil {
+		return err
+	}
+	// Nothing is rendered so output is discarded.
+	factory := resource.DefaultFactory(osfs.New(tc.AssetRoot), memfs.New())
+	root, err := resource.New(index, tc.Selector, factory)
+	if err != nil {
+		return err
+	}
+	tc.print(ctx, root, 0)
+	return nil
+}
+
+// print writes a line for the resource and recurses into its children, each
+// level indented by two spaces.
+func (tc *TreeCmd) print(ctx *Context, r *resource.Resource, depth int) {
+	if tc.Depth > 0 && depth >= tc.Depth {
+		return
+	}
+	if tc.OnlyKGV == "" || r.Selector.KGV == tc.OnlyKGV {
+		line := []string{strings.Repeat("  ", depth) + r.Selector.ID()}
+		if title := r.Title(); title != "" {
+			line = append(line, `"`+title+`"`)
+		}
+		if href := r.Href(); href != "" {
+			line = append(line, href)
+		}
+		badge := "[PAGE]"
+		if r.Instance().AsAsset != nil {
+			badge = "[ASSET]"
+		}
+		ctx.Logger.Stdout.Print(strings.Join(append(line, badge), " "))
+	}
+	for _, child := range r.Children() {
+		tc.print(ctx, child, depth+1)
+	}
+}
@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTreeCmd_Run(t *testing.T) {
+	lines := strings.Split(strings.TrimSpace(run(t, "test tree -a ../../testdata -l ../../testdata/blog website/content/v1/domain/blog")), "\n")
+	expected := []string{
+		`website/content/v1/domain/blog "Test Blog" /index.html [PAGE]`,
+		`  website/content/v1/post/one`,
+		`  website/content/v1/post/two`,
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines, got %d: %s", len(expected), len(lines), lines)
+	}
+	for idx, prefix := range expected {
+		if !strings.HasPrefix(lines[idx], prefix) {
+			t.Fatalf("expected line %d to start with %s, got %s", idx, prefix, lines[idx])
+		}
+	}
+}
+
+func TestTreeCmd_RunDepth(t *testing.T) {
+	output := strings.TrimSpace(run(t, "test tree -a ../../testdata -l ../../testdata/blog --depth 1 website/content/v1/domain/blog"))
+	if strings.Contains(output, "\n") {
+		t.Fatalf("expected only the root, got %s", output)
+	}
+}
+
+func TestTreeCmd_RunOnlyKGV(t *testing.T) {
+	output := run(t, "test tree -a ../../testdata -l ../../testdata/blog --only-kgv website/content/v1 website/content/v1/domain/blog")
+	if strings.Count(output, "\n") != 3 {
+		t.Fatalf("expected all resources, got %s", output)
+	}
+	output = run(t, "test tree -a ../../testdata -l ../../testdata/blog --only-kgv html/template/v1 website/content/v1/domain/blog")
+	if output != "" {
+		t.Fatalf("expected no resources, got %s", output)
+	}
+}
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/hex"
+	"fmt"
+	json "github.com/json-iterator/go"
+	hash "github.com/minio/sha256-simd"
+	"github.com/tkellen/aevitas/internal/render"
+	"net/http"
+	"time"
+)
+
+// webhookTimeout bounds each attempt to notify a webhook.
+const webhookTimeout = 5 * time.Second
+
+// webhookPayload describes a successful render to a webhook.
+type webhookPayload struct {
+	Selector   string `json:"selector"`
+	DurationMS int64  `json:"duration_ms"`
+	Pages      int    `json:"pages"`
+	Assets     int    `json:"assets"`
+}
+
+// notify posts the outcome of rendering a selector to the webhook. When a
+// secret is supplied the body is signed with it so receivers can verify the
+// sender. Failed attempts are retried once.
+func (r *RenderCmd) notify(ctx context.Context, selector string, stats render.Stats) error {
+	body, err := json.Marshal(&webhookPayload{
+		Selector:   selector,
+		DurationMS: stats.Elapsed.Milliseconds(),
+		Pages:      stats.Pages,
+		Assets:     stats.Assets,
+	})
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: webhookTimeout}
+	var postErr error
+	for attempt := 0; attempt < 2; attempt++ {
+		if postErr = r.post(ctx, client, body); postErr == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("webhook: %w", postErr)
+}
+
+func (r *RenderCmd) post(ctx context.Context, client *http.Client, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.WebhookSecret != "" {
+		req.Header.Set("X-Aevitas-Signature", "sha256="+sign(body, r.WebhookSecret))
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("%s responded with %s", r.WebhookURL, res.Status)
+	}
+	return nil
+}
+
+// sign computes the hex encoded HMAC-SHA256 of body.
+func sign(body []byte, secret string) string {
+	mac := hmac.New(hash.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
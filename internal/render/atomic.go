@@ -0,0 +1,56 @@
+package render
+
+import (
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/tkellen/aevitas/internal/safefs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// SafeWriter is implemented by filesystems that can replace the content of a
+// file without readers ever observing a partial write.
+type SafeWriter = safefs.Writer
+
+// OSFilesystem is a filesystem on disk that replaces files atomically.
+type OSFilesystem struct {
+	billy.Filesystem
+}
+
+// NewOSFilesystem does just what you think it does.
+func NewOSFilesystem(root string) *OSFilesystem {
+	return &OSFilesystem{Filesystem: osfs.New(root)}
+}
+
+// MkdirAll creates directories with perm as osfs ignores it.
+func (fs *OSFilesystem) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(filepath.Join(fs.Root(), path), perm)
+}
+
+// WriteAtomic writes content next to the target and renames it into place.
+func (fs *OSFilesystem) WriteAtomic(path string, content []byte, mode os.FileMode) error {
+	target := filepath.Join(fs.Root(), path)
+	temp := target + ".tmp"
+	if err := ioutil.WriteFile(temp, content, mode); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	// The mode supplied when writing is masked by the umask and ignored if
+	// the file already existed.
+	if err := os.Chmod(temp, mode); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	if err := os.Rename(temp, target); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return nil
+}
+
+// WriteAtomic replaces the file at path in dest with content the same way
+// assets are written. Filesystems that cannot rename are written to directly.
+func WriteAtomic(dest billy.Filesystem, path string, content []byte, mode os.FileMode) error {
+	return safefs.WriteFile(dest, path, content, mode)
+}
@@ -0,0 +1,91 @@
+package render_test
+
+import (
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/tkellen/aevitas/internal/render"
+	"os"
+	"testing"
+)
+
+// panicFs simulates the process being killed mid-write by panicking on write
+// after some of the content has reached the file.
+type panicFs struct {
+	billy.Filesystem
+}
+
+func (fs *panicFs) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	file, err := fs.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &panicFile{File: file}, nil
+}
+
+type panicFile struct {
+	billy.File
+}
+
+func (f *panicFile) Write(p []byte) (int, error) {
+	f.File.Write(p[:len(p)/2])
+	panic("killed mid-write")
+}
+
+// noRenameFs is a filesystem that does not support renaming files.
+type noRenameFs struct {
+	billy.Filesystem
+}
+
+func (fs *noRenameFs) Rename(from, to string) error {
+	return billy.ErrNotSupported
+}
+
+func TestWriteAtomic(t *testing.T) {
+	for name, fs := range map[string]billy.Filesystem{
+		"rename":    memfs.New(),
+		"no rename": &noRenameFs{memfs.New()},
+		"os":        render.NewOSFilesystem(t.TempDir()),
+	} {
+		fs := fs
+		t.Run(name, func(t *testing.T) {
+			if err := util.WriteFile(fs, "index.html", []byte("old"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := render.WriteAtomic(fs, "index.html", []byte("new"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if actual, err := readFile(fs, "index.html"); err != nil || actual != "new" {
+				t.Fatalf("expected new, got %s (%v)", actual, err)
+			}
+			if _, err := fs.Stat("index.html.tmp"); !os.IsNotExist(err) {
+				t.Fatalf("expected temporary file to be removed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestWriteAtomic_Interrupted(t *testing.T) {
+	fs := &panicFs{memfs.New()}
+	if err := util.WriteFile(fs.Filesystem, "index.html", []byte("complete"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected write to panic")
+			}
+		}()
+		render.WriteAtomic(fs, "index.html", []byte("replacement"), 0644)
+	}()
+	if actual, err := readFile(fs, "index.html"); err != nil || actual != "complete" {
+		t.Fatalf("expected previous output to remain intact, got %s (%v)", actual, err)
+	}
+	func() {
+		defer func() { recover() }()
+		render.WriteAtomic(fs, "new.html", []byte("replacement"), 0644)
+	}()
+	if _, err := fs.Stat("new.html"); !os.IsNotExist(err) {
+		t.Fatalf("expected no partial file, got %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package render
+
+import (
+	"github.com/tkellen/aevitas/pkg/resource"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// scriptAttr matches each attribute of an opening script tag, capturing its
+// name and value.
+var scriptAttr = regexp.MustCompile(`\s([a-z-]+)(?:\s*=\s*("[^"]*"|'[^']*'|[^\s"'>]+))?`)
+
+// integrityValue matches the hashes scriptTag writes as the integrity of a
+// script.
+var integrityValue = regexp.MustCompile(`^sha(?:256|384|512)-[a-z0-9+/]+=*$`)
+
+// HTMLAuditWarning identifies rendered output that includes a script which
+// does not appear in any template, suggesting it was interpolated without
+// being escaped.
+type HTMLAuditWarning struct {
+	Resource string
+	Line     int
+	Context  string
+}
+
+// WithHTMLAudit makes the html template function fail on content that looks
+// like it includes a script and scans rendered pages for scripts that were
+// interpolated into them. Scripts written in the body of a manifest or
+// produced by scriptTag are expected. Resources are rebuilt from a copy of the
+// factory so other trees using it are unaffected.
+func (t *Tree) WithHTMLAudit() (*Tree, error) {
+	t.factory = t.factory.WithHTMLAudit()
+	if err := t.build(); err != nil {
+		return nil, err
+	}
+	t.htmlAudit = true
+	return t, nil
+}
+
+// HTMLAuditWarnings lists what the audit found during the most recent render,
+// ordered by resource and line.
+func (t *Tree) HTMLAuditWarnings() []HTMLAuditWarning {
+	t.auditMu.Lock()
+	defer t.auditMu.Unlock()
+	warnings := append([]HTMLAuditWarning{}, t.auditWarnings...)
+	sort.Slice(warnings, func(a, b int) bool {
+		if warnings[a].Resource != warnings[b].Resource {
+			return warnings[a].Resource < warnings[b].Resource
+		}
+		return warnings[a].Line < warnings[b].Line
+	})
+	return warnings
+}
+
+// auditLiterals collects the scripts written in the body of every manifest.
+// Those containing template actions are left out as only their output is
+// known.
+func (t *Tree) auditLiterals() map[string]bool {
+	literals := map[string]bool{}
+	for _, m := range t.index.Manifests() {
+		for _, snippet := range scriptSnippets(m.Body) {
+			if !strings.Contains(snippet, "{{") {
+				literals[snippet] = true
+			}
+		}
+	}
+	return literals
+}
+
+// audit records a warning for each line of content with a script that is not
+// expected.
+func (t *Tree) audit(target *resource.Resource, content string) {
+	var warnings []HTMLAuditWarning
+	for idx, line := range strings.Split(content, "\n") {
+		if !resource.ContainsScript(line) {
+			continue
+		}
+		for _, snippet := range scriptSnippets(line) {
+			// scriptTag produces tags with the integrity of their source.
+			if t.auditLiteral[snippet] || hasIntegrity(snippet) {
+				continue
+			}
+			warnings = append(warnings, HTMLAuditWarning{
+				Resource: target.Selector.ID(),
+				Line:     idx + 1,
+				Context:  strings.TrimSpace(line),
+			})
+			break
+		}
+	}
+	if len(warnings) == 0 {
+		return
+	}
+	t.auditMu.Lock()
+	defer t.auditMu.Unlock()
+	t.auditWarnings = append(t.auditWarnings, warnings...)
+}
+
+// hasIntegrity reports if snippet is an opening script tag with a subresource
+// integrity attribute.
+func hasIntegrity(snippet string) bool {
+	if !strings.HasPrefix(snippet, "<script") {
+		return false
+	}
+	for _, attr := range scriptAttr.FindAllStringSubmatch(snippet, -1) {
+		if attr[1] == "integrity" && integrityValue.MatchString(strings.Trim(attr[2], `"'`)) {
+			return true
+		}
+	}
+	return false
+}
+
+// scriptSnippets extracts, in lower case, each opening script tag and
+// javascript: url of content.
+func scriptSnippets(content string) []string {
+	lower := strings.ToLower(content)
+	var snippets []string
+	for _, marker := range []struct {
+		start string
+		end   string
+	}{
+		{"<script", ">"},
+		{"javascript:", "\"'> "},
+	} {
+		for rest := lower; ; {
+			start := strings.Index(rest, marker.start)
+			if start == -1 {
+				break
+			}
+			rest = rest[start:]
+			end := strings.IndexAny(rest[len(marker.start):], marker.end)
+			if end == -1 {
+				snippets = append(snippets, rest)
+				break
+			}
+			end += len(marker.start)
+			snippets = append(snippets, rest[:end+1])
+			rest = rest[end:]
+		}
+	}
+	return snippets
+}
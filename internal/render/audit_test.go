@@ -0,0 +1,86 @@
+package render_test
+
+import (
+	"context"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/tkellen/aevitas/internal/render"
+	"github.com/tkellen/aevitas/internal/testhelper"
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"github.com/tkellen/aevitas/pkg/resource"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTree_HTMLAudit(t *testing.T) {
+	cacheDir, tempErr := ioutil.TempDir("", "aevitas-cache")
+	if tempErr != nil {
+		t.Fatal(tempErr)
+	}
+	defer os.RemoveAll(cacheDir)
+	source := memfs.New()
+	if err := util.WriteFile(source, "comment.html", []byte("<p>nice post</p><script>alert(1)</script>\n<script src=\"/x.js\" data-note=\"integrity=sha384-abc\"></script>\n<script src=\"/y.js\" integrity=\"sha384-AbC+/9=\" crossorigin=\"anonymous\"></script>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	site := testhelper.MakeManifest(t, "website/content/v1/domain/site", `{"href":"/index.html"}`, "")
+	site.Body = "<script src=\"/app.js\"></script>\n<main>\n{{ embed \"comment.html\" }}\n</main>"
+	newTreeWith := func(t *testing.T, factory *resource.Factory, manifests ...*manifest.Manifest) *render.Tree {
+		tree, err := render.NewTree("website/content/v1/domain/site", testhelper.MakeIndex(t, manifests...), factory)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tree.WithCacheDir(cacheDir)
+	}
+	newTree := func(t *testing.T, manifests ...*manifest.Manifest) *render.Tree {
+		return newTreeWith(t, testhelper.MakeFactory(source, memfs.New()), manifests...)
+	}
+	audited := func(t *testing.T, tree *render.Tree) *render.Tree {
+		tree, err := tree.WithHTMLAudit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tree
+	}
+	tree := audited(t, newTree(t, site))
+	if err := tree.Render(context.Background(), 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := []render.HTMLAuditWarning{{
+		Resource: "website/content/v1/domain/site",
+		Line:     3,
+		Context:  `<p>nice post</p><script>alert(1)</script>`,
+	}, {
+		Resource: "website/content/v1/domain/site",
+		Line:     4,
+		Context:  `<script src="/x.js" data-note="integrity=sha384-abc"></script>`,
+	}}
+	if actual := tree.HTMLAuditWarnings(); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	unaudited := newTree(t, site)
+	if err := unaudited.Render(context.Background(), 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if warnings := unaudited.HTMLAuditWarnings(); len(warnings) != 0 {
+		t.Fatalf("expected no warnings without auditing, got %v", warnings)
+	}
+	// The html function rejects scripts while auditing.
+	escaped := testhelper.MakeManifest(t, "website/content/v1/domain/site", `{"href":"/index.html","description":"<script>alert(1)</script>"}`, "")
+	escaped.Body = "<p>{{ html .Meta.Description }}</p>"
+	err := audited(t, newTree(t, escaped)).Render(context.Background(), 1, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "html audit") {
+		t.Fatalf("expected html audit error, got %v", err)
+	}
+	if err := newTree(t, escaped).Render(context.Background(), 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Auditing one tree leaves others sharing its factory alone.
+	shared := testhelper.MakeFactory(source, memfs.New())
+	audited(t, newTreeWith(t, shared, escaped))
+	if err := newTreeWith(t, shared, escaped).Render(context.Background(), 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+}
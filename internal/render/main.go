@@ -1,22 +1,101 @@
 package render
 
 import (
-	"bytes"
 	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	json "github.com/json-iterator/go"
+	hash "github.com/minio/sha256-simd"
+	"github.com/tkellen/aevitas/internal/selector"
 	"github.com/tkellen/aevitas/pkg/manifest"
 	"github.com/tkellen/aevitas/pkg/resource"
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/sync/semaphore"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// contentHashFile is the name of the file within the cache directory that
+// records the hash of the output produced by each resource.
+const contentHashFile = "content-hash.json"
+
+// cacheIDFile is the name of the file within the cache directory that records
+// the cache ID each resource had when its output was last produced.
+const cacheIDFile = "cache-id.json"
+
+// defaultMaxOpenFiles is how many output files may be open at once unless
+// configured otherwise.
+const defaultMaxOpenFiles = 100
+
 type Tree struct {
 	Root     *resource.Resource
+	target   string
+	index    *manifest.Index
+	factory  *resource.Factory
 	toRender []*resource.Resource
 	assets   []*resource.Resource
 	cacheDir string
+	// hashes maps resource IDs to the sha256 of the output they produced on
+	// the last render. It is nil when no cache file was found.
+	hashes   map[string]string
+	hashesMu sync.Mutex
+	// cacheIDs maps selector IDs to the ID of the resource that last produced
+	// their output. With skipUnchanged, pages whose resource ID is unchanged
+	// are not rendered at all. It is guarded by hashesMu.
+	cacheIDs      map[string]string
+	skipUnchanged bool
+	// rendered records the selector ID of every resource whose output has
+	// been written or found to be current. It is guarded by hashesMu.
+	rendered map[string]bool
+	state    []*ResourceState
+	stateMu  sync.Mutex
+	written  int64
+	elapsed  time.Duration
+	// renderedPages and renderedAssets count what the most recent render
+	// included, which is a subset of the tree when rebuilding after a change.
+	renderedPages  int
+	renderedAssets int
+	// cacheControl maps file extensions to the Cache-Control header used
+	// when uploading output.
+	cacheControl map[string]string
+	// fileMode and dirMode are the permissions of created output.
+	fileMode os.FileMode
+	dirMode  os.FileMode
+	// openFiles limits how many output files are open at once, separately
+	// from the concurrency of rendering.
+	openFiles *semaphore.Weighted
+	// output receives rendered pages. When nil they are written to the
+	// destination of each resource.
+	output OutputAdapter
+	// htmlAudit enables scanning pages for interpolated scripts. The scripts
+	// found in manifests are collected in auditLiteral when rendering starts.
+	htmlAudit     bool
+	auditLiteral  map[string]bool
+	auditWarnings []HTMLAuditWarning
+	auditMu       sync.Mutex
+}
+
+// Stats describes the outcome of the most recent render of a tree.
+type Stats struct {
+	// Pages is the number of resources that produce output.
+	Pages int
+	// Rendered is the number of pages that were rendered.
+	Rendered int
+	// Written is the number of pages whose output changed and was written.
+	Written int
+	// Assets is the number of assets that were rendered.
+	Assets int
+	// Elapsed is how long the render took.
+	Elapsed time.Duration
 }
 
 func NewTree(target string, index *manifest.Index, factory *resource.Factory) (*Tree, error) {
@@ -26,24 +105,265 @@ func NewTree(target string, index *manifest.Index, factory *resource.Factory) (*
 	}
 	resources := root.Flatten()
 	return &Tree{
-		Root:     root,
-		toRender: resources,
-		assets:   assets(resources),
-		cacheDir: ".cache",
+		Root:         root,
+		target:       target,
+		index:        index,
+		factory:      factory,
+		toRender:     prioritize(resources),
+		assets:       assets(resources),
+		cacheDir:     ".cache",
+		cacheControl: DefaultCacheControl,
+		fileMode:     0644,
+		dirMode:      0755,
+		openFiles:    semaphore.NewWeighted(defaultMaxOpenFiles),
 	}, nil
 }
 
+// WithCacheDir controls where the tree stores details about previous renders.
+func (t *Tree) WithCacheDir(dir string) *Tree {
+	t.cacheDir = dir
+	return t
+}
+
+// WithSkipUnchanged skips rendering pages whose resource ID matches the one
+// that last produced their output. The ID covers a manifest, its relations,
+// its layouts and its parent but not what templates reach through the index,
+// e.g. Prev, Next, SameYear or YearsPastAndFuture. Pages using those are left
+// stale when their neighbours change until the cache is reset.
+func (t *Tree) WithSkipUnchanged() *Tree {
+	t.skipUnchanged = true
+	return t
+}
+
+// WithFileMode controls the permissions of output files written to the
+// destination of each resource.
+func (t *Tree) WithFileMode(mode os.FileMode) *Tree {
+	t.fileMode = mode
+	return t
+}
+
+// WithMaxOpenFiles limits how many output files are open at once. Creating
+// many files at the same time fails on some filesystems (e.g. NFS mounts)
+// even when rendering itself is not limited. Limits below one are raised to
+// one, as no file could be written otherwise.
+func (t *Tree) WithMaxOpenFiles(n int) *Tree {
+	if n < 1 {
+		n = 1
+	}
+	t.openFiles = semaphore.NewWeighted(int64(n))
+	return t
+}
+
+// WithDirMode controls the permissions of directories created for output
+// written to the destination of each resource.
+func (t *Tree) WithDirMode(mode os.FileMode) *Tree {
+	t.dirMode = mode
+	return t
+}
+
+// WithOutputAdapter controls where rendered pages are written. Only pages
+// written to an FSOutputAdapter are skipped when their output is current,
+// other adapters receive every page. Assets are always rendered to the
+// destination of their resource.
+func (t *Tree) WithOutputAdapter(a OutputAdapter) *Tree {
+	t.output = a
+	return t
+}
+
+// outputFor returns the adapter receiving pages destined for dest.
+func (t *Tree) outputFor(dest billy.Filesystem) OutputAdapter {
+	if t.output != nil {
+		return t.output
+	}
+	return &FSOutputAdapter{Filesystem: dest, FileMode: t.fileMode, DirMode: t.dirMode}
+}
+
+// Stats reports details about the most recent render.
+func (t *Tree) Stats() Stats {
+	pages := 0
+	for _, item := range t.toRender {
+		if hasOutput(item) {
+			pages++
+		}
+	}
+	return Stats{
+		Pages:    pages,
+		Rendered: t.renderedPages,
+		Written:  int(atomic.LoadInt64(&t.written)),
+		Assets:   t.renderedAssets,
+		Elapsed:  t.elapsed,
+	}
+}
+
+// PurgeCache removes the cache directory and every output the tree renders so
+// the next render starts from scratch.
+func (t *Tree) PurgeCache() error {
+	if err := os.RemoveAll(t.cacheDir); err != nil {
+		return err
+	}
+	if err := t.clearOutput(); err != nil {
+		return err
+	}
+	t.hashesMu.Lock()
+	t.hashes = nil
+	t.cacheIDs = nil
+	t.rendered = nil
+	t.hashesMu.Unlock()
+	return nil
+}
+
+// HighPriorityCount returns the number of resources with a render priority.
+func (t *Tree) HighPriorityCount() int {
+	count := 0
+	for _, item := range t.toRender {
+		if item.Meta.RenderPriority > 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// Reset removes every output of the tree and forgets the hashes of previous
+// renders so the next render writes everything again. Resources are rebuilt
+// from the existing index, picking up changes to templates that were made
+// in place, without reading manifests from disk.
+func (t *Tree) Reset() error {
+	if err := t.clearOutput(); err != nil {
+		return err
+	}
+	for _, file := range []string{contentHashFile, cacheIDFile} {
+		err := os.Remove(filepath.Join(t.cacheDir, file))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := t.build(); err != nil {
+		return err
+	}
+	t.hashesMu.Lock()
+	t.hashes = nil
+	t.cacheIDs = nil
+	t.rendered = nil
+	t.hashesMu.Unlock()
+	return nil
+}
+
+// build creates the resources of the tree from its index and factory.
+func (t *Tree) build() error {
+	root, err := resource.New(t.index, t.target, t.factory)
+	if err != nil {
+		return err
+	}
+	resources := root.Flatten()
+	t.Root = root
+	t.toRender = prioritize(resources)
+	t.assets = assets(resources)
+	return nil
+}
+
+// ResetPage removes the output of a single rendered resource and forgets its
+// hash so the next render writes it again.
+func (t *Tree) ResetPage(target string) error {
+	s, err := selector.New(target)
+	if err != nil {
+		return err
+	}
+	t.hashesMu.Lock()
+	rendered := t.rendered[s.ID()]
+	t.hashesMu.Unlock()
+	if !rendered {
+		return fmt.Errorf("%s has not been rendered", s)
+	}
+	for _, item := range t.toRender {
+		if item.Selector.ID() != s.ID() || !hasOutput(item) {
+			continue
+		}
+		if err := clearDest(item.Instance().Dest, item.Href()); err != nil {
+			return err
+		}
+		t.hashesMu.Lock()
+		delete(t.hashes, item.ID())
+		delete(t.cacheIDs, s.ID())
+		delete(t.rendered, s.ID())
+		t.hashesMu.Unlock()
+	}
+	return nil
+}
+
+// clearOutput removes the output of every resource in the tree.
+func (t *Tree) clearOutput() error {
+	for _, item := range t.toRender {
+		if !hasOutput(item) {
+			continue
+		}
+		if err := clearDest(item.Instance().Dest, item.Href()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clearDest removes the output at href from dest, if there is any.
+func clearDest(dest billy.Filesystem, href string) error {
+	name, err := sanitizePath(dest.Root(), href)
+	if err != nil {
+		return err
+	}
+	if err := dest.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
 func (t *Tree) Render(
 	ctx context.Context,
 	concurrency int64,
 	watchAssets func(int, <-chan struct{}),
 	watchPages func(int, <-chan struct{}),
 ) error {
+	return t.renderResources(ctx, concurrency, t.assets, t.toRender, watchAssets, watchPages)
+}
+
+// renderResources renders the supplied assets and then pages, which must
+// belong to the tree.
+func (t *Tree) renderResources(
+	ctx context.Context,
+	concurrency int64,
+	assets []*resource.Resource,
+	toRender []*resource.Resource,
+	watchAssets func(int, <-chan struct{}),
+	watchPages func(int, <-chan struct{}),
+) error {
+	start := time.Now()
+	t.renderedPages = 0
+	for _, item := range toRender {
+		if hasOutput(item) {
+			t.renderedPages++
+		}
+	}
+	t.renderedAssets = len(assets)
+	atomic.StoreInt64(&t.written, 0)
+	t.stateMu.Lock()
+	t.state = nil
+	t.stateMu.Unlock()
+	if t.htmlAudit {
+		t.auditLiteral = t.auditLiterals()
+		t.auditMu.Lock()
+		t.auditWarnings = nil
+		t.auditMu.Unlock()
+	}
 	if err := os.MkdirAll(t.cacheDir, 0755); err != nil {
 		return err
 	}
-	assetCount := len(t.assets)
+	if err := t.loadCache(); err != nil {
+		return err
+	}
+	assetCount := len(assets)
+	// Progress channels are closed however rendering ends so watchers never
+	// wait on items that will not be rendered. Every send has completed by the
+	// time these run as errgroups are always waited on before returning.
 	assetsProgress := make(chan struct{})
+	defer close(assetsProgress)
 	eg, egCtx := errgroup.WithContext(ctx)
 	if assetCount > 0 {
 		if watchAssets != nil {
@@ -51,7 +371,8 @@ func (t *Tree) Render(
 		}
 		assetSem := semaphore.NewWeighted(concurrency)
 		eg.Go(func() error {
-			for _, item := range t.assets {
+			for _, item := range assets {
+				item := item
 				instance := item.Instance()
 				if err := assetSem.Acquire(egCtx, 1); err != nil {
 					return err
@@ -63,20 +384,32 @@ func (t *Tree) Render(
 							assetsProgress <- struct{}{}
 						}
 					}()
-					return instance.AsAsset.Render(egCtx, instance.Source, instance.Dest)
+					start := time.Now()
+					if err := instance.AsAsset.Render(egCtx, instance.Source, instance.Dest); err != nil {
+						return err
+					}
+					t.recordState(item, start, true, false, 0)
+					return nil
 				})
 			}
 			return nil
 		})
 	}
+	// Assets are rendered before pages so details computed while rendering
+	// them (e.g. dominant colors) are available to templates.
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+	eg, egCtx = errgroup.WithContext(ctx)
 	pagesProgress := make(chan struct{})
-	if len(t.toRender) > 0 {
+	defer close(pagesProgress)
+	if len(toRender) > 0 {
 		if watchPages != nil {
-			go watchPages(len(t.toRender), pagesProgress)
+			go watchPages(len(toRender), pagesProgress)
 		}
 		pageSem := semaphore.NewWeighted(concurrency)
 		eg.Go(func() error {
-			for _, item := range t.toRender {
+			for _, item := range toRender {
 				if err := pageSem.Acquire(egCtx, 1); err != nil {
 					return err
 				}
@@ -97,63 +430,217 @@ func (t *Tree) Render(
 	if err := eg.Wait(); err != nil {
 		return err
 	}
-	close(assetsProgress)
-	close(pagesProgress)
+	t.elapsed = time.Since(start)
+	if err := t.saveCache(); err != nil {
+		return err
+	}
+	return t.saveState()
+}
+
+// loadCache reads the hash and cache ID of all previously rendered output, if
+// any.
+func (t *Tree) loadCache() error {
+	hashes, err := readCacheFile(filepath.Join(t.cacheDir, contentHashFile))
+	if err != nil {
+		return err
+	}
+	cacheIDs, err := readCacheFile(filepath.Join(t.cacheDir, cacheIDFile))
+	if err != nil {
+		return err
+	}
+	t.hashesMu.Lock()
+	t.hashes = hashes
+	t.cacheIDs = cacheIDs
+	t.hashesMu.Unlock()
 	return nil
 }
 
-func (t *Tree) isCached(target *resource.Resource, outputPath string) bool {
-	cachePath := filepath.Join(t.cacheDir, target.ID())
-	cachedContent, cacheErr := ioutil.ReadFile(cachePath)
-	currentContent, currentErr := ioutil.ReadFile(outputPath)
-	if cacheErr != nil || currentErr != nil {
+// readCacheFile decodes a map stored in the cache directory, returning nil if
+// the file does not exist.
+func readCacheFile(path string) (map[string]string, error) {
+	data, readErr := ioutil.ReadFile(path)
+	if errors.Is(readErr, os.ErrNotExist) {
+		return nil, nil
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
+	entries := map[string]string{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// saveCache persists the hash and cache ID of all rendered output.
+func (t *Tree) saveCache() error {
+	t.hashesMu.Lock()
+	hashes, hashesErr := json.Marshal(t.hashes)
+	cacheIDs, cacheIDsErr := json.Marshal(t.cacheIDs)
+	t.hashesMu.Unlock()
+	if hashesErr != nil {
+		return hashesErr
+	}
+	if cacheIDsErr != nil {
+		return cacheIDsErr
+	}
+	if err := ioutil.WriteFile(filepath.Join(t.cacheDir, contentHashFile), hashes, 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(t.cacheDir, cacheIDFile), cacheIDs, 0644)
+}
+
+// isUnchanged determines if the output of a resource was produced by a
+// resource with the same cache ID and still exists, so there is no need to
+// render it again.
+func (t *Tree) isUnchanged(target *resource.Resource, dest billy.Filesystem, name string) bool {
+	t.hashesMu.Lock()
+	cacheID, ok := t.cacheIDs[target.Selector.ID()]
+	t.hashesMu.Unlock()
+	if !ok || cacheID != target.ID() {
 		return false
 	}
-	// if the cached and current content match, the content is up to date
-	if bytes.Equal(cachedContent, currentContent) {
-		return true
+	_, statErr := dest.Stat(name)
+	return statErr == nil
+}
+
+// isCached determines if the output of a resource is already up to date. When
+// a cache file was found during loading, the recorded hash is all that is
+// checked. Otherwise the existing output is read and hashed for comparison.
+func (t *Tree) isCached(target *resource.Resource, dest billy.Filesystem, name string, sum string) bool {
+	t.hashesMu.Lock()
+	hashes := t.hashes
+	cached, ok := hashes[target.ID()]
+	t.hashesMu.Unlock()
+	if hashes != nil {
+		if !ok || cached != sum {
+			return false
+		}
+		_, statErr := dest.Stat(name)
+		return statErr == nil
 	}
-	// if we have an older cached copy, put it back
-	if err := ioutil.WriteFile(outputPath, cachedContent, 0644); err == nil {
-		return true
+	file, openErr := dest.Open(name)
+	if openErr != nil {
+		return false
+	}
+	defer file.Close()
+	current, readErr := ioutil.ReadAll(file)
+	if readErr != nil {
+		return false
+	}
+	return contentHash(current) == sum
+}
+
+// record stores the hash of the output produced by a resource.
+func (t *Tree) record(target *resource.Resource, sum string) {
+	t.hashesMu.Lock()
+	defer t.hashesMu.Unlock()
+	if t.hashes == nil {
+		t.hashes = map[string]string{}
 	}
-	// if writing the older cached copy failed for some reason, trigger regen
-	return false
+	t.hashes[target.ID()] = sum
+	t.recordRendered(target)
 }
 
-func (t *Tree) cache(target *resource.Resource, content []byte) error {
-	cachePath := filepath.Join(t.cacheDir, target.ID())
-	return ioutil.WriteFile(cachePath, content, 0644)
+// recordRendered notes that the output of a resource is current. It must be
+// called with hashesMu held.
+func (t *Tree) recordRendered(target *resource.Resource) {
+	if t.cacheIDs == nil {
+		t.cacheIDs = map[string]string{}
+	}
+	t.cacheIDs[target.Selector.ID()] = target.ID()
+	if t.rendered == nil {
+		t.rendered = map[string]bool{}
+	}
+	t.rendered[target.Selector.ID()] = true
 }
 
-func (t *Tree) render(_ context.Context, target *resource.Resource) error {
+func (t *Tree) render(ctx context.Context, target *resource.Resource) error {
 	// skip resources that have no output
-	if target.Href() == "" || target.Href() == "/" {
+	if !hasOutput(target) {
 		return nil
 	}
-	outputPath := filepath.Join(target.Instance().Dest.Root(), target.Href())
-	if t.isCached(target, outputPath) {
+	start := time.Now()
+	dest := target.Instance().Dest
+	name, pathErr := sanitizePath(dest.Root(), target.Href())
+	if pathErr != nil {
+		return fmt.Errorf("%s: %w", target.Manifest, pathErr)
+	}
+	output := t.outputFor(dest)
+	fsOutput, isFS := output.(*FSOutputAdapter)
+	// Every page must be rendered for the audit to be complete.
+	if isFS && t.skipUnchanged && !t.htmlAudit && t.isUnchanged(target, fsOutput.Filesystem, name) {
+		t.hashesMu.Lock()
+		t.recordRendered(target)
+		t.hashesMu.Unlock()
+		t.recordState(target, start, false, true, 0)
 		return nil
 	}
 	content, contentErr := target.Render()
 	if contentErr != nil {
 		return contentErr
 	}
-	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
-		return err
+	if t.htmlAudit {
+		t.audit(target, string(content))
 	}
 	contentBytes := []byte(content)
-	file, createErr := os.Create(outputPath)
-	if createErr != nil {
-		return createErr
+	sum := contentHash(contentBytes)
+	if err := t.openFiles.Acquire(ctx, 1); err != nil {
+		return err
 	}
-	if _, writeErr := file.Write(contentBytes); writeErr != nil {
-		return writeErr
+	defer t.openFiles.Release(1)
+	if isFS && t.isCached(target, fsOutput.Filesystem, name, sum) {
+		t.record(target, sum)
+		t.recordState(target, start, false, true, len(contentBytes))
+		return nil
 	}
-	if err := t.cache(target, contentBytes); err != nil {
+	name = filepath.ToSlash(name)
+	if err := output.Mkdir(path.Dir(name)); err != nil {
 		return err
 	}
-	return file.Close()
+	if err := output.Write(name, contentBytes); err != nil {
+		return err
+	}
+	atomic.AddInt64(&t.written, 1)
+	t.record(target, sum)
+	t.recordState(target, start, false, false, len(contentBytes))
+	return nil
+}
+
+// sanitizePath ensures href does not escape destRoot once joined to it,
+// returning the joined path relative to destRoot.
+func sanitizePath(destRoot string, href string) (string, error) {
+	root := filepath.Clean(destRoot)
+	joined := filepath.Join(root, href)
+	prefix := root
+	if !strings.HasSuffix(prefix, string(os.PathSeparator)) {
+		prefix = prefix + string(os.PathSeparator)
+	}
+	if !strings.HasPrefix(joined, prefix) {
+		return "", fmt.Errorf("path traversal detected: %s escapes %s", href, destRoot)
+	}
+	return strings.TrimPrefix(joined, prefix), nil
+}
+
+// prioritize orders resources by descending render priority so those with the
+// highest claim rendering slots first. Resources of equal priority retain
+// their order.
+func prioritize(resources []*resource.Resource) []*resource.Resource {
+	sort.SliceStable(resources, func(a, b int) bool {
+		return resources[a].Meta.RenderPriority > resources[b].Meta.RenderPriority
+	})
+	return resources
+}
+
+// hasOutput determines if a resource produces a file when rendered.
+func hasOutput(target *resource.Resource) bool {
+	return target.Href() != "" && target.Href() != "/" && target.Instance().IsPage()
+}
+
+// contentHash computes a hex encoded sha256 of the supplied content.
+func contentHash(content []byte) string {
+	digest := hash.Sum256(content)
+	return hex.EncodeToString(digest[:])
 }
 
 // assets filters an array of resources to those which are assets.
@@ -164,6 +651,7 @@ func assets(resources []*resource.Resource) []*resource.Resource {
 		if _, ok := seen[resource.Manifest]; ok {
 			continue
 		}
+		seen[resource.Manifest] = struct{}{}
 		if resource.Instance().AsAsset != nil {
 			assets = append(assets, resource)
 		}
@@ -0,0 +1,573 @@
+package render_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/tkellen/aevitas/internal/render"
+	"github.com/tkellen/aevitas/internal/testhelper"
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"go.uber.org/goleak"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingFs records how many times files are opened for reading or writing.
+type countingFs struct {
+	billy.Filesystem
+	mu      sync.Mutex
+	opened  int
+	created int
+}
+
+func (fs *countingFs) Open(filename string) (billy.File, error) {
+	fs.mu.Lock()
+	fs.opened++
+	fs.mu.Unlock()
+	return fs.Filesystem.Open(filename)
+}
+
+func (fs *countingFs) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	if flag&os.O_CREATE != 0 {
+		fs.mu.Lock()
+		fs.created++
+		fs.mu.Unlock()
+	}
+	return fs.Filesystem.OpenFile(filename, flag, perm)
+}
+
+func (fs *countingFs) reset() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.opened = 0
+	fs.created = 0
+}
+
+func testIndex(t *testing.T, dirs ...string) *manifest.Index {
+	t.Helper()
+	manifests, err := manifest.NewFromDirs(dirs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testhelper.MakeIndex(t, manifests...)
+}
+
+func testTree(t *testing.T, dest billy.Filesystem, cacheDir string) *render.Tree {
+	factory := testhelper.MakeFactory(osfs.New("../../testdata"), dest)
+	tree, err := render.NewTree("website/content/v1/domain/blog", testIndex(t, "../../testdata/blog"), factory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree.WithCacheDir(cacheDir)
+}
+
+func TestTree_RenderCache(t *testing.T) {
+	cacheDir, tempErr := ioutil.TempDir("", "aevitas-cache")
+	if tempErr != nil {
+		t.Fatal(tempErr)
+	}
+	defer os.RemoveAll(cacheDir)
+	dest := &countingFs{Filesystem: memfs.New()}
+	// A cold render has no cache file so existing output must be consulted.
+	if err := testTree(t, dest, cacheDir).Render(context.Background(), 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if dest.created != 3 {
+		t.Fatalf("expected cold render to create 3 files, created %d", dest.created)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, "content-hash.json")); err != nil {
+		t.Fatalf("expected cache file to be written: %s", err)
+	}
+	// A warm render should only consult the cache file.
+	dest.reset()
+	if err := testTree(t, dest, cacheDir).Render(context.Background(), 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if dest.opened != 0 {
+		t.Fatalf("expected warm render to read no output, read %d files", dest.opened)
+	}
+	if dest.created != 0 {
+		t.Fatalf("expected warm render to write no output, wrote %d files", dest.created)
+	}
+	state, stateErr := render.LoadState(cacheDir)
+	if stateErr != nil {
+		t.Fatal(stateErr)
+	}
+	for _, item := range state.Resources {
+		if !item.Cached {
+			t.Fatalf("expected %s to be recorded as cached", item.ID)
+		}
+	}
+	if len(state.Resources) != 3 {
+		t.Fatalf("expected state for 3 resources, got %d", len(state.Resources))
+	}
+}
+
+func TestTree_RenderUnchanged(t *testing.T) {
+	cacheDir, tempErr := ioutil.TempDir("", "aevitas-cache")
+	if tempErr != nil {
+		t.Fatal(tempErr)
+	}
+	defer os.RemoveAll(cacheDir)
+	dest := &countingFs{Filesystem: memfs.New()}
+	if err := testTree(t, dest, cacheDir).Render(context.Background(), 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Without content hashes, only the cache IDs of resources show that
+	// their output is current.
+	if err := os.Remove(filepath.Join(cacheDir, "content-hash.json")); err != nil {
+		t.Fatal(err)
+	}
+	if err := dest.Remove("2018/07/one.html"); err != nil {
+		t.Fatal(err)
+	}
+	dest.reset()
+	tree := testTree(t, dest, cacheDir).WithSkipUnchanged()
+	if err := tree.Render(context.Background(), 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Only the output that was removed is looked for.
+	if dest.opened != 1 {
+		t.Fatalf("expected unchanged resources to read no output, read %d files", dest.opened)
+	}
+	if dest.created != 1 {
+		t.Fatalf("expected only missing output to be written, wrote %d files", dest.created)
+	}
+	if stats := tree.Stats(); stats.Written != 1 {
+		t.Fatalf("expected %d page written, got %d", 1, stats.Written)
+	}
+	state, stateErr := render.LoadState(cacheDir)
+	if stateErr != nil {
+		t.Fatal(stateErr)
+	}
+	for _, item := range state.Resources {
+		if expected := item.Href != "/2018/07/one.html"; item.Cached != expected {
+			t.Fatalf("expected %s to have cached %v, got %v", item.Href, expected, item.Cached)
+		}
+	}
+}
+
+func TestTree_RenderNeighbourInserted(t *testing.T) {
+	cacheDir, tempErr := ioutil.TempDir("", "aevitas-cache")
+	if tempErr != nil {
+		t.Fatal(tempErr)
+	}
+	defer os.RemoveAll(cacheDir)
+	dest := memfs.New()
+	post := func(name string, day int) *manifest.Manifest {
+		m := testhelper.MakeManifest(t, "website/content/v1/post/"+name, fmt.Sprintf(`{"href":"%s.html","publishAt":{"year":2020,"month":1,"day":%d}}`, name, day), "")
+		m.Body = "{{ with .Next }}{{ .Href }}{{ end }}"
+		return m
+	}
+	manifests := []*manifest.Manifest{
+		// The inserted post is only reachable through Next, so no relation
+		// of the rendered pages changes.
+		testhelper.MakeManifest(t, "website/content/v1/domain/site", `{"href":"/index.html","children":[{"selector":"website/content/v1/post/one"},{"selector":"website/content/v1/post/three"}]}`, ""),
+		post("one", 1),
+		post("three", 3),
+	}
+	for _, inserted := range []*manifest.Manifest{nil, post("two", 2)} {
+		if inserted != nil {
+			manifests = append(manifests, inserted)
+		}
+		factory := testhelper.MakeFactory(memfs.New(), dest)
+		tree, err := render.NewTree("website/content/v1/domain/site", testhelper.MakeIndex(t, manifests...), factory)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.WithCacheDir(cacheDir).Render(context.Background(), 1, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file, openErr := dest.Open("one.html")
+	if openErr != nil {
+		t.Fatal(openErr)
+	}
+	defer file.Close()
+	actual, _ := ioutil.ReadAll(file)
+	if expected := "two.html"; !strings.Contains(string(actual), expected) {
+		t.Fatalf("expected %s, got %s", expected, actual)
+	}
+}
+
+func TestTree_RenderErrorClosesProgress(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+	cacheDir, tempErr := ioutil.TempDir("", "aevitas-cache")
+	if tempErr != nil {
+		t.Fatal(tempErr)
+	}
+	defer os.RemoveAll(cacheDir)
+	manifests := []*manifest.Manifest{
+		testhelper.MakeManifest(t, "website/content/v1/domain/site", `{"href":"/index.html","children":[{"selector":"website/content/v1/post/*"}]}`, ""),
+	}
+	for _, name := range []string{"broken", "one", "two", "three"} {
+		post := testhelper.MakeManifest(t, "website/content/v1/post/"+name, `{"href":"`+name+`.html"}`, "")
+		post.Body = "{{ .Missing }}"
+		manifests = append(manifests, post)
+	}
+	factory := testhelper.MakeFactory(memfs.New(), memfs.New())
+	tree, err := render.NewTree("website/content/v1/domain/site", testhelper.MakeIndex(t, manifests...), factory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := make(chan struct{})
+	watchPages := func(count int, progress <-chan struct{}) {
+		for range progress {
+		}
+		close(closed)
+	}
+	if err := tree.WithCacheDir(cacheDir).Render(context.Background(), 1, nil, watchPages); err == nil {
+		t.Fatal("expected render to fail")
+	}
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected progress channel to be closed")
+	}
+}
+
+func TestTree_RenderPriority(t *testing.T) {
+	cacheDir, tempErr := ioutil.TempDir("", "aevitas-cache")
+	if tempErr != nil {
+		t.Fatal(tempErr)
+	}
+	defer os.RemoveAll(cacheDir)
+	manifests := []*manifest.Manifest{
+		testhelper.MakeManifest(t, "website/content/v1/domain/site", `{"href":"/index.html","children":[{"selector":"website/content/v1/post/*"}]}`, ""),
+	}
+	priority := map[string]bool{}
+	for idx, name := range []string{"one", "two", "three", "four", "five", "six"} {
+		meta := `{"href":"` + name + `.html"}`
+		if idx%2 == 1 {
+			meta = `{"href":"` + name + `.html","renderPriority":10}`
+			priority["website/content/v1/post/"+name] = true
+		}
+		manifests = append(manifests, testhelper.MakeManifest(t, "website/content/v1/post/"+name, meta, ""))
+	}
+	factory := testhelper.MakeFactory(memfs.New(), memfs.New())
+	tree, err := render.NewTree("website/content/v1/domain/site", testhelper.MakeIndex(t, manifests...), factory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := tree.HighPriorityCount(); count != len(priority) {
+		t.Fatalf("expected %d high priority resources, got %d", len(priority), count)
+	}
+	if err := tree.WithCacheDir(cacheDir).Render(context.Background(), 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	state, stateErr := render.LoadState(cacheDir)
+	if stateErr != nil {
+		t.Fatal(stateErr)
+	}
+	// Resources are recorded in the order they complete.
+	for idx, item := range state.Resources {
+		if expected := idx < len(priority); priority[item.ID] != expected {
+			t.Fatalf("expected priority resources to complete first, got %s at %d", item.ID, idx)
+		}
+	}
+}
+
+func TestTree_RenderPathTraversal(t *testing.T) {
+	cacheDir, tempErr := ioutil.TempDir("", "aevitas-cache")
+	if tempErr != nil {
+		t.Fatal(tempErr)
+	}
+	defer os.RemoveAll(cacheDir)
+	outputDir, tempErr := ioutil.TempDir("", "aevitas-output")
+	if tempErr != nil {
+		t.Fatal(tempErr)
+	}
+	defer os.RemoveAll(outputDir)
+	manifests := []*manifest.Manifest{
+		testhelper.MakeManifest(t, "website/content/v1/domain/site", `{"href":"/index.html","children":[{"selector":"website/content/v1/post/*"}]}`, ""),
+		testhelper.MakeManifest(t, "website/content/v1/post/secret", `{"href":"../../secret"}`, ""),
+	}
+	factory := testhelper.MakeFactory(memfs.New(), osfs.New(outputDir))
+	tree, err := render.NewTree("website/content/v1/domain/site", testhelper.MakeIndex(t, manifests...), factory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tree.WithCacheDir(cacheDir).Render(context.Background(), 1, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "path traversal") {
+		t.Fatalf("expected path traversal error, got %v", err)
+	}
+	if _, statErr := os.Stat(filepath.Join(outputDir, "../../secret")); statErr == nil {
+		t.Fatal("expected nothing to be written outside of the output directory")
+	}
+}
+
+func TestTree_PurgeCache(t *testing.T) {
+	cacheDir, tempErr := ioutil.TempDir("", "aevitas-cache")
+	if tempErr != nil {
+		t.Fatal(tempErr)
+	}
+	defer os.RemoveAll(cacheDir)
+	dest := memfs.New()
+	tree := testTree(t, dest, cacheDir)
+	if err := tree.Render(context.Background(), 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.PurgeCache(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(cacheDir); !os.IsNotExist(err) {
+		t.Fatal("expected cache directory to be removed")
+	}
+	for _, href := range []string{"/index.html", "/2018/07/one.html", "/2018/08/two.html"} {
+		if _, err := dest.Stat(href); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed", href)
+		}
+	}
+}
+
+// renderOutput renders the tree and collects its output by href.
+func renderOutput(t *testing.T, tree *render.Tree, dest billy.Filesystem, hrefs []string) map[string]string {
+	t.Helper()
+	if err := tree.Render(context.Background(), 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	output := map[string]string{}
+	for _, href := range hrefs {
+		content, err := readFile(dest, href)
+		if err != nil {
+			t.Fatal(err)
+		}
+		output[href] = content
+	}
+	return output
+}
+
+func TestTree_Reset(t *testing.T) {
+	cacheDir, tempErr := ioutil.TempDir("", "aevitas-cache")
+	if tempErr != nil {
+		t.Fatal(tempErr)
+	}
+	defer os.RemoveAll(cacheDir)
+	hrefs := []string{"/index.html", "/2018/07/one.html", "/2018/08/two.html"}
+	dest := memfs.New()
+	tree := testTree(t, dest, cacheDir)
+	first := renderOutput(t, tree, dest, hrefs)
+	if err := tree.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	for _, href := range hrefs {
+		if _, err := dest.Stat(href); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed", href)
+		}
+	}
+	second := renderOutput(t, tree, dest, hrefs)
+	if !reflect.DeepEqual(first, second) {
+		t.Fatalf("expected %v, got %v", first, second)
+	}
+	state, stateErr := render.LoadState(cacheDir)
+	if stateErr != nil {
+		t.Fatal(stateErr)
+	}
+	if stats := tree.Stats(); stats.Written != len(hrefs) {
+		t.Fatalf("expected %d pages written after reset, got %d", len(hrefs), stats.Written)
+	}
+	for _, item := range state.Resources {
+		if item.Cached {
+			t.Fatalf("expected %s to miss the cache after reset", item.ID)
+		}
+	}
+}
+
+func TestTree_ResetPage(t *testing.T) {
+	cacheDir, tempErr := ioutil.TempDir("", "aevitas-cache")
+	if tempErr != nil {
+		t.Fatal(tempErr)
+	}
+	defer os.RemoveAll(cacheDir)
+	dest := memfs.New()
+	tree := testTree(t, dest, cacheDir)
+	if err := tree.ResetPage("website/content/v1/post/one"); err == nil {
+		t.Fatal("expected error resetting a page that was not rendered")
+	}
+	if err := tree.Render(context.Background(), 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.ResetPage("website/content/v1/post/one"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dest.Stat("/2018/07/one.html"); !os.IsNotExist(err) {
+		t.Fatal("expected reset page to be removed")
+	}
+	if _, err := dest.Stat("/2018/08/two.html"); err != nil {
+		t.Fatalf("expected other pages to remain: %s", err)
+	}
+	if err := tree.Render(context.Background(), 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if stats := tree.Stats(); stats.Written != 1 {
+		t.Fatalf("expected only the reset page to be written, got %d", stats.Written)
+	}
+}
+
+func TestTree_FileMode(t *testing.T) {
+	table := map[string]struct {
+		fileMode os.FileMode
+		dirMode  os.FileMode
+		expected os.FileMode
+		dir      os.FileMode
+	}{
+		"default":    {expected: 0644, dir: 0755},
+		"configured": {fileMode: 0600, dirMode: 0700, expected: 0600, dir: 0700},
+	}
+	for name, test := range table {
+		// osfs ignores the permissions of directories it creates.
+		for destName, dest := range map[string]struct {
+			new     func(string) billy.Filesystem
+			dirMode bool
+		}{
+			"safe writer": {new: func(root string) billy.Filesystem { return render.NewOSFilesystem(root) }, dirMode: true},
+			"osfs":        {new: osfs.New},
+		} {
+			test, dest := test, dest
+			t.Run(name+" "+destName, func(t *testing.T) {
+				output, tempErr := ioutil.TempDir("", "aevitas-output")
+				if tempErr != nil {
+					t.Fatal(tempErr)
+				}
+				defer os.RemoveAll(output)
+				tree := testTree(t, dest.new(output), filepath.Join(output, ".cache"))
+				if test.fileMode != 0 {
+					tree.WithFileMode(test.fileMode).WithDirMode(test.dirMode)
+				}
+				if err := tree.Render(context.Background(), 1, nil, nil); err != nil {
+					t.Fatal(err)
+				}
+				expectedModes := map[string]os.FileMode{
+					"index.html":       test.expected,
+					"2018/07/one.html": test.expected,
+				}
+				if dest.dirMode {
+					expectedModes["2018/07"] = test.dir | os.ModeDir
+				}
+				for path, expected := range expectedModes {
+					info, err := os.Stat(filepath.Join(output, path))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if actual := info.Mode(); actual != expected {
+						t.Fatalf("%s: expected mode %s, got %s", path, expected, actual)
+					}
+				}
+			})
+		}
+	}
+}
+
+// openLimitFs records the most files it has had open at once. Writes are
+// slowed down so renders overlap. Calls to the wrapped filesystem are
+// serialized as memfs is not safe for concurrent use.
+type openLimitFs struct {
+	billy.Filesystem
+	fsMu    sync.Mutex
+	mu      sync.Mutex
+	open    int
+	maxOpen int
+}
+
+func (fs *openLimitFs) MkdirAll(filename string, perm os.FileMode) error {
+	fs.fsMu.Lock()
+	defer fs.fsMu.Unlock()
+	return fs.Filesystem.MkdirAll(filename, perm)
+}
+
+func (fs *openLimitFs) Rename(from string, to string) error {
+	fs.fsMu.Lock()
+	defer fs.fsMu.Unlock()
+	return fs.Filesystem.Rename(from, to)
+}
+
+func (fs *openLimitFs) Stat(filename string) (os.FileInfo, error) {
+	fs.fsMu.Lock()
+	defer fs.fsMu.Unlock()
+	return fs.Filesystem.Stat(filename)
+}
+
+type openLimitFile struct {
+	billy.File
+	fs *openLimitFs
+}
+
+func (f *openLimitFile) Write(p []byte) (int, error) {
+	time.Sleep(5 * time.Millisecond)
+	return f.File.Write(p)
+}
+
+func (f *openLimitFile) Close() error {
+	f.fs.mu.Lock()
+	f.fs.open--
+	f.fs.mu.Unlock()
+	return f.File.Close()
+}
+
+func (fs *openLimitFs) Open(filename string) (billy.File, error) {
+	return fs.OpenFile(filename, os.O_RDONLY, 0)
+}
+
+func (fs *openLimitFs) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	fs.fsMu.Lock()
+	file, err := fs.Filesystem.OpenFile(filename, flag, perm)
+	fs.fsMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	fs.mu.Lock()
+	fs.open++
+	if fs.open > fs.maxOpen {
+		fs.maxOpen = fs.open
+	}
+	fs.mu.Unlock()
+	return &openLimitFile{File: file, fs: fs}, nil
+}
+
+func TestTree_WithMaxOpenFiles(t *testing.T) {
+	manifests := []*manifest.Manifest{
+		testhelper.MakeManifest(t, "website/content/v1/domain/site", `{"href":"/index.html","children":[{"selector":"website/content/v1/post/*"}]}`, ""),
+	}
+	for idx := 0; idx < 9; idx++ {
+		name := fmt.Sprintf("post%d", idx)
+		manifests = append(manifests, testhelper.MakeManifest(t, "website/content/v1/post/"+name, `{"href":"`+name+`.html"}`, ""))
+	}
+	table := map[string]struct {
+		limit    int
+		expected int
+	}{
+		"limited":  {limit: 2, expected: 2},
+		"zero":     {limit: 0, expected: 1},
+		"negative": {limit: -1, expected: 1},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			dest := &openLimitFs{Filesystem: memfs.New()}
+			tree, err := render.NewTree("website/content/v1/domain/site", testhelper.MakeIndex(t, manifests...), testhelper.MakeFactory(memfs.New(), dest))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// A limit that admits no files would block until the deadline.
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := tree.WithCacheDir(t.TempDir()).WithMaxOpenFiles(test.limit).Render(ctx, 10, nil, nil); err != nil {
+				t.Fatal(err)
+			}
+			if stats := tree.Stats(); stats.Written != 10 {
+				t.Fatalf("expected %d pages written, got %d", 10, stats.Written)
+			}
+			if dest.maxOpen > test.expected {
+				t.Fatalf("expected at most %d open files, got %d", test.expected, dest.maxOpen)
+			}
+		})
+	}
+}
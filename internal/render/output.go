@@ -0,0 +1,173 @@
+package render
+
+import (
+	"archive/tar"
+	"github.com/go-git/go-billy/v5"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// OutputAdapter receives the rendered pages of a tree. Paths are relative to
+// the root of the output and use forward slashes. Adapters must be safe for
+// concurrent use.
+type OutputAdapter interface {
+	Write(path string, content []byte) error
+	Mkdir(path string) error
+}
+
+// FSOutputAdapter writes pages to a filesystem atomically. It is used by
+// default, writing to the destination of each resource.
+type FSOutputAdapter struct {
+	Filesystem billy.Filesystem
+	FileMode   os.FileMode
+	DirMode    os.FileMode
+}
+
+// NewFSOutputAdapter does just what you think it does.
+func NewFSOutputAdapter(fs billy.Filesystem) *FSOutputAdapter {
+	return &FSOutputAdapter{Filesystem: fs, FileMode: 0644, DirMode: 0755}
+}
+
+// Write replaces the file at path with content.
+func (a *FSOutputAdapter) Write(path string, content []byte) error {
+	return WriteAtomic(a.Filesystem, path, content, a.FileMode)
+}
+
+// Mkdir creates path and any missing parents.
+func (a *FSOutputAdapter) Mkdir(path string) error {
+	return a.Filesystem.MkdirAll(path, a.DirMode)
+}
+
+// MemOutputAdapter holds pages in memory.
+type MemOutputAdapter struct {
+	mu    sync.Mutex
+	files map[string][]byte
+	dirs  map[string]bool
+}
+
+// NewMemOutputAdapter does just what you think it does.
+func NewMemOutputAdapter() *MemOutputAdapter {
+	return &MemOutputAdapter{files: map[string][]byte{}, dirs: map[string]bool{}}
+}
+
+// Write stores a copy of content at path.
+func (a *MemOutputAdapter) Write(path string, content []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.files[path] = append([]byte{}, content...)
+	return nil
+}
+
+// Mkdir records that path was created.
+func (a *MemOutputAdapter) Mkdir(path string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.dirs[path] = true
+	return nil
+}
+
+// File returns the content written to path, if any.
+func (a *MemOutputAdapter) File(path string) ([]byte, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	content, ok := a.files[path]
+	return content, ok
+}
+
+// Paths returns the sorted path of every file written.
+func (a *MemOutputAdapter) Paths() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	paths := make([]string, 0, len(a.files))
+	for path := range a.files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// TarOutputAdapter writes pages to a tar archive. Closing the writer is left
+// to the caller once rendering is complete.
+type TarOutputAdapter struct {
+	FileMode os.FileMode
+	DirMode  os.FileMode
+	prefix   string
+	archive  *tarArchive
+}
+
+// tarArchive is shared by every adapter writing to the same archive.
+type tarArchive struct {
+	mu      sync.Mutex
+	writer  *tar.Writer
+	dirs    map[string]bool
+	modTime time.Time
+}
+
+// NewTarOutputAdapter creates an adapter writing to w. Every entry has the
+// time the adapter was created as its modification time.
+func NewTarOutputAdapter(w *tar.Writer) *TarOutputAdapter {
+	return &TarOutputAdapter{
+		FileMode: 0644,
+		DirMode:  0755,
+		archive:  &tarArchive{writer: w, dirs: map[string]bool{}, modTime: time.Now()},
+	}
+}
+
+// Within returns an adapter writing to the same archive beneath dir.
+func (a *TarOutputAdapter) Within(dir string) *TarOutputAdapter {
+	within := *a
+	within.prefix = path.Join(a.prefix, dir)
+	return &within
+}
+
+// Write adds a file with content to the archive.
+func (a *TarOutputAdapter) Write(name string, content []byte) error {
+	name = path.Join(a.prefix, name)
+	a.archive.mu.Lock()
+	defer a.archive.mu.Unlock()
+	if err := a.mkdir(path.Dir(name)); err != nil {
+		return err
+	}
+	if err := a.archive.writer.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     int64(a.FileMode.Perm()),
+		Size:     int64(len(content)),
+		ModTime:  a.archive.modTime,
+	}); err != nil {
+		return err
+	}
+	_, err := a.archive.writer.Write(content)
+	return err
+}
+
+// Mkdir adds path and any parents not yet in the archive.
+func (a *TarOutputAdapter) Mkdir(name string) error {
+	a.archive.mu.Lock()
+	defer a.archive.mu.Unlock()
+	return a.mkdir(path.Join(a.prefix, name))
+}
+
+// mkdir adds directories to the archive. It must be called with the lock of
+// the archive held.
+func (a *TarOutputAdapter) mkdir(name string) error {
+	if name == "." || name == "/" || a.archive.dirs[name] {
+		return nil
+	}
+	if err := a.mkdir(path.Dir(name)); err != nil {
+		return err
+	}
+	if err := a.archive.writer.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     int64(a.DirMode.Perm()),
+		ModTime:  a.archive.modTime,
+	}); err != nil {
+		return err
+	}
+	a.archive.dirs[name] = true
+	return nil
+}
@@ -0,0 +1,91 @@
+package render_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/tkellen/aevitas/internal/render"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestTree_MemOutputAdapter(t *testing.T) {
+	cacheDir, tempErr := ioutil.TempDir("", "aevitas-cache")
+	if tempErr != nil {
+		t.Fatal(tempErr)
+	}
+	defer os.RemoveAll(cacheDir)
+	dest := memfs.New()
+	output := render.NewMemOutputAdapter()
+	tree := testTree(t, dest, cacheDir).WithOutputAdapter(output)
+	if err := tree.Render(context.Background(), 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"2018/07/one.html", "2018/08/two.html", "index.html"}
+	if actual := output.Paths(); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	if content, _ := output.File("index.html"); len(content) == 0 {
+		t.Fatal("expected index.html to have content")
+	}
+	if _, err := dest.Stat("index.html"); !os.IsNotExist(err) {
+		t.Fatal("expected nothing to be written to the destination")
+	}
+	// Adapters other than the filesystem receive every page on each render.
+	second := render.NewMemOutputAdapter()
+	if err := tree.WithOutputAdapter(second).Render(context.Background(), 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if actual := second.Paths(); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestTree_TarOutputAdapter(t *testing.T) {
+	cacheDir, tempErr := ioutil.TempDir("", "aevitas-cache")
+	if tempErr != nil {
+		t.Fatal(tempErr)
+	}
+	defer os.RemoveAll(cacheDir)
+	var buf bytes.Buffer
+	archive := tar.NewWriter(&buf)
+	output := render.NewTarOutputAdapter(archive).Within("blog")
+	if err := testTree(t, memfs.New(), cacheDir).WithOutputAdapter(output).Render(context.Background(), 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	entries := map[string]byte{}
+	reader := tar.NewReader(&buf)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := entries[header.Name]; ok {
+			t.Fatalf("expected %s to be archived once", header.Name)
+		}
+		entries[header.Name] = header.Typeflag
+	}
+	expected := map[string]byte{
+		"blog/":                 tar.TypeDir,
+		"blog/2018/":            tar.TypeDir,
+		"blog/2018/07/":         tar.TypeDir,
+		"blog/2018/08/":         tar.TypeDir,
+		"blog/index.html":       tar.TypeReg,
+		"blog/2018/07/one.html": tar.TypeReg,
+		"blog/2018/08/two.html": tar.TypeReg,
+	}
+	if !reflect.DeepEqual(expected, entries) {
+		t.Fatalf("expected %v, got %v", expected, entries)
+	}
+}
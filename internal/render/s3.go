@@ -0,0 +1,147 @@
+package render
+
+import (
+	"bytes"
+	"context"
+	"github.com/go-git/go-billy/v5"
+	"github.com/minio/minio-go/v7"
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+	"mime"
+	"path"
+	"strings"
+)
+
+// uploadConcurrency limits how many files are uploaded at once.
+const uploadConcurrency = 20
+
+// defaultCacheControl is used for files whose extension has no entry in the
+// cache control map of the tree.
+const defaultCacheControl = "max-age=31536000, immutable"
+
+// DefaultCacheControl keeps pages fresh while letting assets, which change
+// names when their content does, be cached indefinitely.
+var DefaultCacheControl = map[string]string{
+	".html": "max-age=300",
+}
+
+// WithCacheControl controls the Cache-Control header of uploaded files by
+// extension.
+func (t *Tree) WithCacheControl(cacheControl map[string]string) *Tree {
+	t.cacheControl = cacheControl
+	return t
+}
+
+// WriteToS3 uploads every file in the output of the tree to a bucket, with
+// keys relative to the supplied prefix.
+func (t *Tree) WriteToS3(ctx context.Context, cfg manifest.S3Config, keyPrefix string) error {
+	client, clientErr := cfg.Client()
+	if clientErr != nil {
+		return clientErr
+	}
+	dest := t.Root.Instance().Dest
+	files, walkErr := walkFiles(dest, "/")
+	if walkErr != nil {
+		return walkErr
+	}
+	sem := semaphore.NewWeighted(uploadConcurrency)
+	eg, egCtx := errgroup.WithContext(ctx)
+	for _, name := range files {
+		name := name
+		if err := sem.Acquire(egCtx, 1); err != nil {
+			break
+		}
+		eg.Go(func() error {
+			defer sem.Release(1)
+			return t.upload(egCtx, client, cfg.Bucket, dest, name, strings.TrimPrefix(path.Join(keyPrefix, name), "/"))
+		})
+	}
+	return eg.Wait()
+}
+
+func (t *Tree) upload(ctx context.Context, client *minio.Client, bucket string, dest billy.Filesystem, name string, key string) error {
+	info, statErr := dest.Stat(name)
+	if statErr != nil {
+		return statErr
+	}
+	file, openErr := dest.Open(name)
+	if openErr != nil {
+		return openErr
+	}
+	defer file.Close()
+	_, err := client.PutObject(ctx, bucket, key, file, info.Size(), putOptions(t.cacheControl, name))
+	return err
+}
+
+// putOptions sets the headers of an uploaded file by its extension.
+func putOptions(cacheControl map[string]string, name string) minio.PutObjectOptions {
+	ext := path.Ext(name)
+	control, ok := cacheControl[ext]
+	if !ok {
+		control = defaultCacheControl
+	}
+	contentType := mime.TypeByExtension(ext)
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return minio.PutObjectOptions{ContentType: contentType, CacheControl: control}
+}
+
+// S3OutputAdapter uploads pages to a bucket as they are rendered, with keys
+// relative to a prefix.
+type S3OutputAdapter struct {
+	ctx          context.Context
+	client       *minio.Client
+	bucket       string
+	keyPrefix    string
+	cacheControl map[string]string
+}
+
+// NewS3OutputAdapter creates an adapter uploading to the bucket of cfg. Files
+// are given a Cache-Control header by extension, as they are by WriteToS3.
+func NewS3OutputAdapter(ctx context.Context, cfg manifest.S3Config, keyPrefix string, cacheControl map[string]string) (*S3OutputAdapter, error) {
+	client, err := cfg.Client()
+	if err != nil {
+		return nil, err
+	}
+	return &S3OutputAdapter{
+		ctx:          ctx,
+		client:       client,
+		bucket:       cfg.Bucket,
+		keyPrefix:    keyPrefix,
+		cacheControl: cacheControl,
+	}, nil
+}
+
+// Write uploads content to the key for path.
+func (a *S3OutputAdapter) Write(name string, content []byte) error {
+	key := strings.TrimPrefix(path.Join(a.keyPrefix, name), "/")
+	_, err := a.client.PutObject(a.ctx, a.bucket, key, bytes.NewReader(content), int64(len(content)), putOptions(a.cacheControl, name))
+	return err
+}
+
+// Mkdir does nothing as buckets have no directories.
+func (a *S3OutputAdapter) Mkdir(string) error { return nil }
+
+// walkFiles lists the path of every file within a directory of a filesystem.
+func walkFiles(fs billy.Filesystem, dir string) ([]string, error) {
+	entries, err := fs.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		name := path.Join(dir, entry.Name())
+		if !entry.IsDir() {
+			files = append(files, name)
+			continue
+		}
+		nested, err := walkFiles(fs, name)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, nested...)
+	}
+	return files, nil
+}
@@ -0,0 +1,121 @@
+package render_test
+
+import (
+	"context"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/tkellen/aevitas/internal/render"
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type upload struct {
+	contentType  string
+	cacheControl string
+}
+
+// s3Server mocks the S3 api for uploading objects, recording the headers of
+// everything it receives.
+func s3Server(t *testing.T, bucket string) (*httptest.Server, map[string]upload, *sync.Mutex) {
+	var mu sync.Mutex
+	uploads := map[string]upload{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		ioutil.ReadAll(r.Body)
+		mu.Lock()
+		uploads[strings.TrimPrefix(r.URL.Path, "/"+bucket+"/")] = upload{
+			contentType:  r.Header.Get("Content-Type"),
+			cacheControl: r.Header.Get("Cache-Control"),
+		}
+		mu.Unlock()
+		w.Header().Set("ETag", `"etag"`)
+	}))
+	t.Cleanup(server.Close)
+	return server, uploads, &mu
+}
+
+func TestTree_WriteToS3(t *testing.T) {
+	cacheDir, tempErr := ioutil.TempDir("", "aevitas-cache")
+	if tempErr != nil {
+		t.Fatal(tempErr)
+	}
+	defer os.RemoveAll(cacheDir)
+	dest := memfs.New()
+	tree := testTree(t, dest, cacheDir)
+	if err := tree.Render(context.Background(), 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := util.WriteFile(dest, "/css/site.css", []byte("a{color:red}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server, uploads, mu := s3Server(t, "site")
+	cfg := manifest.S3Config{
+		Endpoint: strings.TrimPrefix(server.URL, "http://"),
+		Bucket:   "site",
+		Region:   "us-east-1",
+	}
+	if err := tree.WriteToS3(context.Background(), cfg, "www"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	expected := map[string]upload{
+		"www/index.html":       {"text/html; charset=utf-8", "max-age=300"},
+		"www/2018/07/one.html": {"text/html; charset=utf-8", "max-age=300"},
+		"www/2018/08/two.html": {"text/html; charset=utf-8", "max-age=300"},
+		"www/css/site.css":     {"text/css; charset=utf-8", "max-age=31536000, immutable"},
+	}
+	for key, headers := range expected {
+		actual, ok := uploads[key]
+		if !ok {
+			t.Fatalf("expected %s to be uploaded, got %v", key, uploads)
+		}
+		if actual != headers {
+			t.Fatalf("%s: expected %v, got %v", key, headers, actual)
+		}
+	}
+	if len(uploads) != len(expected) {
+		t.Fatalf("expected %d uploads, got %v", len(expected), uploads)
+	}
+}
+
+func TestTree_S3OutputAdapter(t *testing.T) {
+	cacheDir, tempErr := ioutil.TempDir("", "aevitas-cache")
+	if tempErr != nil {
+		t.Fatal(tempErr)
+	}
+	defer os.RemoveAll(cacheDir)
+	server, uploads, mu := s3Server(t, "site")
+	cfg := manifest.S3Config{
+		Endpoint: strings.TrimPrefix(server.URL, "http://"),
+		Bucket:   "site",
+		Region:   "us-east-1",
+	}
+	output, err := render.NewS3OutputAdapter(context.Background(), cfg, "www", render.DefaultCacheControl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := memfs.New()
+	if err := testTree(t, dest, cacheDir).WithOutputAdapter(output).Render(context.Background(), 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, key := range []string{"www/index.html", "www/2018/07/one.html", "www/2018/08/two.html"} {
+		if actual := uploads[key]; actual != (upload{"text/html; charset=utf-8", "max-age=300"}) {
+			t.Fatalf("%s: expected html upload, got %v", key, actual)
+		}
+	}
+	if len(uploads) != 3 {
+		t.Fatalf("expected %d uploads, got %v", 3, uploads)
+	}
+}
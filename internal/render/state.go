@@ -0,0 +1,76 @@
+package render
+
+import (
+	json "github.com/json-iterator/go"
+	"github.com/tkellen/aevitas/pkg/resource"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+)
+
+// renderStateFile is the name of the file within the cache directory that
+// records details about how each resource was rendered on the last render.
+const renderStateFile = "render-state.json"
+
+// State describes the outcome of rendering every resource in a tree.
+type State struct {
+	Target    string
+	Elapsed   time.Duration
+	Resources []*ResourceState
+}
+
+// ResourceState describes the outcome of rendering a single resource.
+type ResourceState struct {
+	ID    string
+	KGV   string
+	Href  string
+	Asset bool
+	// Cached is true when a page was already up to date and not written.
+	Cached   bool
+	Duration time.Duration
+	// Size is the number of bytes of page output. It is zero for assets.
+	Size int
+}
+
+// LoadState reads the state of the last render from a cache directory.
+func LoadState(cacheDir string) (*State, error) {
+	data, err := ioutil.ReadFile(filepath.Join(cacheDir, renderStateFile))
+	if err != nil {
+		return nil, err
+	}
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// recordState notes how a resource was rendered.
+func (t *Tree) recordState(target *resource.Resource, start time.Time, asset bool, cached bool, size int) {
+	t.stateMu.Lock()
+	defer t.stateMu.Unlock()
+	t.state = append(t.state, &ResourceState{
+		ID:       target.Selector.ID(),
+		KGV:      target.Selector.KGV,
+		Href:     target.Href(),
+		Asset:    asset,
+		Cached:   cached,
+		Duration: time.Since(start),
+		Size:     size,
+	})
+}
+
+// saveState persists the state of the most recent render.
+func (t *Tree) saveState() error {
+	t.stateMu.Lock()
+	data, err := json.Marshal(&State{
+		Target:    t.target,
+		Elapsed:   t.elapsed,
+		Resources: t.state,
+	})
+	t.stateMu.Unlock()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(t.cacheDir, renderStateFile), data, 0644)
+}
@@ -0,0 +1,158 @@
+package render
+
+import (
+	"context"
+	"github.com/fsnotify/fsnotify"
+	"github.com/tkellen/aevitas/pkg/resource"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// watchDebounce is how long to wait for additional changes before rebuilding.
+// Editors frequently produce several events for a single save.
+const watchDebounce = 200 * time.Millisecond
+
+// watchExtensions are the file types that contain manifests.
+var watchExtensions = map[string]struct{}{
+	".json": {},
+	".yml":  {},
+	".md":   {},
+	".html": {},
+}
+
+// Watch monitors the supplied directories for changes to manifests. When any
+// occur, the changed files are reloaded, the tree is rebuilt and the resources
+// affected by the change are rendered. A resource is affected when its ID
+// changes, which happens when it or anything it depends on (e.g. a template
+// it is rendered with) changes. The rebuilt tree is supplied to onChange and
+// becomes the basis for subsequent changes. Watch blocks until the context is
+// cancelled.
+func (t *Tree) Watch(ctx context.Context, dirs []string, onChange func(rebuilt *Tree, err error)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	for _, dir := range dirs {
+		if err := watchDir(watcher, dir); err != nil {
+			return err
+		}
+	}
+	current := t
+	pending := map[string]struct{}{}
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			return err
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			// Directories created after watching started must be watched too.
+			if event.Op&fsnotify.Create != 0 {
+				if info, statErr := os.Stat(event.Name); statErr == nil && info.IsDir() {
+					if err := watchDir(watcher, event.Name); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			if _, ok := watchExtensions[filepath.Ext(event.Name)]; !ok {
+				continue
+			}
+			if strings.HasPrefix(filepath.Base(event.Name), ".") {
+				continue
+			}
+			pending[filepath.Clean(event.Name)] = struct{}{}
+			debounce = time.After(watchDebounce)
+		case <-debounce:
+			debounce = nil
+			var changed []string
+			for path := range pending {
+				changed = append(changed, path)
+			}
+			pending = map[string]struct{}{}
+			rebuilt, rebuildErr := current.rebuild(ctx, changed)
+			if rebuildErr == nil {
+				current = rebuilt
+			}
+			onChange(rebuilt, rebuildErr)
+		}
+	}
+}
+
+// rebuild produces a new tree from the current index with the changed files
+// reloaded and renders every resource whose ID differs from this tree.
+func (t *Tree) rebuild(ctx context.Context, changed []string) (*Tree, error) {
+	index, reloadErr := t.index.Reload(changed...)
+	if reloadErr != nil {
+		return nil, reloadErr
+	}
+	rebuilt, newErr := NewTree(t.target, index, t.factory)
+	if newErr != nil {
+		return nil, newErr
+	}
+	rebuilt.copySettings(t)
+	previous := map[string]struct{}{}
+	for _, item := range append(t.toRender, t.assets...) {
+		previous[item.ID()] = struct{}{}
+	}
+	if err := rebuilt.renderResources(
+		ctx,
+		int64(runtime.NumCPU()),
+		affected(rebuilt.assets, previous),
+		affected(rebuilt.toRender, previous),
+		nil,
+		nil,
+	); err != nil {
+		return nil, err
+	}
+	return rebuilt, nil
+}
+
+// copySettings applies everything configured on other by its With methods to
+// this tree.
+func (t *Tree) copySettings(other *Tree) {
+	t.cacheDir = other.cacheDir
+	t.skipUnchanged = other.skipUnchanged
+	t.cacheControl = other.cacheControl
+	t.fileMode = other.fileMode
+	t.dirMode = other.dirMode
+	t.openFiles = other.openFiles
+	t.output = other.output
+	t.htmlAudit = other.htmlAudit
+}
+
+// affected filters resources to those whose ID was not previously seen.
+func affected(resources []*resource.Resource, previous map[string]struct{}) []*resource.Resource {
+	var changed []*resource.Resource
+	for _, item := range resources {
+		if _, ok := previous[item.ID()]; !ok {
+			changed = append(changed, item)
+		}
+	}
+	return changed
+}
+
+// watchDir watches a directory and all of its subdirectories, skipping those
+// that are hidden just as manifest loading does.
+func watchDir(watcher *fsnotify.Watcher, root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return err
+		}
+		if path != root && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		return watcher.Add(path)
+	})
+}
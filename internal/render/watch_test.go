@@ -0,0 +1,209 @@
+package render_test
+
+import (
+	"context"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/tkellen/aevitas/internal/render"
+	"github.com/tkellen/aevitas/internal/testhelper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readFile(fs billy.Filesystem, name string) (string, error) {
+	file, err := fs.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	content, err := ioutil.ReadAll(file)
+	return string(content), err
+}
+
+func replaceIn(t *testing.T, file string, old string, new string) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, []byte(strings.Replace(string(content), old, new, 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTree_Watch(t *testing.T) {
+	type testCase struct {
+		file string
+		old  string
+		new  string
+		// rendered is how many pages were affected by the change.
+		rendered int
+		// output is a file expected to contain the new content.
+		output string
+	}
+	table := map[string]testCase{
+		"post": {
+			file: "one.html",
+			old:  "Post numero uno.",
+			new:  "Post number one.",
+			// The domain relates to the post and its children inherit the ID
+			// of the domain so everything is affected.
+			rendered: 3,
+			output:   "/2018/07/one.html",
+		},
+		"template": {
+			file:     "layout.yml",
+			old:      "<!DOCTYPE html>",
+			new:      "<!doctype html>",
+			rendered: 3,
+			output:   "/2018/08/two.html",
+		},
+		"unrelated": {
+			file:     "unrelated.yml",
+			new:      "kind: website\ngroup: content\nversion: v1\nnamespace: page\nname: unrelated\nmeta:\n  live: true\n",
+			rendered: 0,
+		},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			dir := testhelper.CopyFixture(t, "../../testdata/blog")
+			cacheDir, tempErr := ioutil.TempDir("", "aevitas-cache")
+			if tempErr != nil {
+				t.Fatal(tempErr)
+			}
+			defer os.RemoveAll(cacheDir)
+			dest := memfs.New()
+			factory := testhelper.MakeFactory(osfs.New("../../testdata"), dest)
+			tree, err := render.NewTree("website/content/v1/domain/blog", testIndex(t, dir), factory)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tree = tree.WithCacheDir(cacheDir)
+			if err := tree.Render(context.Background(), 1, nil, nil); err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			changes := make(chan *render.Tree, 1)
+			errs := make(chan error, 1)
+			go func() {
+				errs <- tree.Watch(ctx, []string{dir}, func(rebuilt *render.Tree, err error) {
+					if err != nil {
+						errs <- err
+						return
+					}
+					changes <- rebuilt
+				})
+			}()
+			// Give the watcher a moment to start.
+			time.Sleep(100 * time.Millisecond)
+			if test.old == "" {
+				if err := ioutil.WriteFile(filepath.Join(dir, test.file), []byte(test.new), 0644); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				replaceIn(t, filepath.Join(dir, test.file), test.old, test.new)
+			}
+			select {
+			case rebuilt := <-changes:
+				if stats := rebuilt.Stats(); stats.Rendered != test.rendered {
+					t.Fatalf("expected %d pages to be rendered, got %d", test.rendered, stats.Rendered)
+				}
+				if test.output == "" {
+					return
+				}
+				content, readErr := readFile(dest, test.output)
+				if readErr != nil {
+					t.Fatal(readErr)
+				}
+				if !strings.Contains(content, test.new) {
+					t.Fatalf("expected output to contain %s, got %s", test.new, content)
+				}
+			case err := <-errs:
+				t.Fatal(err)
+			case <-time.After(time.Second):
+				t.Fatal("expected onChange to be called within a second")
+			}
+		})
+	}
+}
+
+func TestTree_WatchRepeated(t *testing.T) {
+	dir := testhelper.CopyFixture(t, "../../testdata/blog")
+	cacheDir, tempErr := ioutil.TempDir("", "aevitas-cache")
+	if tempErr != nil {
+		t.Fatal(tempErr)
+	}
+	defer os.RemoveAll(cacheDir)
+	dest := memfs.New()
+	output := render.NewMemOutputAdapter()
+	factory := testhelper.MakeFactory(osfs.New("../../testdata"), dest)
+	tree, err := render.NewTree("website/content/v1/domain/blog", testIndex(t, dir), factory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree = tree.WithCacheDir(cacheDir).WithOutputAdapter(output)
+	if err := tree.Render(context.Background(), 1, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan *render.Tree, 1)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- tree.Watch(ctx, []string{dir}, func(rebuilt *render.Tree, err error) {
+			if err != nil {
+				errs <- err
+				return
+			}
+			changes <- rebuilt
+		})
+	}()
+	// Give the watcher a moment to start.
+	time.Sleep(100 * time.Millisecond)
+	for _, change := range []struct {
+		file     string
+		old      string
+		new      string
+		rendered int
+		output   string
+	}{
+		{file: "one.html", old: "Post numero uno.", new: "Post number one.", rendered: 3, output: "2018/07/one.html"},
+		{file: "unrelated.yml", new: "kind: website\ngroup: content\nversion: v1\nnamespace: page\nname: unrelated\nmeta:\n  live: true\n"},
+		{file: "layout.yml", old: "<!DOCTYPE html>", new: "<!doctype html>", rendered: 3, output: "2018/08/two.html"},
+	} {
+		if change.old == "" {
+			if err := ioutil.WriteFile(filepath.Join(dir, change.file), []byte(change.new), 0644); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			replaceIn(t, filepath.Join(dir, change.file), change.old, change.new)
+		}
+		select {
+		case rebuilt := <-changes:
+			expected := render.Stats{Pages: 3, Rendered: change.rendered}
+			if stats := rebuilt.Stats(); stats.Pages != expected.Pages || stats.Rendered != expected.Rendered {
+				t.Fatalf("%s: expected %d of %d pages to be rendered, got %d of %d", change.file, expected.Rendered, expected.Pages, stats.Rendered, stats.Pages)
+			}
+			if change.output == "" {
+				continue
+			}
+			content, ok := output.File(change.output)
+			if !ok || !strings.Contains(string(content), change.new) {
+				t.Fatalf("%s: expected output adapter to receive %s, got %s", change.file, change.new, content)
+			}
+			if _, err := dest.Stat(change.output); !os.IsNotExist(err) {
+				t.Fatalf("%s: expected nothing to be written to the destination", change.file)
+			}
+		case err := <-errs:
+			t.Fatal(err)
+		case <-time.After(time.Second):
+			t.Fatalf("%s: expected onChange to be called within a second", change.file)
+		}
+	}
+}
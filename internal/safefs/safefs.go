@@ -0,0 +1,59 @@
+// Package safefs replaces files on billy filesystems without leaving partial
+// writes behind.
+package safefs
+
+import (
+	"errors"
+	"github.com/go-git/go-billy/v5"
+	"os"
+)
+
+// Writer is implemented by filesystems that can replace the content of a file
+// without readers ever observing a partial write.
+type Writer interface {
+	WriteAtomic(path string, content []byte, mode os.FileMode) error
+}
+
+// WriteFile replaces the file at path in dest with content. If dest is not a
+// Writer the content is written to a temporary file that is renamed into
+// place. Filesystems that cannot rename are written to directly. The file is
+// given mode if dest supports changing it.
+func WriteFile(dest billy.Filesystem, path string, content []byte, mode os.FileMode) error {
+	if safe, ok := dest.(Writer); ok {
+		return safe.WriteAtomic(path, content, mode)
+	}
+	temp := path + ".tmp"
+	if err := writeFile(dest, temp, content, mode); err != nil {
+		dest.Remove(temp)
+		return err
+	}
+	if err := dest.Rename(temp, path); err != nil {
+		dest.Remove(temp)
+		if errors.Is(err, billy.ErrNotSupported) {
+			return writeFile(dest, path, content, mode)
+		}
+		return err
+	}
+	return nil
+}
+
+// writeFile creates path in dest with content. The mode is set before any of
+// the content is written if dest supports changing it, otherwise it is only
+// applied by filesystems that respect it when creating files.
+func writeFile(dest billy.Filesystem, path string, content []byte, mode os.FileMode) error {
+	file, createErr := dest.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode)
+	if createErr != nil {
+		return createErr
+	}
+	if change, ok := dest.(billy.Change); ok {
+		if err := change.Chmod(path, mode); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if _, writeErr := file.Write(content); writeErr != nil {
+		file.Close()
+		return writeErr
+	}
+	return file.Close()
+}
@@ -1,9 +1,14 @@
 // Package selector provides the basis for identifying and locating resources.
+//
+// Lists of selectors are ordered by ID. List.SortStable guarantees selectors
+// with the same ID keep the order they were added in, List.Sort does not.
 package selector
 
 import (
 	"fmt"
 	json "github.com/json-iterator/go"
+	"net/url"
+	"sort"
 	"strings"
 )
 
@@ -23,16 +28,23 @@ type Selector struct {
 // New produces a selector from a string.
 // The expected form is: "namespace/kind/group/version/Name".
 // A wildcard selector is this form: "namespace/kind/group/version/*".
+// The name is url-decoded so it may include slashes written as %2F (e.g.
+// 2024%2F01%2F15 is the name 2024/01/15).
 func New(selector string) (*Selector, error) {
 	parts := strings.Split(selector, "/")
 	if len(parts) != 5 {
 		return nil, fmt.Errorf("unsupported selector: %s", selector)
 	}
+	name, err := url.PathUnescape(parts[4])
+	if err != nil {
+		return nil, fmt.Errorf("invalid name in selector %s: %w", selector, err)
+	}
+	kgvn := strings.Join(parts[0:4], "/")
 	instance := &Selector{
-		Raw:  selector,
+		Raw:  kgvn + "/" + EncodeName(name),
 		KGV:  strings.Join(parts[0:3], "/"),
-		KGVN: strings.Join(parts[0:4], "/"),
-		Name: parts[4],
+		KGVN: kgvn,
+		Name: name,
 	}
 	if err := instance.Validate(); err != nil {
 		return nil, err
@@ -40,6 +52,14 @@ func New(selector string) (*Selector, error) {
 	return instance, nil
 }
 
+// nameEncoder escapes the characters of a name New would otherwise split on
+// or decode.
+var nameEncoder = strings.NewReplacer("%", "%25", "/", "%2F")
+
+// EncodeName produces the form of name used in the string representation of a
+// selector.
+func EncodeName(name string) string { return nameEncoder.Replace(name) }
+
 func Must(selector string) *Selector {
 	instance, err := New(selector)
 	if err != nil {
@@ -48,15 +68,81 @@ func Must(selector string) *Selector {
 	return instance
 }
 
+// WithName produces a selector differing only in name (e.g. "*" for the
+// wildcard of a selector). The name is written as it appears in String, so a
+// slash must be encoded as %2F.
+func (s Selector) WithName(name string) (Selector, error) {
+	if name == "" || strings.Contains(name, "/") {
+		return Selector{}, fmt.Errorf("name must be set and must not contain a slash: %q", name)
+	}
+	result, err := New(s.KGVN + "/" + name)
+	if err != nil {
+		return Selector{}, err
+	}
+	return *result, nil
+}
+
+// MustWithName does just what WithName does, panicking on an invalid name.
+func (s Selector) MustWithName(name string) Selector {
+	result, err := s.WithName(name)
+	if err != nil {
+		panic(err)
+	}
+	return result
+}
+
+// List is a collection of selectors ordered by ID.
+type List []*Selector
+
+func (l List) Len() int           { return len(l) }
+func (l List) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l List) Less(i, j int) bool { return l[i].ID() < l[j].ID() }
+
+// Sort orders the list by ID.
+func (l List) Sort() { sort.Sort(l) }
+
+// SortStable orders the list by ID, keeping selectors with the same ID in
+// their original order.
+func (l List) SortStable() { sort.Stable(l) }
+
+// Validate checks every selector of the list, returning an error for each that
+// is invalid rather than stopping at the first.
+func (l List) Validate() []error {
+	var errs []error
+	for idx, s := range l {
+		if s == nil {
+			errs = append(errs, fmt.Errorf("%d: selector is nil", idx))
+			continue
+		}
+		if err := s.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%d: %w", idx, err))
+		}
+	}
+	return errs
+}
+
+// VersionRange produces a version wildcard matching every version of the
+// supplied major version (e.g. v1, v1.1, v1.2).
+func VersionRange(major int) string { return fmt.Sprintf("v%d.*", major) }
+
 func (s Selector) Validate() error {
 	parts := strings.Split(s.Raw, "/")
+	if len(parts) != 5 {
+		return fmt.Errorf("unsupported selector: %s", s)
+	}
 	if parts[0] == "" || parts[1] == "" || parts[2] == "" || parts[3] == "" || parts[4] == "" {
 		return fmt.Errorf("kind, group, version, Name and namespace must be set: %s", s)
 	}
+	if version := parts[2]; strings.Contains(version, "*") {
+		if strings.Count(version, "*") != 1 || !strings.HasSuffix(version, ".*") || version == ".*" {
+			return fmt.Errorf("version wildcards must be of the form v1.*: %s", s)
+		}
+	}
 	return nil
 }
 
-// String returns a full string representation of the selector.
+// String returns a full string representation of the selector. The name is
+// encoded so it never contains a slash.
 func (s Selector) String() string { return s.ID() }
 
 // ID returns a full string representation of the selector.
@@ -66,9 +152,56 @@ func (s Selector) ID() string { return s.Raw }
 // a namespace/kind/group/version.
 func (s Selector) IsWildcard() bool { return s.Name == "*" }
 
+// IsVersionWildcard indicates if a selector is meant to reference all
+// versions sharing a prefix (e.g. v1.* matches v1, v1.1 and v1.2).
+func (s Selector) IsVersionWildcard() bool { return strings.HasSuffix(s.version(), ".*") }
+
 // Match returns a boolean indicating if the provided selector matches
 func (s Selector) Matches(check *Selector) bool {
-	return check.KGVN == s.KGVN && (check.Name == s.Name || check.IsWildcard() || s.IsWildcard())
+	return s.MatchesKGVN(check) && (check.Name == s.Name || check.IsWildcard() || s.IsWildcard())
+}
+
+// MatchesKGVN returns a boolean indicating if the provided selector shares the
+// same kind, group, version and namespace, accounting for version wildcards.
+func (s Selector) MatchesKGVN(check *Selector) bool {
+	if check.KGVN == s.KGVN {
+		return true
+	}
+	if !s.IsVersionWildcard() && !check.IsVersionWildcard() {
+		return false
+	}
+	// Versions are the only part of a KGVN permitted to differ.
+	sParts := strings.Split(s.KGVN, "/")
+	checkParts := strings.Split(check.KGVN, "/")
+	return sParts[0] == checkParts[0] &&
+		sParts[1] == checkParts[1] &&
+		sParts[3] == checkParts[3] &&
+		versionMatches(sParts[2], checkParts[2])
+}
+
+// version does just what you think it does.
+func (s Selector) version() string {
+	parts := strings.SplitN(s.KGV, "/", 3)
+	if len(parts) != 3 {
+		return ""
+	}
+	return parts[2]
+}
+
+// versionMatches determines if two versions are the same or if either is a
+// wildcard that includes the other.
+func versionMatches(a string, b string) bool {
+	if a == b {
+		return true
+	}
+	if strings.HasSuffix(a, ".*") {
+		prefix := strings.TrimSuffix(a, ".*")
+		return b == prefix || strings.HasPrefix(b, prefix+".")
+	}
+	if strings.HasSuffix(b, ".*") {
+		return versionMatches(b, a)
+	}
+	return false
 }
 
 // UnmarshalJSON instantiates a selector from a string.
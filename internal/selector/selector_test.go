@@ -4,19 +4,25 @@ import (
 	"fmt"
 	json "github.com/json-iterator/go"
 	"github.com/tkellen/aevitas/internal/selector"
+	"strings"
 	"testing"
 )
 
 func TestNewSelector(t *testing.T) {
 	table := map[string]bool{
-		"invalid////": true,
-		"/invalid///": true,
-		"//invalid//": true,
-		"///invalid/": true,
-		"////invalid": true,
-		"/////":       true,
-		"k/g/v/ns/n":  false,
-		"k/g/v/ns/*":  false,
+		"invalid////":   true,
+		"/invalid///":   true,
+		"//invalid//":   true,
+		"///invalid/":   true,
+		"////invalid":   true,
+		"/////":         true,
+		"k/g/v/ns/n":    false,
+		"k/g/v/ns/*":    false,
+		"k/g/v1.*/ns/n": false,
+		"k/g/*/ns/n":    true,
+		"k/g/.*/ns/n":   true,
+		"k/g/v1*/ns/n":  true,
+		"k/g/v*.*/ns/n": true,
 	}
 	for input, expectedErr := range table {
 		input, expectedErr := input, expectedErr
@@ -58,6 +64,78 @@ func TestSelector_ID(t *testing.T) {
 	}
 }
 
+func TestSelector_EncodedName(t *testing.T) {
+	table := map[string]struct {
+		name string
+		id   string
+	}{
+		"k/g/v/ns/2024%2F01%2F15": {name: "2024/01/15", id: "k/g/v/ns/2024%2F01%2F15"},
+		"k/g/v/ns/2024%2f01%2f15": {name: "2024/01/15", id: "k/g/v/ns/2024%2F01%2F15"},
+		"k/g/v/ns/100%25":         {name: "100%", id: "k/g/v/ns/100%25"},
+		"k/g/v/ns/a%20b":          {name: "a b", id: "k/g/v/ns/a b"},
+		"k/g/v/ns/plain":          {name: "plain", id: "k/g/v/ns/plain"},
+	}
+	for input, expected := range table {
+		input, expected := input, expected
+		t.Run(input, func(t *testing.T) {
+			s := selector.Must(input)
+			if s.Name != expected.name {
+				t.Fatalf("expected name %s, got %s", expected.name, s.Name)
+			}
+			if s.ID() != expected.id {
+				t.Fatalf("expected id %s, got %s", expected.id, s.ID())
+			}
+			if again := selector.Must(s.String()); *again != *s {
+				t.Fatalf("expected %v, got %v", s, again)
+			}
+		})
+	}
+}
+
+func TestSelector_WithName(t *testing.T) {
+	table := map[string]struct {
+		target   string
+		name     string
+		expected string
+	}{
+		"concrete":        {target: "k/g/v/ns/one", name: "two", expected: "k/g/v/ns/two"},
+		"to wildcard":     {target: "k/g/v1.*/ns/one", name: "*", expected: "k/g/v1.*/ns/*"},
+		"from wildcard":   {target: "k/g/v/ns/*", name: "one", expected: "k/g/v/ns/one"},
+		"encoded slashes": {target: "k/g/v/ns/one", name: "2024%2F01%2F15", expected: "k/g/v/ns/2024%2F01%2F15"},
+		"empty":           {target: "k/g/v/ns/one", name: ""},
+		"slash":           {target: "k/g/v/ns/one", name: "2024/01"},
+		"bad encoding":    {target: "k/g/v/ns/one", name: "bad%zz"},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			original := selector.Must(test.target)
+			result, err := original.WithName(test.name)
+			if test.expected == "" {
+				if err == nil {
+					t.Fatalf("expected error, got %s", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != *selector.Must(test.expected) {
+				t.Fatalf("expected %v, got %v", selector.Must(test.expected), result)
+			}
+			if original.ID() != test.target {
+				t.Fatalf("expected %s to be unchanged, got %s", test.target, original)
+			}
+		})
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected MustWithName to panic")
+		}
+	}()
+	selector.Must("k/g/v/ns/one").MustWithName("")
+}
+
 func TestSelector_String(t *testing.T) {
 	type testCase struct {
 		selector *selector.Selector
@@ -98,7 +176,7 @@ func TestSelector_KGV(t *testing.T) {
 	for _, test := range table {
 		test := test
 		t.Run(test.expected, func(t *testing.T) {
-			actual := test.selector.KGV()
+			actual := test.selector.KGV
 			if test.expected != actual {
 				t.Fatalf("expected %s, got %s", test.expected, actual)
 			}
@@ -120,7 +198,7 @@ func TestSelector_KGVN(t *testing.T) {
 	for _, test := range table {
 		test := test
 		t.Run(test.expected, func(t *testing.T) {
-			actual := test.selector.KGVN()
+			actual := test.selector.KGVN
 			if test.expected != actual {
 				t.Fatalf("expected %s, got %s", test.expected, actual)
 			}
@@ -181,6 +259,31 @@ func TestSelector_Matches(t *testing.T) {
 			b:        selector.Must("k/g/v/test/n"),
 			expected: false,
 		},
+		{
+			a:        selector.Must("k/g/v1.*/ns/*"),
+			b:        selector.Must("k/g/v1/ns/n"),
+			expected: true,
+		},
+		{
+			a:        selector.Must("k/g/v1.*/ns/*"),
+			b:        selector.Must("k/g/v1.2/ns/n"),
+			expected: true,
+		},
+		{
+			a:        selector.Must("k/g/v1.*/ns/*"),
+			b:        selector.Must("k/g/v10/ns/n"),
+			expected: false,
+		},
+		{
+			a:        selector.Must("k/g/v1.*/ns/*"),
+			b:        selector.Must("k/g/v2.0/ns/n"),
+			expected: false,
+		},
+		{
+			a:        selector.Must("k/g/v1.*/ns/n"),
+			b:        selector.Must("k/g/v1.1/other/n"),
+			expected: false,
+		},
 	}
 	for _, test := range table {
 		test := test
@@ -197,6 +300,12 @@ func TestSelector_Matches(t *testing.T) {
 	}
 }
 
+func TestVersionRange(t *testing.T) {
+	if actual := selector.VersionRange(1); actual != "v1.*" {
+		t.Fatalf("expected %s, got %s", "v1.*", actual)
+	}
+}
+
 func TestSelector_UnmarshalJSON(t *testing.T) {
 	type testCase struct {
 		input    string
@@ -213,6 +322,11 @@ func TestSelector_UnmarshalJSON(t *testing.T) {
 			input:    "k/g/v/ns/n",
 			expected: selector.Must("k/g/v/ns/n"),
 		},
+		{
+			input:    "k/g/v/ns/2024%2F01%2F15",
+			expected: &selector.Selector{Raw: "k/g/v/ns/2024%2F01%2F15", KGV: "k/g/v", KGVN: "k/g/v/ns", Name: "2024/01/15"},
+		},
+		{input: "k/g/v/ns/bad%zz"},
 	}
 	type testStruct struct {
 		Selector *selector.Selector
@@ -238,3 +352,59 @@ func TestSelector_UnmarshalJSON(t *testing.T) {
 		})
 	}
 }
+
+func TestList_SortStable(t *testing.T) {
+	// Enough equal selectors that sort.Sort does not fall back to an
+	// insertion sort, which happens to be stable.
+	var list selector.List
+	var equal []*selector.Selector
+	for idx := 0; idx < 50; idx++ {
+		list = append(list, selector.Must(fmt.Sprintf("k/g/v/ns/%d", 50-idx)))
+		same := selector.Must("k/g/v/ns/same")
+		equal = append(equal, same)
+		list = append(list, same)
+	}
+	list.SortStable()
+	var actual []*selector.Selector
+	for idx, item := range list {
+		if idx > 0 && list[idx-1].ID() > item.ID() {
+			t.Fatalf("expected %s before %s", item, list[idx-1])
+		}
+		if item.ID() == "k/g/v/ns/same" {
+			actual = append(actual, item)
+		}
+	}
+	for idx := range equal {
+		if actual[idx] != equal[idx] {
+			t.Fatalf("expected equal selectors to keep their order, %d moved", idx)
+		}
+	}
+	list.Sort()
+	for idx := 1; idx < len(list); idx++ {
+		if list[idx-1].ID() > list[idx].ID() {
+			t.Fatalf("expected %s before %s", list[idx], list[idx-1])
+		}
+	}
+}
+
+func TestList_Validate(t *testing.T) {
+	list := selector.List{
+		selector.Must("k/g/v/ns/one"),
+		{Raw: "k/g/*/ns/two"},
+		selector.Must("k/g/v/ns/three"),
+		nil,
+		{Raw: "k/g/v/four"},
+	}
+	errs := list.Validate()
+	if len(errs) != 3 {
+		t.Fatalf("expected %d errors, got %d: %v", 3, len(errs), errs)
+	}
+	for idx, prefix := range []string{"1: ", "3: ", "4: "} {
+		if actual := errs[idx].Error(); !strings.HasPrefix(actual, prefix) {
+			t.Fatalf("expected %s to start with %s", actual, prefix)
+		}
+	}
+	if errs := list[:1].Validate(); errs != nil {
+		t.Fatalf("expected no errors, got %v", errs)
+	}
+}
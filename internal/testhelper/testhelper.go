@@ -0,0 +1,100 @@
+// Package testhelper collects the setup shared by tests across packages.
+package testhelper
+
+import (
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	"github.com/tidwall/sjson"
+	"github.com/tkellen/aevitas/internal/selector"
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"github.com/tkellen/aevitas/pkg/resource"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// MakeManifest creates a live manifest for the target selector. Meta and spec
+// are json documents and either may be empty.
+func MakeManifest(tb testing.TB, target string, meta string, spec string) *manifest.Manifest {
+	tb.Helper()
+	s, err := selector.New(target)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	parts := strings.Split(target, "/")
+	doc := fmt.Sprintf(`{"kind":%q,"group":%q,"version":%q,"namespace":%q,"name":%q}`,
+		parts[0], parts[1], parts[2], parts[3], s.Name)
+	if meta != "" {
+		if doc, err = sjson.SetRaw(doc, "meta", meta); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	if doc, err = sjson.Set(doc, "meta.live", true); err != nil {
+		tb.Fatal(err)
+	}
+	if spec != "" {
+		if doc, err = sjson.SetRaw(doc, "spec", spec); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	manifests, err := manifest.New([]byte(doc), "test")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	// Generated manifests precede the one that generated them.
+	return manifests[len(manifests)-1]
+}
+
+// MakeIndex inserts the supplied manifests into a new index and collates it.
+func MakeIndex(tb testing.TB, manifests ...*manifest.Manifest) *manifest.Index {
+	tb.Helper()
+	index := manifest.NewIndex()
+	if err := index.Insert(manifests...); err != nil {
+		tb.Fatal(err)
+	}
+	if err := index.Collate(); err != nil {
+		tb.Fatal(err)
+	}
+	return index
+}
+
+// MakeFactory creates the default factory reading from source and writing to
+// dest.
+func MakeFactory(source billy.Filesystem, dest billy.Filesystem) *resource.Factory {
+	return resource.DefaultFactory(source, dest)
+}
+
+// AssertHref fails the test if the href of the resource is not expected.
+func AssertHref(tb testing.TB, r *resource.Resource, expected string) {
+	tb.Helper()
+	if actual := r.Href(); actual != expected {
+		tb.Fatalf("%s: expected href %s, got %s", r.Selector, expected, actual)
+	}
+}
+
+// CopyFixture copies the files of a testdata directory to a temporary one, which
+// is removed when the test finishes, so they can be changed.
+func CopyFixture(tb testing.TB, src string) string {
+	tb.Helper()
+	dest, err := ioutil.TempDir("", "aevitas-fixture")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { os.RemoveAll(dest) })
+	files, err := ioutil.ReadDir(src)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for _, file := range files {
+		content, readErr := ioutil.ReadFile(filepath.Join(src, file.Name()))
+		if readErr != nil {
+			tb.Fatal(readErr)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dest, file.Name()), content, 0644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return dest
+}
@@ -0,0 +1,185 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"github.com/allegro/bigcache"
+	hash "github.com/minio/sha256-simd"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// cacheLifetime controls how long parsed manifests are reused.
+const cacheLifetime = 24 * time.Hour
+
+// CachedParser memoizes New. Parsed manifests are held in memory and, when a
+// cache directory is supplied, written there so later invocations can reuse
+// them.
+type CachedParser struct {
+	cache  *bigcache.BigCache
+	dir    string
+	hits   uint64
+	misses uint64
+}
+
+// CacheStats counts how often a CachedParser found a manifest it had parsed
+// before.
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+// NewCachedParser creates a parser persisting manifests to cacheDir. An empty
+// cacheDir keeps them in memory only.
+func NewCachedParser(cacheDir string) (*CachedParser, error) {
+	config := bigcache.DefaultConfig(cacheLifetime)
+	// The defaults preallocate hundreds of megabytes for far more entries
+	// than any site has manifests.
+	config.Shards = 64
+	config.MaxEntriesInWindow = 1024
+	config.MaxEntrySize = 4096
+	cache, err := bigcache.NewBigCache(config)
+	if err != nil {
+		return nil, err
+	}
+	return &CachedParser{cache: cache, dir: cacheDir}, nil
+}
+
+// New does just what the package level New does, returning previously parsed
+// manifests when the same data has been seen from the same source.
+func (p *CachedParser) New(data []byte, source string) ([]*Manifest, error) {
+	key := cacheKey(data, source)
+	if cached, err := p.get(key); err != nil {
+		return nil, err
+	} else if cached != nil {
+		atomic.AddUint64(&p.hits, 1)
+		return cached, nil
+	}
+	atomic.AddUint64(&p.misses, 1)
+	manifests, err := New(data, source)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(manifests); err != nil {
+		return nil, err
+	}
+	if err := p.cache.Set(key, buf.Bytes()); err != nil {
+		return nil, err
+	}
+	if p.dir != "" {
+		if err := os.MkdirAll(p.dir, 0755); err != nil {
+			return nil, err
+		}
+		if err := p.write(key, buf.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+	return manifests, nil
+}
+
+// Stats reports how many calls to New were served from the cache.
+func (p *CachedParser) Stats() CacheStats {
+	return CacheStats{
+		Hits:   atomic.LoadUint64(&p.hits),
+		Misses: atomic.LoadUint64(&p.misses),
+	}
+}
+
+// write persists an entry to the cache directory. It is written beside its
+// final name and renamed into place so an interrupted write never leaves part
+// of an entry to be read.
+func (p *CachedParser) write(key string, encoded []byte) error {
+	temp, err := ioutil.TempFile(p.dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := temp.Write(encoded); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	if err := os.Chmod(temp.Name(), 0644); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	if err := os.Rename(temp.Name(), filepath.Join(p.dir, key)); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	return nil
+}
+
+// get decodes cached manifests, returning nil if there are none. Manifests are
+// decoded on every call so callers never share them. Entries that cannot be
+// decoded are discarded and treated as missing.
+func (p *CachedParser) get(key string) ([]*Manifest, error) {
+	encoded, err := p.cache.Get(key)
+	if errors.Is(err, bigcache.ErrEntryNotFound) {
+		if encoded, err = p.read(key); err != nil || encoded == nil {
+			return nil, err
+		}
+		if err := p.cache.Set(key, encoded); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	var manifests []*Manifest
+	if err := gob.NewDecoder(bytes.NewReader(encoded)).Decode(&manifests); err != nil {
+		return nil, p.discard(key)
+	}
+	return manifests, nil
+}
+
+// discard removes an entry from memory and the cache directory.
+func (p *CachedParser) discard(key string) error {
+	if err := p.cache.Delete(key); err != nil && !errors.Is(err, bigcache.ErrEntryNotFound) {
+		return err
+	}
+	if p.dir == "" {
+		return nil
+	}
+	if err := os.Remove(filepath.Join(p.dir, key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// read loads an entry from the cache directory if it has not expired.
+func (p *CachedParser) read(key string) ([]byte, error) {
+	if p.dir == "" {
+		return nil, nil
+	}
+	path := filepath.Join(p.dir, key)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if time.Since(info.ModTime()) > cacheLifetime {
+		return nil, nil
+	}
+	return ioutil.ReadFile(path)
+}
+
+// cacheKey identifies data parsed from a source. The source is included as it
+// is recorded on every manifest.
+func cacheKey(data []byte, source string) string {
+	sum := hash.New()
+	sum.Write([]byte(source))
+	sum.Write([]byte{0})
+	sum.Write(data)
+	return hex.EncodeToString(sum.Sum(nil))
+}
@@ -0,0 +1,108 @@
+package manifest_test
+
+import (
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCachedParser(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aevitas-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := []byte("---\nkind: k\ngroup: g\nversion: v\nnamespace: ns\nname: \"n\"\nmeta:\n  title: Title\n  relations:\n  - selector: a/b/c/d/e\n---\ncontent")
+	expected, err := manifest.New(data, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parser, err := manifest.NewCachedParser(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for idx, expectedStats := range []manifest.CacheStats{
+		{Hits: 0, Misses: 1},
+		{Hits: 1, Misses: 1},
+	} {
+		actual, err := parser.New(data, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expected, actual) {
+			t.Fatalf("parse %d: expected %#v, got %#v", idx, expected, actual)
+		}
+		if stats := parser.Stats(); stats != expectedStats {
+			t.Fatalf("parse %d: expected %+v, got %+v", idx, expectedStats, stats)
+		}
+	}
+	// A new parser reads what the first persisted.
+	reloaded, err := manifest.NewCachedParser(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reloaded.New(data, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if stats := reloaded.Stats(); stats.Hits != 1 {
+		t.Fatalf("expected a hit from the cache directory, got %+v", stats)
+	}
+	if _, err := reloaded.New(data, "other"); err != nil {
+		t.Fatal(err)
+	}
+	if stats := reloaded.Stats(); stats.Misses != 1 {
+		t.Fatalf("expected a different source to miss, got %+v", stats)
+	}
+	if _, err := parser.New([]byte("---\n}::: BAD :::{\n---\n"), "test"); err == nil {
+		t.Fatal("expected parse error")
+	}
+}
+
+func TestCachedParser_Corrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aevitas-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := []byte(`{"kind":"k","group":"g","version":"v","namespace":"ns","name":"n"}`)
+	parser, err := manifest.NewCachedParser(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.New(data, "test"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected %d cache entry, got %d", 1, len(entries))
+	}
+	// Truncate the entry as an interrupted write would have.
+	if err := ioutil.WriteFile(filepath.Join(dir, entries[0].Name()), []byte{0x1}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := manifest.NewCachedParser(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for idx, expectedStats := range []manifest.CacheStats{
+		{Hits: 0, Misses: 1},
+		{Hits: 1, Misses: 1},
+	} {
+		actual, err := reloaded.New(data, "test")
+		if err != nil {
+			t.Fatalf("parse %d: %s", idx, err)
+		}
+		if len(actual) != 1 || actual[0].Selector.ID() != "k/g/v/ns/n" {
+			t.Fatalf("parse %d: expected %s, got %v", idx, "k/g/v/ns/n", actual)
+		}
+		if stats := reloaded.Stats(); stats != expectedStats {
+			t.Fatalf("parse %d: expected %+v, got %+v", idx, expectedStats, stats)
+		}
+	}
+}
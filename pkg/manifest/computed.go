@@ -0,0 +1,81 @@
+package manifest
+
+import (
+	"bytes"
+	"fmt"
+	json "github.com/json-iterator/go"
+	"github.com/tidwall/sjson"
+	"strings"
+	"text/template"
+)
+
+// ComputedField describes a spec field whose value is derived from the other
+// fields of the spec. The template is executed with the spec as data.
+type ComputedField struct {
+	Name     string
+	Template string
+}
+
+// validate does just what you think it does.
+func (c *ComputedField) validate(spec map[string]interface{}) error {
+	if c.Name == "" {
+		return fmt.Errorf("name must be set")
+	}
+	if strings.ContainsAny(c.Name, ".*?#|") {
+		return fmt.Errorf("%s: name must not contain path characters", c.Name)
+	}
+	if _, exists := spec[c.Name]; exists {
+		return fmt.Errorf("%s: collides with an existing spec field", c.Name)
+	}
+	return nil
+}
+
+// computeSpec evaluates the `computed` entries of a spec, if any, and injects
+// the results back into it. Computed fields are evaluated in order so each can
+// reference those that came before it.
+func computeSpec(spec json.RawMessage) (json.RawMessage, error) {
+	if len(spec) == 0 {
+		return spec, nil
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(spec, &data); err != nil {
+		return nil, fmt.Errorf("json unmarshal: %w", err)
+	}
+	var key string
+	for field := range data {
+		if strings.EqualFold(field, "computed") {
+			key = field
+		}
+	}
+	if key == "" {
+		return spec, nil
+	}
+	var fields []*ComputedField
+	raw, _ := json.Marshal(data[key])
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("json unmarshal: %w", err)
+	}
+	delete(data, key)
+	result, err := sjson.DeleteBytes(spec, key)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range fields {
+		if err := field.validate(data); err != nil {
+			return nil, err
+		}
+		tmpl, tmplErr := template.New(field.Name).Parse(field.Template)
+		if tmplErr != nil {
+			return nil, tmplErr
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, err
+		}
+		if result, err = sjson.SetBytes(result, field.Name, buf.String()); err != nil {
+			return nil, err
+		}
+		data[field.Name] = buf.String()
+	}
+	return result, nil
+}
@@ -0,0 +1,137 @@
+package manifest
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+)
+
+// dotPalette holds the fill colors of nodes in graphs written by WriteDOT.
+var dotPalette = []string{
+	"#8dd3c7", "#ffffb3", "#bebada", "#fb8072", "#80b1d3", "#fdb462",
+	"#b3de69", "#fccde5", "#d9d9d9", "#bc80bd", "#ccebc5", "#ffed6f",
+}
+
+// dotColor deterministically picks a color for a kind/group/version.
+func dotColor(kgv string) string {
+	h := fnv.New32a()
+	h.Write([]byte(kgv))
+	return dotPalette[h.Sum32()%uint32(len(dotPalette))]
+}
+
+type dotEdge struct {
+	from  *Manifest
+	to    *Manifest
+	label string
+}
+
+// WriteDOT writes the direct relations of every manifest in the supplied
+// kind/group/version/namespace as a Graphviz graph. Manifests on the other end
+// of those relations are included as nodes. The index must be collated.
+func (i *Index) WriteDOT(w io.Writer, kgvn string) error {
+	shard, ok := i.content.shard[kgvn]
+	if !ok {
+		return fmt.Errorf("%s is empty", kgvn)
+	}
+	return i.writeDOT(w, shard.manifests)
+}
+
+// WriteFullDOT does just what WriteDOT does for every live manifest in the
+// index.
+func (i *Index) WriteFullDOT(w io.Writer) error {
+	return i.writeDOT(w, i.content.all.manifests)
+}
+
+func (i *Index) writeDOT(w io.Writer, sources []*Manifest) error {
+	nodes := map[*Manifest]struct{}{}
+	var edges []dotEdge
+	for _, source := range sources {
+		nodes[source] = struct{}{}
+		sourceEdges, err := i.directEdges(source)
+		if err != nil {
+			return err
+		}
+		for _, edge := range sourceEdges {
+			nodes[edge.to] = struct{}{}
+		}
+		edges = append(edges, sourceEdges...)
+	}
+	sorted := make([]*Manifest, 0, len(nodes))
+	for node := range nodes {
+		sorted = append(sorted, node)
+	}
+	sort.Slice(sorted, func(a, b int) bool {
+		return sorted[a].Selector.ID() < sorted[b].Selector.ID()
+	})
+	sort.Slice(edges, func(a, b int) bool {
+		x, y := edges[a], edges[b]
+		if x.from.Selector.ID() != y.from.Selector.ID() {
+			return x.from.Selector.ID() < y.from.Selector.ID()
+		}
+		if x.to.Selector.ID() != y.to.Selector.ID() {
+			return x.to.Selector.ID() < y.to.Selector.ID()
+		}
+		return x.label < y.label
+	})
+	out := bufio.NewWriter(w)
+	fmt.Fprintln(out, "digraph aevitas {")
+	fmt.Fprintln(out, "  node [style=filled];")
+	for _, node := range sorted {
+		fmt.Fprintf(out, "  %q [fillcolor=%q];\n", node.Selector.ID(), dotColor(node.Selector.KGV))
+	}
+	for _, edge := range edges {
+		fmt.Fprintf(out, "  %q -> %q [label=%q];\n", edge.from.Selector.ID(), edge.to.Selector.ID(), edge.label)
+	}
+	fmt.Fprintln(out, "}")
+	return out.Flush()
+}
+
+// directEdges resolves the relations, imports and children a manifest declares
+// without following the relations of the manifests they point to. Edges are
+// labeled with the name of the relation that produced them.
+func (i *Index) directEdges(source *Manifest) ([]dotEdge, error) {
+	if source.Meta == nil {
+		return nil, nil
+	}
+	type labeled struct {
+		relation *Relation
+		fallback string
+	}
+	var relations []labeled
+	for _, relation := range source.Meta.Relations {
+		relations = append(relations, labeled{relation, "relations"})
+	}
+	for _, relation := range source.Meta.Imports {
+		relations = append(relations, labeled{relation, "imports"})
+	}
+	for _, child := range source.Meta.Children {
+		relations = append(relations, labeled{child.Relation, "children"})
+	}
+	seen := map[dotEdge]struct{}{}
+	var edges []dotEdge
+	for _, item := range relations {
+		relation, err := item.relation.relationFor(source)
+		if err != nil {
+			return nil, fmt.Errorf("%s: resolving relations: %w", source, err)
+		}
+		expanded, err := relation.Resolve(i)
+		if !relation.Selector.IsWildcard() && err != nil {
+			return nil, fmt.Errorf("%s: resolving relations: %w", source, err)
+		}
+		label := item.relation.Name
+		if label == "" {
+			label = item.fallback
+		}
+		for _, target := range expanded {
+			edge := dotEdge{from: source, to: target, label: label}
+			if _, ok := seen[edge]; ok {
+				continue
+			}
+			seen[edge] = struct{}{}
+			edges = append(edges, edge)
+		}
+	}
+	return edges, nil
+}
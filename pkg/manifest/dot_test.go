@@ -0,0 +1,68 @@
+package manifest_test
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// countDOT scans a graph line by line, counting node and edge statements.
+func countDOT(t *testing.T, graph []byte) (int, int) {
+	var nodes, edges int
+	scanner := bufio.NewScanner(bytes.NewReader(graph))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.Contains(line, " -> "):
+			edges++
+		case strings.HasPrefix(line, `"`):
+			nodes++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return nodes, edges
+}
+
+func TestIndex_WriteDOT(t *testing.T) {
+	// Every integer relates to the even or odd set and primes additionally
+	// relate to the prime set (2, 3, 5 and 7).
+	index := generateIndex(generateManifests(10))
+	var shard bytes.Buffer
+	if err := index.WriteDOT(&shard, "test/number/v1/integer"); err != nil {
+		t.Fatal(err)
+	}
+	if nodes, edges := countDOT(t, shard.Bytes()); nodes != 13 || edges != 14 {
+		t.Fatalf("expected 13 nodes and 14 edges, got %d and %d:\n%s", nodes, edges, shard.String())
+	}
+	if !strings.Contains(shard.String(), `"test/number/v1/integer/two" -> "test/number/v1/set/prime" [label="relations"];`) {
+		t.Fatalf("expected labeled edge to prime set, got:\n%s", shard.String())
+	}
+	// Only direct relations are graphed so the sets have no edges of their own.
+	var sets bytes.Buffer
+	if err := index.WriteDOT(&sets, "test/number/v1/set"); err != nil {
+		t.Fatal(err)
+	}
+	if nodes, edges := countDOT(t, sets.Bytes()); nodes != 3 || edges != 0 {
+		t.Fatalf("expected 3 nodes and 0 edges, got %d and %d:\n%s", nodes, edges, sets.String())
+	}
+	var full bytes.Buffer
+	if err := index.WriteFullDOT(&full); err != nil {
+		t.Fatal(err)
+	}
+	if nodes, edges := countDOT(t, full.Bytes()); nodes != 14 || edges != 14 {
+		t.Fatalf("expected 14 nodes and 14 edges, got %d and %d:\n%s", nodes, edges, full.String())
+	}
+	var again bytes.Buffer
+	if err := index.WriteFullDOT(&again); err != nil {
+		t.Fatal(err)
+	}
+	if again.String() != full.String() {
+		t.Fatalf("expected deterministic output")
+	}
+	if err := index.WriteDOT(&again, "test/number/v1/missing"); err == nil {
+		t.Fatal("expected error for unknown shard")
+	}
+}
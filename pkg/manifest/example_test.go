@@ -0,0 +1,124 @@
+package manifest_test
+
+import (
+	"fmt"
+	"github.com/tkellen/aevitas/internal/selector"
+	"github.com/tkellen/aevitas/pkg/manifest"
+)
+
+// exampleIndex collates an index of three posts published on consecutive
+// days and a page related to the first two.
+func exampleIndex() *manifest.Index {
+	var manifests []*manifest.Manifest
+	for day, name := range []string{"first", "second", "third"} {
+		created, err := manifest.New([]byte(fmt.Sprintf(
+			`{"kind":"website","group":"content","version":"v1","namespace":"post","name":%q,"meta":{"live":true,"publishAt":{"year":2024,"month":1,"day":%d}}}`,
+			name, day+1,
+		)), "example")
+		if err != nil {
+			panic(err)
+		}
+		manifests = append(manifests, created...)
+	}
+	page, err := manifest.New([]byte(
+		`{"kind":"website","group":"content","version":"v1","namespace":"page","name":"index","meta":{"live":true,"relations":[{"selector":"website/content/v1/post/first"},{"selector":"website/content/v1/post/second"}]}}`,
+	), "example")
+	if err != nil {
+		panic(err)
+	}
+	index := manifest.NewIndex()
+	if err := index.Insert(append(manifests, page...)...); err != nil {
+		panic(err)
+	}
+	if err := index.Collate(); err != nil {
+		panic(err)
+	}
+	return index
+}
+
+func ExampleNew_json() {
+	manifests, err := manifest.New([]byte(
+		`{"kind":"website","group":"content","version":"v1","namespace":"post","name":"hello","meta":{"title":"Hello","href":"hello.html"}}`,
+	), "hello.json")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(manifests[0].Selector)
+	fmt.Println(manifests[0].Meta.Title)
+	// Output:
+	// website/content/v1/post/hello
+	// Hello
+}
+
+func ExampleNew_yaml() {
+	manifests, err := manifest.New([]byte(
+		"---\nkind: website\ngroup: content\nversion: v1\nnamespace: post\nname: hello\nmeta:\n  title: Hello\n---\n<p>Hello, world.</p>",
+	), "hello.md")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(manifests[0].Selector)
+	fmt.Println(manifests[0].Meta.Title)
+	// Output:
+	// website/content/v1/post/hello
+	// Hello
+}
+
+func ExampleIndex_Collate() {
+	index := exampleIndex()
+	for _, m := range index.Manifests() {
+		fmt.Println(m.Selector)
+	}
+	// Output:
+	// website/content/v1/page/index
+	// website/content/v1/post/first
+	// website/content/v1/post/second
+	// website/content/v1/post/third
+}
+
+func ExampleIndex_FindOne() {
+	m, err := exampleIndex().FindOne(selector.Must("website/content/v1/post/second"))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(m.Meta.PublishAt.Day)
+	// Output: 2
+}
+
+func ExampleIndex_FindMany() {
+	matches, err := exampleIndex().FindMany(selector.Must("website/content/v1/post/*"))
+	if err != nil {
+		panic(err)
+	}
+	for _, m := range matches {
+		fmt.Println(m.Selector.Name)
+	}
+	// Output:
+	// first
+	// second
+	// third
+}
+
+func ExampleIndex_Next() {
+	index := exampleIndex()
+	first, err := index.FindOne(selector.Must("website/content/v1/post/first"))
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(index.Next(first).Selector.Name)
+	// Output: second
+}
+
+func ExampleRelation_Resolve() {
+	relation := &manifest.Relation{Selector: selector.Must("website/content/v1/post/*"), Limit: 2}
+	matches, err := relation.Resolve(exampleIndex())
+	if err != nil {
+		panic(err)
+	}
+	for _, m := range matches {
+		fmt.Println(m.Selector.Name)
+	}
+	// Output:
+	// first
+	// second
+}
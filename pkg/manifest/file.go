@@ -0,0 +1,121 @@
+package manifest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileOpener reads the file of a manifest from the location identified by the
+// path of its uri (everything after scheme://). Reading stops when ctx is
+// cancelled.
+type FileOpener func(ctx context.Context, path string) (io.ReadCloser, error)
+
+// fileSchemes are the uri schemes the file of a manifest may have. Files with
+// the file scheme are read from the source of the resource.
+var fileSchemes = map[string]bool{"file": true, "s3": true, "http": true, "https": true}
+
+var (
+	fileOpeners = map[string]FileOpener{
+		"http":  openHTTP("http"),
+		"https": openHTTP("https"),
+	}
+	fileOpenersMu sync.RWMutex
+)
+
+// httpClient fetches files over http.
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// RegisterFileOpener controls how files with the supplied scheme are read,
+// returning the opener it replaces, if any. A nil opener leaves files with the
+// scheme unreadable.
+func RegisterFileOpener(scheme string, fn FileOpener) FileOpener {
+	fileOpenersMu.Lock()
+	defer fileOpenersMu.Unlock()
+	previous := fileOpeners[scheme]
+	if fn == nil {
+		delete(fileOpeners, scheme)
+	} else {
+		fileOpeners[scheme] = fn
+	}
+	return previous
+}
+
+// OpenFile reads a file using the opener registered for its scheme.
+func OpenFile(ctx context.Context, scheme string, path string) (io.ReadCloser, error) {
+	fileOpenersMu.RLock()
+	open, ok := fileOpeners[scheme]
+	fileOpenersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%s://%s: no reader is configured for %s files", scheme, path, scheme)
+	}
+	return open(ctx, path)
+}
+
+// FileURI splits File into its scheme and path. A bare path has the file
+// scheme.
+func (m *Meta) FileURI() (string, string, error) {
+	if m.File == "" || !strings.Contains(m.File, "://") {
+		return "file", m.File, nil
+	}
+	parsed, err := url.Parse(m.File)
+	if err != nil {
+		return "", "", fmt.Errorf("file: %w", err)
+	}
+	if !fileSchemes[parsed.Scheme] {
+		return "", "", fmt.Errorf("file must be a path or a file://, s3:// or http(s):// uri: %s", m.File)
+	}
+	path := strings.TrimPrefix(m.File, parsed.Scheme+"://")
+	if path == "" || parsed.Scheme != "file" && parsed.Host == "" {
+		return "", "", fmt.Errorf("file uri is missing a location: %s", m.File)
+	}
+	return parsed.Scheme, path, nil
+}
+
+// openHTTP reads files with a GET request.
+func openHTTP(scheme string) FileOpener {
+	return func(ctx context.Context, path string) (io.ReadCloser, error) {
+		target := scheme + "://" + path
+		req, reqErr := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if reqErr != nil {
+			return nil, reqErr
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s: %s", target, resp.Status)
+		}
+		return resp.Body, nil
+	}
+}
+
+// OpenFile reads s3://bucket/key files from the configured storage. The
+// bucket of the config is ignored in favor of the one in the path.
+func (c S3Config) OpenFile(ctx context.Context, path string) (io.ReadCloser, error) {
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, fmt.Errorf("s3://%s: expected s3://bucket/key", path)
+	}
+	client, err := c.Client()
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	if err := retry(ctx, func() error {
+		var getErr error
+		data, getErr = getObject(ctx, client, parts[0], parts[1])
+		return getErr
+	}); err != nil {
+		return nil, fmt.Errorf("s3://%s: %w", path, err)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
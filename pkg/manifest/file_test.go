@@ -0,0 +1,55 @@
+package manifest_test
+
+import (
+	"context"
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"strings"
+	"testing"
+)
+
+func TestMeta_FileURI(t *testing.T) {
+	table := map[string]struct {
+		file        string
+		scheme      string
+		path        string
+		expectedErr bool
+	}{
+		"empty":              {file: "", scheme: "file", path: ""},
+		"bare path":          {file: "images/red.jpg", scheme: "file", path: "images/red.jpg"},
+		"file":               {file: "file:///abs/red.jpg", scheme: "file", path: "/abs/red.jpg"},
+		"s3":                 {file: "s3://bucket/images/red.jpg", scheme: "s3", path: "bucket/images/red.jpg"},
+		"http":               {file: "http://example.com/red.jpg", scheme: "http", path: "example.com/red.jpg"},
+		"https":              {file: "https://example.com/red.jpg?w=1", scheme: "https", path: "example.com/red.jpg?w=1"},
+		"unsupported scheme": {file: "ftp://example.com/red.jpg", expectedErr: true},
+		"missing location":   {file: "s3://", expectedErr: true},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			scheme, path, err := (&manifest.Meta{File: test.file}).FileURI()
+			if test.expectedErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if scheme != test.scheme || path != test.path {
+				t.Fatalf("expected %s %s, got %s %s", test.scheme, test.path, scheme, path)
+			}
+		})
+	}
+	_, err := manifest.New([]byte(`{"kind":"k","group":"g","version":"v","namespace":"ns","name":"n","meta":{"file":"ftp://example.com/red.jpg"}}`), "test")
+	if err == nil || !strings.Contains(err.Error(), "file://, s3:// or http(s)://") {
+		t.Fatalf("expected unsupported scheme to be rejected, got %v", err)
+	}
+}
+
+func TestOpenFile_Unregistered(t *testing.T) {
+	_, err := manifest.OpenFile(context.Background(), "gopher", "example.com/red.jpg")
+	if err == nil || !strings.Contains(err.Error(), "no reader is configured for gopher files") {
+		t.Fatalf("expected helpful error, got %v", err)
+	}
+}
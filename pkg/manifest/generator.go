@@ -4,16 +4,30 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/Masterminds/sprig"
+	"github.com/tidwall/gjson"
 	"golang.org/x/sync/errgroup"
+	"strings"
 	"text/template"
 )
 
+// generatedSourcePrefix prefixes the source of every generated manifest.
+const generatedSourcePrefix = "generated by "
+
 // Generator describes how a manifest can generate other manifests.
 type Generator struct {
 	Name     string
 	Loops    []GeneratorRange
 	Template string
 	Context  map[string]interface{}
+	// InheritFields are paths (e.g. spec.author) to fields of the generating
+	// manifest that are exposed to the template by the last segment of their
+	// path (e.g. author).
+	InheritFields []string
+	// ExposedSpecFields and ExposedMetaFields are shorthand for inheriting
+	// fields of the spec or meta of the generating manifest, e.g. domain for
+	// spec.domain.
+	ExposedSpecFields []string
+	ExposedMetaFields []string
 }
 
 type GeneratorRange struct {
@@ -28,9 +42,52 @@ func (g *Generator) Validate() error {
 			return fmt.Errorf("invalid range")
 		}
 	}
+	for _, field := range g.inheritedFields() {
+		if field == "" || strings.HasSuffix(field, ".") || strings.Contains(field, "..") {
+			return fmt.Errorf("invalid inherited field %q", field)
+		}
+	}
 	return nil
 }
 
+// inheritedFields returns the paths of every field of the generating manifest
+// that is exposed to the template.
+func (g *Generator) inheritedFields() []string {
+	fields := append([]string{}, g.InheritFields...)
+	for _, field := range g.ExposedSpecFields {
+		fields = append(fields, "spec."+field)
+	}
+	for _, field := range g.ExposedMetaFields {
+		fields = append(fields, "meta."+field)
+	}
+	return fields
+}
+
+// context combines the context of the generator with the fields inherited
+// from the manifest that hosts it.
+func (g *Generator) context(host *Manifest) (map[string]interface{}, error) {
+	fields := g.inheritedFields()
+	if len(fields) == 0 {
+		return g.Context, nil
+	}
+	data, err := host.JSON()
+	if err != nil {
+		return nil, err
+	}
+	context := map[string]interface{}{}
+	for key, value := range g.Context {
+		context[key] = value
+	}
+	for _, field := range fields {
+		result := gjson.GetBytes(data, field)
+		if !result.Exists() {
+			return nil, fmt.Errorf("inherited field %s not found", field)
+		}
+		context[field[strings.LastIndex(field, ".")+1:]] = result.Value()
+	}
+	return context, nil
+}
+
 func (g *Generator) iterations() [][]int {
 	var sets [][]int
 	for _, loop := range g.Loops {
@@ -64,17 +121,12 @@ func product(sets [][]int) [][]int {
 }
 
 func (g *Generator) Generate(host *Manifest) ([]*Manifest, error) {
-	queue := make(chan *Manifest)
-	collector := errgroup.Group{}
-	var manifests []*Manifest
-	collector.Go(func() error {
-		for manifest := range queue {
-			manifests = append(manifests, manifest)
-		}
-		return nil
-	})
+	generated := &SafeList{}
+	context, contextErr := g.context(host)
+	if contextErr != nil {
+		return nil, contextErr
+	}
 	process := errgroup.Group{}
-
 	for _, iteration := range g.iterations() {
 		iteration := iteration
 		process.Go(func() error {
@@ -89,7 +141,7 @@ func (g *Generator) Generate(host *Manifest) ([]*Manifest, error) {
 			if tmplErr != nil {
 				return tmplErr
 			}
-			if err := tmpl.Execute(&buf, g.Context); err != nil {
+			if err := tmpl.Execute(&buf, context); err != nil {
 				return err
 			}
 			manifests, newErr := New(buf.Bytes(), host.Selector.ID())
@@ -97,16 +149,14 @@ func (g *Generator) Generate(host *Manifest) ([]*Manifest, error) {
 				return fmt.Errorf("\n%s\n%w", buf.String(), newErr)
 			}
 			for _, manifest := range manifests {
-				manifest.Source = fmt.Sprintf("generated by %s", host.Selector)
-				queue <- manifest
+				manifest.Source = generatedSourcePrefix + host.Selector.String()
 			}
+			generated.Append(manifests...)
 			return nil
 		})
 	}
 	if err := process.Wait(); err != nil {
 		return nil, err
 	}
-	close(queue)
-	collector.Wait()
-	return manifests, nil
+	return generated.Slice(), nil
 }
@@ -1,5 +1,98 @@
 package manifest_test
 
+import (
+	json "github.com/json-iterator/go"
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"testing"
+)
+
+func TestGenerator_InheritFields(t *testing.T) {
+	manifests, err := manifest.New([]byte(`{
+		"kind": "website", "group": "content", "version": "v1", "namespace": "author", "name": "alice",
+		"meta": {"live": true},
+		"spec": {"author": "Alice"},
+		"generateManifests": [{
+			"name": "posts",
+			"loops": [{"name": "idx", "range": [1, 3]}],
+			"inheritFields": ["spec.author"],
+			"template": "{\"kind\": \"website\", \"group\": \"content\", \"version\": \"v1\", \"namespace\": \"post\", \"name\": \"post-(( idx ))\", \"spec\": {\"author\": \"(( .author ))\"}}"
+		}]
+	}`), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated := manifests[:len(manifests)-1]
+	if len(generated) != 3 {
+		t.Fatalf("expected 3 generated manifests, got %d", len(generated))
+	}
+	for _, m := range generated {
+		var spec struct{ Author string }
+		if err := json.Unmarshal(m.Spec, &spec); err != nil {
+			t.Fatal(err)
+		}
+		if spec.Author != "Alice" {
+			t.Fatalf("%s: expected author Alice, got %s", m.Selector, spec.Author)
+		}
+	}
+	if _, err := manifest.New([]byte(`{
+		"kind": "website", "group": "content", "version": "v1", "namespace": "author", "name": "bob",
+		"generateManifests": [{"name": "posts", "inheritFields": ["spec.missing"], "template": "{}"}]
+	}`), "test"); err == nil {
+		t.Fatal("expected error for missing inherited field")
+	}
+}
+
+func TestGenerator_ExposedFields(t *testing.T) {
+	manifests, err := manifest.New([]byte(`{
+		"kind": "website", "group": "content", "version": "v1", "namespace": "site", "name": "example",
+		"meta": {"live": true, "title": "Example"},
+		"spec": {"domain": "example.com"},
+		"generateManifests": [{
+			"name": "pages",
+			"loops": [{"name": "idx", "range": [1, 2]}],
+			"exposedSpecFields": ["domain"],
+			"exposedMetaFields": ["title"],
+			"template": "{\"kind\": \"website\", \"group\": \"content\", \"version\": \"v1\", \"namespace\": \"page\", \"name\": \"(( .domain ))-(( idx ))\", \"meta\": {\"title\": \"(( .title ))\"}}"
+		}]
+	}`), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated := manifests[:len(manifests)-1]
+	expected := map[string]bool{
+		"website/content/v1/page/example.com-1": true,
+		"website/content/v1/page/example.com-2": true,
+	}
+	if len(generated) != len(expected) {
+		t.Fatalf("expected %d generated manifests, got %d", len(expected), len(generated))
+	}
+	for _, m := range generated {
+		if !expected[m.Selector.ID()] {
+			t.Fatalf("unexpected generated manifest %s", m.Selector)
+		}
+		if m.Meta.Title != "Example" {
+			t.Fatalf("%s: expected title Example, got %s", m.Selector, m.Meta.Title)
+		}
+	}
+	table := map[string]string{
+		"missing spec field": `"exposedSpecFields": ["missing"]`,
+		"missing meta field": `"exposedMetaFields": ["missing"]`,
+		"empty field":        `"exposedSpecFields": [""]`,
+	}
+	for name, fields := range table {
+		fields := fields
+		t.Run(name, func(t *testing.T) {
+			if _, err := manifest.New([]byte(`{
+				"kind": "website", "group": "content", "version": "v1", "namespace": "site", "name": "example",
+				"spec": {"domain": "example.com"},
+				"generateManifests": [{"name": "pages", `+fields+`, "template": "{}"}]
+			}`), "test"); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
 /*
 func TestGenerator_Generate(t *testing.T) {
 	generator := &manifest.Generator{
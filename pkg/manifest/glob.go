@@ -0,0 +1,67 @@
+package manifest
+
+import (
+	"context"
+	"fmt"
+	"github.com/bmatcuk/doublestar/v4"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+	"runtime"
+)
+
+// NewFromGlob creates manifests from all files matching any of the supplied
+// patterns. Patterns use the syntax of filepath.Match and may include ** to
+// match any number of directories (e.g. content/posts/2024/**/*.md). Files
+// matched by more than one pattern are only loaded once.
+func NewFromGlob(patterns []string, watch progressFn) ([]*Manifest, error) {
+	seen := map[string]bool{}
+	var files []string
+	for _, pattern := range patterns {
+		matches, err := doublestar.FilepathGlob(pattern, doublestar.WithFilesOnly())
+		if err != nil {
+			return nil, fmt.Errorf("glob %s: %w", pattern, err)
+		}
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				files = append(files, match)
+			}
+		}
+	}
+	progress := make(chan struct{})
+	defer close(progress)
+	if watch != nil {
+		go watch(len(files), progress)
+	}
+	results := make([][]*Manifest, len(files))
+	sem := semaphore.NewWeighted(int64(runtime.NumCPU()))
+	eg, egCtx := errgroup.WithContext(context.Background())
+	for idx, file := range files {
+		idx, file := idx, file
+		if err := sem.Acquire(egCtx, 1); err != nil {
+			break
+		}
+		eg.Go(func() error {
+			defer func() {
+				sem.Release(1)
+				if watch != nil {
+					progress <- struct{}{}
+				}
+			}()
+			manifests, err := NewFromFile(file)
+			if err != nil {
+				return err
+			}
+			results[idx] = manifests
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	var manifests []*Manifest
+	for _, result := range results {
+		manifests = append(manifests, result...)
+	}
+	return manifests, nil
+}
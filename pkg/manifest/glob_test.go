@@ -0,0 +1,112 @@
+package manifest_test
+
+import (
+	"fmt"
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewFromGlob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aevitas-glob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for idx, file := range []string{
+		"posts/2023/01/a.json",
+		"posts/2024/01/b.json",
+		"posts/2024/02/deep/c.json",
+		"posts/2024/d.yml",
+		"pages/e.json",
+	} {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		doc := fmt.Sprintf(`{"kind":"k","group":"g","version":"v","namespace":"ns","name":"%d"}`, idx)
+		if err := ioutil.WriteFile(path, []byte(doc), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	table := map[string]struct {
+		patterns []string
+		expected []string
+	}{
+		"single level": {
+			patterns: []string{"posts/*/01/*.json"},
+			expected: []string{"0", "1"},
+		},
+		"any depth": {
+			patterns: []string{"posts/2024/**/*.json"},
+			expected: []string{"1", "2"},
+		},
+		"several patterns": {
+			patterns: []string{"pages/*", "posts/2024/*.yml"},
+			expected: []string{"4", "3"},
+		},
+		"overlapping patterns": {
+			patterns: []string{"posts/2024/**/*.json", "posts/2024/01/*"},
+			expected: []string{"1", "2"},
+		},
+		"directories are not loaded": {
+			patterns: []string{"posts/2024/*"},
+			expected: []string{"3"},
+		},
+		"no matches": {
+			patterns: []string{"missing/**/*.json"},
+		},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			var patterns []string
+			for _, pattern := range test.patterns {
+				patterns = append(patterns, filepath.Join(dir, pattern))
+			}
+			manifests, err := manifest.NewFromGlob(patterns, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var actual []string
+			for _, m := range manifests {
+				actual = append(actual, m.Selector.Name)
+			}
+			if !reflect.DeepEqual(test.expected, actual) {
+				t.Fatalf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+	if _, err := manifest.NewFromGlob([]string{filepath.Join(dir, "[")}, nil); err == nil {
+		t.Fatal("expected error for invalid pattern")
+	}
+}
+
+func TestNewFromGlob_WatchError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aevitas-glob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	watch := func(_ int, progress <-chan struct{}) {
+		for range progress {
+		}
+		close(done)
+	}
+	if _, err := manifest.NewFromGlob([]string{filepath.Join(dir, "*.json")}, watch); err == nil {
+		t.Fatal("expected error for invalid manifest")
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected progress to be closed after an error")
+	}
+}
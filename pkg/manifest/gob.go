@@ -0,0 +1,102 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"sort"
+)
+
+func init() {
+	// Match expression values and generator contexts are decoded from json
+	// and may hold these.
+	gob.Register([]interface{}{})
+	gob.Register(map[string]interface{}{})
+}
+
+// gobIndex is the encoded form of an index.
+type gobIndex struct {
+	Manifests []*Manifest
+	// Relations are keyed by manifest ID as gob cannot encode maps with
+	// pointer keys.
+	Relations            []gobRelations
+	CollationConcurrency int64
+}
+
+type gobRelations struct {
+	Parent  string
+	Related []string
+}
+
+// GobEncode encodes every manifest in a collated index along with the
+// relations between them so the index can be restored without collating it
+// again. Relations of a lazily collated index are resolved first.
+func (i *Index) GobEncode() ([]byte, error) {
+	relations, lazy, _ := i.relationState()
+	if lazy != nil {
+		if err := lazy.resolve(i); err != nil {
+			return nil, err
+		}
+		relations = lazy.relations
+	}
+	encoded := gobIndex{
+		Manifests:            i.Manifests(),
+		CollationConcurrency: i.collationConcurrency,
+	}
+	for parent, related := range relations {
+		ids := make([]string, len(related.all.manifests))
+		for idx, m := range related.all.manifests {
+			ids[idx] = m.Selector.ID()
+		}
+		encoded.Relations = append(encoded.Relations, gobRelations{
+			Parent:  parent.Selector.ID(),
+			Related: ids,
+		})
+	}
+	sort.Slice(encoded.Relations, func(a, b int) bool {
+		return encoded.Relations[a].Parent < encoded.Relations[b].Parent
+	})
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&encoded); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode replaces the content of the index with one produced by GobEncode.
+// The decoded index is collated.
+func (i *Index) GobDecode(data []byte) error {
+	var decoded gobIndex
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&decoded); err != nil {
+		return err
+	}
+	content := newIndex()
+	if err := content.insert(decoded.Manifests...); err != nil {
+		return err
+	}
+	content.collate()
+	relations := make(map[*Manifest]*index, len(decoded.Relations))
+	for _, entry := range decoded.Relations {
+		parent, ok := content.byID[entry.Parent]
+		if !ok {
+			return fmt.Errorf("%s: relations of unknown manifest", entry.Parent)
+		}
+		related := make([]*Manifest, len(entry.Related))
+		for idx, id := range entry.Related {
+			m, ok := content.byID[id]
+			if !ok {
+				return fmt.Errorf("%s: related to unknown manifest %s", entry.Parent, id)
+			}
+			related[idx] = m
+		}
+		relations[parent] = newIndex()
+		if err := relations[parent].insert(related...); err != nil {
+			return err
+		}
+		relations[parent].collate()
+	}
+	i.content = content
+	i.collationConcurrency = decoded.CollationConcurrency
+	i.setRelationState(relations, nil, newRelationsHashCache())
+	return nil
+}
@@ -0,0 +1,135 @@
+package manifest_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"testing"
+)
+
+func TestIndex_GobRoundTrip(t *testing.T) {
+	example, err := manifest.NewFromDirs([]string{"../../example/website", "../../example/core"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, index := range map[string]*manifest.Index{
+		"example": newCollatedIndex(t, example),
+		"numbers": generateIndex(generateManifests(100)),
+	} {
+		index := index
+		t.Run(name, func(t *testing.T) {
+			data, encodeErr := index.GobEncode()
+			if encodeErr != nil {
+				t.Fatal(encodeErr)
+			}
+			decoded := &manifest.Index{}
+			if err := decoded.GobDecode(data); err != nil {
+				t.Fatal(err)
+			}
+			expected := index.Manifests()
+			if actual := decoded.Manifests(); len(actual) != len(expected) {
+				t.Fatalf("expected %d manifests, got %d", len(expected), len(actual))
+			}
+			for _, m := range expected {
+				if !m.IsLive() {
+					continue
+				}
+				found, err := decoded.FindOne(m.Selector)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if found.Hash != m.Hash || found.Source != m.Source || !bytes.Equal(found.Raw, m.Raw) {
+					t.Fatalf("expected %s to be identical after round trip", m.Selector)
+				}
+				if next, actual := index.Next(m), decoded.Next(found); (next == nil) != (actual == nil) || next != nil && next.Selector.ID() != actual.Selector.ID() {
+					t.Fatalf("%s: expected next %v, got %v", m.Selector, next, actual)
+				}
+				if expected, actual := index.RelationsHash(m), decoded.RelationsHash(found); expected != actual {
+					t.Fatalf("%s: expected relations hash %s, got %s", m.Selector, expected, actual)
+				}
+			}
+		})
+	}
+}
+
+func newCollatedIndex(t *testing.T, manifests []*manifest.Manifest) *manifest.Index {
+	t.Helper()
+	index := manifest.NewIndex()
+	if err := index.Insert(manifests...); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Collate(); err != nil {
+		t.Fatal(err)
+	}
+	return index
+}
+
+// rawIndex produces a collated index of manifests decoded from json so they
+// can be written as such. Each year relates to the numbers published in it.
+func rawIndex(b *testing.B, count int) *manifest.Index {
+	var docs []string
+	for year := 2000; year < 2020; year++ {
+		docs = append(docs, fmt.Sprintf(
+			`{"kind":"test","group":"number","version":"v1","namespace":"year","name":"%d","meta":{"live":true,"relations":[{"selector":"test/number/v1/integer/*","matchExpression":[{"operator":"InYear","values":[%d]}]}]}}`,
+			year, year,
+		))
+	}
+	for idx := 0; idx < count; idx++ {
+		docs = append(docs, fmt.Sprintf(
+			`{"kind":"test","group":"number","version":"v1","namespace":"integer","name":"%d","meta":{"live":true,"publishAt":{"year":%d,"month":1,"day":%d}}}`,
+			idx, 2000+idx%20, idx%28+1,
+		))
+	}
+	var manifests []*manifest.Manifest
+	for _, doc := range docs {
+		created, err := manifest.New([]byte(doc), "test")
+		if err != nil {
+			b.Fatal(err)
+		}
+		manifests = append(manifests, created...)
+	}
+	index := manifest.NewIndex()
+	if err := index.Insert(manifests...); err != nil {
+		b.Fatal(err)
+	}
+	if err := index.Collate(); err != nil {
+		b.Fatal(err)
+	}
+	return index
+}
+
+func BenchmarkIndex_Encoding(b *testing.B) {
+	index := rawIndex(b, 10000)
+	b.Run("gob", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			data, err := index.GobEncode()
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := (&manifest.Index{}).GobDecode(data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("json", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			var buf bytes.Buffer
+			if err := index.WriteNDJSON(&buf); err != nil {
+				b.Fatal(err)
+			}
+			manifests, err := manifest.NewFromReader(&buf, nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			decoded := manifest.NewIndex()
+			if err := decoded.Insert(manifests...); err != nil {
+				b.Fatal(err)
+			}
+			if err := decoded.Collate(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
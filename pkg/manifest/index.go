@@ -2,63 +2,351 @@ package manifest
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"github.com/tidwall/pretty"
 	"github.com/tkellen/aevitas/internal/selector"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
 // Index provides fast lookups for finding resources during rendering.
 type Index struct {
-	content   *index
-	relations map[*Manifest]*index
+	content *index
+	// relationsMu guards relations, lazy and relationsHashes so they may be
+	// invalidated by inserts while the index is being read.
+	relationsMu sync.RWMutex
+	relations   map[*Manifest]*index
+	// lazy is populated by LazyCollate and defers resolving relations until
+	// they are first requested. It is shared with every derived index.
+	lazy *lazyRelations
+	// relationsHashes memoizes RelationsHash. It is replaced whenever
+	// relations are recomputed and shared with every derived index.
+	relationsHashes *relationsHashCache
+	// collationConcurrency controls how many manifests may have their
+	// relations resolved simultaneously during collation.
+	collationConcurrency int64
 }
 
 // NewIndex does just what you think it does.
 func NewIndex() *Index {
 	return &Index{
-		content: newIndex(),
+		content:              newIndex(),
+		collationConcurrency: int64(runtime.NumCPU()),
 	}
 }
 
+// WithCollationConcurrency controls how many manifests may have their
+// relations resolved simultaneously during collation. A value of one or less
+// resolves them serially.
+func (i *Index) WithCollationConcurrency(n int64) *Index {
+	i.collationConcurrency = n
+	return i
+}
+
 // String returns the count for each shard in the index as a coarse debugging
 // guide about what is contained within.
 func (i *Index) String() string {
 	format := "%-45s%v"
 	totals := []string{fmt.Sprintf(format, "INDEX SHARD", "COUNT")}
+	counts := i.Count()
 	var shards []string
-	for shard := range i.content.shard {
+	for shard := range counts {
 		shards = append(shards, shard)
 	}
 	sort.Strings(shards)
 	for _, shard := range shards {
-		totals = append(totals, fmt.Sprintf(format, shard, len(i.content.shard[shard].manifests)))
+		totals = append(totals, fmt.Sprintf(format, shard, counts[shard]))
 	}
 	return strings.Join(totals, "\n")
 }
 
+// Count returns the number of live manifests in each KGVN.
+func (i *Index) Count() map[string]int {
+	i.content.mu.RLock()
+	defer i.content.mu.RUnlock()
+	counts := make(map[string]int, len(i.content.shard))
+	for kgvn, shard := range i.content.shard {
+		counts[kgvn] = len(shard.manifests)
+	}
+	return counts
+}
+
+// CountAll does just what Count does while including manifests that are not
+// live.
+func (i *Index) CountAll() map[string]int {
+	counts := i.Count()
+	i.content.mu.RLock()
+	defer i.content.mu.RUnlock()
+	for _, m := range i.content.notLive {
+		counts[m.Selector.KGVN]++
+	}
+	return counts
+}
+
+// IndexStats counts the manifests of a shard.
+type IndexStats struct {
+	Shard   string
+	Live    int
+	NotLive int
+}
+
+// Stats counts the live and not live manifests of every shard, ordered by
+// shard name.
+func (i *Index) Stats() []IndexStats {
+	i.content.mu.RLock()
+	defer i.content.mu.RUnlock()
+	byShard := map[string]*IndexStats{}
+	statsFor := func(kgvn string) *IndexStats {
+		if _, ok := byShard[kgvn]; !ok {
+			byShard[kgvn] = &IndexStats{Shard: kgvn}
+		}
+		return byShard[kgvn]
+	}
+	for kgvn, shard := range i.content.shard {
+		statsFor(kgvn).Live = len(shard.manifests)
+	}
+	for _, m := range i.content.notLive {
+		statsFor(m.Selector.KGVN).NotLive++
+	}
+	stats := make([]IndexStats, 0, len(byShard))
+	for _, entry := range byShard {
+		stats = append(stats, *entry)
+	}
+	sort.Slice(stats, func(a, b int) bool { return stats[a].Shard < stats[b].Shard })
+	return stats
+}
+
+// TotalCount returns the number of live manifests in the index.
+func (i *Index) TotalCount() int {
+	i.content.mu.RLock()
+	defer i.content.mu.RUnlock()
+	return len(i.content.all.manifests)
+}
+
+// IsEmpty reports if the index has no live manifests.
+func (i *Index) IsEmpty() bool { return i.TotalCount() == 0 }
+
 // Insert adds a record to the index. Due to limitations in how relationships
 // between manifests are currently handled, any insert invalidates the entire
 // computed set of relations. In practice, inserting all manifests currently
 // happens once and the index is read-only after that. If that changes, this
 // will likely require revision.
 func (i *Index) Insert(manifests ...*Manifest) error {
-	i.relations = nil
+	i.setRelationState(nil, nil, nil)
 	return i.content.insert(manifests...)
 }
 
+// InsertAllOrNone does just what Insert does if every manifest is live and
+// none are already in the index or repeated in the batch. Otherwise nothing
+// is inserted and every manifest that would not have been is reported.
+func (i *Index) InsertAllOrNone(manifests ...*Manifest) error {
+	if err := i.content.insertAllOrNone(manifests...); err != nil {
+		return err
+	}
+	i.setRelationState(nil, nil, nil)
+	return nil
+}
+
+// relationState returns the relations of the index, those of a lazily
+// collated index and the memoized hashes of both.
+func (i *Index) relationState() (map[*Manifest]*index, *lazyRelations, *relationsHashCache) {
+	i.relationsMu.RLock()
+	defer i.relationsMu.RUnlock()
+	return i.relations, i.lazy, i.relationsHashes
+}
+
+// setRelationState replaces everything returned by relationState.
+func (i *Index) setRelationState(relations map[*Manifest]*index, lazy *lazyRelations, hashes *relationsHashCache) {
+	i.relationsMu.Lock()
+	defer i.relationsMu.Unlock()
+	i.relations = relations
+	i.lazy = lazy
+	i.relationsHashes = hashes
+}
+
+// Manifests returns every manifest in the index. Those that are not live are
+// included (sorted by ID) so derived indexes retain them for helpful error
+// messages.
+func (i *Index) Manifests() []*Manifest {
+	i.content.mu.RLock()
+	defer i.content.mu.RUnlock()
+	manifests := append([]*Manifest{}, i.content.all.manifests...)
+	var notLive []*Manifest
+	for _, m := range i.content.notLive {
+		notLive = append(notLive, m)
+	}
+	sort.Slice(notLive, func(a, b int) bool {
+		return notLive[a].Selector.ID() < notLive[b].Selector.ID()
+	})
+	return append(manifests, notLive...)
+}
+
+// WriteNDJSON writes every manifest in the index to w as one json document per
+// line, suitable for reading with NewFromReader. Generated manifests are
+// omitted as the manifests that generated them recreate them when read.
+func (i *Index) WriteNDJSON(w io.Writer) error {
+	return writeNDJSON(w, i.Manifests())
+}
+
+// WriteSorted does just what WriteNDJSON does with manifests sorted by ID so
+// the output is deterministic.
+func (i *Index) WriteSorted(w io.Writer) error {
+	manifests := i.Manifests()
+	sort.Slice(manifests, func(a, b int) bool {
+		return manifests[a].Selector.ID() < manifests[b].Selector.ID()
+	})
+	return writeNDJSON(w, manifests)
+}
+
+func writeNDJSON(w io.Writer, manifests []*Manifest) error {
+	for _, m := range manifests {
+		if m.IsGenerated() {
+			continue
+		}
+		data, err := m.JSON()
+		if err != nil {
+			return fmt.Errorf("%s: %w", m, err)
+		}
+		if _, err := w.Write(append(pretty.Ugly(data), '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reload produces a new, collated index where every manifest that originated
+// from one of the supplied files (including those it generated) is replaced
+// by the current content of the file. Files that no longer exist have their
+// manifests removed. The receiver is not modified.
+func (i *Index) Reload(paths ...string) (*Index, error) {
+	changed := map[string]struct{}{}
+	for _, path := range paths {
+		changed[filepath.Clean(path)] = struct{}{}
+	}
+	manifests := i.Manifests()
+	generatedBy := map[string]struct{}{}
+	for _, m := range manifests {
+		if _, ok := changed[filepath.Clean(m.Source)]; ok {
+			generatedBy[generatedSourcePrefix+m.Selector.String()] = struct{}{}
+		}
+	}
+	var kept []*Manifest
+	for _, m := range manifests {
+		if _, ok := changed[filepath.Clean(m.Source)]; ok {
+			continue
+		}
+		if _, ok := generatedBy[m.Source]; ok {
+			continue
+		}
+		kept = append(kept, m)
+	}
+	for _, path := range paths {
+		reloaded, err := NewFromFile(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		kept = append(kept, reloaded...)
+	}
+	derived := NewIndex()
+	if err := derived.Insert(kept...); err != nil {
+		return nil, err
+	}
+	if err := derived.Collate(); err != nil {
+		return nil, err
+	}
+	return derived, nil
+}
+
+// WithDefaults produces a new index where every manifest in the supplied KGVN
+// has had the defaults applied. The receiver is not modified. Manifests
+// outside the KGVN are shared with the original so the derived index should be
+// treated as read-only.
+func (i *Index) WithDefaults(kgvn string, defaults *Meta) (*Index, error) {
+	derived := NewIndex()
+	manifests := i.Manifests()
+	for idx, m := range manifests {
+		if m.Selector.KGVN == kgvn {
+			withDefaults, err := m.ApplyDefaults(defaults)
+			if err != nil {
+				return nil, err
+			}
+			manifests[idx] = withDefaults
+		}
+	}
+	if err := derived.Insert(manifests...); err != nil {
+		return nil, err
+	}
+	relations, lazy, _ := i.relationState()
+	if lazy != nil {
+		if err := derived.LazyCollate(); err != nil {
+			return nil, err
+		}
+	} else if relations != nil {
+		if err := derived.Collate(); err != nil {
+			return nil, err
+		}
+	}
+	return derived, nil
+}
+
+// WithCaching controls if the results of FindMany are memoized. Inserting
+// into or collating the index clears them and they are not used while
+// relations are being resolved.
+func (i *Index) WithCaching(enabled bool) *Index {
+	i.content.findCacheMu.Lock()
+	defer i.content.findCacheMu.Unlock()
+	i.content.findCaching = enabled
+	i.content.findCache = nil
+	i.content.findGeneration++
+	return i
+}
+
+// ClearFindCache discards the memoized results of FindMany.
+func (i *Index) ClearFindCache() { i.content.clearFindCache() }
+
 // FindMany produces an array of manifests whose selectors match the one provided.
 func (i *Index) FindMany(target *selector.Selector) ([]*Manifest, error) {
+	cached, generation, ok := i.content.cachedFind(target)
+	if ok {
+		return cached, nil
+	}
+	matches, err := i.findMany(target)
+	if err != nil {
+		return nil, err
+	}
+	i.content.cacheFind(target, matches, generation)
+	return matches, nil
+}
+
+func (i *Index) findMany(target *selector.Selector) ([]*Manifest, error) {
+	if target.IsVersionWildcard() {
+		return i.content.findVersions(target)
+	}
 	if target.IsWildcard() {
-		shard, shardErr := i.content.shardOf(target)
+		var matches []*Manifest
+		shardErr := i.content.inShard(target, func(shard *shard) {
+			// ensure a copy is returned to prevent external mutation (e.g
+			// sorting) ugh. i should use rust.
+			matches = make([]*Manifest, len(shard.manifests))
+			copy(matches, shard.manifests)
+		})
 		if shardErr != nil {
 			return nil, shardErr
 		}
-		// ensure a copy is returned to prevent external mutation (e.g sorting)
-		// ugh. i should use rust.
-		return append([]*Manifest{}, shard.manifests...), nil
+		return matches, nil
 	}
 	match, err := i.content.findOne(target, false)
 	if err != nil {
@@ -67,6 +355,48 @@ func (i *Index) FindMany(target *selector.Selector) ([]*Manifest, error) {
 	return []*Manifest{match}, nil
 }
 
+// FindManyInNamespaces finds every manifest of the supplied kind, group and
+// version in any of the namespaces. Results are ordered by publish date, earliest
+// first, and then by ID.
+func (i *Index) FindManyInNamespaces(kind, group, version string, namespaces []string) ([]*Manifest, error) {
+	seen := map[string]bool{}
+	var found []*Manifest
+	for _, namespace := range namespaces {
+		target, err := selector.New(fmt.Sprintf("%s/%s/%s/%s/*", kind, group, version, namespace))
+		if err != nil {
+			return nil, err
+		}
+		matches, err := i.FindMany(target)
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range matches {
+			if id := match.Selector.ID(); !seen[id] {
+				seen[id] = true
+				found = append(found, match)
+			}
+		}
+	}
+	sort.Slice(found, func(a, b int) bool { return publishedThenID(found[a], found[b]) })
+	return found, nil
+}
+
+// FindManyByKind finds every manifest of the supplied kind regardless of group,
+// version or namespace. Results are ordered by KGVN and then as they are
+// within each shard.
+func (i *Index) FindManyByKind(kind string) []*Manifest {
+	i.content.mu.RLock()
+	defer i.content.mu.RUnlock()
+	return append([]*Manifest{}, i.content.byKind[kind]...)
+}
+
+// FindManyByGroup does just what FindManyByKind does for a group.
+func (i *Index) FindManyByGroup(group string) []*Manifest {
+	i.content.mu.RLock()
+	defer i.content.mu.RUnlock()
+	return append([]*Manifest{}, i.content.byGroup[group]...)
+}
+
 // FindMany locates a single manifest based on the selector provided.
 func (i *Index) FindOne(target *selector.Selector) (*Manifest, error) {
 	return i.content.findOne(target, false)
@@ -74,39 +404,57 @@ func (i *Index) FindOne(target *selector.Selector) (*Manifest, error) {
 
 // Next finds the next latest manifest within the target's KGVN.
 func (i *Index) Next(target *Manifest) *Manifest {
-	shard, shardErr := i.content.shardOf(target.Selector)
-	if shardErr != nil {
-		return nil
-	}
-	return shard.next(target)
+	var found *Manifest
+	i.content.inShard(target.Selector, func(shard *shard) {
+		found = shard.next(target)
+	})
+	return found
 }
 
 // Prev finds the next earliest manifest within the target's KGVN.
 func (i *Index) Prev(target *Manifest) *Manifest {
-	shard, shardErr := i.content.shardOf(target.Selector)
-	if shardErr != nil {
-		return nil
-	}
-	return shard.previous(target)
+	var found *Manifest
+	i.content.inShard(target.Selector, func(shard *shard) {
+		found = shard.previous(target)
+	})
+	return found
 }
 
 // SameMonthDay finds manifest within the target's KGVN that were published on
 // the same month and day.
 func (i *Index) SameMonthDay(target *Manifest) []*Manifest {
-	shard, shardErr := i.content.shardOf(target.Selector)
-	if shardErr != nil {
-		return nil
-	}
-	return shard.sameMonthDay(target)
+	var found []*Manifest
+	i.content.inShard(target.Selector, func(shard *shard) {
+		found = shard.sameMonthDay(target)
+	})
+	return found
+}
+
+// SameYear finds manifests within the target's KGVN that were published in the
+// same year, excluding the target itself.
+func (i *Index) SameYear(target *Manifest) []*Manifest {
+	var found []*Manifest
+	i.content.inShard(target.Selector, func(shard *shard) {
+		found = shard.sameYearAs(target)
+	})
+	return found
 }
 
 // RelatedIndex returns a new index which contains only manifests which are
 // related to the supplied target.
 func (i *Index) RelatedIndex(target *Manifest) (*Index, error) {
-	if index, ok := i.relations[target]; ok {
+	index, err := i.relationsOf(target)
+	if err != nil {
+		return nil, err
+	}
+	if index != nil {
+		relations, lazy, hashes := i.relationState()
 		return &Index{
-			content:   index,
-			relations: i.relations,
+			content:              index,
+			relations:            relations,
+			lazy:                 lazy,
+			relationsHashes:      hashes,
+			collationConcurrency: i.collationConcurrency,
 		}, nil
 	}
 	return nil, fmt.Errorf("unable to find relationships for %s", target)
@@ -115,7 +463,65 @@ func (i *Index) RelatedIndex(target *Manifest) (*Index, error) {
 // RelationsHash returns a unique identifier for all relations of the target
 // manifest.
 func (i *Index) RelationsHash(target *Manifest) string {
-	return i.relations[target].hash()
+	_, _, hashes := i.relationState()
+	if hashes != nil {
+		if hash, ok := hashes.get(target); ok {
+			return hash
+		}
+	}
+	index, err := i.relationsOf(target)
+	if err != nil || index == nil {
+		return ""
+	}
+	hash := index.hash()
+	if hashes != nil {
+		hashes.set(target, hash)
+	}
+	return hash
+}
+
+// relationsHashCache holds the relations hash of each manifest once computed.
+type relationsHashCache struct {
+	mu     sync.RWMutex
+	hashes map[*Manifest]string
+}
+
+func newRelationsHashCache() *relationsHashCache {
+	return &relationsHashCache{hashes: map[*Manifest]string{}}
+}
+
+func (c *relationsHashCache) get(target *Manifest) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	hash, ok := c.hashes[target]
+	return hash, ok
+}
+
+func (c *relationsHashCache) set(target *Manifest, hash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hashes[target] = hash
+}
+
+// relationsOf finds the relations of the target, resolving them first if
+// the index was lazily collated.
+func (i *Index) relationsOf(target *Manifest) (*index, error) {
+	i.relationsMu.RLock()
+	lazy := i.lazy
+	related := i.relations[target]
+	i.relationsMu.RUnlock()
+	if lazy == nil {
+		return related, nil
+	}
+	if err := lazy.resolve(i); err != nil {
+		return nil, err
+	}
+	index, ok := lazy.relations[target]
+	if !ok {
+		return nil, nil
+	}
+	lazy.collated[target].Do(index.collate)
+	return index, nil
 }
 
 func (i *Index) isRelated(target *Manifest, mustRelateTo *selector.Selector) (bool, error) {
@@ -150,7 +556,8 @@ func (i *Index) FindManyWithRelation(target *selector.Selector, mustRelateTo *se
 	if findErr != nil {
 		return nil, findErr
 	}
-	var validMatches []*Manifest
+	// Every match may be valid so allocate enough room for all of them.
+	validMatches := make([]*Manifest, 0, len(matches))
 	for _, match := range matches {
 		if ok, _ := i.isRelated(match, mustRelateTo); ok {
 			validMatches = append(validMatches, match)
@@ -159,46 +566,313 @@ func (i *Index) FindManyWithRelation(target *selector.Selector, mustRelateTo *se
 	return validMatches, nil
 }
 
+// Collate sorts the index and resolves the relations of every manifest in it.
+// Sorting first ensures resolution does not depend on insertion order.
 func (i *Index) Collate() error {
-	i.relations = map[*Manifest]*index{}
+	return i.CollateContext(context.Background())
+}
+
+// CollateContext does just what Collate does but stops resolving relations,
+// returning the error of the context, once it is done.
+func (i *Index) CollateContext(ctx context.Context) error {
+	i.content.collate()
+	if err := i.resolveAll(ctx); err != nil {
+		return err
+	}
+	relations, _, _ := i.relationState()
+	for _, index := range relations {
+		index.collate()
+	}
+	return nil
+}
+
+// LazyCollate does just what Collate does but only validates and sorts the
+// index itself. Relations are resolved the first time any are requested and
+// the relations of each manifest are only sorted when that manifest is. This
+// is useful when a small portion of a large index is being rendered.
+func (i *Index) LazyCollate() error {
+	for _, m := range i.content.all.manifests {
+		if i.content.byID[m.Selector.ID()] != m {
+			return fmt.Errorf("%s: duplicate id", m.Selector)
+		}
+	}
+	i.setRelationState(nil, &lazyRelations{}, newRelationsHashCache())
+	i.content.collate()
+	return nil
+}
+
+// lazyRelations holds the relations of a lazily collated index.
+type lazyRelations struct {
+	once      sync.Once
+	err       error
+	relations map[*Manifest]*index
+	// collated ensures the relations of each manifest are sorted once, on
+	// first use. It is populated during resolution and read-only after.
+	collated map[*Manifest]*sync.Once
+}
+
+// resolve computes relations for every manifest in the supplied index the
+// first time it is called.
+func (l *lazyRelations) resolve(i *Index) error {
+	l.once.Do(func() {
+		// Resolution reads relations from the index it resolves against, so
+		// it is performed eagerly on a copy to avoid re-entering once.
+		resolver := &Index{
+			content:              i.content,
+			collationConcurrency: i.collationConcurrency,
+		}
+		if l.err = resolver.resolveAll(context.Background()); l.err != nil {
+			return
+		}
+		l.relations, _, _ = resolver.relationState()
+		l.collated = make(map[*Manifest]*sync.Once, len(l.relations))
+		for m := range l.relations {
+			l.collated[m] = &sync.Once{}
+		}
+	})
+	return l.err
+}
+
+// resolveAll records the relations of every manifest in the index.
+func (i *Index) resolveAll(ctx context.Context) error {
+	i.setRelationState(map[*Manifest]*index{}, nil, newRelationsHashCache())
+	i.content.suspendFindCache()
+	defer i.content.resumeFindCache()
+	var sem *semaphore.Weighted
+	if i.collationConcurrency > 1 {
+		sem = semaphore.NewWeighted(i.collationConcurrency)
+	}
 	totalCount := 0
 	lastCount := -1
 	// Because relationships can be indirect, this repeatedly passes over the
 	// index until all relationships are resolved.
 	for lastCount != totalCount {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		lastCount = totalCount
-		totalCount = 0
-		for _, item := range i.content.all.manifests {
-			if item.Meta == nil {
+		var err error
+		if sem == nil {
+			totalCount, err = i.collateSerial()
+		} else {
+			totalCount, err = i.collateParallel(ctx, sem)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return i.checkImportCycles()
+}
+
+// CyclicRelationError reports manifests whose imports lead back to them.
+type CyclicRelationError struct {
+	// A imports B, which imports A directly or through others.
+	A, B *Manifest
+}
+
+func (e *CyclicRelationError) Error() string {
+	return fmt.Sprintf("import cycle: %s imports %s, which imports it in turn", e.A, e.B)
+}
+
+// checkImportCycles finds manifests whose imports lead back to them. Only
+// imports of a single manifest are considered as wildcard imports commonly
+// include the manifest that declares them (e.g. partials importing partials).
+func (i *Index) checkImportCycles() error {
+	imports := map[string]map[string]struct{}{}
+	byID := map[string]*Manifest{}
+	for _, item := range i.content.all.manifests {
+		byID[item.Selector.ID()] = item
+		if item.Meta == nil {
+			continue
+		}
+		for _, toImport := range item.Meta.Imports {
+			relation, err := toImport.relationFor(item)
+			if err != nil {
+				return fmt.Errorf("%s: resolving relations: %w", item, err)
+			}
+			if relation.Selector.IsWildcard() {
 				continue
 			}
-			relations := append(item.Meta.Imports, item.Meta.Relations...)
-			for _, child := range item.Meta.Children {
-				relations = append(relations, child.Relation)
+			if imports[item.Selector.ID()] == nil {
+				imports[item.Selector.ID()] = map[string]struct{}{}
 			}
-			var related []*Manifest
-			for _, relation := range relations {
-				expanded, err := relation.Resolve(i)
-				if !relation.Selector.IsWildcard() && err != nil {
-					return fmt.Errorf("%s: resolving relations: %w", item, err)
+			imports[item.Selector.ID()][relation.Selector.ID()] = struct{}{}
+		}
+	}
+	// Depth first search, a manifest imported while its own imports are
+	// being visited closes a cycle.
+	const visiting, visited = 1, 2
+	state := map[string]int{}
+	var visit func(id string) error
+	visit = func(id string) error {
+		state[id] = visiting
+		targets := make([]string, 0, len(imports[id]))
+		for target := range imports[id] {
+			targets = append(targets, target)
+		}
+		sort.Strings(targets)
+		for _, target := range targets {
+			switch state[target] {
+			case visiting:
+				return &CyclicRelationError{A: byID[id], B: byID[target]}
+			case 0:
+				if err := visit(target); err != nil {
+					return err
 				}
-				related = append(related, expanded...)
 			}
-			totalCount = totalCount + len(related)
-			// skip redundant passes
-			if m, ok := i.relations[item]; ok {
-				if len(m.byID) == len(related) {
-					continue
-				}
+		}
+		state[id] = visited
+		return nil
+	}
+	for _, item := range i.content.all.manifests {
+		if state[item.Selector.ID()] == 0 {
+			if err := visit(item.Selector.ID()); err != nil {
+				return err
 			}
-			if err := i.addRelation(item, related...); err != nil {
-				return fmt.Errorf("adding relations to %s: %w", item, err)
+		}
+	}
+	return nil
+}
+
+// collateSerial makes a single pass over the index resolving and recording the
+// relations of each manifest in turn. It returns the total count of relations.
+func (i *Index) collateSerial() (int, error) {
+	totalCount := 0
+	for _, item := range i.content.all.manifests {
+		related, err := i.resolveRelations(item)
+		if err != nil {
+			return 0, err
+		}
+		totalCount = totalCount + len(related)
+		if err := i.recordRelations(item, related); err != nil {
+			return 0, err
+		}
+	}
+	return totalCount, nil
+}
+
+// collateParallel does just what collateSerial does but resolves relations
+// concurrently. Resolution only reads from the index so the results are
+// recorded serially once every manifest is resolved. This ensures resolution
+// never observes partially recorded relations.
+func (i *Index) collateParallel(ctx context.Context, sem *semaphore.Weighted) (int, error) {
+	items := i.content.all.manifests
+	resolved := make([][]*Manifest, len(items))
+	eg, egCtx := errgroup.WithContext(ctx)
+	for idx, item := range items {
+		if err := sem.Acquire(egCtx, 1); err != nil {
+			// The context is cancelled when resolution fails or the caller
+			// gives up, both are reported below.
+			break
+		}
+		idx, item := idx, item
+		eg.Go(func() error {
+			defer sem.Release(1)
+			related, err := i.resolveRelations(item)
+			resolved[idx] = related
+			return err
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return 0, err
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	totalCount := 0
+	for idx, item := range items {
+		totalCount = totalCount + len(resolved[idx])
+		if err := i.recordRelations(item, resolved[idx]); err != nil {
+			return 0, err
+		}
+	}
+	return totalCount, nil
+}
+
+// ResolveAll resolves each relation against the index, returning the matches
+// of each in the order supplied. Up to concurrency relations are resolved at
+// once; a value of one or less resolves them serially. Resolution only reads
+// from the index so it is safe once the index is collated.
+func (i *Index) ResolveAll(ctx context.Context, relations []*Relation, concurrency int64) ([][]*Manifest, error) {
+	resolved := make([][]*Manifest, len(relations))
+	if concurrency <= 1 || len(relations) < 2 {
+		for idx, relation := range relations {
+			expanded, err := relation.Resolve(i)
+			if err != nil {
+				return nil, err
 			}
+			resolved[idx] = expanded
 		}
+		return resolved, nil
 	}
-	i.content.collate()
-	for _, index := range i.relations {
-		index.collate()
+	sem := semaphore.NewWeighted(concurrency)
+	eg, egCtx := errgroup.WithContext(ctx)
+	for idx, relation := range relations {
+		if err := sem.Acquire(egCtx, 1); err != nil {
+			break
+		}
+		idx, relation := idx, relation
+		eg.Go(func() error {
+			defer sem.Release(1)
+			expanded, err := relation.Resolve(i)
+			resolved[idx] = expanded
+			return err
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	// The context may be cancelled by the caller without any resolution
+	// failing.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return resolved, nil
+}
+
+// resolveRelations expands every relation, import and child of a manifest.
+func (i *Index) resolveRelations(item *Manifest) ([]*Manifest, error) {
+	if item.Meta == nil {
+		return nil, nil
+	}
+	relations := append(append([]*Relation{}, item.Meta.Imports...), item.Meta.Relations...)
+	for _, child := range item.Meta.Children {
+		relations = append(relations, child.Relation)
+	}
+	var related []*Manifest
+	for _, relation := range relations {
+		relation, err := relation.relationFor(item)
+		if err != nil {
+			return nil, fmt.Errorf("%s: resolving relations: %w", item, err)
+		}
+		expanded, err := relation.Resolve(i)
+		if !relation.Selector.IsWildcard() && err != nil {
+			return nil, fmt.Errorf("%s: resolving relations: %w", item, err)
+		}
+		related = append(related, expanded...)
+	}
+	return related, nil
+}
+
+// recordRelations stores the resolved relations of a manifest.
+func (i *Index) recordRelations(item *Manifest, related []*Manifest) error {
+	if item.Meta == nil {
+		return nil
+	}
+	// skip redundant passes
+	i.relationsMu.RLock()
+	m, ok := i.relations[item]
+	i.relationsMu.RUnlock()
+	if ok {
+		m.mu.RLock()
+		recorded := len(m.byID)
+		m.mu.RUnlock()
+		if recorded == len(related) {
+			return nil
+		}
+	}
+	if err := i.addRelation(item, related...); err != nil {
+		return fmt.Errorf("adding relations to %s: %w", item, err)
 	}
 	return nil
 }
@@ -206,9 +880,6 @@ func (i *Index) Collate() error {
 // addRelation records a relationship from one manifest to another and the
 // inverse relationship back.
 func (i *Index) addRelation(parent *Manifest, manifests ...*Manifest) error {
-	if _, ok := i.relations[parent]; !ok {
-		i.relations[parent] = newIndex()
-	}
 	relations := make([]*Manifest, len(manifests))
 	for idx, m := range manifests {
 		manifest, err := i.FindOne(m.Selector)
@@ -217,6 +888,11 @@ func (i *Index) addRelation(parent *Manifest, manifests ...*Manifest) error {
 		}
 		relations[idx] = manifest
 	}
+	i.relationsMu.Lock()
+	defer i.relationsMu.Unlock()
+	if _, ok := i.relations[parent]; !ok {
+		i.relations[parent] = newIndex()
+	}
 	// Make parent relations to all supplied manifests. Ignore duplicate insertion
 	// errors as this is expected.
 	_ = i.relations[parent].insert(relations...)
@@ -232,10 +908,27 @@ func (i *Index) addRelation(parent *Manifest, manifests ...*Manifest) error {
 }
 
 type index struct {
+	// mu guards everything below so manifests may be inserted while the
+	// index is being read.
+	mu      sync.RWMutex
 	all     *shard // all manifests.
 	byID    map[string]*Manifest
 	notLive map[string]*Manifest
 	shard   map[string]*shard // manifests sharded by KGVN
+	// byKind and byGroup hold the manifests of every shard sharing a kind or
+	// group. They are built during collation and rebuilt by any insert that
+	// follows it.
+	byKind  map[string][]*Manifest
+	byGroup map[string][]*Manifest
+	// findCache memoizes FindMany by selector ID when findCaching is enabled
+	// and no resolution has suspended it. findGeneration changes whenever
+	// the cache is cleared so results found before then are not stored.
+	// These are guarded by findCacheMu.
+	findCache          map[string][]*Manifest
+	findCaching        bool
+	findCacheSuspended int
+	findGeneration     uint64
+	findCacheMu        sync.RWMutex
 }
 
 func newIndex() *index {
@@ -247,16 +940,82 @@ func newIndex() *index {
 	}
 }
 
+// cachedFind returns a copy of the memoized matches of a selector. The
+// generation of the cache is returned for storing matches found on a miss.
+func (i *index) cachedFind(target *selector.Selector) ([]*Manifest, uint64, bool) {
+	i.findCacheMu.RLock()
+	defer i.findCacheMu.RUnlock()
+	if !i.findCaching || i.findCacheSuspended > 0 {
+		return nil, i.findGeneration, false
+	}
+	cached, ok := i.findCache[target.ID()]
+	if !ok {
+		return nil, i.findGeneration, false
+	}
+	return append([]*Manifest{}, cached...), i.findGeneration, true
+}
+
+// cacheFind memoizes a copy of the matches of a selector unless the cache has
+// been cleared since the generation they were found in.
+func (i *index) cacheFind(target *selector.Selector, matches []*Manifest, generation uint64) {
+	i.findCacheMu.Lock()
+	defer i.findCacheMu.Unlock()
+	if !i.findCaching || i.findCacheSuspended > 0 || i.findGeneration != generation {
+		return
+	}
+	if i.findCache == nil {
+		i.findCache = map[string][]*Manifest{}
+	}
+	i.findCache[target.ID()] = append([]*Manifest{}, matches...)
+}
+
+func (i *index) clearFindCache() {
+	i.findCacheMu.Lock()
+	defer i.findCacheMu.Unlock()
+	i.findCache = nil
+	i.findGeneration++
+}
+
+// suspendFindCache bypasses the cache until a matching call to
+// resumeFindCache. Calls may be nested.
+func (i *index) suspendFindCache() {
+	i.findCacheMu.Lock()
+	defer i.findCacheMu.Unlock()
+	i.findCacheSuspended++
+}
+
+func (i *index) resumeFindCache() {
+	i.findCacheMu.Lock()
+	defer i.findCacheMu.Unlock()
+	i.findCacheSuspended--
+}
+
 func (i *index) shardOf(target *selector.Selector) (*shard, error) {
+	var found *shard
+	err := i.inShard(target, func(shard *shard) {
+		found = shard
+	})
+	return found, err
+}
+
+// inShard calls fn with the shard of the target's KGVN while holding the read
+// lock. Shards are modified in place by inserts so they must not be read once
+// it has returned.
+func (i *index) inShard(target *selector.Selector, fn func(*shard)) error {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
 	shardKey := target.KGVN
 	shard, exists := i.shard[shardKey]
-	if exists {
-		return shard, nil
+	if !exists {
+		return fmt.Errorf("%s is empty", shardKey)
 	}
-	return nil, fmt.Errorf("%s is empty", shardKey)
+	fn(shard)
+	return nil
 }
 
 func (i *index) hash() string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
 	var hash strings.Builder
 	for _, entry := range i.all.manifests {
 		hash.WriteString(entry.Hash)
@@ -265,15 +1024,77 @@ func (i *index) hash() string {
 }
 
 func (i *index) collate() {
-	i.all.collate()
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.clearFindCache()
+	// Shards that were collated before manifests were inserted remain so.
+	if !i.all.collated {
+		i.sortAll()
+	}
 	for _, shard := range i.shard {
-		shard.collate()
+		if !shard.collated {
+			shard.collate()
+		}
+	}
+	i.groupShards()
+}
+
+// groupShards builds byKind and byGroup from the shards in KGVN order. It must
+// be called with the write lock held.
+func (i *index) groupShards() {
+	keys := make([]string, 0, len(i.shard))
+	for key := range i.shard {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	i.byKind = map[string][]*Manifest{}
+	i.byGroup = map[string][]*Manifest{}
+	for _, key := range keys {
+		parts := strings.SplitN(key, "/", 3)
+		manifests := i.shard[key].manifests
+		i.byKind[parts[0]] = append(i.byKind[parts[0]], manifests...)
+		i.byGroup[parts[1]] = append(i.byGroup[parts[1]], manifests...)
 	}
 }
 
+// sortAll collates every manifest in the index in a total order so the result
+// is the same regardless of the order manifests were inserted.
+func (i *index) sortAll() {
+	i.all.less = publishedThenID
+	i.all.collate()
+}
+
 var notFound = errors.New("resource not found")
 
+// findVersions collects manifests matching a selector with a version wildcard
+// from every shard. Shards are visited in order so results are stable.
+func (i *index) findVersions(target *selector.Selector) ([]*Manifest, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	keys := make([]string, 0, len(i.shard))
+	for key, shard := range i.shard {
+		if len(shard.manifests) > 0 && target.MatchesKGVN(shard.manifests[0].Selector) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var matches []*Manifest
+	for _, key := range keys {
+		for _, m := range i.shard[key].manifests {
+			if target.Matches(m.Selector) {
+				matches = append(matches, m)
+			}
+		}
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%w: %s", notFound, target.ID())
+	}
+	return matches, nil
+}
+
 func (i *index) findOne(target *selector.Selector, fastError bool) (*Manifest, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
 	id := target.ID()
 	manifest, found := i.byID[id]
 	if !found {
@@ -296,7 +1117,44 @@ func (i *index) findOne(target *selector.Selector, fastError bool) (*Manifest, e
 // manifest of the same ID has been previously inserted, trigger an error. This
 // error is for detecting duplicates during initial index creation.
 func (i *index) insert(manifests ...*Manifest) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.insertLocked(manifests...)
+}
+
+// insertAllOrNone inserts manifests only if a dry run into a temporary index
+// finds that all of them would be accepted.
+func (i *index) insertAllOrNone(manifests ...*Manifest) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	var rejected bytes.Buffer
+	dryRun := newIndex()
+	for _, m := range manifests {
+		id := m.Selector.ID()
+		if !m.IsLive() {
+			rejected.WriteString(fmt.Sprintf("%s: not live\n", m.Selector))
+			continue
+		}
+		if _, ok := i.byID[id]; ok {
+			rejected.WriteString(fmt.Sprintf("%s: already indexed\n", m.Selector))
+			continue
+		}
+		if err := dryRun.insertLocked(m); err != nil {
+			rejected.WriteString(fmt.Sprintf("%s: repeated in batch\n", m.Selector))
+		}
+	}
+	if rejected.Len() > 0 {
+		return fmt.Errorf("nothing inserted, rejected:\n%s", rejected.String())
+	}
+	return i.insertLocked(manifests...)
+}
+
+// insertLocked must be called with the write lock held.
+func (i *index) insertLocked(manifests ...*Manifest) error {
+	i.clearFindCache()
 	var collisions bytes.Buffer
+	var all []*Manifest
+	batches := map[string][]*Manifest{}
 	for _, m := range manifests {
 		id := m.Selector.ID()
 		// skip unpublished resources (save for helpful error messages though).
@@ -310,15 +1168,24 @@ func (i *index) insert(manifests ...*Manifest) error {
 			continue
 		}
 		i.byID[id] = m
-		i.all.insert(m)
+		all = append(all, m)
 		// shard index by kind group version namespace.
-		shardKey := m.Selector.KGVN
+		batches[m.Selector.KGVN] = append(batches[m.Selector.KGVN], m)
+	}
+	if len(all) > 0 {
+		i.all.insert(all)
+	}
+	for shardKey, batch := range batches {
 		shard, ok := i.shard[shardKey]
 		if !ok {
 			i.shard[shardKey] = newShard()
 			shard = i.shard[shardKey]
 		}
-		shard.insert(m)
+		shard.insert(batch)
+	}
+	// Manifests inserted after collation are found by kind and group too.
+	if i.byKind != nil && len(all) > 0 {
+		i.groupShards()
 	}
 	// If there were any collisions, enumerate them all in the returned error.
 	if collisions.Len() > 0 {
@@ -337,23 +1204,56 @@ func (l manifestList) Less(i, j int) bool { return l[i].Less(l[j]) }
 type shard struct {
 	manifests []*Manifest
 	collated  bool
-	before    map[*Manifest]*Manifest
-	after     map[*Manifest]*Manifest
-	sameTime  map[time.Time][]*Manifest
+	// less orders the manifests of the shard.
+	less     func(a, b *Manifest) bool
+	before   map[*Manifest]*Manifest
+	after    map[*Manifest]*Manifest
+	sameTime map[time.Time][]*Manifest
+	sameYear map[int][]*Manifest
 }
 
 // newShard does just what you think it does.
 func newShard() *shard {
 	return &shard{
 		manifests: []*Manifest{},
+		less:      lessThenID,
+	}
+}
+
+// lessThenID orders manifests just as Less does, breaking ties in publish date
+// by ID so a shard is ordered the same however it was populated.
+func lessThenID(a, b *Manifest) bool {
+	if less, greater := a.Less(b), b.Less(a); less != greater {
+		return less
+	}
+	return a.Selector.ID() < b.Selector.ID()
+}
+
+// publishedThenID orders manifests by publish date, treating those without one
+// as the earliest, and then by ID. Unlike Less it is a total order, so the
+// order of every manifest in an index never depends on insertion order.
+func publishedThenID(a, b *Manifest) bool {
+	var x, y time.Time
+	if a.Meta != nil {
+		x = a.PublishAt()
+	}
+	if b.Meta != nil {
+		y = b.PublishAt()
+	}
+	if !x.Equal(y) {
+		return x.Before(y)
 	}
+	return a.Selector.ID() < b.Selector.ID()
 }
 
 func (l *shard) collate() {
-	sort.Sort(manifestList(l.manifests))
+	sort.Slice(l.manifests, func(a, b int) bool {
+		return l.less(l.manifests[a], l.manifests[b])
+	})
 	l.before = map[*Manifest]*Manifest{}
 	l.after = map[*Manifest]*Manifest{}
 	l.sameTime = map[time.Time][]*Manifest{}
+	l.sameYear = map[int][]*Manifest{}
 	count := len(l.manifests)
 	if count > 1 {
 		for idx, manifest := range l.manifests {
@@ -365,6 +1265,10 @@ func (l *shard) collate() {
 			}
 			timeKey := manifest.PublishMonthDay()
 			l.sameTime[timeKey] = append(l.sameTime[timeKey], manifest)
+			if manifest.Meta.PublishAt != nil {
+				year := manifest.Meta.PublishAt.Year
+				l.sameYear[year] = append(l.sameYear[year], manifest)
+			}
 		}
 	}
 	l.collated = true
@@ -382,9 +1286,78 @@ func (l *shard) sameMonthDay(compare *Manifest) []*Manifest {
 	return l.sameTime[compare.PublishMonthDay()]
 }
 
-func (l *shard) insert(manifests ...*Manifest) {
+func (l *shard) sameYearAs(compare *Manifest) []*Manifest {
+	if compare.Meta.PublishAt == nil {
+		return nil
+	}
+	var matches []*Manifest
+	for _, manifest := range l.sameYear[compare.Meta.PublishAt.Year] {
+		if manifest != compare {
+			matches = append(matches, manifest)
+		}
+	}
+	return matches
+}
+
+// insert adds manifests to the shard, keeping it collated if it already is.
+func (l *shard) insert(manifests []*Manifest) {
+	if !l.collated {
+		l.insertBatch(manifests)
+		return
+	}
+	for _, m := range manifests {
+		l.insertSorted(m)
+	}
+}
+
+// insertBatch adds manifests to the shard, marking it as needing collation.
+func (l *shard) insertBatch(manifests []*Manifest) {
 	l.collated = false
-	for _, manifest := range manifests {
-		l.manifests = append(l.manifests, manifest)
+	l.manifests = append(l.manifests, manifests...)
+}
+
+// insertSorted adds a manifest to a collated shard in its sorted position,
+// updating navigation so the shard remains collated.
+func (l *shard) insertSorted(m *Manifest) {
+	// Collation of a single manifest records no navigation, so there is
+	// nothing to update until there are two.
+	if !l.collated || len(l.manifests) < 2 {
+		l.manifests = append(l.manifests, m)
+		l.collate()
+		return
+	}
+	pos := sort.Search(len(l.manifests), func(idx int) bool {
+		return l.less(m, l.manifests[idx])
+	})
+	l.manifests = append(l.manifests, nil)
+	copy(l.manifests[pos+1:], l.manifests[pos:])
+	l.manifests[pos] = m
+	if pos > 0 {
+		prev := l.manifests[pos-1]
+		l.before[m] = prev
+		l.after[prev] = m
 	}
+	if pos+1 < len(l.manifests) {
+		next := l.manifests[pos+1]
+		l.after[m] = next
+		l.before[next] = m
+	}
+	timeKey := m.PublishMonthDay()
+	l.sameTime[timeKey] = withSorted(l.sameTime[timeKey], m, l.less)
+	if m.Meta.PublishAt != nil {
+		year := m.Meta.PublishAt.Year
+		l.sameYear[year] = withSorted(l.sameYear[year], m, l.less)
+	}
+}
+
+// withSorted produces a copy of a sorted list with the manifest inserted in
+// its sorted position. A copy is made as these lists are shared with callers.
+func withSorted(list []*Manifest, m *Manifest, less func(a, b *Manifest) bool) []*Manifest {
+	pos := sort.Search(len(list), func(idx int) bool {
+		return less(m, list[idx])
+	})
+	result := make([]*Manifest, 0, len(list)+1)
+	result = append(result, list[:pos]...)
+	result = append(result, m)
+	return append(result, list[pos:]...)
 }
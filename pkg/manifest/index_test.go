@@ -1,13 +1,25 @@
 package manifest_test
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/tkellen/aevitas/internal/selector"
+	"github.com/tkellen/aevitas/internal/testhelper"
 	"github.com/tkellen/aevitas/pkg/manifest"
+	"io"
+	"io/ioutil"
 	"math/big"
 	"math/rand"
 	"moul.io/number-to-words"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -286,3 +298,1171 @@ func TestIndex_Relationships(t *testing.T) {
 	}
 }
 */
+
+func BenchmarkIndex_Insert(b *testing.B) {
+	numbers := generateManifests(10000)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		index := manifest.NewIndex()
+		if err := index.Insert(numbers...); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestIndex_SameYear(t *testing.T) {
+	index := manifest.NewIndex()
+	var target *manifest.Manifest
+	for _, year := range []int{2018, 2019, 2020} {
+		for day := 1; day <= 10; day++ {
+			m := &manifest.Manifest{
+				Selector: selector.Must(fmt.Sprintf("test/number/v1/date/%d-%d", year, day)),
+				Meta: &manifest.Meta{
+					Live:      true,
+					PublishAt: &manifest.PublishAt{Year: year, Month: 1, Day: day},
+				},
+			}
+			if year == 2019 && day == 5 {
+				target = m
+			}
+			if err := index.Insert(m); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := index.Collate(); err != nil {
+		t.Fatal(err)
+	}
+	matches := index.SameYear(target)
+	if len(matches) != 9 {
+		t.Fatalf("expected 9 matches, got %d", len(matches))
+	}
+	for _, match := range matches {
+		if match == target {
+			t.Fatal("expected target to be excluded")
+		}
+		if match.Meta.PublishAt.Year != 2019 {
+			t.Fatalf("expected match from 2019, got %d", match.Meta.PublishAt.Year)
+		}
+	}
+}
+
+func TestIndex_EncodedName(t *testing.T) {
+	manifests, err := manifest.New([]byte(`{"kind":"k","group":"g","version":"v","namespace":"ns","name":"2024/01/15","meta":{"live":true}}`), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "k/g/v/ns/2024%2F01%2F15"; manifests[0].Selector.ID() != expected {
+		t.Fatalf("expected %s, got %s", expected, manifests[0].Selector.ID())
+	}
+	index := testhelper.MakeIndex(t, manifests...)
+	for _, target := range []string{"k/g/v/ns/2024%2F01%2F15", "k/g/v/ns/2024%2f01%2f15"} {
+		found, err := index.FindOne(selector.Must(target))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != manifests[0] {
+			t.Fatalf("expected %s, got %s", manifests[0].Selector, found.Selector)
+		}
+	}
+	if _, err := index.FindOne(selector.Must("k/g/v/ns/2024")); err == nil {
+		t.Fatal("expected no manifest to match a prefix of the name")
+	}
+}
+
+func TestIndex_FindManyVersionWildcard(t *testing.T) {
+	index := manifest.NewIndex()
+	for _, version := range []string{"v1", "v1.1", "v1.2", "v2.0"} {
+		if err := index.Insert(&manifest.Manifest{
+			Selector: selector.Must(fmt.Sprintf("test/number/%s/integer/one", version)),
+			Meta:     &manifest.Meta{Live: true},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := index.Collate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, target := range []string{"test/number/v1.*/integer/*", "test/number/v1.*/integer/one"} {
+		matches, err := index.FindMany(selector.Must(target))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var actual []string
+		for _, match := range matches {
+			actual = append(actual, match.Selector.ID())
+		}
+		expected := "test/number/v1.1/integer/one test/number/v1.2/integer/one test/number/v1/integer/one"
+		if strings.Join(actual, " ") != expected {
+			t.Fatalf("expected %s, got %s", expected, actual)
+		}
+	}
+	if _, err := index.FindMany(selector.Must("test/number/v3.*/integer/*")); err == nil {
+		t.Fatal("expected error when no versions match")
+	}
+}
+
+func TestIndex_FindManyInNamespaces(t *testing.T) {
+	index := manifest.NewIndex()
+	for idx, id := range []string{"ns1/c", "ns2/a", "ns1/b", "ns3/a", "ns2/b"} {
+		if err := index.Insert(&manifest.Manifest{
+			Selector: selector.Must("test/post/v1/" + id),
+			Meta: &manifest.Meta{
+				Live:      true,
+				PublishAt: &manifest.PublishAt{Year: 2020, Month: 1, Day: 5 - idx%2},
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := index.Collate(); err != nil {
+		t.Fatal(err)
+	}
+	matches, err := index.FindManyInNamespaces("test", "post", "v1", []string{"ns2", "ns1", "ns2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual []string
+	for _, match := range matches {
+		actual = append(actual, match.Selector.ID())
+	}
+	expected := "test/post/v1/ns2/a test/post/v1/ns1/b test/post/v1/ns1/c test/post/v1/ns2/b"
+	if strings.Join(actual, " ") != expected {
+		t.Fatalf("expected %s, got %s", expected, actual)
+	}
+	if _, err := index.FindManyInNamespaces("test", "post", "v1", []string{"ns1", "missing"}); err == nil {
+		t.Fatal("expected error when a namespace is empty")
+	}
+}
+
+func TestIndex_WithDefaults(t *testing.T) {
+	index := manifest.NewIndex()
+	authors := []string{"tyler", "", "alex", "", "sam"}
+	for idx, author := range authors {
+		if err := index.Insert(&manifest.Manifest{
+			Selector: selector.Must(fmt.Sprintf("test/number/v1/integer/%s", asWord(idx))),
+			Meta:     &manifest.Meta{Live: true, Author: author},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other := &manifest.Manifest{
+		Selector: selector.Must("test/number/v1/other/one"),
+		Meta:     &manifest.Meta{Live: true},
+	}
+	if err := index.Insert(other); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Collate(); err != nil {
+		t.Fatal(err)
+	}
+	derived, err := index.WithDefaults("test/number/v1/integer", &manifest.Meta{Author: "default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	modified := 0
+	for idx, author := range authors {
+		target := selector.Must(fmt.Sprintf("test/number/v1/integer/%s", asWord(idx)))
+		original, _ := index.FindOne(target)
+		if original.Meta.Author != author {
+			t.Fatalf("expected original to be unchanged, got %s", original.Meta.Author)
+		}
+		m, err := derived.FindOne(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := author
+		if author == "" {
+			expected = "default"
+			modified++
+		}
+		if m.Meta.Author != expected {
+			t.Fatalf("expected %s, got %s", expected, m.Meta.Author)
+		}
+		if changed := m.Hash != original.Hash; changed != (author == "") {
+			t.Fatalf("expected hash to change only when defaults apply, got %s for %s", m.Hash, original.Hash)
+		}
+	}
+	if modified != 2 {
+		t.Fatalf("expected 2 manifests to be modified, got %d", modified)
+	}
+	if m, _ := derived.FindOne(other.Selector); m != other {
+		t.Fatal("expected manifests outside the kgvn to be shared")
+	}
+}
+
+func TestIndex_FindMany(t *testing.T) {
+	numbers := generateManifests(1000)
+	index := generateIndex(numbers)
+	matches, err := index.FindMany(selector.Must("test/number/v1/integer/*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != len(numbers) {
+		t.Fatalf("expected %d matches, got %d", len(numbers), len(matches))
+	}
+	for idx, match := range matches {
+		if match != numbers[idx] {
+			t.Fatalf("expected %s at %d, got %s", numbers[idx], idx, match)
+		}
+	}
+	// Mutating the result must not alter the index.
+	matches[0] = nil
+	again, _ := index.FindMany(selector.Must("test/number/v1/integer/*"))
+	if again[0] != numbers[0] {
+		t.Fatal("expected index to be unaffected by mutation of results")
+	}
+	single, err := index.FindMany(selector.Must("test/number/v1/integer/one"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(single) != 1 || single[0] != numbers[1] {
+		t.Fatalf("expected only %s, got %v", numbers[1], single)
+	}
+}
+
+func TestIndex_FindManyByKind(t *testing.T) {
+	var manifests []*manifest.Manifest
+	for _, id := range []string{
+		"image/jpeg/v1/photo/one",
+		"image/jpeg/v2/photo/two",
+		"image/png/v1/diagram/three",
+		"audio/mpeg/v1/song/four",
+		"website/content/v1/post/five",
+		"website/image/v1/post/six",
+	} {
+		manifests = append(manifests, testhelper.MakeManifest(t, id, "", ""))
+	}
+	index := testhelper.MakeIndex(t, manifests...)
+	ids := func(manifests []*manifest.Manifest) []string {
+		var result []string
+		for _, m := range manifests {
+			result = append(result, m.Selector.ID())
+		}
+		return result
+	}
+	table := map[string]struct {
+		actual   []*manifest.Manifest
+		expected []string
+	}{
+		"kind image": {
+			actual:   index.FindManyByKind("image"),
+			expected: []string{"image/jpeg/v1/photo/one", "image/jpeg/v2/photo/two", "image/png/v1/diagram/three"},
+		},
+		"kind audio": {
+			actual:   index.FindManyByKind("audio"),
+			expected: []string{"audio/mpeg/v1/song/four"},
+		},
+		"kind missing": {
+			actual: index.FindManyByKind("video"),
+		},
+		"group image": {
+			actual:   index.FindManyByGroup("image"),
+			expected: []string{"website/image/v1/post/six"},
+		},
+		"group jpeg": {
+			actual:   index.FindManyByGroup("jpeg"),
+			expected: []string{"image/jpeg/v1/photo/one", "image/jpeg/v2/photo/two"},
+		},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			if actual := ids(test.actual); !reflect.DeepEqual(test.expected, actual) {
+				t.Fatalf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+	// Mutating the result must not alter the index.
+	images := index.FindManyByKind("image")
+	images[0] = nil
+	if index.FindManyByKind("image")[0] == nil {
+		t.Fatal("expected index to be unaffected by mutation of results")
+	}
+	// Manifests inserted after collation are found without collating again.
+	if err := index.Insert(testhelper.MakeManifest(t, "image/gif/v1/photo/seven", "", "")); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"image/gif/v1/photo/seven", "image/jpeg/v1/photo/one", "image/jpeg/v2/photo/two", "image/png/v1/diagram/three"}
+	if actual := ids(index.FindManyByKind("image")); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	if actual := ids(index.FindManyByGroup("gif")); !reflect.DeepEqual([]string{"image/gif/v1/photo/seven"}, actual) {
+		t.Fatalf("expected %v, got %v", []string{"image/gif/v1/photo/seven"}, actual)
+	}
+}
+
+func BenchmarkIndex_FindMany(b *testing.B) {
+	index := manifest.NewIndex()
+	if err := index.Insert(generateManifests(10000)...); err != nil {
+		b.Fatal(err)
+	}
+	table := map[string]*selector.Selector{
+		"wildcard": selector.Must("test/number/v1/integer/*"),
+		"single":   selector.Must("test/number/v1/integer/one"),
+	}
+	for name, target := range table {
+		target := target
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				if _, err := index.FindMany(target); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkIndex_FindManyWithRelation(b *testing.B) {
+	index := generateIndex(generateManifests(10000))
+	target := selector.Must("test/number/v1/integer/*")
+	even := selector.Must("test/number/v1/set/even")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		matches, err := index.FindManyWithRelation(target, even)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(matches) != 5000 {
+			b.Fatalf("expected %d matches, got %d", 5000, len(matches))
+		}
+	}
+}
+
+func TestIndex_WithCaching(t *testing.T) {
+	numbers := generateManifests(100)
+	index := generateIndex(numbers[:50]).WithCaching(true)
+	target := selector.Must("test/number/v1/integer/*")
+	matches, err := index.FindMany(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Mutating a cached result must not alter later ones.
+	matches[0] = nil
+	cached, _ := index.FindMany(target)
+	if len(cached) != 50 || cached[0] != numbers[0] {
+		t.Fatal("expected cache hits to return a copy")
+	}
+	// Inserting must discard cached results.
+	if err := index.Insert(numbers[50:]...); err != nil {
+		t.Fatal(err)
+	}
+	if inserted, _ := index.FindMany(target); len(inserted) != 100 {
+		t.Fatalf("expected %d matches after insert, got %d", 100, len(inserted))
+	}
+	if err := index.Insert(testhelper.MakeManifest(t, "test/number/v1/integer/extra", "", "")); err != nil {
+		t.Fatal(err)
+	}
+	index.ClearFindCache()
+	if cleared, _ := index.FindMany(target); len(cleared) != 101 {
+		t.Fatalf("expected %d matches after clearing, got %d", 101, len(cleared))
+	}
+}
+
+func BenchmarkIndex_FindManyCaching(b *testing.B) {
+	var manifests []*manifest.Manifest
+	for version := 0; version < 10; version++ {
+		for namespace := 0; namespace < 100; namespace++ {
+			for name := 0; name < 10; name++ {
+				manifests = append(manifests, testhelper.MakeManifest(b,
+					fmt.Sprintf("test/number/v1.%d/ns%d/n%d", version, namespace, name), "", "",
+				))
+			}
+		}
+	}
+	var targets []*selector.Selector
+	for namespace := 0; namespace < 100; namespace++ {
+		targets = append(targets, selector.Must(fmt.Sprintf("test/number/v1.*/ns%d/*", namespace)))
+	}
+	for _, enabled := range []bool{true, false} {
+		index := testhelper.MakeIndex(b, manifests...).WithCaching(enabled)
+		b.Run(fmt.Sprintf("caching %t", enabled), func(b *testing.B) {
+			b.ReportAllocs()
+			for n := 0; n < b.N; n++ {
+				for call := 0; call < 10000; call++ {
+					if _, err := index.FindMany(targets[call%len(targets)]); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestIndex_InsertAllOrNone(t *testing.T) {
+	existing := testhelper.MakeManifest(t, "test/batch/v1/item/existing", "", "")
+	index := testhelper.MakeIndex(t, existing)
+	valid := testhelper.MakeManifest(t, "test/batch/v1/item/valid", "", "")
+	duplicate := testhelper.MakeManifest(t, "test/batch/v1/item/existing", "", "")
+	err := index.InsertAllOrNone(valid, duplicate)
+	if err == nil || !strings.Contains(err.Error(), "test/batch/v1/item/existing: already indexed") {
+		t.Fatalf("expected error listing the duplicate, got %v", err)
+	}
+	if _, findErr := index.FindOne(valid.Selector); findErr == nil {
+		t.Fatal("expected valid manifest not to be inserted")
+	}
+	notLive := testhelper.MakeManifest(t, "test/batch/v1/item/draft", "", "")
+	notLive.Meta.Live = false
+	err = index.InsertAllOrNone(valid, valid, notLive)
+	if err == nil || !strings.Contains(err.Error(), "item/valid: repeated in batch") || !strings.Contains(err.Error(), "item/draft: not live") {
+		t.Fatalf("expected error listing every rejection, got %v", err)
+	}
+	if count := len(index.Manifests()); count != 1 {
+		t.Fatalf("expected %d manifest, got %d", 1, count)
+	}
+	if err := index.InsertAllOrNone(valid); err != nil {
+		t.Fatal(err)
+	}
+	if _, findErr := index.FindOne(valid.Selector); findErr != nil {
+		t.Fatal(findErr)
+	}
+}
+
+func TestIndex_CollateImportCycle(t *testing.T) {
+	imports := func(targets ...string) string {
+		var relations []string
+		for _, target := range targets {
+			relations = append(relations, `{"selector":"test/cycle/v1/item/`+target+`"}`)
+		}
+		return `{"imports":[` + strings.Join(relations, ",") + `]}`
+	}
+	table := map[string]struct {
+		imports  map[string]string
+		expected [2]string
+	}{
+		"no cycle": {
+			imports: map[string]string{"a": imports("b", "c"), "b": imports("c"), "c": imports()},
+		},
+		"pair": {
+			imports:  map[string]string{"a": imports("b"), "b": imports("a")},
+			expected: [2]string{"test/cycle/v1/item/b", "test/cycle/v1/item/a"},
+		},
+		"indirect": {
+			imports:  map[string]string{"a": imports("b"), "b": imports("c"), "c": imports("a")},
+			expected: [2]string{"test/cycle/v1/item/c", "test/cycle/v1/item/a"},
+		},
+		"self": {
+			imports:  map[string]string{"a": imports("a")},
+			expected: [2]string{"test/cycle/v1/item/a", "test/cycle/v1/item/a"},
+		},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			var manifests []*manifest.Manifest
+			for name, meta := range test.imports {
+				manifests = append(manifests, testhelper.MakeManifest(t, "test/cycle/v1/item/"+name, meta, ""))
+			}
+			index := manifest.NewIndex()
+			if err := index.Insert(manifests...); err != nil {
+				t.Fatal(err)
+			}
+			err := index.Collate()
+			if test.expected[0] == "" {
+				if err != nil {
+					t.Fatalf("unexpected err %s", err)
+				}
+				return
+			}
+			var cycleErr *manifest.CyclicRelationError
+			if !errors.As(err, &cycleErr) {
+				t.Fatalf("expected cyclic relation error, got %v", err)
+			}
+			if actual := [2]string{cycleErr.A.Selector.ID(), cycleErr.B.Selector.ID()}; actual != test.expected {
+				t.Fatalf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestIndex_Reload(t *testing.T) {
+	dir, tempErr := ioutil.TempDir("", "aevitas-reload")
+	if tempErr != nil {
+		t.Fatal(tempErr)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name string, title string) string {
+		path := filepath.Join(dir, name)
+		doc := fmt.Sprintf(`{"kind":"k","group":"g","version":"v","namespace":"ns","name":"%s","meta":{"live":true,"title":"%s"}}`, name, title)
+		if err := ioutil.WriteFile(path, []byte(doc), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	changed := write("changed", "before")
+	removed := write("removed", "before")
+	write("unchanged", "before")
+	manifests, err := manifest.NewFromDirs([]string{dir}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := manifest.NewIndex()
+	if err := index.Insert(manifests...); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Collate(); err != nil {
+		t.Fatal(err)
+	}
+	write("changed", "after")
+	if err := os.Remove(removed); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, reloadErr := index.Reload(changed, removed)
+	if reloadErr != nil {
+		t.Fatal(reloadErr)
+	}
+	if m, _ := reloaded.FindOne(selector.Must("k/g/v/ns/changed")); m == nil || m.Meta.Title != "after" {
+		t.Fatalf("expected changed manifest to be reloaded, got %v", m)
+	}
+	if _, err := reloaded.FindOne(selector.Must("k/g/v/ns/removed")); err == nil {
+		t.Fatal("expected removed manifest to be gone")
+	}
+	before, _ := index.FindOne(selector.Must("k/g/v/ns/unchanged"))
+	after, _ := reloaded.FindOne(selector.Must("k/g/v/ns/unchanged"))
+	if before == nil || before != after {
+		t.Fatal("expected unchanged manifest to be retained")
+	}
+	if m, _ := index.FindOne(selector.Must("k/g/v/ns/changed")); m.Meta.Title != "before" {
+		t.Fatal("expected original index to be unmodified")
+	}
+}
+
+func TestIndex_WriteNDJSON(t *testing.T) {
+	manifests, err := manifest.NewFromDirs([]string{"../../example/website", "../../example/core"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := manifest.NewIndex()
+	if err := index.Insert(manifests...); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Collate(); err != nil {
+		t.Fatal(err)
+	}
+	for name, write := range map[string]func(io.Writer) error{
+		"unsorted": index.WriteNDJSON,
+		"sorted":   index.WriteSorted,
+	} {
+		write := write
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := write(&buf); err != nil {
+				t.Fatal(err)
+			}
+			read, readErr := manifest.NewFromReader(&buf, nil)
+			if readErr != nil {
+				t.Fatal(readErr)
+			}
+			roundTrip := manifest.NewIndex()
+			if err := roundTrip.Insert(read...); err != nil {
+				t.Fatal(err)
+			}
+			if err := roundTrip.Collate(); err != nil {
+				t.Fatal(err)
+			}
+			expected := index.Manifests()
+			if actual := roundTrip.Manifests(); len(actual) != len(expected) {
+				t.Fatalf("expected %d manifests, got %d", len(expected), len(actual))
+			}
+			for _, m := range expected {
+				if !m.IsLive() {
+					continue
+				}
+				found, err := roundTrip.FindOne(m.Selector)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(found.Meta, m.Meta) || found.Body != m.Body || !bytes.Equal(found.Spec, m.Spec) {
+					t.Fatalf("expected %s to be equivalent after round trip", m)
+				}
+			}
+		})
+	}
+}
+
+// denseManifests produces manifests that each relate to the next degree
+// manifests, wrapping around at the end.
+func denseManifests(count int, degree int) []*manifest.Manifest {
+	manifests := generateManifests(count)
+	for idx, m := range manifests {
+		for offset := 1; offset <= degree; offset++ {
+			m.Meta.Relations = append(m.Meta.Relations, &manifest.Relation{
+				Selector: manifests[(idx+offset)%count].Selector,
+			})
+		}
+	}
+	return manifests
+}
+
+func TestIndex_CollateParallel(t *testing.T) {
+	example, err := manifest.NewFromDirs([]string{"../../example/website", "../../example/core"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sets := generateIndex(nil).Manifests()
+	table := map[string][]*manifest.Manifest{
+		"example": example,
+		"dense":   append(denseManifests(500, 10), sets...),
+	}
+	for name, manifests := range table {
+		manifests := manifests
+		t.Run(name, func(t *testing.T) {
+			collate := func(concurrency int64) *manifest.Index {
+				index := manifest.NewIndex().WithCollationConcurrency(concurrency)
+				if err := index.Insert(manifests...); err != nil {
+					t.Fatal(err)
+				}
+				if err := index.Collate(); err != nil {
+					t.Fatal(err)
+				}
+				return index
+			}
+			serial := collate(1)
+			parallel := collate(8)
+			for _, m := range serial.Manifests() {
+				if !m.IsLive() {
+					continue
+				}
+				serialRelated, _ := serial.RelatedIndex(m)
+				parallelRelated, _ := parallel.RelatedIndex(m)
+				if len(serialRelated.Manifests()) != len(parallelRelated.Manifests()) {
+					t.Fatalf("%s: expected %d relations, got %d", m, len(serialRelated.Manifests()), len(parallelRelated.Manifests()))
+				}
+				if serial.RelationsHash(m) != parallel.RelationsHash(m) {
+					t.Fatalf("%s: expected identical relations", m)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkIndex_Collate(b *testing.B) {
+	manifests := append(denseManifests(5000, 10), generateIndex(nil).Manifests()...)
+	for name, concurrency := range map[string]int64{"serial": 1, "parallel": int64(runtime.NumCPU())} {
+		concurrency := concurrency
+		b.Run(name, func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				index := manifest.NewIndex().WithCollationConcurrency(concurrency)
+				if err := index.Insert(manifests...); err != nil {
+					b.Fatal(err)
+				}
+				if err := index.Collate(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestIndex_LazyCollate(t *testing.T) {
+	example, err := manifest.NewFromDirs([]string{"../../example/website", "../../example/core"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := map[string][]*manifest.Manifest{
+		"example": example,
+		"dense":   append(denseManifests(500, 10), generateIndex(nil).Manifests()...),
+	}
+	for name, manifests := range table {
+		manifests := manifests
+		t.Run(name, func(t *testing.T) {
+			eager := manifest.NewIndex()
+			lazy := manifest.NewIndex()
+			if err := eager.Insert(manifests...); err != nil {
+				t.Fatal(err)
+			}
+			if err := lazy.Insert(manifests...); err != nil {
+				t.Fatal(err)
+			}
+			if err := eager.Collate(); err != nil {
+				t.Fatal(err)
+			}
+			if err := lazy.LazyCollate(); err != nil {
+				t.Fatal(err)
+			}
+			for _, m := range eager.Manifests() {
+				if !m.IsLive() {
+					continue
+				}
+				if expected, actual := eager.Next(m), lazy.Next(m); expected != actual {
+					t.Fatalf("%s: expected next %s, got %s", m, expected, actual)
+				}
+				if expected, actual := eager.Prev(m), lazy.Prev(m); expected != actual {
+					t.Fatalf("%s: expected prev %s, got %s", m, expected, actual)
+				}
+				if eager.RelationsHash(m) != lazy.RelationsHash(m) {
+					t.Fatalf("%s: expected identical relations", m)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkIndex_LazyCollate(b *testing.B) {
+	manifests := append(denseManifests(10000, 10), generateIndex(nil).Manifests()...)
+	rendered := manifests[:100]
+	collate := map[string]func(index *manifest.Index) error{
+		"eager": (*manifest.Index).Collate,
+		"lazy":  (*manifest.Index).LazyCollate,
+	}
+	for name, fn := range collate {
+		fn := fn
+		b.Run(name, func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				index := manifest.NewIndex()
+				if err := index.Insert(manifests...); err != nil {
+					b.Fatal(err)
+				}
+				if err := fn(index); err != nil {
+					b.Fatal(err)
+				}
+				for _, m := range rendered {
+					if _, err := index.RelatedIndex(m); err != nil {
+						b.Fatal(err)
+					}
+					index.Next(m)
+					index.Prev(m)
+				}
+			}
+		})
+	}
+}
+
+// wildcardImports produces size manifests in each of count namespaces
+// and a relation selecting every manifest of each namespace.
+func wildcardImports(count int, size int) ([]*manifest.Manifest, []*manifest.Relation) {
+	var manifests []*manifest.Manifest
+	var relations []*manifest.Relation
+	for ns := 0; ns < count; ns++ {
+		for idx := 0; idx < size; idx++ {
+			manifests = append(manifests, &manifest.Manifest{
+				Selector: selector.Must(fmt.Sprintf("test/import/v1/ns-%d/%d", ns, idx)),
+				Meta: &manifest.Meta{
+					Live:      true,
+					PublishAt: &manifest.PublishAt{Year: 2020, Month: 1, Day: idx%28 + 1},
+				},
+			})
+		}
+		relations = append(relations, &manifest.Relation{
+			Selector: selector.Must(fmt.Sprintf("test/import/v1/ns-%d/*", ns)),
+		})
+	}
+	return manifests, relations
+}
+
+func TestIndex_ResolveAll(t *testing.T) {
+	manifests, relations := wildcardImports(50, 100)
+	index := testhelper.MakeIndex(t, manifests...)
+	serial, err := index.ResolveAll(context.Background(), relations, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	concurrent, err := index.ResolveAll(context.Background(), relations, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(serial, concurrent) {
+		t.Fatal("expected concurrent resolution to match serial resolution")
+	}
+	missing := append(relations, &manifest.Relation{Selector: selector.Must("test/import/v1/missing/name")})
+	if _, err := index.ResolveAll(context.Background(), missing, 8); err == nil {
+		t.Fatal("expected error resolving missing manifest")
+	}
+}
+
+func BenchmarkIndex_ResolveAll(b *testing.B) {
+	manifests, relations := wildcardImports(50, 100)
+	index := testhelper.MakeIndex(b, manifests...)
+	for name, concurrency := range map[string]int64{"serial": 1, "concurrent": int64(runtime.NumCPU())} {
+		concurrency := concurrency
+		b.Run(name, func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				if _, err := index.ResolveAll(context.Background(), relations, concurrency); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// hubManifests produces a manifest related to count others.
+func hubManifests(count int) (*manifest.Manifest, []*manifest.Manifest) {
+	manifests := generateManifests(count)
+	hub := &manifest.Manifest{
+		Selector: selector.Must("test/number/v1/hub/all"),
+		Meta: &manifest.Meta{
+			Live:      true,
+			Relations: []*manifest.Relation{{Selector: selector.Must("test/number/v1/integer/*")}},
+		},
+	}
+	for idx, m := range manifests {
+		m.Meta.Relations = nil
+		m.Hash = fmt.Sprintf("%064d", idx)
+	}
+	return hub, append(manifests, hub)
+}
+
+func TestIndex_RelationsHash(t *testing.T) {
+	hub, manifests := hubManifests(100)
+	index := testhelper.MakeIndex(t, manifests...)
+	uncached := index.RelationsHash(hub)
+	if cached := index.RelationsHash(hub); cached != uncached {
+		t.Fatalf("expected %s, got %s", uncached, cached)
+	}
+	related, err := index.RelatedIndex(hub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if derived := related.RelationsHash(hub); derived != uncached {
+		t.Fatalf("expected %s, got %s", uncached, derived)
+	}
+	added := generateManifests(101)[100]
+	added.Meta.Relations = nil
+	added.Hash = "changed"
+	if err := index.Insert(added); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Collate(); err != nil {
+		t.Fatal(err)
+	}
+	if invalidated := index.RelationsHash(hub); invalidated == uncached {
+		t.Fatal("expected inserting a related manifest to invalidate the cached hash")
+	}
+}
+
+func BenchmarkIndex_RelationsHash(b *testing.B) {
+	hub, manifests := hubManifests(100)
+	index := testhelper.MakeIndex(b, manifests...)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for templates := 0; templates < 1000; templates++ {
+			index.RelationsHash(hub)
+		}
+	}
+}
+
+func TestRelation_Limit(t *testing.T) {
+	manifests, relations := wildcardImports(1, 100)
+	index := testhelper.MakeIndex(t, manifests...)
+	table := map[string]struct {
+		limit     int
+		offset    int
+		expected  int
+		unlimited bool
+	}{
+		"zero is unlimited":     {limit: 0, expected: 100, unlimited: true},
+		"negative is unlimited": {limit: -1, expected: 100, unlimited: true},
+		"one":                   {limit: 1, expected: 1},
+		"fifty":                 {limit: 50, expected: 50},
+		"past the end":          {limit: 50, offset: 90, expected: 10},
+		"unlimited with offset": {limit: 0, offset: 90, expected: 10, unlimited: true},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			relation := *relations[0]
+			relation.Limit = test.limit
+			relation.Offset = test.offset
+			if relation.IsUnlimited() != test.unlimited {
+				t.Fatalf("expected IsUnlimited to be %v", test.unlimited)
+			}
+			matches, err := relation.Resolve(index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(matches) != test.expected {
+				t.Fatalf("expected %d matches, got %d", test.expected, len(matches))
+			}
+		})
+	}
+	for _, invalid := range []string{`"limit":-2`, `"offset":-1`} {
+		input := `{"kind":"k","group":"g","version":"v","namespace":"ns","name":"n","meta":{"relations":[{"selector":"a/b/c/d/*",` + invalid + `}]}}`
+		if _, err := manifest.New([]byte(input), "test"); err == nil {
+			t.Fatalf("expected %s to be rejected", invalid)
+		}
+	}
+}
+
+func TestRelation_SelectorTemplate(t *testing.T) {
+	relations := `{"relations":[{"name":"category","selectorTemplate":"website/category/v1/site/{{.Spec.category}}"}]}`
+	news := testhelper.MakeManifest(t, "website/category/v1/site/news", "", "")
+	sports := testhelper.MakeManifest(t, "website/category/v1/site/sports", "", "")
+	first := testhelper.MakeManifest(t, "website/content/v1/post/first", relations, `{"category":"news"}`)
+	second := testhelper.MakeManifest(t, "website/content/v1/post/second", relations, `{"category":"sports"}`)
+	index := testhelper.MakeIndex(t, news, sports, first, second)
+	table := map[*manifest.Manifest]*manifest.Manifest{first: news, second: sports}
+	for post, category := range table {
+		matches, err := post.Meta.Relations[0].ResolveFor(index, post)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(matches) != 1 || matches[0] != category {
+			t.Fatalf("%s: expected %s, got %v", post.Selector, category.Selector, matches)
+		}
+		related, err := index.FindManyWithRelation(selector.Must("website/content/v1/post/*"), category.Selector)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(related) != 1 || related[0] != post {
+			t.Fatalf("%s: expected to be related to %s, got %v", category.Selector, post.Selector, related)
+		}
+	}
+	if _, err := first.Meta.Relations[0].Resolve(index); err == nil {
+		t.Fatal("expected error resolving a selector template without a context")
+	}
+	missing := testhelper.MakeManifest(t, "website/content/v1/post/missing", relations, `{}`)
+	if _, err := missing.Meta.Relations[0].ResolveFor(index, missing); err == nil {
+		t.Fatal("expected error for a missing spec field")
+	}
+	for name, relation := range map[string]string{
+		"both":    `{"relations":[{"selector":"a/b/c/d/e","selectorTemplate":"a/b/c/d/{{.Spec.e}}"}]}`,
+		"neither": `{"relations":[{"name":"empty"}]}`,
+		"invalid": `{"relations":[{"selectorTemplate":"a/b/c/d/{{"}]}`,
+	} {
+		input := `{"kind":"k","group":"g","version":"v","namespace":"ns","name":"n","meta":` + relation + `}`
+		if _, err := manifest.New([]byte(input), "test"); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+func TestRelation_MatchIfRelatedTo(t *testing.T) {
+	related := func(sets ...string) string {
+		var relations []string
+		for _, set := range sets {
+			relations = append(relations, `{"selector":"test/set/v1/set/`+set+`"}`)
+		}
+		return `{"relations":[` + strings.Join(relations, ",") + `]}`
+	}
+	index := testhelper.MakeIndex(t,
+		testhelper.MakeManifest(t, "test/set/v1/set/a", "", ""),
+		testhelper.MakeManifest(t, "test/set/v1/set/b", "", ""),
+		testhelper.MakeManifest(t, "test/item/v1/item/a", related("a"), ""),
+		testhelper.MakeManifest(t, "test/item/v1/item/b", related("b"), ""),
+		testhelper.MakeManifest(t, "test/item/v1/item/both", related("a", "b"), ""),
+		testhelper.MakeManifest(t, "test/item/v1/item/neither", "", ""),
+	)
+	relation := &manifest.Relation{
+		Selector:         selector.Must("test/item/v1/item/*"),
+		MatchIfRelatedTo: []*selector.Selector{selector.Must("test/set/v1/set/a"), selector.Must("test/set/v1/set/b")},
+	}
+	matches, err := relation.Resolve(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual []string
+	for _, m := range matches {
+		actual = append(actual, m.Selector.ID())
+	}
+	expected := []string{"test/item/v1/item/a", "test/item/v1/item/b", "test/item/v1/item/both"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	// Windows over the matches count the manifest related to both once.
+	relation.Offset, relation.Limit = 2, 5
+	if matches, err = relation.Resolve(index); err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0].Selector.ID() != "test/item/v1/item/both" {
+		t.Fatalf("expected only test/item/v1/item/both, got %v", matches)
+	}
+}
+
+// cancelAfterFirstCheck is cancelled the second time its error is checked,
+// once collation has made its first pass over the index.
+type cancelAfterFirstCheck struct {
+	context.Context
+	cancel context.CancelFunc
+	checks int32
+}
+
+func (c *cancelAfterFirstCheck) Err() error {
+	if atomic.AddInt32(&c.checks, 1) > 1 {
+		c.cancel()
+	}
+	return c.Context.Err()
+}
+
+func TestIndex_CollateContext(t *testing.T) {
+	manifests := append(denseManifests(500, 10), generateIndex(nil).Manifests()...)
+	for name, concurrency := range map[string]int64{"serial": 1, "parallel": 8} {
+		concurrency := concurrency
+		t.Run(name, func(t *testing.T) {
+			index := manifest.NewIndex().WithCollationConcurrency(concurrency)
+			if err := index.Insert(manifests...); err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if err := index.CollateContext(&cancelAfterFirstCheck{Context: ctx, cancel: cancel}); !errors.Is(err, context.Canceled) {
+				t.Fatalf("expected cancellation, got %v", err)
+			}
+		})
+	}
+}
+
+func TestIndex_CollateContextCancelled(t *testing.T) {
+	index := manifest.NewIndex()
+	if err := index.Insert(generateIndex(nil).Manifests()...); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := index.CollateContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %s, got %v", context.Canceled, err)
+	}
+}
+
+func TestIndex_CollateOrderIndependent(t *testing.T) {
+	// Many manifests share a publish date and some have none, across
+	// several kind/group/version/namespaces.
+	var manifests []*manifest.Manifest
+	for idx := 0; idx < 100; idx++ {
+		meta := &manifest.Meta{Live: true}
+		if idx%5 != 0 {
+			meta.PublishAt = &manifest.PublishAt{Year: 2020, Month: 1, Day: idx%3 + 1}
+		}
+		manifests = append(manifests, &manifest.Manifest{
+			Selector: selector.Must(fmt.Sprintf("test/number/v1/%s/%s", []string{"a", "b", "c"}[idx%3], asWord(idx))),
+			Meta:     meta,
+		})
+	}
+	var expected []string
+	for permutation := 0; permutation < 10; permutation++ {
+		shuffled := append([]*manifest.Manifest{}, manifests...)
+		random := rand.New(rand.NewSource(int64(permutation)))
+		random.Shuffle(len(shuffled), func(i, j int) {
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		})
+		index := testhelper.MakeIndex(t, shuffled...)
+		var actual []string
+		for _, m := range index.Manifests() {
+			actual = append(actual, m.Selector.ID())
+		}
+		if permutation == 0 {
+			expected = actual
+			continue
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("permutation %d: expected %v, got %v", permutation, expected, actual)
+		}
+	}
+}
+
+func TestIndex_ConcurrentInsert(t *testing.T) {
+	manifests := generateManifests(200)
+	for _, m := range manifests {
+		m.Meta.Relations = nil
+	}
+	existing, inserted := manifests[:100], manifests[100:]
+	index := testhelper.MakeIndex(t, existing...)
+	wildcard := selector.Must("test/number/v1/integer/*")
+	var wg sync.WaitGroup
+	errs := make(chan error, runtime.NumCPU()+1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, m := range inserted {
+			if err := index.Insert(m); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	for reader := 0; reader < runtime.NumCPU(); reader++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, m := range existing {
+				if _, err := index.FindOne(m.Selector); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := index.FindMany(wildcard); err != nil {
+					errs <- err
+					return
+				}
+				index.Next(m)
+				// Inserting invalidates relations so they may be missing, but
+				// reading them must not race with that.
+				index.RelatedIndex(m)
+				index.RelationsHash(m)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if actual := len(index.Manifests()); actual != len(manifests) {
+		t.Fatalf("expected %d manifests, got %d", len(manifests), actual)
+	}
+}
+
+func TestIndex_Count(t *testing.T) {
+	index := manifest.NewIndex()
+	if !index.IsEmpty() {
+		t.Fatal("expected new index to be empty")
+	}
+	live := map[string]int{"k/g/v/a": 3, "k/g/v/b": 1, "k/h/v/a": 2}
+	for kgvn, count := range live {
+		for idx := 0; idx < count; idx++ {
+			if err := index.Insert(testhelper.MakeManifest(t, fmt.Sprintf("%s/%d", kgvn, idx), "", "")); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	notLive, err := manifest.New([]byte(`{"kind":"k","group":"g","version":"v","namespace":"c","name":"draft"}`), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Insert(notLive...); err != nil {
+		t.Fatal(err)
+	}
+	if actual := index.Count(); !reflect.DeepEqual(actual, live) {
+		t.Fatalf("expected %v, got %v", live, actual)
+	}
+	all := map[string]int{"k/g/v/a": 3, "k/g/v/b": 1, "k/h/v/a": 2, "k/g/v/c": 1}
+	if actual := index.CountAll(); !reflect.DeepEqual(actual, all) {
+		t.Fatalf("expected %v, got %v", all, actual)
+	}
+	if actual := index.TotalCount(); actual != 6 {
+		t.Fatalf("expected 6, got %d", actual)
+	}
+	if index.IsEmpty() {
+		t.Fatal("expected index not to be empty")
+	}
+}
+
+func TestIndex_Stats(t *testing.T) {
+	index := manifest.NewIndex()
+	for _, id := range []string{"k/g/v/b/0", "k/g/v/a/0", "k/g/v/a/1"} {
+		if err := index.Insert(testhelper.MakeManifest(t, id, "", "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for idx, namespace := range []string{"a", "c", "c"} {
+		notLive, err := manifest.New([]byte(fmt.Sprintf(`{"kind":"k","group":"g","version":"v","namespace":"%s","name":"draft-%d"}`, namespace, idx)), "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := index.Insert(notLive...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []manifest.IndexStats{
+		{Shard: "k/g/v/a", Live: 2, NotLive: 1},
+		{Shard: "k/g/v/b", Live: 1},
+		{Shard: "k/g/v/c", NotLive: 2},
+	}
+	if actual := index.Stats(); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+	if actual := manifest.NewIndex().Stats(); len(actual) != 0 {
+		t.Fatalf("expected no stats for an empty index, got %v", actual)
+	}
+}
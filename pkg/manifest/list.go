@@ -0,0 +1,151 @@
+package manifest
+
+import (
+	"container/heap"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// List is an ordered collection of manifests.
+type List []*Manifest
+
+// CyclicDependencyError reports manifests that could not be ordered because
+// their imports depend on one another.
+type CyclicDependencyError struct {
+	Manifests []*Manifest
+}
+
+func (e *CyclicDependencyError) Error() string {
+	ids := make([]string, len(e.Manifests))
+	for idx, m := range e.Manifests {
+		ids[idx] = m.Selector.ID()
+	}
+	return fmt.Sprintf("import cycle between: %s", strings.Join(ids, ", "))
+}
+
+// TopologicalSort orders the list so every manifest follows the manifests it
+// imports. Imports are resolved against the index and only those in the list
+// are considered. Wildcard imports depend on every manifest they match other
+// than the importer itself. Manifests without a dependency between them keep
+// their order.
+func (l List) TopologicalSort(index *Index) (List, error) {
+	position := make(map[string]int, len(l))
+	for idx, m := range l {
+		position[m.Selector.ID()] = idx
+	}
+	inDegree := make([]int, len(l))
+	dependents := make([][]int, len(l))
+	for idx, m := range l {
+		if m.Meta == nil {
+			continue
+		}
+		seen := map[int]bool{}
+		for _, toImport := range m.Meta.Imports {
+			relation, err := toImport.relationFor(m)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", m, err)
+			}
+			var imported []*Manifest
+			if relation.Selector.IsWildcard() {
+				// An empty shard means there is nothing to depend on.
+				imported, _ = index.FindMany(relation.Selector)
+			} else if imported, err = relation.Resolve(index); err != nil {
+				return nil, fmt.Errorf("%s: %w", m, err)
+			}
+			for _, dependency := range imported {
+				dep, ok := position[dependency.Selector.ID()]
+				if !ok || dep == idx || seen[dep] {
+					continue
+				}
+				seen[dep] = true
+				inDegree[idx]++
+				dependents[dep] = append(dependents[dep], idx)
+			}
+		}
+	}
+	// Taking the earliest ready manifest first keeps independent manifests
+	// in their original order.
+	ready := &readyQueue{}
+	for idx := range l {
+		if inDegree[idx] == 0 {
+			*ready = append(*ready, idx)
+		}
+	}
+	sorted := make(List, 0, len(l))
+	emitted := make([]bool, len(l))
+	for ready.Len() > 0 {
+		next := heap.Pop(ready).(int)
+		emitted[next] = true
+		sorted = append(sorted, l[next])
+		for _, dependent := range dependents[next] {
+			if inDegree[dependent]--; inDegree[dependent] == 0 {
+				heap.Push(ready, dependent)
+			}
+		}
+	}
+	if len(sorted) < len(l) {
+		var remaining []*Manifest
+		for idx, m := range l {
+			if !emitted[idx] {
+				remaining = append(remaining, m)
+			}
+		}
+		return nil, &CyclicDependencyError{Manifests: remaining}
+	}
+	return sorted, nil
+}
+
+// readyQueue is a min-heap of list positions whose imports have been emitted.
+type readyQueue []int
+
+func (q readyQueue) Len() int            { return len(q) }
+func (q readyQueue) Less(a, b int) bool  { return q[a] < q[b] }
+func (q readyQueue) Swap(a, b int)       { q[a], q[b] = q[b], q[a] }
+func (q *readyQueue) Push(x interface{}) { *q = append(*q, x.(int)) }
+func (q *readyQueue) Pop() interface{} {
+	old := *q
+	last := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return last
+}
+
+// ReverseTopological orders the list so every manifest precedes the manifests
+// it imports.
+func (l List) ReverseTopological(index *Index) (List, error) {
+	sorted, err := l.TopologicalSort(index)
+	if err != nil {
+		return nil, err
+	}
+	for a, b := 0, len(sorted)-1; a < b; a, b = a+1, b-1 {
+		sorted[a], sorted[b] = sorted[b], sorted[a]
+	}
+	return sorted, nil
+}
+
+// SafeList collects manifests from concurrent goroutines.
+type SafeList struct {
+	mu        sync.Mutex
+	manifests []*Manifest
+}
+
+// Append adds manifests to the list.
+func (l *SafeList) Append(manifests ...*Manifest) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.manifests = append(l.manifests, manifests...)
+}
+
+// Slice returns a copy of the manifests in the order they were appended.
+func (l *SafeList) Slice() []*Manifest {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]*Manifest(nil), l.manifests...)
+}
+
+// Len returns the number of manifests in the list.
+func (l *SafeList) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.manifests)
+}
@@ -0,0 +1,119 @@
+package manifest_test
+
+import (
+	"errors"
+	"github.com/tkellen/aevitas/internal/testhelper"
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestList_TopologicalSort(t *testing.T) {
+	item := func(name string, imports ...string) *manifest.Manifest {
+		var relations []string
+		for _, target := range imports {
+			relations = append(relations, `{"selector":"test/list/v1/item/`+target+`"}`)
+		}
+		return testhelper.MakeManifest(t, "test/list/v1/item/"+name, `{"imports":[`+strings.Join(relations, ",")+`]}`, "")
+	}
+	table := map[string]struct {
+		list     manifest.List
+		outside  []*manifest.Manifest
+		expected []string
+		cyclic   bool
+		reversed bool
+	}{
+		"chain": {
+			list:     manifest.List{item("a", "b"), item("b", "c"), item("c")},
+			expected: []string{"c", "b", "a"},
+		},
+		"chain reversed": {
+			list:     manifest.List{item("c"), item("a", "b"), item("b", "c")},
+			expected: []string{"a", "b", "c"},
+			reversed: true,
+		},
+		"independent keep their order": {
+			list:     manifest.List{item("b"), item("a", "c"), item("c")},
+			expected: []string{"b", "c", "a"},
+		},
+		"imports outside the list are ignored": {
+			list:     manifest.List{item("a", "b"), item("c", "a")},
+			outside:  []*manifest.Manifest{item("b")},
+			expected: []string{"a", "c"},
+		},
+		"wildcard imports depend on every match": {
+			list:     manifest.List{item("a", "*"), item("b"), item("c")},
+			expected: []string{"b", "c", "a"},
+		},
+		"wildcard imports in a cycle": {
+			list:   manifest.List{item("a", "*"), item("b", "a"), item("c")},
+			cyclic: true,
+		},
+		"cycle": {
+			list:   manifest.List{item("a", "b"), item("b", "a"), item("c")},
+			cyclic: true,
+		},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			index := manifest.NewIndex()
+			if err := index.Insert(append(test.outside, test.list...)...); err != nil {
+				t.Fatal(err)
+			}
+			sort := test.list.TopologicalSort
+			if test.reversed {
+				sort = test.list.ReverseTopological
+			}
+			sorted, err := sort(index)
+			if test.cyclic {
+				var cycleErr *manifest.CyclicDependencyError
+				if !errors.As(err, &cycleErr) {
+					t.Fatalf("expected cyclic dependency error, got %v", err)
+				}
+				if len(cycleErr.Manifests) != 2 {
+					t.Fatalf("expected %d manifests in cycle, got %d", 2, len(cycleErr.Manifests))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var actual []string
+			for _, m := range sorted {
+				actual = append(actual, m.Selector.Name)
+			}
+			if strings.Join(actual, ",") != strings.Join(test.expected, ",") {
+				t.Fatalf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestSafeList_Append(t *testing.T) {
+	m := testhelper.MakeManifest(t, "test/list/v1/item/one", "", "")
+	list := &manifest.SafeList{}
+	var wg sync.WaitGroup
+	for worker := 0; worker < 50; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				list.Append(m)
+			}
+		}()
+	}
+	wg.Wait()
+	if list.Len() != 5000 {
+		t.Fatalf("expected %d manifests, got %d", 5000, list.Len())
+	}
+	slice := list.Slice()
+	slice[0] = nil
+	if list.Slice()[0] != m {
+		t.Fatal("expected list to be unaffected by mutation of its slice")
+	}
+	if len(slice) != 5000 {
+		t.Fatalf("expected slice of %d, got %d", 5000, len(slice))
+	}
+}
@@ -4,13 +4,16 @@ package manifest
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"github.com/BurntSushi/toml"
 	"github.com/ghodss/yaml"
 	json "github.com/json-iterator/go"
 	"github.com/lestrrat-go/strftime"
 	hash "github.com/minio/sha256-simd"
+	"github.com/mitchellh/copystructure"
 	"github.com/tidwall/sjson"
 	"github.com/tkellen/aevitas/internal/selector"
 	"io"
@@ -39,21 +42,25 @@ type Manifest struct {
 	Source string
 	// Raw is the raw data that produced the manifest
 	Raw []byte
+	// OriginalRaw is the content of the source before any conversion, e.g.
+	// the yaml of a .yml file whose json form is held in Raw.
+	OriginalRaw []byte
 	// Hash is the sha256 hash of the raw content of the manifest. This provides
 	// the basis for cache busting of generated resources.
 	Hash string
 }
 
-// Validate does just what you think it does.
+// Validate runs every registered validator against the manifest, returning
+// the first failure.
 func (m *Manifest) Validate() error {
-	for _, generator := range m.GenerateManifests {
-		if err := generator.Validate(); err != nil {
-			return fmt.Errorf("%s: %w", generator.Name, err)
+	validatorsMu.RLock()
+	registered := validators
+	validatorsMu.RUnlock()
+	for _, validate := range registered {
+		if err := validate(m); err != nil {
+			return err
 		}
 	}
-	if err := m.Meta.validate(); err != nil {
-		return err
-	}
 	return nil
 }
 
@@ -81,7 +88,7 @@ func (m *Manifest) UnmarshalJSON(data []byte) error {
 	if err := json.Unmarshal(data, &temp); err != nil {
 		return fmt.Errorf("json unmarshal: %w", err)
 	}
-	s, err := selector.New(fmt.Sprintf("%s/%s/%s/%s/%s", temp.Kind, temp.Group, temp.Version, temp.Namespace, temp.Name))
+	s, err := selector.New(fmt.Sprintf("%s/%s/%s/%s/%s", temp.Kind, temp.Group, temp.Version, temp.Namespace, selector.EncodeName(temp.Name)))
 	if err != nil {
 		return err
 	}
@@ -95,6 +102,66 @@ func (m *Manifest) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// Clone produces a deep copy of the manifest.
+func (m *Manifest) Clone() (*Manifest, error) {
+	clone, err := copystructure.Copy(m)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", m, err)
+	}
+	return clone.(*Manifest), nil
+}
+
+// ApplyDefaults produces a clone of the manifest whose empty metadata is
+// filled in from the supplied defaults. When any are applied the hash of the
+// clone covers its metadata so resources rendered from it are not mistaken for
+// those of the original.
+func (m *Manifest) ApplyDefaults(defaults *Meta) (*Manifest, error) {
+	clone, err := m.Clone()
+	if err != nil {
+		return nil, err
+	}
+	if defaults == nil {
+		return clone, nil
+	}
+	if clone.Meta == nil {
+		clone.Meta = &Meta{}
+	}
+	applied := false
+	if clone.Meta.Author == "" && defaults.Author != "" {
+		clone.Meta.Author = defaults.Author
+		applied = true
+	}
+	if clone.Meta.Description == "" && defaults.Description != "" {
+		clone.Meta.Description = defaults.Description
+		applied = true
+	}
+	if clone.Meta.License == "" && defaults.License != "" {
+		clone.Meta.License = defaults.License
+		applied = true
+	}
+	if applied {
+		meta, err := json.Marshal(clone.Meta)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", m, err)
+		}
+		digest := hash.Sum256(append([]byte(m.Hash), meta...))
+		clone.Hash = hex.EncodeToString(digest[:])
+	}
+	return clone, nil
+}
+
+// JSON returns the json-encoded form of the raw data that produced the
+// manifest, converting front-matter if needed.
+func (m *Manifest) JSON() ([]byte, error) {
+	return toJSON(m.Raw)
+}
+
+// IsGenerated indicates if the manifest was produced by the generator of
+// another manifest.
+func (m *Manifest) IsGenerated() bool {
+	return strings.HasPrefix(m.Source, generatedSourcePrefix)
+}
+
 // Date returns a native time from the deconstructed form stored in metadata.
 func (m *Manifest) PublishAt() time.Time {
 	if m.Meta.PublishAt == nil {
@@ -102,8 +169,8 @@ func (m *Manifest) PublishAt() time.Time {
 	}
 	return time.Date(
 		m.Meta.PublishAt.Year,
-		time.Month(m.Meta.PublishAt.Month),
-		m.Meta.PublishAt.Day,
+		time.Month(m.Meta.PublishAt.month()),
+		m.Meta.PublishAt.day(),
 		m.Meta.PublishAt.Hours,
 		m.Meta.PublishAt.Minutes,
 		m.Meta.PublishAt.Seconds,
@@ -122,8 +189,8 @@ func (m *Manifest) PublishMonthDay() time.Time {
 	}
 	return time.Date(
 		0,
-		time.Month(m.Meta.PublishAt.Month),
-		m.Meta.PublishAt.Day,
+		time.Month(m.Meta.PublishAt.month()),
+		m.Meta.PublishAt.day(),
 		0,
 		0,
 		0,
@@ -140,8 +207,8 @@ func (m *Manifest) IsLive() bool {
 	if !m.Meta.Live {
 		return false
 	}
-	if !m.PublishAt().IsZero() {
-		return time.Now().After(m.PublishAt())
+	if publishAt := m.PublishAt(); !publishAt.IsZero() {
+		return time.Now().After(m.Meta.truncatePublishAt(publishAt))
 	}
 	return true
 }
@@ -183,6 +250,30 @@ func (m *Manifest) Equal(compare *Manifest) bool {
 	return m.PublishAt().Equal(compare.PublishAt())
 }
 
+// HashEqual determines if the receiver manifest has the same content as the
+// compared.
+func (m *Manifest) HashEqual(compare *Manifest) bool {
+	return compare != nil && m.Hash == compare.Hash
+}
+
+// SitemapPriority is the priority of the manifest for sitemaps, defaulting to
+// 0.5 when none is set.
+func (m *Manifest) SitemapPriority() float64 {
+	if m.Meta.Priority == 0 {
+		return 0.5
+	}
+	return m.Meta.Priority
+}
+
+// SitemapChangeFreq is how often the manifest changes for sitemaps, defaulting
+// to monthly when none is set.
+func (m *Manifest) SitemapChangeFreq() string {
+	if m.Meta.ChangeFreq == "" {
+		return "monthly"
+	}
+	return m.Meta.ChangeFreq
+}
+
 func (m *Manifest) Title() string {
 	return m.Meta.Title
 }
@@ -197,6 +288,25 @@ func (m *Manifest) Href() string {
 	return path.Join(m.Meta.HrefPrefix, m.Meta.Href)
 }
 
+// CanonicalURL returns the absolute URL search engines should consider the
+// primary location of the manifest. When no canonical override is present,
+// the href is used.
+func (m *Manifest) CanonicalURL(host string) string {
+	if isAbsoluteURL(m.Meta.Canonical) {
+		return m.Meta.Canonical
+	}
+	target := m.Meta.Canonical
+	if target == "" {
+		target = m.Href()
+	}
+	return joinURL(host, target)
+}
+
+// joinURL produces an absolute URL from a host and path.
+func joinURL(host string, target string) string {
+	return strings.TrimSuffix(host, "/") + "/" + strings.TrimPrefix(target, "/")
+}
+
 // Import describes a manifest that is required to render the parent.
 type Import struct {
 	Name       string
@@ -208,17 +318,25 @@ type Import struct {
 // ResolveStaticImports converts all imports selectors into manifests using the
 // supplied index.
 func (m *Manifest) ResolveStaticImports(index *Index) ([]*Import, error) {
-	var associated []*Import
-	for _, toImport := range m.Meta.Imports {
-		expanded, err := toImport.Resolve(index)
+	imports := make([]*Relation, len(m.Meta.Imports))
+	for idx, toImport := range m.Meta.Imports {
+		relation, err := toImport.relationFor(m)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("%s: %w", m, err)
 		}
+		imports[idx] = relation
+	}
+	resolved, err := index.ResolveAll(context.Background(), imports, index.collationConcurrency)
+	if err != nil {
+		return nil, err
+	}
+	var associated []*Import
+	for idx, toImport := range imports {
 		associated = append(associated, &Import{
 			Name:       toImport.Name,
 			Single:     !toImport.Selector.IsWildcard(),
 			IsTemplate: toImport.Selector.KGV == "html/template/v1",
-			Manifests:  expanded,
+			Manifests:  resolved[idx],
 		})
 	}
 	return associated, nil
@@ -229,38 +347,40 @@ func (m *Manifest) ResolveStaticImports(index *Index) ([]*Import, error) {
 func (m *Manifest) ResolveDynamicImports(index *Index, context *Manifest) ([]*Import, error) {
 	var associated []*Import
 	for _, toImport := range m.Meta.ImportsDynamic {
-		expanded, err := toImport.Resolve(index, context)
+		relation, err := toImport.relationFor(context)
+		if err != nil {
+			return nil, err
+		}
+		expanded, err := relation.resolve(index, context, toImport.MatchIfRelatedToContext)
 		if err != nil {
 			return nil, err
 		}
 		associated = append(associated, &Import{
 			Name:       toImport.Name,
-			Single:     !toImport.Selector.IsWildcard(),
-			IsTemplate: toImport.Selector.KGV == "html/template/v1",
+			Single:     !relation.Selector.IsWildcard(),
+			IsTemplate: relation.Selector.KGV == "html/template/v1",
 			Manifests:  expanded,
 		})
 	}
 	return associated, nil
 }
 
-// New creates a manifest from a json-encoded byte array or a yaml-front-matter
-// having byte array. If front-matter is found, the content below it is assigned
-// to `.Spec.content` (overwriting any content that may be there).
+// New creates a manifest from a json-encoded byte array or a yaml or toml
+// front-matter having byte array. If front-matter is found, the content below
+// it is assigned to `.Spec.content` (overwriting any content that may be
+// there).
 func New(data []byte, source string) ([]*Manifest, error) {
+	return NewWithSource(data, source, data)
+}
+
+// NewWithSource does just what New does while retaining the original content
+// of the source the data was derived from.
+func NewWithSource(data []byte, source string, originalRaw []byte) ([]*Manifest, error) {
 	var manifest *Manifest
-	var err error
 	digest := hash.Sum256(data)
-	body := append([]byte{}, data...)
-	// Process front-matter, if any.
-	if data, content, ok := frontmatter(body, []byte("<!--"), []byte("-->")); ok {
-		if body, err = yaml.YAMLToJSON(data); err != nil {
-			return nil, err
-		}
-		if len(content) > 0 {
-			if body, err = sjson.SetBytes(body, "body", content); err != nil {
-				return nil, err
-			}
-		}
+	body, err := toJSON(data)
+	if err != nil {
+		return nil, err
 	}
 	if err = json.Unmarshal(body, &manifest); err != nil {
 		return nil, fmt.Errorf("json unmarshal: %w", err)
@@ -272,9 +392,13 @@ func New(data []byte, source string) ([]*Manifest, error) {
 	if manifest.Meta == nil {
 		manifest.Meta = &Meta{}
 	}
+	if manifest.Spec, err = computeSpec(manifest.Spec); err != nil {
+		return nil, fmt.Errorf("computed: %w", err)
+	}
 	if err := manifest.Validate(); err != nil {
-		return nil, err
+		return nil, &ValidatorError{Source: source, Err: err}
 	}
+	manifest.Body = trimBody(manifest.Body, manifest.Meta)
 	if manifest.Meta.HrefPrefix != "" {
 		parse, timeErr := strftime.New(manifest.Meta.HrefPrefix)
 		if timeErr != nil {
@@ -290,6 +414,7 @@ func New(data []byte, source string) ([]*Manifest, error) {
 		manifest.Meta.Href = parse.FormatString(manifest.PublishAt())
 	}
 	manifest.Raw = data
+	manifest.OriginalRaw = originalRaw
 	manifest.Source = source
 	var manifests []*Manifest
 	if manifest.GenerateManifests != nil {
@@ -304,33 +429,83 @@ func New(data []byte, source string) ([]*Manifest, error) {
 	return append(manifests, manifest), nil
 }
 
+// trimBody applies the whitespace options of meta to every line of body.
+func trimBody(body string, meta *Meta) string {
+	if !meta.TrimBody && meta.TrimBodyIndent == 0 {
+		return body
+	}
+	lines := strings.Split(body, "\n")
+	for idx, line := range lines {
+		if meta.TrimBodyIndent > 0 {
+			indent := len(line) - len(strings.TrimLeft(line, " "))
+			if indent > meta.TrimBodyIndent {
+				indent = meta.TrimBodyIndent
+			}
+			line = line[indent:]
+		}
+		if meta.TrimBody {
+			line = strings.TrimSpace(line)
+		}
+		lines[idx] = line
+	}
+	return strings.Join(lines, "\n")
+}
+
 // NewFromFile creates a manifest from a source file.
 func NewFromFile(filepath string) ([]*Manifest, error) {
 	data, err := ioutil.ReadFile(filepath)
 	if err != nil {
 		return nil, err
 	}
-	if path.Ext(filepath) == ".yml" {
+	return newFromBytes(data, filepath)
+}
+
+// newFromBytes creates manifests from the content of a source whose format is
+// determined by its extension.
+func newFromBytes(data []byte, source string) ([]*Manifest, error) {
+	original := data
+	var err error
+	if path.Ext(source) == ".yml" {
 		data, err = yaml.YAMLToJSON(data)
 		if err != nil {
-			return nil, fmt.Errorf("%s: yaml to json failure: %w\n%s", filepath, err)
+			return nil, fmt.Errorf("%s: yaml to json failure: %w", source, err)
 		}
 	}
-	manifest, newErr := New(data, filepath)
+	manifest, newErr := NewWithSource(data, source, original)
+	var validatorErr *ValidatorError
+	if errors.As(newErr, &validatorErr) {
+		// The source is already identified.
+		return nil, newErr
+	}
 	if newErr != nil {
-		return nil, fmt.Errorf("%s: %w", filepath, newErr)
+		return nil, fmt.Errorf("%s: %w", source, newErr)
 	}
 	return manifest, nil
 }
 
 type progressFn func(int, <-chan struct{})
 
+// ParseError reports a line read by NewFromReader that could not be parsed.
+type ParseError struct {
+	// Line is the number of the line, starting from 1.
+	Line int
+	Raw  []byte
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d:\n---\n%s\n---\n: %s", e.Line, e.Raw, e.Err)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
 // NewFromReader creates manifests from a provided reader taking the assumption
 // that the reader contains one json-encoded manifest per line.
 func NewFromReader(input io.Reader, watch progressFn) ([]*Manifest, error) {
 	reader := bufio.NewReader(input)
 	var docs [][]byte
-	for {
+	var lines []int
+	for line := 1; ; line++ {
 		raw, err := reader.ReadBytes('\n')
 		if errors.Is(err, io.EOF) {
 			break
@@ -339,19 +514,20 @@ func NewFromReader(input io.Reader, watch progressFn) ([]*Manifest, error) {
 			continue
 		}
 		docs = append(docs, bytes.TrimRight(raw, "\n"))
+		lines = append(lines, line)
 	}
 	var manifests []*Manifest
 	progress := make(chan struct{})
 	if watch != nil {
 		go watch(len(docs), progress)
 	}
-	for _, doc := range docs {
+	for idx, doc := range docs {
 		if watch != nil {
 			progress <- struct{}{}
 		}
 		results, err := New(doc, "stream")
 		if err != nil {
-			return nil, fmt.Errorf("\n---\n%s\n---\n: %w", doc, err)
+			return nil, &ParseError{Line: lines[idx], Raw: doc, Err: err}
 		}
 		manifests = append(manifests, results...)
 	}
@@ -359,19 +535,63 @@ func NewFromReader(input io.Reader, watch progressFn) ([]*Manifest, error) {
 	return manifests, nil
 }
 
+// DirOptions controls how manifests are loaded from directories.
+type DirOptions struct {
+	// SkipPatterns are globs, in the syntax of filepath.Match, matched against
+	// the name of each directory and file. Matching directories are not
+	// descended into. Hidden directories and files are always skipped.
+	SkipPatterns []string
+	// Watch, when set, is supplied the count of files to be loaded and a
+	// channel that receives once as each is.
+	Watch func(int, <-chan struct{})
+}
+
+// skip indicates if a directory or file with the supplied name should be
+// ignored.
+func (o DirOptions) skip(name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return true
+	}
+	for _, pattern := range o.SkipPatterns {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
 // NewFromDirs creates manifests from all files found in an array of supplied
 // directories.
 func NewFromDirs(dirs []string, watch progressFn) ([]*Manifest, error) {
+	return NewFromDirsWithOptions(dirs, DirOptions{Watch: watch})
+}
+
+// NewFromDirsWithOptions does just what NewFromDirs does while skipping any
+// directories or files matching the supplied options.
+func NewFromDirsWithOptions(dirs []string, opts DirOptions) ([]*Manifest, error) {
+	for _, pattern := range opts.SkipPatterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("skip pattern %s: %w", pattern, err)
+		}
+	}
+	watch := opts.Watch
 	var files []string
 	for _, dir := range dirs {
 		if err := filepath.Walk(dir, func(path string, f os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			// The supplied directories are never skipped.
+			if path == dir && f.IsDir() {
+				return nil
+			}
 			if f.IsDir() {
-				if strings.HasPrefix(f.Name(), ".") {
+				if opts.skip(f.Name()) {
 					return filepath.SkipDir
 				}
 				return nil
 			}
-			if strings.HasPrefix(filepath.Base(f.Name()), ".") {
+			if opts.skip(f.Name()) {
 				return nil
 			}
 			files = append(files, path)
@@ -399,6 +619,59 @@ func NewFromDirs(dirs []string, watch progressFn) ([]*Manifest, error) {
 	return manifests, nil
 }
 
+// toJSON converts a yaml or toml front-matter having byte array into json. The
+// content below the front-matter is assigned to `body`. Input without
+// front-matter is returned as is.
+func toJSON(data []byte) ([]byte, error) {
+	body := append([]byte{}, data...)
+	frontmatter, content, isTOML, ok := extractFrontmatter(body)
+	if !ok {
+		return body, nil
+	}
+	var err error
+	if isTOML {
+		if body, err = tomlToJSON(frontmatter); err != nil {
+			return nil, err
+		}
+	} else if body, err = yaml.YAMLToJSON(frontmatter); err != nil {
+		return nil, err
+	}
+	if len(content) > 0 {
+		if body, err = sjson.SetBytes(body, "body", content); err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+// extractFrontmatter locates front-matter delimited by html comments or, when
+// the input begins with one, yaml document separators or the +++ lines that
+// surround toml. It reports if the front-matter is toml.
+func extractFrontmatter(input []byte) ([]byte, []byte, bool, bool) {
+	if data, content, ok := frontmatter(input, []byte("<!--"), []byte("-->")); ok {
+		return data, content, false, true
+	}
+	trimmed := bytes.TrimLeft(input, " \t\r\n")
+	if bytes.HasPrefix(trimmed, []byte("---")) {
+		data, content, ok := frontmatter(input, []byte("---"), []byte("---"))
+		return data, content, false, ok
+	}
+	if bytes.HasPrefix(trimmed, []byte("+++")) {
+		data, content, ok := frontmatter(input, []byte("+++"), []byte("+++"))
+		return data, content, true, ok
+	}
+	return nil, nil, false, false
+}
+
+// tomlToJSON does just what you think it does.
+func tomlToJSON(data []byte) ([]byte, error) {
+	var decoded map[string]interface{}
+	if err := toml.Unmarshal(data, &decoded); err != nil {
+		return nil, fmt.Errorf("toml: %w", err)
+	}
+	return json.Marshal(decoded)
+}
+
 func frontmatter(input []byte, openDelim []byte, closeDelim []byte) ([]byte, []byte, bool) {
 	s := bytes.Index(input, openDelim)
 	if s == -1 {
@@ -2,10 +2,22 @@ package manifest_test
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	json "github.com/json-iterator/go"
 	"github.com/tkellen/aevitas/internal/selector"
+	"github.com/tkellen/aevitas/internal/testhelper"
 	"github.com/tkellen/aevitas/pkg/manifest"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"testing/quick"
+	"time"
 )
 
 func TestNew(t *testing.T) {
@@ -16,21 +28,24 @@ func TestNew(t *testing.T) {
 		expectedErr      bool
 	}
 	expectedSelector := selector.Must("k/g/v/ns/n")
-	expectedMeta := &manifest.Meta{
-		File:       "test",
-		HrefPrefix: "/",
-		Href:       "test.html",
-		Title:      "Title",
-		Relations: []*manifest.Relation{{
-			Selector: selector.Must("a/b/c/d/e"),
-		}},
-		Children: []*manifest.Child{{
-			Relation: manifest.Relation{
-				Selector: selector.Must("e/d/c/b/a"),
-			},
-			renderWith: []*selector.Selector{selector.Must("f/g/h/i/j")},
-		}},
+	metaWithTemplates := func(templates manifest.RenderWith) *manifest.Meta {
+		return &manifest.Meta{
+			File:       "test",
+			HrefPrefix: "/",
+			Href:       "test.html",
+			Title:      "Title",
+			Relations: []*manifest.Relation{{
+				Selector: selector.Must("a/b/c/d/e"),
+			}},
+			Children: []*manifest.Child{{
+				Relation: &manifest.Relation{
+					Selector: selector.Must("e/d/c/b/a"),
+				},
+				Templates: templates,
+			}},
+		}
 	}
+	expectedMeta := metaWithTemplates(manifest.RenderWith{selector.Must("f/g/h/i/j")})
 	table := map[string]testCase{
 		"from json": {
 			input:            []byte(`{"kind":"k","group":"g","version":"v","namespace":"ns","name":"n","meta":{"file":"test","hrefPrefix":"/","href":"test.html","title":"Title","relations":[{"selector":"a/b/c/d/e"}],"children":[{"selector":"e/d/c/b/a","templates":["f/g/h/i/j"]}]}}`),
@@ -41,6 +56,12 @@ func TestNew(t *testing.T) {
 		"with yaml as frontmatter": {
 			input:            []byte("---\nkind: k\ngroup: g\nversion: v\nnamespace: ns\nname: \"n\"\nmeta:\n  file: test\n  hrefPrefix: /\n  href: test.html\n  title: Title\n  relations:\n  - selector: a/b/c/d/e\n  children:\n  - selector: e/d/c/b/a\n    renderWith: [f/g/h/i/j]\n\n---\ncontent"),
 			expectedSelector: expectedSelector,
+			expectedMeta:     metaWithTemplates(nil),
+			expectedErr:      false,
+		},
+		"with yaml templates as frontmatter": {
+			input:            []byte("---\nkind: k\ngroup: g\nversion: v\nnamespace: ns\nname: \"n\"\nmeta:\n  file: test\n  hrefPrefix: /\n  href: test.html\n  title: Title\n  relations:\n  - selector: a/b/c/d/e\n  children:\n  - selector: e/d/c/b/a\n    templates: [f/g/h/i/j]\n\n---\ncontent"),
+			expectedSelector: expectedSelector,
 			expectedMeta:     expectedMeta,
 			expectedErr:      false,
 		},
@@ -48,6 +69,16 @@ func TestNew(t *testing.T) {
 			input:       []byte("---\n}::: BAD :::{\n---\ncontent"),
 			expectedErr: true,
 		},
+		"with toml as frontmatter": {
+			input:            []byte("+++\nkind = \"k\"\ngroup = \"g\"\nversion = \"v\"\nnamespace = \"ns\"\nname = \"n\"\n\n[meta]\nfile = \"test\"\nhrefPrefix = \"/\"\nhref = \"test.html\"\ntitle = \"Title\"\n\n[[meta.relations]]\nselector = \"a/b/c/d/e\"\n\n[[meta.children]]\nselector = \"e/d/c/b/a\"\ntemplates = [\"f/g/h/i/j\"]\n+++\ncontent"),
+			expectedSelector: expectedSelector,
+			expectedMeta:     expectedMeta,
+			expectedErr:      false,
+		},
+		"with invalid toml as frontmatter": {
+			input:       []byte("+++\n}::: BAD :::{\n+++\ncontent"),
+			expectedErr: true,
+		},
 	}
 	for name, test := range table {
 		test := test
@@ -107,3 +138,644 @@ func TestManifest_EqualGreaterLess(t *testing.T) {
 		t.Fatal("did not expect first to be greater than last")
 	}
 }
+
+func TestNew_Computed(t *testing.T) {
+	type testCase struct {
+		input       string
+		expected    map[string]string
+		expectedErr bool
+	}
+	table := map[string]testCase{
+		"computes fields from the spec": {
+			input: `{"kind":"k","group":"g","version":"v","namespace":"ns","name":"n","spec":{"firstName":"Tyler","lastName":"Kellen","computed":[{"name":"fullName","template":"{{.firstName}} {{.lastName}}"},{"name":"greeting","template":"Hi {{.fullName}}"}]}}`,
+			expected: map[string]string{
+				"firstName": "Tyler",
+				"fullName":  "Tyler Kellen",
+				"greeting":  "Hi Tyler Kellen",
+			},
+		},
+		"rejects collisions with explicit fields": {
+			input:       `{"kind":"k","group":"g","version":"v","namespace":"ns","name":"n","spec":{"fullName":"Explicit","computed":[{"name":"fullName","template":"Computed"}]}}`,
+			expectedErr: true,
+		},
+		"rejects invalid templates": {
+			input:       `{"kind":"k","group":"g","version":"v","namespace":"ns","name":"n","spec":{"computed":[{"name":"broken","template":"{{"}]}}`,
+			expectedErr: true,
+		},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			m, err := manifest.New([]byte(test.input), "test")
+			if test.expectedErr {
+				if err == nil {
+					t.Fatal("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected err %s", err)
+			}
+			var spec map[string]interface{}
+			if err := json.Unmarshal(m[0].Spec, &spec); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := spec["computed"]; ok {
+				t.Fatal("expected computed definitions to be removed from spec")
+			}
+			for key, expected := range test.expected {
+				if spec[key] != expected {
+					t.Fatalf("expected %s to be %q, got %q", key, expected, spec[key])
+				}
+			}
+		})
+	}
+}
+
+func TestNewFromFile_OriginalRaw(t *testing.T) {
+	for _, file := range []string{"../../testdata/blog/domain.yml", "../../testdata/blog/one.html"} {
+		file := file
+		t.Run(file, func(t *testing.T) {
+			original, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			manifests, err := manifest.NewFromFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := manifests[len(manifests)-1]
+			if !bytes.Equal(original, m.OriginalRaw) {
+				t.Fatalf("expected %s, got %s", original, m.OriginalRaw)
+			}
+			// Yaml files are converted to json before parsing so only
+			// front-matter files can be parsed again from their source.
+			if path.Ext(file) == ".yml" {
+				if !json.Valid(m.Raw) {
+					t.Fatalf("expected raw to be json, got %s", m.Raw)
+				}
+				return
+			}
+			reparsed, err := manifest.New(m.OriginalRaw, m.Source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reparsed[0].Hash != m.Hash || reparsed[0].Selector.ID() != m.Selector.ID() {
+				t.Fatalf("expected %s to round trip, got %s", m.Selector, reparsed[0].Selector)
+			}
+		})
+	}
+}
+
+func TestNewFromReader_ParseError(t *testing.T) {
+	valid := `{"kind":"k","group":"g","version":"v","namespace":"ns","name":"%s","meta":{"live":true}}`
+	input := strings.Join([]string{
+		fmt.Sprintf(valid, "one"),
+		"",
+		fmt.Sprintf(valid, "two"),
+		`{"kind":"k","group":"g"`,
+		fmt.Sprintf(valid, "three"),
+	}, "\n") + "\n"
+	_, err := manifest.NewFromReader(strings.NewReader(input), nil)
+	var parseErr *manifest.ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("expected parse error, got %v", err)
+	}
+	if parseErr.Line != 4 {
+		t.Fatalf("expected line %d, got %d", 4, parseErr.Line)
+	}
+	if string(parseErr.Raw) != `{"kind":"k","group":"g"` {
+		t.Fatalf("expected raw line, got %s", parseErr.Raw)
+	}
+	if !strings.HasPrefix(err.Error(), "line 4:") {
+		t.Fatalf("expected error to start with line number, got %s", err)
+	}
+	manifests, err := manifest.NewFromReader(strings.NewReader(fmt.Sprintf(valid, "one")+"\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) != 1 {
+		t.Fatalf("expected %d manifests, got %d", 1, len(manifests))
+	}
+}
+
+func TestNewFromDirsWithOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aevitas-dirs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"vendor", "content", "_private"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		doc := fmt.Sprintf(`{"kind":"k","group":"g","version":"v","namespace":"ns","name":"%s"}`, strings.TrimPrefix(name, "_"))
+		if err := ioutil.WriteFile(filepath.Join(dir, name, "manifest.json"), []byte(doc), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	table := map[string]struct {
+		patterns []string
+		expected []string
+	}{
+		"no patterns": {
+			expected: []string{"k/g/v/ns/content", "k/g/v/ns/private", "k/g/v/ns/vendor"},
+		},
+		"literal": {
+			patterns: []string{"vendor"},
+			expected: []string{"k/g/v/ns/content", "k/g/v/ns/private"},
+		},
+		"glob": {
+			patterns: []string{"vendor", "_*"},
+			expected: []string{"k/g/v/ns/content"},
+		},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			manifests, err := manifest.NewFromDirsWithOptions([]string{dir}, manifest.DirOptions{SkipPatterns: test.patterns})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var actual []string
+			for _, m := range manifests {
+				actual = append(actual, m.Selector.ID())
+			}
+			sort.Strings(actual)
+			if !reflect.DeepEqual(test.expected, actual) {
+				t.Fatalf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+	if _, err := manifest.NewFromDirsWithOptions([]string{dir}, manifest.DirOptions{SkipPatterns: []string{"["}}); err == nil {
+		t.Fatal("expected error for invalid pattern")
+	}
+}
+
+func TestManifestInvariants(t *testing.T) {
+	invariants := map[string]func(*manifest.Manifest) bool{
+		"hash is stable": func(m *manifest.Manifest) bool {
+			reparsed, err := manifest.New(m.Raw, "test")
+			return err == nil && reparsed[0].Hash == m.Hash
+		},
+		"not live before publish date": func(m *manifest.Manifest) bool {
+			return !m.PublishAt().After(time.Now()) || !m.IsLive()
+		},
+		"clone is deeply equal": func(m *manifest.Manifest) bool {
+			clone, err := m.Clone()
+			return err == nil && clone != m && reflect.DeepEqual(m, clone)
+		},
+	}
+	for name, invariant := range invariants {
+		invariant := invariant
+		t.Run(name, func(t *testing.T) {
+			if err := quick.Check(invariant, &quick.Config{MaxCount: 1000}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestManifest_CanonicalURL(t *testing.T) {
+	type testCase struct {
+		meta        string
+		expected    string
+		expectedErr bool
+	}
+	table := map[string]testCase{
+		"falls back to href": {
+			meta:     `{"href":"/post.html"}`,
+			expected: "https://example.com/post.html",
+		},
+		"uses canonical path": {
+			meta:     `{"href":"/post.html","canonical":"/canonical.html"}`,
+			expected: "https://example.com/canonical.html",
+		},
+		"uses canonical url": {
+			meta:     `{"href":"/post.html","canonical":"https://other.com/post.html"}`,
+			expected: "https://other.com/post.html",
+		},
+		"rejects relative canonical": {
+			meta:        `{"href":"/post.html","canonical":"post.html"}`,
+			expectedErr: true,
+		},
+		"rejects host without scheme": {
+			meta:        `{"href":"/post.html","host":"example.com"}`,
+			expectedErr: true,
+		},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			manifests, err := manifest.New([]byte(`{"kind":"k","group":"g","version":"v","namespace":"ns","name":"n","meta":`+test.meta+`}`), "test")
+			if err != nil {
+				if !test.expectedErr {
+					t.Fatalf("did not expect error: %s", err)
+				}
+				return
+			}
+			if test.expectedErr {
+				t.Fatal("expected error")
+			}
+			if actual := manifests[0].CanonicalURL("https://example.com/"); actual != test.expected {
+				t.Fatalf("expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestManifest_Sitemap(t *testing.T) {
+	type testCase struct {
+		meta               string
+		expectedPriority   float64
+		expectedChangeFreq string
+		expectedErr        bool
+	}
+	table := map[string]testCase{
+		"defaults": {
+			meta:               `{}`,
+			expectedPriority:   0.5,
+			expectedChangeFreq: "monthly",
+		},
+		"explicit values": {
+			meta:               `{"priority":0.8,"changeFreq":"daily"}`,
+			expectedPriority:   0.8,
+			expectedChangeFreq: "daily",
+		},
+		"maximum priority": {
+			meta:               `{"priority":1.0}`,
+			expectedPriority:   1.0,
+			expectedChangeFreq: "monthly",
+		},
+		"rejects priority above one": {
+			meta:        `{"priority":1.01}`,
+			expectedErr: true,
+		},
+		"rejects negative priority": {
+			meta:        `{"priority":-0.1}`,
+			expectedErr: true,
+		},
+		"rejects unknown change frequency": {
+			meta:        `{"changeFreq":"fortnightly"}`,
+			expectedErr: true,
+		},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			manifests, err := manifest.New([]byte(`{"kind":"k","group":"g","version":"v","namespace":"ns","name":"n","meta":`+test.meta+`}`), "test")
+			if err != nil {
+				if !test.expectedErr {
+					t.Fatalf("did not expect error: %s", err)
+				}
+				return
+			}
+			if test.expectedErr {
+				t.Fatal("expected error")
+			}
+			if actual := manifests[0].SitemapPriority(); actual != test.expectedPriority {
+				t.Fatalf("expected %v, got %v", test.expectedPriority, actual)
+			}
+			if actual := manifests[0].SitemapChangeFreq(); actual != test.expectedChangeFreq {
+				t.Fatalf("expected %s, got %s", test.expectedChangeFreq, actual)
+			}
+		})
+	}
+}
+
+func TestManifest_RenderPriority(t *testing.T) {
+	if _, err := manifest.New([]byte(`{"kind":"k","group":"g","version":"v","namespace":"ns","name":"n","meta":{"renderPriority":-1}}`), "test"); err == nil {
+		t.Fatal("expected negative render priority to be rejected")
+	}
+}
+
+func TestManifest_PublishAtPartial(t *testing.T) {
+	table := map[string]struct {
+		publishAt string
+		expected  time.Time
+	}{
+		"year":       {publishAt: `{"year":2020}`, expected: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		"year month": {publishAt: `{"year":2020,"month":6}`, expected: time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)},
+		"full":       {publishAt: `{"year":2020,"month":6,"day":2,"hours":3,"minutes":4,"seconds":5}`, expected: time.Date(2020, 6, 2, 3, 4, 5, 0, time.UTC)},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			m := testhelper.MakeManifest(t, "k/g/v/ns/n", `{"publishAt":`+test.publishAt+`}`, "")
+			if actual := m.PublishAt(); !actual.Equal(test.expected) {
+				t.Fatalf("expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestManifest_IsLivePrecision(t *testing.T) {
+	now := time.Now().UTC()
+	publishAt := func(year int, month int, day int, hours int, minutes int, seconds int) string {
+		return fmt.Sprintf(`{"year":%d,"month":%d,"day":%d,"hours":%d,"minutes":%d,"seconds":%d}`, year, month, day, hours, minutes, seconds)
+	}
+	// The final second of the current year, month and day have not passed
+	// (unless this runs during them).
+	endOfYear := publishAt(now.Year(), 12, 31, 23, 59, 59)
+	endOfMonth := publishAt(now.Year(), int(now.Month()), now.AddDate(0, 1, -now.Day()).Day(), 23, 59, 59)
+	endOfDay := publishAt(now.Year(), int(now.Month()), now.Day(), 23, 59, 59)
+	table := map[string]struct {
+		publishAt string
+		precision string
+		expected  bool
+	}{
+		"year only in the past":       {publishAt: fmt.Sprintf(`{"year":%d}`, now.Year()-1), expected: true},
+		"year only in the future":     {publishAt: fmt.Sprintf(`{"year":%d}`, now.Year()+1), expected: false},
+		"year month in the future":    {publishAt: fmt.Sprintf(`{"year":%d,"month":1}`, now.Year()+1), expected: false},
+		"end of year by time":         {publishAt: endOfYear, expected: false},
+		"end of year by year":         {publishAt: endOfYear, precision: "year", expected: true},
+		"end of month by month":       {publishAt: endOfMonth, precision: "month", expected: true},
+		"end of day by time":          {publishAt: endOfDay, precision: "time", expected: false},
+		"end of day by day":           {publishAt: endOfDay, precision: "day", expected: true},
+		"next year by year precision": {publishAt: fmt.Sprintf(`{"year":%d}`, now.Year()+1), precision: "year", expected: false},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			meta := `{"publishAt":` + test.publishAt + `}`
+			if test.precision != "" {
+				meta = `{"publishAtPrecision":"` + test.precision + `","publishAt":` + test.publishAt + `}`
+			}
+			if actual := testhelper.MakeManifest(t, "k/g/v/ns/n", meta, "").IsLive(); actual != test.expected {
+				t.Fatalf("expected IsLive to be %v", test.expected)
+			}
+		})
+	}
+	if _, err := manifest.New([]byte(`{"kind":"k","group":"g","version":"v","namespace":"ns","name":"n","meta":{"publishAtPrecision":"week"}}`), "test"); err == nil {
+		t.Fatal("expected unknown precision to be rejected")
+	}
+}
+
+func TestNew_TrimBody(t *testing.T) {
+	body := "\n    <div>\n      <p>nested</p>\n    </div>  \n  short\n"
+	table := map[string]struct {
+		meta     string
+		body     string
+		expected string
+	}{
+		"unchanged by default": {meta: "{}", expected: body},
+		"uniform indent":       {meta: `{"trimBodyIndent":4}`, body: "    <p>\n    one\n    </p>", expected: "<p>\none\n</p>"},
+		"indent":               {meta: `{"trimBodyIndent":4}`, expected: "\n<div>\n  <p>nested</p>\n</div>  \nshort\n"},
+		"trim":                 {meta: `{"trimBody":true}`, expected: "\n<div>\n<p>nested</p>\n</div>\nshort\n"},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			m := testhelper.MakeManifest(t, "k/g/v/ns/n", test.meta, "")
+			if test.body == "" {
+				test.body = body
+			}
+			input := fmt.Sprintf("<!--\n%s\n-->%s", m.Raw, test.body)
+			manifests, err := manifest.New([]byte(input), "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual := manifests[0].Body; actual != test.expected {
+				t.Fatalf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+	if _, err := manifest.New([]byte(`{"kind":"k","group":"g","version":"v","namespace":"ns","name":"n","meta":{"trimBodyIndent":-1}}`), "test"); err == nil {
+		t.Fatal("expected negative indent to be rejected")
+	}
+}
+
+func TestDynamicRelation_SelectorTemplate(t *testing.T) {
+	image := testhelper.MakeManifest(t, "image/jpeg/v1/site/sunset", "", "")
+	context := testhelper.MakeManifest(t, "website/content/v1/post/sunset", `{"href":"sunset"}`, "")
+	index := testhelper.MakeIndex(t, image, context)
+	relation := &manifest.DynamicRelation{Relation: manifest.Relation{SelectorTemplate: "image/jpeg/v1/site/{{.Meta.Href}}"}}
+	matches, err := relation.Resolve(index, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0] != image {
+		t.Fatalf("expected %s, got %v", image.Selector, matches)
+	}
+	host := &manifest.Manifest{Meta: &manifest.Meta{ImportsDynamic: []*manifest.DynamicRelation{relation}}}
+	imports, err := host.ResolveDynamicImports(index, context)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imports) != 1 || !imports[0].Single || imports[0].Manifests[0] != image {
+		t.Fatalf("expected single import of %s, got %v", image.Selector, imports)
+	}
+}
+
+func TestDynamicRelation_Validate(t *testing.T) {
+	table := map[string]struct {
+		relation    string
+		expectedErr bool
+	}{
+		"selector template": {
+			relation: `{"selectorTemplate":"image/jpeg/v1/site/{{.Meta.Href}}"}`,
+		},
+		"non-wildcard selector": {
+			relation: `{"selector":"image/jpeg/v1/site/sunset"}`,
+		},
+		"wildcard selector related to context": {
+			relation: `{"selector":"image/jpeg/v1/site/*","matchIfRelatedToContext":true}`,
+		},
+		"wildcard selector": {
+			relation:    `{"selector":"image/jpeg/v1/site/*"}`,
+			expectedErr: true,
+		},
+		"selector and selector template": {
+			relation:    `{"selector":"image/jpeg/v1/site/sunset","selectorTemplate":"image/jpeg/v1/site/{{.Meta.Href}}"}`,
+			expectedErr: true,
+		},
+		"invalid selector template": {
+			relation:    `{"selectorTemplate":"image/jpeg/v1/site/{{"}`,
+			expectedErr: true,
+		},
+		"neither": {
+			relation:    `{}`,
+			expectedErr: true,
+		},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			input := `{"kind":"k","group":"g","version":"v","namespace":"ns","name":"n","meta":{"importsDynamic":[` + test.relation + `]}}`
+			_, err := manifest.New([]byte(input), "test")
+			if test.expectedErr && err == nil {
+				t.Fatal("expected error, got none")
+			}
+			if !test.expectedErr && err != nil {
+				t.Fatalf("unexpected err %s", err)
+			}
+		})
+	}
+}
+
+func TestMatchExpression_NotIn(t *testing.T) {
+	var manifests []*manifest.Manifest
+	for idx, year := range []int{2023, 2024, 2024, 2025, 2024} {
+		manifests = append(manifests, testhelper.MakeManifest(t,
+			fmt.Sprintf("test/post/v1/post/%d", idx),
+			fmt.Sprintf(`{"publishAt":{"year":%d,"month":%d,"day":%d}}`, year, idx%2+1, idx+1),
+			"",
+		))
+	}
+	table := map[string]struct {
+		expression string
+		expected   []string
+		invalid    bool
+	}{
+		"year":             {expression: `"key":"year","values":[2024]`, expected: []string{"0", "3"}},
+		"years":            {expression: `"key":"year","values":[2023,2025]`, expected: []string{"1", "2", "4"}},
+		"month":            {expression: `"key":"month","values":[1]`, expected: []string{"1", "3"}},
+		"day":              {expression: `"key":"day","values":[1,5]`, expected: []string{"1", "2", "3"}},
+		"nothing excluded": {expression: `"key":"year","values":[1999]`, expected: []string{"0", "1", "2", "3", "4"}},
+		"missing key":      {expression: `"values":[2024]`, invalid: true},
+		"unknown key":      {expression: `"key":"hour","values":[1]`, invalid: true},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			meta := `{"imports":[{"name":"posts","selector":"test/post/v1/post/*","matchExpression":[{"operator":"NotIn",` + test.expression + `}]}]}`
+			if test.invalid {
+				if _, err := manifest.New([]byte(`{"kind":"test","group":"page","version":"v1","namespace":"page","name":"index","meta":`+meta+`}`), "test"); err == nil {
+					t.Fatal("expected validation error")
+				}
+				return
+			}
+			importer := testhelper.MakeManifest(t, "test/page/v1/page/index", meta, "")
+			index := testhelper.MakeIndex(t, append([]*manifest.Manifest{importer}, manifests...)...)
+			imports, err := importer.ResolveStaticImports(index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var actual []string
+			for _, m := range imports[0].Manifests {
+				actual = append(actual, m.Selector.Name)
+			}
+			sort.Strings(actual)
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestMatchExpression_Between(t *testing.T) {
+	var manifests []*manifest.Manifest
+	for idx, date := range [][3]int{{2023, 6, 30}, {2023, 7, 1}, {2023, 9, 30}, {2023, 12, 31}, {2024, 1, 15}, {2024, 2, 1}} {
+		manifests = append(manifests, testhelper.MakeManifest(t,
+			fmt.Sprintf("test/post/v1/post/%d", idx),
+			fmt.Sprintf(`{"publishAt":{"year":%d,"month":%d,"day":%d}}`, date[0], date[1], date[2]),
+			"",
+		))
+	}
+	// A post with only a year is published on the first of January.
+	manifests = append(manifests, testhelper.MakeManifest(t, "test/post/v1/post/6", `{"publishAt":{"year":2022}}`, ""))
+	table := map[string]struct {
+		values   string
+		expected []string
+		invalid  bool
+	}{
+		"quarter":         {values: `[[2023,7,1],[2023,9,30]]`, expected: []string{"1", "2"}},
+		"across years":    {values: `[[2023,12,1],[2024,1,31]]`, expected: []string{"3", "4"}},
+		"single day":      {values: `[[2024,2,1],[2024,2,1]]`, expected: []string{"5"}},
+		"nothing matched": {values: `[[1999,1,1],[1999,12,31]]`, expected: nil},
+		"year only":       {values: `[[2022,1,1],[2022,12,31]]`, expected: []string{"6"}},
+		"one value":       {values: `[[2023,7,1]]`, invalid: true},
+		"three values":    {values: `[[2023,7,1],[2023,8,1],[2023,9,1]]`, invalid: true},
+		"not a date":      {values: `[2023,[2023,9,30]]`, invalid: true},
+		"partial date":    {values: `[[2023,7],[2023,9,30]]`, invalid: true},
+		"reversed":        {values: `[[2024,1,31],[2023,12,1]]`, invalid: true},
+		"month too large": {values: `[[2023,13,1],[2024,1,1]]`, invalid: true},
+		"day too large":   {values: `[[2023,1,1],[2023,1,100]]`, invalid: true},
+		"zero day":        {values: `[[2023,1,0],[2023,1,31]]`, invalid: true},
+		"fractional":      {values: `[[2023,1.5,1],[2023,9,30]]`, invalid: true},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			meta := `{"imports":[{"name":"posts","selector":"test/post/v1/post/*","matchExpression":[{"operator":"Between","values":` + test.values + `}]}]}`
+			if test.invalid {
+				if _, err := manifest.New([]byte(`{"kind":"test","group":"page","version":"v1","namespace":"page","name":"index","meta":`+meta+`}`), "test"); err == nil {
+					t.Fatal("expected validation error")
+				}
+				return
+			}
+			importer := testhelper.MakeManifest(t, "test/page/v1/page/index", meta, "")
+			index := testhelper.MakeIndex(t, append([]*manifest.Manifest{importer}, manifests...)...)
+			imports, err := importer.ResolveStaticImports(index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var actual []string
+			for _, m := range imports[0].Manifests {
+				actual = append(actual, m.Selector.Name)
+			}
+			sort.Strings(actual)
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestManifest_ResolveStaticImportsMatchExpression(t *testing.T) {
+	var manifests []*manifest.Manifest
+	for idx, year := range []int{2023, 2024, 2024, 2025, 2024} {
+		manifests = append(manifests, testhelper.MakeManifest(t,
+			fmt.Sprintf("test/post/v1/post/%d", idx),
+			fmt.Sprintf(`{"publishAt":{"year":%d,"month":1,"day":%d}}`, year, idx+1),
+			"",
+		))
+	}
+	table := map[string]struct {
+		window   string
+		expected []string
+	}{
+		"all matches":  {expected: []string{"1", "2", "4"}},
+		"with limit":   {window: `,"limit":2`, expected: []string{"1", "2"}},
+		"with offset":  {window: `,"offset":1`, expected: []string{"2", "4"}},
+		"with both":    {window: `,"limit":1,"offset":1`, expected: []string{"2"}},
+		"past the end": {window: `,"offset":3`, expected: nil},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			importer := testhelper.MakeManifest(t, "test/page/v1/page/2024",
+				`{"imports":[{"name":"posts","selector":"test/post/v1/post/*","matchExpression":[{"operator":"InYear","values":[2024]}]`+test.window+`}]}`,
+				"",
+			)
+			index := testhelper.MakeIndex(t, append([]*manifest.Manifest{importer}, manifests...)...)
+			imports, err := importer.ResolveStaticImports(index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var actual []string
+			for _, m := range imports[0].Manifests {
+				actual = append(actual, m.Selector.Name)
+			}
+			if !reflect.DeepEqual(actual, test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestRelation_ResolveWithContext(t *testing.T) {
+	post := testhelper.MakeManifest(t, "test/post/v1/post/one", "", "")
+	index := testhelper.MakeIndex(t, post)
+	relation := &manifest.Relation{Name: "posts", Selector: selector.Must("test/post/v1/post/*")}
+	resolved, err := relation.ResolveWithContext(index, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.Name != "posts" {
+		t.Fatalf("expected %s, got %s", "posts", resolved.Name)
+	}
+	if len(resolved.Manifests) != 1 || resolved.Manifests[0] != post {
+		t.Fatalf("expected %v, got %v", []*manifest.Manifest{post}, resolved.Manifests)
+	}
+}
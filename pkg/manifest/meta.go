@@ -2,13 +2,21 @@ package manifest
 
 import (
 	"fmt"
+	json "github.com/json-iterator/go"
+	"github.com/lestrrat-go/strftime"
 	"github.com/tkellen/aevitas/internal/selector"
+	"math"
 	"sort"
+	"strings"
+	"text/template"
+	"time"
 )
 
 // Meta provides details about a resource.
 type Meta struct {
-	// File is a temporary hack to make this system work with memorybox.
+	// File is a temporary hack to make this system work with memorybox. It
+	// is a path within the source of the resource or a file://, s3:// or
+	// http(s):// uri.
 	File string
 	// Live controls if a manifest is collected during production builds.
 	Live bool
@@ -16,14 +24,45 @@ type Meta struct {
 	Title string
 	// A description for the resource.
 	Description string
+	// The author of the resource.
+	Author string
+	// The license the resource is published under.
+	License string
 	// An optional field that will be prefixed to the href.
 	HrefPrefix string
 	// A URL for the manifest, defaults to index.html if not specified.
 	Href string
+	// Canonical overrides the URL search engines should consider the primary
+	// location of the resource. It may be a path or an absolute URL.
+	Canonical string
+	// Host is the scheme and domain (e.g. https://example.com) used to build
+	// absolute URLs for the manifest and its children.
+	Host string
+	// Priority hints the importance of the resource relative to others on the
+	// same site for sitemaps, from 0.0 to 1.0.
+	Priority float64
+	// ChangeFreq hints how often the resource changes for sitemaps.
+	ChangeFreq string
+	// RenderPriority orders rendering, resources with a higher priority are
+	// rendered first (e.g. a homepage that should be served immediately).
+	RenderPriority int
+	// PostProcess names output processors that are applied, in order, to
+	// the rendered output.
+	PostProcess []string
+	// TrimBody removes leading and trailing whitespace from every line of
+	// the body.
+	TrimBody bool
+	// TrimBodyIndent removes up to this many leading spaces from every line
+	// of the body, e.g. to undo indentation shared by all of them.
+	TrimBodyIndent int
 	// PublishAt controls if a manifest is collected during production builds.
 	// If present, current date/time must be greater than the machine that runs
 	// the build. It also provides the basis for ordering manifests.
 	PublishAt *PublishAt
+	// PublishAtPrecision is one of year, month, day or time (the default)
+	// and controls how much of PublishAt must have passed for the manifest
+	// to be live, e.g. with day, it is live from the start of the day.
+	PublishAtPrecision string
 	// Relations allows expressing relationships with other manifests.
 	Relations []*Relation
 	// RenderWith allows a manifest to declare dependencies on other manifests
@@ -52,7 +91,63 @@ type PublishAt struct {
 	Seconds int
 }
 
+// month defaults an omitted month to January.
+func (p *PublishAt) month() int {
+	if p.Month == 0 {
+		return 1
+	}
+	return p.Month
+}
+
+// day defaults an omitted day to the first of the month.
+func (p *PublishAt) day() int {
+	if p.Day == 0 {
+		return 1
+	}
+	return p.Day
+}
+
+// truncatePublishAt discards the parts of a publish time finer than the
+// precision of the meta.
+func (m *Meta) truncatePublishAt(publishAt time.Time) time.Time {
+	switch m.PublishAtPrecision {
+	case "year":
+		return time.Date(publishAt.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	case "month":
+		return time.Date(publishAt.Year(), publishAt.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case "day":
+		return time.Date(publishAt.Year(), publishAt.Month(), publishAt.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return publishAt
+}
+
 func (m *Meta) validate() error {
+	if _, _, err := m.FileURI(); err != nil {
+		return err
+	}
+	if m.Canonical != "" && !strings.HasPrefix(m.Canonical, "/") && !isAbsoluteURL(m.Canonical) {
+		return fmt.Errorf("canonical must be a path or absolute url: %s", m.Canonical)
+	}
+	if m.Host != "" && !isAbsoluteURL(m.Host) {
+		return fmt.Errorf("host must be an absolute url: %s", m.Host)
+	}
+	if m.Priority < 0 || m.Priority > 1 {
+		return fmt.Errorf("priority must be between 0.0 and 1.0: %v", m.Priority)
+	}
+	if m.RenderPriority < 0 {
+		return fmt.Errorf("renderPriority must not be negative: %d", m.RenderPriority)
+	}
+	switch m.PublishAtPrecision {
+	case "", "year", "month", "day", "time":
+	default:
+		return fmt.Errorf("publishAtPrecision must be one of year, month, day or time: %s", m.PublishAtPrecision)
+	}
+	if m.TrimBodyIndent < 0 {
+		return fmt.Errorf("trimBodyIndent must not be negative: %d", m.TrimBodyIndent)
+	}
+	if m.ChangeFreq != "" && !changeFreqs[m.ChangeFreq] {
+		return fmt.Errorf("changeFreq must be one of always, hourly, daily, weekly, monthly, yearly or never: %s", m.ChangeFreq)
+	}
 	if m.RenderWith != nil {
 		if err := m.RenderWith.validate(); err != nil {
 			return err
@@ -81,6 +176,22 @@ func (m *Meta) validate() error {
 	return nil
 }
 
+// changeFreqs are the values sitemaps accept for how often a page changes.
+var changeFreqs = map[string]bool{
+	"always":  true,
+	"hourly":  true,
+	"daily":   true,
+	"weekly":  true,
+	"monthly": true,
+	"yearly":  true,
+	"never":   true,
+}
+
+// isAbsoluteURL does just what you think it does.
+func isAbsoluteURL(value string) bool {
+	return strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://")
+}
+
 // RenderWith describes an array of selectors to (template-containing)
 // manifests that should be used during rendering.
 type RenderWith []*selector.Selector
@@ -112,8 +223,13 @@ func (rw RenderWith) Resolve(index *Index) ([]*Manifest, error) {
 // alongside the parent.
 type Child struct {
 	*Relation
+	// TitlePrefix and HrefPrefix are strftime patterns formatted with the
+	// publish date of the parent.
 	TitlePrefix string
 	HrefPrefix  string
+	// Templates, when present, replace the renderWith of every manifest
+	// resolved through this child relationship.
+	Templates RenderWith
 }
 
 // validate does just what you think it does.
@@ -121,6 +237,15 @@ func (c *Child) validate() error {
 	if err := c.Relation.validate(); err != nil {
 		return err
 	}
+	if _, err := strftime.New(c.HrefPrefix); err != nil {
+		return fmt.Errorf("hrefPrefix: %w", err)
+	}
+	if _, err := strftime.New(c.TitlePrefix); err != nil {
+		return fmt.Errorf("titlePrefix: %w", err)
+	}
+	if err := c.Templates.validate(); err != nil {
+		return fmt.Errorf("templates: %w", err)
+	}
 	return nil
 }
 
@@ -130,21 +255,50 @@ type Relation struct {
 	Name string
 	// Selector points to the manifest(s) that are "related".
 	Selector *selector.Selector
+	// SelectorTemplate is a text/template, executed with the context manifest
+	// as data, that produces the selector of the relation. The spec of the
+	// context is available as a map, e.g. {{.Spec.category}}.
+	SelectorTemplate string
 	// MatchIfRelatedTo is the first step in finding matched manifests. Each
 	// selector accumulates more potential matches (multiple entries are OR'd).
 	MatchIfRelatedTo []*selector.Selector
 	// MatchExpression is iterated in order, successively narrowing eligible
 	// matched manifests with each step (multiple entries are AND'd).
 	MatchExpression []*MatchExpression
-	Limit           int
-	Offset          int
-	Order           string
+	// Limit caps how many matches are returned. Zero, the default, means
+	// no limit. -1 means the same and may be used to state it explicitly.
+	Limit int
+	// Offset skips this many matches before any are returned.
+	Offset int
+	Order  string
 }
 
+// IsUnlimited indicates if every match of the relation is returned.
+func (r *Relation) IsUnlimited() bool { return r.Limit == 0 || r.Limit == -1 }
+
 // validate does just what you think it does.
 func (r *Relation) validate() error {
-	if r.Selector == nil {
-		return fmt.Errorf("selector must not be nil")
+	if (r.Selector == nil) == (r.SelectorTemplate == "") {
+		return fmt.Errorf("exactly one of selector or selectorTemplate must be set")
+	}
+	if r.SelectorTemplate != "" {
+		if _, err := template.New("").Parse(r.SelectorTemplate); err != nil {
+			return fmt.Errorf("selectorTemplate: %w", err)
+		}
+	}
+	return r.validateOptions()
+}
+
+// validateOptions validates everything about a relation but its selector.
+func (r *Relation) validateOptions() error {
+	// A limit of zero means unlimited rather than no results, as relations
+	// that should never match are of no use. -1 is accepted as an explicit
+	// alternative.
+	if r.Limit < -1 {
+		return fmt.Errorf("limit must be -1 (unlimited), 0 (unlimited) or positive")
+	}
+	if r.Offset < 0 {
+		return fmt.Errorf("offset must not be negative")
 	}
 	if r.Order != "" && r.Order != "asc" && r.Order != "desc" {
 		return fmt.Errorf("order must be asc or desc")
@@ -158,12 +312,84 @@ func (r *Relation) validate() error {
 }
 
 // Resolve turns a relation into (potentially) many manifests by searching the
-// index for matches and filtering the results on match expressions.
+// index for matches and filtering the results on match expressions. Relations
+// with a selector template must be resolved with ResolveFor.
 func (r *Relation) Resolve(index *Index) ([]*Manifest, error) {
 	return r.resolve(index, nil, false)
 }
 
+// ResolveFor does just what Resolve does, computing the selector of the
+// relation from the supplied context if it has a selector template.
+func (r *Relation) ResolveFor(index *Index, context *Manifest) ([]*Manifest, error) {
+	return r.resolve(index, context, false)
+}
+
+// ResolvedRelation is the result of resolving a relation, identified by the
+// relation's name.
+type ResolvedRelation struct {
+	Name      string
+	Manifests []*Manifest
+}
+
+// ResolveWithContext does just what ResolveFor does, keeping the name of the
+// relation with the manifests it resolved to.
+func (r *Relation) ResolveWithContext(index *Index, context *Manifest) (*ResolvedRelation, error) {
+	manifests, err := r.resolve(index, context, false)
+	if err != nil {
+		return nil, err
+	}
+	return &ResolvedRelation{Name: r.Name, Manifests: manifests}, nil
+}
+
+// relationFor produces the relation to resolve for the context. When there is
+// a selector template, this is a copy whose selector is computed by executing
+// the template against the context.
+func (r *Relation) relationFor(context *Manifest) (*Relation, error) {
+	if r.SelectorTemplate == "" {
+		return r, nil
+	}
+	if context == nil {
+		return nil, fmt.Errorf("selectorTemplate %s: no context manifest to execute it with", r.SelectorTemplate)
+	}
+	tmpl, err := template.New("").Option("missingkey=error").Parse(r.SelectorTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("selectorTemplate: %w", err)
+	}
+	data := selectorContext{Manifest: context}
+	if len(context.Spec) > 0 {
+		if err := json.Unmarshal(context.Spec, &data.Spec); err != nil {
+			return nil, fmt.Errorf("selectorTemplate: %w", err)
+		}
+	}
+	var target strings.Builder
+	if err := tmpl.Execute(&target, data); err != nil {
+		return nil, fmt.Errorf("selectorTemplate: %w", err)
+	}
+	s, err := selector.New(target.String())
+	if err != nil {
+		return nil, fmt.Errorf("selectorTemplate: %w", err)
+	}
+	relation := *r
+	relation.Selector = s
+	relation.SelectorTemplate = ""
+	return &relation, nil
+}
+
+// selectorContext is the data selector templates are executed with. It exposes
+// the spec of the manifest as a map so its fields can be referenced.
+type selectorContext struct {
+	*Manifest
+	Spec map[string]interface{}
+}
+
 func (r *Relation) resolve(index *Index, context *Manifest, mustBeRelatedToContext bool) ([]*Manifest, error) {
+	if r.SelectorTemplate != "" {
+		relation, err := r.relationFor(context)
+		if err != nil {
+			return nil, err
+		}
+		return relation.resolve(index, context, mustBeRelatedToContext)
+	}
 	var validMatches manifestList
 	var findErr error
 	if mustBeRelatedToContext {
@@ -178,13 +404,25 @@ func (r *Relation) resolve(index *Index, context *Manifest, mustBeRelatedToConte
 		}
 	}
 	// If validMatches manifests are constrained by their relationships,
-	// accumulate valid ones using the index.
+	// accumulate valid ones using the index. A manifest satisfying more than
+	// one is only included once so match expressions, limits and offsets
+	// count it once.
+	seen := make(map[string]struct{}, len(validMatches))
+	for _, m := range validMatches {
+		seen[m.Selector.ID()] = struct{}{}
+	}
 	for _, related := range r.MatchIfRelatedTo {
 		matched, findErr := index.FindManyWithRelation(r.Selector, related)
 		if findErr != nil {
 			return nil, findErr
 		}
-		validMatches = append(validMatches, matched...)
+		for _, m := range matched {
+			if _, ok := seen[m.Selector.ID()]; ok {
+				continue
+			}
+			seen[m.Selector.ID()] = struct{}{}
+			validMatches = append(validMatches, m)
+		}
 	}
 	// If there are matchExpressions, narrow validMatches to those that satisfy
 	// the matching criteria.
@@ -199,12 +437,13 @@ func (r *Relation) resolve(index *Index, context *Manifest, mustBeRelatedToConte
 			return nil, filterErr
 		}
 	}
+	// Matches published at the same time keep the order of the index.
 	if r.Order == "" || r.Order == "asc" {
-		sort.Sort(validMatches)
+		sort.Stable(validMatches)
 	} else {
-		sort.Sort(sort.Reverse(validMatches))
+		sort.Stable(sort.Reverse(validMatches))
 	}
-	if r.Offset == 0 && r.Limit == 0 {
+	if r.Offset == 0 && r.IsUnlimited() {
 		return validMatches, nil
 	}
 	// Apply limiting and offsets.
@@ -214,7 +453,7 @@ func (r *Relation) resolve(index *Index, context *Manifest, mustBeRelatedToConte
 	if offset > total {
 		offset = total
 	}
-	if limit == 0 {
+	if r.IsUnlimited() {
 		limit = total
 	} else {
 		limit = offset + limit
@@ -226,6 +465,10 @@ func (r *Relation) resolve(index *Index, context *Manifest, mustBeRelatedToConte
 }
 
 // MatchExpression describes how manifest relationships can be filtered.
+// The NotIn operator excludes manifests whose publish year, month or day, as
+// named by Key, is one of the values. The Between operator takes two
+// [year, month, day] values and matches manifests published from the first
+// through the second, inclusive.
 type MatchExpression struct {
 	Key      string
 	Operator string
@@ -237,12 +480,54 @@ func (m *MatchExpression) validate() error {
 	if len(m.Values) == 0 {
 		return fmt.Errorf("values must contain at least one entry")
 	}
+	if m.Operator == "NotIn" && m.Key != "year" && m.Key != "month" && m.Key != "day" {
+		return fmt.Errorf("NotIn requires a key of year, month or day, got %q", m.Key)
+	}
+	if m.Operator == "Between" {
+		if len(m.Values) != 2 {
+			return fmt.Errorf("Between requires exactly two values, got %d", len(m.Values))
+		}
+		var bounds [2]time.Time
+		for idx, value := range m.Values {
+			date, ok := betweenDate(value)
+			if !ok {
+				return fmt.Errorf("Between values must be [year, month, day] with a month of 1-12 and a day of 1-31, got %v", value)
+			}
+			bounds[idx] = date
+		}
+		if bounds[0].After(bounds[1]) {
+			return fmt.Errorf("Between must start on or before it ends, got %v", m.Values)
+		}
+	}
 	return nil
 }
 
+// betweenDate converts a [year, month, day] value to the start of that day.
+func betweenDate(value interface{}) (time.Time, bool) {
+	parts, ok := value.([]interface{})
+	if !ok || len(parts) != 3 {
+		return time.Time{}, false
+	}
+	var fields [3]int
+	for idx, part := range parts {
+		number, ok := part.(float64)
+		if !ok || number != math.Trunc(number) {
+			return time.Time{}, false
+		}
+		fields[idx] = int(number)
+	}
+	year, month, day := fields[0], fields[1], fields[2]
+	if month < 1 || month > 12 || day < 1 || day > 31 {
+		return time.Time{}, false
+	}
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC), true
+}
+
 func (m *MatchExpression) filter(search []*Manifest, context *Manifest) ([]*Manifest, error) {
 	var filtered []*Manifest
 	var compare func(*Manifest, interface{}) bool
+	var matches func(*Manifest) bool
+	exclude := false
 	switch op := m.Operator; op {
 	case "InYear":
 		compare = func(potential *Manifest, compare interface{}) bool {
@@ -259,19 +544,54 @@ func (m *MatchExpression) filter(search []*Manifest, context *Manifest) ([]*Mani
 				potential.Meta.PublishAt.Month == int(matchWith[1].(float64)) &&
 				potential.Meta.PublishAt.Day == int(matchWith[2].(float64))
 		}
+	case "NotIn":
+		exclude = true
+		compare = func(potential *Manifest, compare interface{}) bool {
+			at := potential.Meta.PublishAt
+			if at == nil {
+				return false
+			}
+			switch m.Key {
+			case "year":
+				return at.Year == int(compare.(float64))
+			case "month":
+				return at.Month == int(compare.(float64))
+			default:
+				return at.Day == int(compare.(float64))
+			}
+		}
+	case "Between":
+		// The values describe a single range, ending at the close of the
+		// last day, so publish times are compared against it once.
+		from, _ := betweenDate(m.Values[0])
+		to, _ := betweenDate(m.Values[1])
+		until := to.AddDate(0, 0, 1)
+		matches = func(potential *Manifest) bool {
+			if potential.Meta.PublishAt == nil {
+				return false
+			}
+			at := potential.PublishAt()
+			return !at.Before(from) && at.Before(until)
+		}
 	default:
 		return nil, fmt.Errorf("%s is not (yet) a supported operator", op)
 	}
+	if matches == nil {
+		matches = func(potential *Manifest) bool {
+			for _, check := range m.Values {
+				if compare(potential, check) {
+					return true
+				}
+			}
+			return false
+		}
+	}
 	// Iterate each of the currently valid matches, populating the filtered
 	// array with each that is still valid.
 	for _, potential := range search {
-		for _, check := range m.Values {
-			if compare(potential, check) {
-				filtered = append(filtered, potential)
-				break
-			}
+		if matches(potential) != exclude {
+			filtered = append(filtered, potential)
 		}
-
 	}
 	return filtered, nil
 }
@@ -280,17 +600,16 @@ func (m *MatchExpression) filter(search []*Manifest, context *Manifest) ([]*Mani
 // relationship with another.
 type DynamicRelation struct {
 	Relation
-	SelectorTemplate        string
 	MatchIfRelatedToContext bool
 }
 
 func (dr *DynamicRelation) validate() error {
+	if !dr.MatchIfRelatedToContext && dr.Selector != nil && dr.Selector.IsWildcard() {
+		return fmt.Errorf("selectorTemplate or a non-wildcard selector is required unless matching manifests related to the context")
+	}
 	return dr.Relation.validate()
 }
 
 func (dr *DynamicRelation) Resolve(index *Index, context *Manifest) ([]*Manifest, error) {
-	if dr.MatchIfRelatedToContext {
-		return dr.resolve(index, context, true)
-	}
-	return dr.resolve(index, context, false)
+	return dr.resolve(index, context, dr.MatchIfRelatedToContext)
 }
@@ -0,0 +1,67 @@
+package manifest
+
+import "fmt"
+
+// Paginator splits a list of manifests into pages, numbered from one.
+type Paginator struct {
+	Items       []*Manifest
+	PageSize    int
+	CurrentPage int
+}
+
+// Pages is the number of pages needed to hold every item. A page size that is
+// not positive places every item on one page.
+func (p *Paginator) Pages() int {
+	if p.PageSize <= 0 || len(p.Items) == 0 {
+		return 1
+	}
+	return (len(p.Items) + p.PageSize - 1) / p.PageSize
+}
+
+// HasNext determines if there is a page after the current one.
+func (p *Paginator) HasNext() bool { return p.CurrentPage < p.Pages() }
+
+// HasPrev determines if there is a page before the current one.
+func (p *Paginator) HasPrev() bool { return p.CurrentPage > 1 }
+
+// NextPage is the number of the page after the current one, or the last page.
+func (p *Paginator) NextPage() int {
+	if p.HasNext() {
+		return p.CurrentPage + 1
+	}
+	return p.Pages()
+}
+
+// PrevPage is the number of the page before the current one, or the first.
+func (p *Paginator) PrevPage() int {
+	if p.HasPrev() {
+		return p.CurrentPage - 1
+	}
+	return 1
+}
+
+// PageItems returns the items on the current page.
+func (p *Paginator) PageItems() []*Manifest {
+	if p.PageSize <= 0 {
+		return p.Items
+	}
+	offset := (p.CurrentPage - 1) * p.PageSize
+	if offset < 0 || offset >= len(p.Items) {
+		return nil
+	}
+	end := offset + p.PageSize
+	if end > len(p.Items) {
+		end = len(p.Items)
+	}
+	return p.Items[offset:end]
+}
+
+// URLS formats the href of each page by supplying its number to hrefTemplate,
+// e.g. "/blog/page/%d.html".
+func (p *Paginator) URLS(hrefTemplate string) []string {
+	urls := make([]string, p.Pages())
+	for idx := range urls {
+		urls[idx] = fmt.Sprintf(hrefTemplate, idx+1)
+	}
+	return urls
+}
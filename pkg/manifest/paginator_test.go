@@ -0,0 +1,57 @@
+package manifest_test
+
+import (
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"reflect"
+	"testing"
+)
+
+func TestPaginator(t *testing.T) {
+	items := generateManifests(25)
+	type testCase struct {
+		items    int
+		hasNext  bool
+		hasPrev  bool
+		nextPage int
+		prevPage int
+	}
+	table := map[int]testCase{
+		1: {items: 10, hasNext: true, hasPrev: false, nextPage: 2, prevPage: 1},
+		2: {items: 10, hasNext: true, hasPrev: true, nextPage: 3, prevPage: 1},
+		3: {items: 5, hasNext: false, hasPrev: true, nextPage: 3, prevPage: 2},
+		4: {items: 0, hasNext: false, hasPrev: true, nextPage: 3, prevPage: 3},
+	}
+	for page, test := range table {
+		paginator := &manifest.Paginator{Items: items, PageSize: 10, CurrentPage: page}
+		if paginator.Pages() != 3 {
+			t.Fatalf("expected 3 pages, got %d", paginator.Pages())
+		}
+		if len(paginator.PageItems()) != test.items {
+			t.Fatalf("page %d: expected %d items, got %d", page, test.items, len(paginator.PageItems()))
+		}
+		if paginator.HasNext() != test.hasNext {
+			t.Fatalf("page %d: expected HasNext %v", page, test.hasNext)
+		}
+		if paginator.HasPrev() != test.hasPrev {
+			t.Fatalf("page %d: expected HasPrev %v", page, test.hasPrev)
+		}
+		if paginator.NextPage() != test.nextPage {
+			t.Fatalf("page %d: expected next page %d, got %d", page, test.nextPage, paginator.NextPage())
+		}
+		if paginator.PrevPage() != test.prevPage {
+			t.Fatalf("page %d: expected prev page %d, got %d", page, test.prevPage, paginator.PrevPage())
+		}
+	}
+	first := (&manifest.Paginator{Items: items, PageSize: 10, CurrentPage: 1}).PageItems()
+	if first[0] != items[0] || first[9] != items[9] {
+		t.Fatal("expected first page to hold the first ten items")
+	}
+}
+
+func TestPaginator_URLS(t *testing.T) {
+	paginator := &manifest.Paginator{Items: generateManifests(25), PageSize: 10, CurrentPage: 1}
+	expected := []string{"/page/1.html", "/page/2.html", "/page/3.html"}
+	if actual := paginator.URLS("/page/%d.html"); !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+}
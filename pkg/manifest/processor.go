@@ -0,0 +1,87 @@
+package manifest
+
+import (
+	"fmt"
+	"github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/minify/v2/css"
+	"github.com/tdewolff/minify/v2/html"
+	"github.com/tdewolff/minify/v2/js"
+	"regexp"
+	"sync"
+)
+
+// OutputProcessor transforms the rendered output of a manifest.
+type OutputProcessor func(input string) (string, error)
+
+var (
+	outputProcessors = map[string]OutputProcessor{
+		"html-minify":     minifyHTML,
+		"inline-css":      minifyInlineCSS,
+		"remove-comments": removeComments,
+	}
+	outputProcessorsMu sync.RWMutex
+)
+
+// RegisterOutputProcessor makes a processor available by name to the
+// postProcess field of manifests, replacing any registered with the same name.
+func RegisterOutputProcessor(name string, fn func(input string) (string, error)) {
+	outputProcessorsMu.Lock()
+	defer outputProcessorsMu.Unlock()
+	outputProcessors[name] = fn
+}
+
+// PostProcess applies the output processors of the manifest in order.
+func (m *Manifest) PostProcess(output string) (string, error) {
+	for _, name := range m.Meta.PostProcess {
+		outputProcessorsMu.RLock()
+		process, ok := outputProcessors[name]
+		outputProcessorsMu.RUnlock()
+		if !ok {
+			return "", fmt.Errorf("%s: unknown output processor %s", m, name)
+		}
+		var err error
+		if output, err = process(output); err != nil {
+			return "", fmt.Errorf("%s: %s: %w", m, name, err)
+		}
+	}
+	return output, nil
+}
+
+// minifyHTML removes whitespace and comments from html, including any styles
+// and scripts it contains. Optional tags are kept so layouts that are split
+// across templates remain readable.
+func minifyHTML(input string) (string, error) {
+	minifier := minify.New()
+	minifier.Add("text/html", &html.Minifier{KeepDocumentTags: true, KeepEndTags: true})
+	minifier.AddFunc("text/css", css.Minify)
+	minifier.AddFunc("text/javascript", js.Minify)
+	return minifier.String("text/html", input)
+}
+
+var styleElement = regexp.MustCompile(`(?s)(<style[^>]*>)(.*?)(</style>)`)
+
+// minifyInlineCSS minifies the content of style elements, leaving the rest of
+// the document untouched.
+func minifyInlineCSS(input string) (string, error) {
+	minifier := minify.New()
+	minifier.AddFunc("text/css", css.Minify)
+	var err error
+	output := styleElement.ReplaceAllStringFunc(input, func(match string) string {
+		parts := styleElement.FindStringSubmatch(match)
+		minified, minifyErr := minifier.String("text/css", parts[2])
+		if minifyErr != nil {
+			err = minifyErr
+			return match
+		}
+		return parts[1] + minified + parts[3]
+	})
+	return output, err
+}
+
+// htmlComment matches html comments other than conditional comments.
+var htmlComment = regexp.MustCompile(`(?s)<!--[^\[].*?-->`)
+
+// removeComments strips html comments from the output.
+func removeComments(input string) (string, error) {
+	return htmlComment.ReplaceAllString(input, ""), nil
+}
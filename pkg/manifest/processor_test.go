@@ -0,0 +1,73 @@
+package manifest_test
+
+import (
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"strings"
+	"testing"
+)
+
+func TestManifest_PostProcess(t *testing.T) {
+	manifest.RegisterOutputProcessor("upper", func(input string) (string, error) {
+		return strings.ToUpper(input), nil
+	})
+	type testCase struct {
+		processors  string
+		input       string
+		expected    string
+		expectedErr bool
+	}
+	table := map[string]testCase{
+		"no processors": {
+			processors: `[]`,
+			input:      "<p> hi </p>",
+			expected:   "<p> hi </p>",
+		},
+		"minifies html": {
+			processors: `["html-minify"]`,
+			input:      "<div>\n    <p>  hi  </p>\n</div>\n",
+			expected:   "<div><p>hi</p></div>",
+		},
+		"minifies inline css": {
+			processors: `["inline-css"]`,
+			input:      "<style>\n  a {  color: red;  }\n</style>\n<p>  hi  </p>",
+			expected:   "<style>a{color:red}</style>\n<p>  hi  </p>",
+		},
+		"removes comments": {
+			processors: `["remove-comments"]`,
+			input:      "<p>hi</p><!-- note --><!--[if IE]>ie<![endif]-->",
+			expected:   "<p>hi</p><!--[if IE]>ie<![endif]-->",
+		},
+		"applies in order": {
+			processors: `["remove-comments", "upper"]`,
+			input:      "<p>hi</p><!-- note -->",
+			expected:   "<P>HI</P>",
+		},
+		"rejects unknown processors": {
+			processors:  `["missing"]`,
+			input:       "<p>hi</p>",
+			expectedErr: true,
+		},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			manifests, err := manifest.New([]byte(`{"kind":"k","group":"g","version":"v","namespace":"ns","name":"n","meta":{"postProcess":`+test.processors+`}}`), "test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual, processErr := manifests[0].PostProcess(test.input)
+			if processErr != nil {
+				if !test.expectedErr {
+					t.Fatalf("did not expect error: %s", processErr)
+				}
+				return
+			}
+			if test.expectedErr {
+				t.Fatal("expected error")
+			}
+			if actual != test.expected {
+				t.Fatalf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package manifest
+
+import (
+	"fmt"
+	json "github.com/json-iterator/go"
+	"math/rand"
+	"reflect"
+	"time"
+)
+
+const arbitraryLetters = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+func arbitraryString(r *rand.Rand) string {
+	value := make([]byte, 1+r.Intn(12))
+	for idx := range value {
+		value[idx] = arbitraryLetters[r.Intn(len(arbitraryLetters))]
+	}
+	return string(value)
+}
+
+// ArbitraryManifest produces a manifest with a randomized but valid selector,
+// meta and spec for property based testing. It panics if the manifest is not
+// accepted by New as that is a bug in the generator, not the caller.
+func ArbitraryManifest(r *rand.Rand) *Manifest {
+	meta := map[string]interface{}{
+		"live":        r.Intn(2) == 0,
+		"title":       arbitraryString(r),
+		"description": arbitraryString(r),
+		"href":        arbitraryString(r) + ".html",
+	}
+	if r.Intn(4) != 0 {
+		// Publish anywhere from five years ago to five years from now.
+		publishAt := time.Now().Add(time.Duration(r.Int63n(int64(10*365*24*time.Hour))) - 5*365*24*time.Hour).UTC()
+		meta["publishAt"] = map[string]int{
+			"year":    publishAt.Year(),
+			"month":   int(publishAt.Month()),
+			"day":     publishAt.Day(),
+			"hours":   publishAt.Hour(),
+			"minutes": publishAt.Minute(),
+			"seconds": publishAt.Second(),
+		}
+	}
+	raw, err := json.Marshal(map[string]interface{}{
+		"kind":      arbitraryString(r),
+		"group":     arbitraryString(r),
+		"version":   fmt.Sprintf("v%d", 1+r.Intn(3)),
+		"namespace": arbitraryString(r),
+		"name":      arbitraryString(r),
+		"meta":      meta,
+		"body":      arbitraryString(r),
+		"spec":      map[string]interface{}{"count": r.Intn(100), "label": arbitraryString(r)},
+	})
+	if err != nil {
+		panic(err)
+	}
+	manifests, err := New(raw, "test")
+	if err != nil {
+		panic(fmt.Errorf("%s: %w", raw, err))
+	}
+	return manifests[0]
+}
+
+// Generate allows testing/quick to produce manifests.
+func (m *Manifest) Generate(r *rand.Rand, _ int) reflect.Value {
+	return reflect.ValueOf(ArbitraryManifest(r))
+}
@@ -0,0 +1,152 @@
+package manifest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"time"
+)
+
+// s3Concurrency limits how many objects are downloaded at once.
+const s3Concurrency = 20
+
+// s3Retries is how many times a transient failure is retried.
+const s3Retries = 3
+
+// s3Backoff is the delay before the first retry, it doubles with each attempt.
+var s3Backoff = 250 * time.Millisecond
+
+// S3Config describes how to connect to S3 compatible storage.
+type S3Config struct {
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+}
+
+// Client creates a client for the configured storage.
+func (c S3Config) Client() (*minio.Client, error) {
+	return minio.New(c.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(c.AccessKey, c.SecretKey, ""),
+		Secure: c.UseSSL,
+		Region: c.Region,
+	})
+}
+
+// NewFromS3 creates manifests from all objects with a supported extension
+// found under the supplied prefix of a bucket.
+func NewFromS3(ctx context.Context, cfg S3Config, prefix string, watch progressFn) ([]*Manifest, error) {
+	client, clientErr := cfg.Client()
+	if clientErr != nil {
+		return nil, clientErr
+	}
+	var keys []string
+	for object := range client.ListObjects(ctx, cfg.Bucket, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("s3://%s/%s: %w", cfg.Bucket, prefix, object.Err)
+		}
+		switch path.Ext(object.Key) {
+		case ".json", ".yml", ".md":
+			keys = append(keys, object.Key)
+		}
+	}
+	progress := make(chan struct{})
+	defer close(progress)
+	if watch != nil {
+		go watch(len(keys), progress)
+	}
+	results := make([][]*Manifest, len(keys))
+	sem := semaphore.NewWeighted(s3Concurrency)
+	eg, egCtx := errgroup.WithContext(ctx)
+	for idx, key := range keys {
+		idx, key := idx, key
+		if err := sem.Acquire(egCtx, 1); err != nil {
+			break
+		}
+		eg.Go(func() error {
+			defer func() {
+				sem.Release(1)
+				if watch != nil {
+					progress <- struct{}{}
+				}
+			}()
+			source := fmt.Sprintf("s3://%s/%s", cfg.Bucket, key)
+			var data []byte
+			if err := retry(egCtx, func() error {
+				var getErr error
+				data, getErr = getObject(egCtx, client, cfg.Bucket, key)
+				return getErr
+			}); err != nil {
+				return fmt.Errorf("%s: %w", source, err)
+			}
+			manifests, err := newFromBytes(data, source)
+			if err != nil {
+				return err
+			}
+			results[idx] = manifests
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	var manifests []*Manifest
+	for _, result := range results {
+		manifests = append(manifests, result...)
+	}
+	return manifests, nil
+}
+
+func getObject(ctx context.Context, client *minio.Client, bucket string, key string) ([]byte, error) {
+	object, err := client.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+	return ioutil.ReadAll(object)
+}
+
+// retry calls fn until it succeeds, fails with an error that is not
+// transient or has been retried s3Retries times.
+func retry(ctx context.Context, fn func() error) error {
+	delay := s3Backoff
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt == s3Retries || !isTransient(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// isTransient determines if a failed request is worth repeating. Responses
+// from the server are only retried when they indicate it is overloaded or
+// unavailable, anything else (e.g. a dropped connection) is always retried.
+func isTransient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	response := minio.ToErrorResponse(err)
+	if response.StatusCode == 0 {
+		return true
+	}
+	return response.StatusCode >= http.StatusInternalServerError ||
+		response.StatusCode == http.StatusTooManyRequests
+}
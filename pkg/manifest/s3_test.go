@@ -0,0 +1,173 @@
+package manifest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/minio/minio-go/v7"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// s3Server mocks the parts of the S3 api used to list and download objects.
+// Keys listed in flaky fail as if the server were overloaded the first time
+// they are requested.
+func s3Server(t *testing.T, bucket string, objects map[string][]byte, flaky ...string) *httptest.Server {
+	var mu sync.Mutex
+	failures := map[string]bool{}
+	for _, key := range flaky {
+		failures[key] = true
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/"+bucket)
+		key = strings.TrimPrefix(key, "/")
+		if key == "" {
+			prefix := r.URL.Query().Get("prefix")
+			var keys []string
+			for name := range objects {
+				if strings.HasPrefix(name, prefix) {
+					keys = append(keys, name)
+				}
+			}
+			sort.Strings(keys)
+			var contents strings.Builder
+			for _, name := range keys {
+				fmt.Fprintf(&contents, "<Contents><Key>%s</Key><Size>%d</Size></Contents>", name, len(objects[name]))
+			}
+			w.Header().Set("Content-Type", "application/xml")
+			fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>%s</Name><Prefix>%s</Prefix><KeyCount>%d</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated>%s</ListBucketResult>`,
+				bucket, prefix, len(keys), contents.String())
+			return
+		}
+		data, ok := objects[key]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `<Error><Code>NoSuchKey</Code><Message>missing</Message><Key>%s</Key></Error>`, key)
+			return
+		}
+		mu.Lock()
+		fail := failures[key]
+		delete(failures, key)
+		mu.Unlock()
+		if fail {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, `<Error><Code>SlowDown</Code><Message>slow down</Message><Key>%s</Key></Error>`, key)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(data)))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("ETag", `"etag"`)
+		w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestNewFromS3(t *testing.T) {
+	s3Backoff = time.Millisecond
+	// Disable retries within the client so the flaky object is retried here.
+	defer func(previous int) { minio.MaxRetry = previous }(minio.MaxRetry)
+	minio.MaxRetry = 1
+	domain, err := ioutil.ReadFile("../../testdata/blog/domain.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	post, err := ioutil.ReadFile("../../testdata/blog/one.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := s3Server(t, "content", map[string][]byte{
+		"site/domain.yml":  domain,
+		"site/posts/1.md":  post,
+		"site/page.json":   []byte(`{"kind":"website","group":"content","version":"v1","namespace":"page","name":"about"}`),
+		"site/readme.txt":  []byte("ignored"),
+		"other/skipped.md": post,
+	}, "site/posts/1.md")
+	cfg := S3Config{
+		Endpoint: strings.TrimPrefix(server.URL, "http://"),
+		Bucket:   "content",
+		Region:   "us-east-1",
+	}
+	manifests, loadErr := NewFromS3(context.Background(), cfg, "site/", nil)
+	if loadErr != nil {
+		t.Fatal(loadErr)
+	}
+	var ids []string
+	for _, m := range manifests {
+		ids = append(ids, m.Selector.ID())
+	}
+	sort.Strings(ids)
+	expected := []string{
+		"website/content/v1/domain/blog",
+		"website/content/v1/page/about",
+		"website/content/v1/post/one",
+	}
+	if strings.Join(ids, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected %v, got %v", expected, ids)
+	}
+	for _, m := range manifests {
+		if !strings.HasPrefix(m.Source, "s3://content/site/") {
+			t.Fatalf("expected s3 source, got %s", m.Source)
+		}
+	}
+}
+
+func TestNewFromS3_Missing(t *testing.T) {
+	server := s3Server(t, "content", map[string][]byte{})
+	cfg := S3Config{
+		Endpoint: strings.TrimPrefix(server.URL, "http://"),
+		Bucket:   "content",
+		Region:   "us-east-1",
+	}
+	manifests, err := NewFromS3(context.Background(), cfg, "site/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) != 0 {
+		t.Fatalf("expected no manifests, got %d", len(manifests))
+	}
+}
+
+func TestRetry(t *testing.T) {
+	s3Backoff = time.Millisecond
+	type testCase struct {
+		err      error
+		expected int
+	}
+	table := map[string]testCase{
+		"success is not retried": {
+			err:      nil,
+			expected: 1,
+		},
+		"transient failures are retried": {
+			err:      errors.New("connection reset"),
+			expected: s3Retries + 1,
+		},
+		"cancellation is not retried": {
+			err:      context.Canceled,
+			expected: 1,
+		},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			calls := 0
+			retry(context.Background(), func() error {
+				calls++
+				return test.err
+			})
+			if calls != test.expected {
+				t.Fatalf("expected %d calls, got %d", test.expected, calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package manifest
+
+import "sort"
+
+// ManifestSet holds unique manifests keyed by the hash of their content.
+type ManifestSet map[string]*Manifest
+
+// NewManifestSet creates a set containing the supplied manifests.
+func NewManifestSet(manifests ...*Manifest) ManifestSet {
+	set := make(ManifestSet, len(manifests))
+	for _, m := range manifests {
+		set.Add(m)
+	}
+	return set
+}
+
+// Add includes a manifest in the set.
+func (s ManifestSet) Add(m *Manifest) { s[m.Hash] = m }
+
+// Has determines if a manifest with the same content is in the set.
+func (s ManifestSet) Has(m *Manifest) bool {
+	_, ok := s[m.Hash]
+	return ok
+}
+
+// Slice returns the manifests in the set sorted by ID.
+func (s ManifestSet) Slice() []*Manifest {
+	manifests := make([]*Manifest, 0, len(s))
+	for _, m := range s {
+		manifests = append(manifests, m)
+	}
+	sort.Slice(manifests, func(i, j int) bool {
+		if manifests[i].Selector.ID() == manifests[j].Selector.ID() {
+			return manifests[i].Hash < manifests[j].Hash
+		}
+		return manifests[i].Selector.ID() < manifests[j].Selector.ID()
+	})
+	return manifests
+}
+
+// Intersection returns the manifests found in both sets.
+func (s ManifestSet) Intersection(other ManifestSet) ManifestSet {
+	result := ManifestSet{}
+	for hash, m := range s {
+		if _, ok := other[hash]; ok {
+			result[hash] = m
+		}
+	}
+	return result
+}
+
+// Difference returns the manifests in the receiver that are not in other.
+func (s ManifestSet) Difference(other ManifestSet) ManifestSet {
+	result := ManifestSet{}
+	for hash, m := range s {
+		if _, ok := other[hash]; !ok {
+			result[hash] = m
+		}
+	}
+	return result
+}
@@ -0,0 +1,97 @@
+package manifest_test
+
+import (
+	"fmt"
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"testing"
+)
+
+// hashedManifests creates manifests numbered from start, each with a distinct
+// hash.
+func hashedManifests(tb testing.TB, start int, count int) []*manifest.Manifest {
+	var manifests []*manifest.Manifest
+	for idx := start; idx < start+count; idx++ {
+		created, err := manifest.New([]byte(fmt.Sprintf(`{
+			"kind": "test", "group": "number", "version": "v1", "namespace": "integer", "name": "%d"
+		}`, idx)), "test")
+		if err != nil {
+			tb.Fatal(err)
+		}
+		manifests = append(manifests, created...)
+	}
+	return manifests
+}
+
+func TestManifest_HashEqual(t *testing.T) {
+	first := hashedManifests(t, 0, 1)[0]
+	same := hashedManifests(t, 0, 1)[0]
+	other := hashedManifests(t, 1, 1)[0]
+	if !first.HashEqual(same) {
+		t.Fatal("expected manifests with the same content to be equal")
+	}
+	if first.HashEqual(other) {
+		t.Fatal("expected manifests with different content to differ")
+	}
+	if first.HashEqual(nil) {
+		t.Fatal("expected nil to differ")
+	}
+}
+
+func TestManifestSet(t *testing.T) {
+	// a holds 0-9 and b holds 5-14, overlapping by five.
+	a := manifest.NewManifestSet(hashedManifests(t, 0, 10)...)
+	b := manifest.NewManifestSet(hashedManifests(t, 5, 10)...)
+	type testCase struct {
+		set      manifest.ManifestSet
+		expected int
+	}
+	table := map[string]testCase{
+		"intersection":          {a.Intersection(b), 5},
+		"difference":            {a.Difference(b), 5},
+		"reverse difference":    {b.Difference(a), 5},
+		"self intersection":     {a.Intersection(a), 10},
+		"self difference":       {a.Difference(a), 0},
+		"disjoint intersection": {a.Intersection(manifest.NewManifestSet(hashedManifests(t, 20, 5)...)), 0},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			if len(test.set) != test.expected {
+				t.Fatalf("expected %d, got %d", test.expected, len(test.set))
+			}
+			if len(test.set.Slice()) != test.expected {
+				t.Fatalf("expected %d, got %d", test.expected, len(test.set.Slice()))
+			}
+		})
+	}
+	for _, m := range a.Intersection(b).Slice() {
+		if !a.Has(m) || !b.Has(m) {
+			t.Fatalf("expected %s in both sets", m)
+		}
+	}
+	for _, m := range a.Difference(b).Slice() {
+		if b.Has(m) {
+			t.Fatalf("expected %s to be missing from b", m)
+		}
+	}
+}
+
+func BenchmarkManifestSet_Has(b *testing.B) {
+	manifests := hashedManifests(b, 0, 10000)
+	set := manifest.NewManifestSet(manifests...)
+	target := manifests[len(manifests)-1]
+	b.Run("set", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			set.Has(target)
+		}
+	})
+	b.Run("linear", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			for _, m := range manifests {
+				if m.HashEqual(target) {
+					break
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,180 @@
+package manifest
+
+import (
+	"fmt"
+	"github.com/tkellen/aevitas/internal/selector"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func testManifests(namespace string, count int) []*Manifest {
+	result := make([]*Manifest, count)
+	for idx := 0; idx < count; idx++ {
+		result[idx] = &Manifest{
+			Selector: selector.Must(fmt.Sprintf("test/number/v1/%s/%d", namespace, idx)),
+			Meta:     &Meta{Live: true},
+		}
+	}
+	return result
+}
+
+func TestIndex_insertCollation(t *testing.T) {
+	sequential := newIndex()
+	batched := newIndex()
+	evens, odds := testManifests("even", 5), testManifests("odd", 5)
+	for _, m := range append(append([]*Manifest{}, evens...), odds...) {
+		if err := sequential.insert(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := batched.insert(append(append([]*Manifest{}, evens...), odds...)...); err != nil {
+		t.Fatal(err)
+	}
+	sequential.collate()
+	batched.collate()
+	// Inserting into a collated shard should keep it collated, regardless of
+	// how the insertion happened.
+	extra := []*Manifest{
+		{Selector: selector.Must("test/number/v1/odd/extra-one"), Meta: &Meta{Live: true}},
+		{Selector: selector.Must("test/number/v1/odd/extra-two"), Meta: &Meta{Live: true}},
+	}
+	for _, m := range extra {
+		if err := sequential.insert(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := batched.insert(extra...); err != nil {
+		t.Fatal(err)
+	}
+	for name, idx := range map[string]*index{"sequential": sequential, "batched": batched} {
+		if !idx.shard["test/number/v1/even"].collated {
+			t.Fatalf("%s: expected untouched shard to remain collated", name)
+		}
+		if !idx.shard["test/number/v1/odd"].collated {
+			t.Fatalf("%s: expected modified shard to remain collated", name)
+		}
+		if !idx.all.collated {
+			t.Fatalf("%s: expected all shard to remain collated", name)
+		}
+		if count := len(idx.shard["test/number/v1/odd"].manifests); count != 7 {
+			t.Fatalf("%s: expected 7 manifests in shard, got %d", name, count)
+		}
+	}
+}
+
+func TestShard_insertBatch(t *testing.T) {
+	s := newShard()
+	s.collate()
+	s.insertBatch(testManifests("ns", 3))
+	if s.collated {
+		t.Fatal("expected batch insert to require collation")
+	}
+	if len(s.manifests) != 3 {
+		t.Fatalf("expected 3 manifests, got %d", len(s.manifests))
+	}
+}
+
+// datedManifests produces manifests published on consecutive days, shuffled.
+func datedManifests(namespace string, count int) []*Manifest {
+	result := make([]*Manifest, count)
+	for idx := 0; idx < count; idx++ {
+		year, month, day := time.Unix(int64(idx+1)*86400, 0).Date()
+		result[idx] = &Manifest{
+			Selector: selector.Must(fmt.Sprintf("test/number/v1/%s/%d", namespace, idx)),
+			Meta: &Meta{
+				Live:      true,
+				PublishAt: &PublishAt{Year: year, Month: int(month), Day: day},
+			},
+		}
+	}
+	rand.Shuffle(len(result), func(i, j int) { result[i], result[j] = result[j], result[i] })
+	return result
+}
+
+func TestShard_insertSorted(t *testing.T) {
+	manifests := datedManifests("ns", 110)
+	s := newShard()
+	s.insertBatch(manifests[:100])
+	s.collate()
+	for count, m := range manifests[100:] {
+		s.insertSorted(m)
+		if !s.collated {
+			t.Fatal("expected shard to remain collated")
+		}
+		if len(s.manifests) != 101+count {
+			t.Fatalf("expected %d manifests, got %d", 101+count, len(s.manifests))
+		}
+		if !sort.IsSorted(manifestList(s.manifests)) {
+			t.Fatalf("expected shard to be sorted after inserting %s", m.Selector)
+		}
+		// Navigation must match what a full collation computes.
+		expected := newShard()
+		expected.insertBatch(s.manifests)
+		expected.collate()
+		for _, item := range s.manifests {
+			if s.next(item) != expected.next(item) || s.previous(item) != expected.previous(item) {
+				t.Fatalf("%s: expected navigation to match a full collation", item.Selector)
+			}
+			if !reflect.DeepEqual(s.sameMonthDay(item), expected.sameMonthDay(item)) {
+				t.Fatalf("%s: expected same month and day to match a full collation", item.Selector)
+			}
+			if !reflect.DeepEqual(s.sameYearAs(item), expected.sameYearAs(item)) {
+				t.Fatalf("%s: expected same year to match a full collation", item.Selector)
+			}
+		}
+	}
+}
+
+func BenchmarkShard_insertSorted(b *testing.B) {
+	manifests := datedManifests("ns", 10000+b.N)
+	s := newShard()
+	s.insertBatch(manifests[:10000])
+	s.collate()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		s.insertSorted(manifests[10000+n])
+	}
+}
+
+func BenchmarkShard_insertBatchCollate(b *testing.B) {
+	manifests := datedManifests("ns", 10000+b.N)
+	s := newShard()
+	s.insertBatch(manifests[:10000])
+	s.collate()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		s.insertBatch(manifests[10000+n : 10000+n+1])
+		s.collate()
+	}
+}
+
+func TestIndex_findCache(t *testing.T) {
+	i := newIndex()
+	i.findCaching = true
+	target := selector.Must("test/number/v1/integer/*")
+	stale := testManifests("integer", 1)
+	// Results found before the cache was cleared are not stored.
+	_, generation, _ := i.cachedFind(target)
+	i.clearFindCache()
+	i.cacheFind(target, stale, generation)
+	if _, _, ok := i.cachedFind(target); ok {
+		t.Fatal("expected results found before clearing not to be cached")
+	}
+	// Caching stays suspended until every suspension is resumed.
+	i.suspendFindCache()
+	i.suspendFindCache()
+	i.resumeFindCache()
+	_, generation, _ = i.cachedFind(target)
+	i.cacheFind(target, stale, generation)
+	if _, _, ok := i.cachedFind(target); ok {
+		t.Fatal("expected caching to stay suspended by a nested suspension")
+	}
+	i.resumeFindCache()
+	i.cacheFind(target, stale, generation)
+	if cached, _, ok := i.cachedFind(target); !ok || !reflect.DeepEqual(cached, stale) {
+		t.Fatalf("expected %v, got %v", stale, cached)
+	}
+}
@@ -0,0 +1,65 @@
+package manifest
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Validator checks that a manifest is acceptable.
+type Validator func(m *Manifest) error
+
+var (
+	validators   []Validator
+	validatorsMu sync.RWMutex
+	// builtinValidators counts the validators registered by this package,
+	// which ClearValidators retains.
+	builtinValidators int
+)
+
+func init() {
+	RegisterValidator(validateGenerators)
+	RegisterValidator(validateMeta)
+	builtinValidators = len(validators)
+}
+
+// RegisterValidator adds a rule every manifest must satisfy. Validators run
+// in the order they are registered, after those of this package.
+func RegisterValidator(fn func(*Manifest) error) {
+	validatorsMu.Lock()
+	defer validatorsMu.Unlock()
+	validators = append(validators, fn)
+}
+
+// ClearValidators removes every validator added with RegisterValidator,
+// leaving the built-in ones.
+func ClearValidators() {
+	validatorsMu.Lock()
+	defer validatorsMu.Unlock()
+	validators = validators[:builtinValidators:builtinValidators]
+}
+
+// ValidatorError reports a manifest that failed validation.
+type ValidatorError struct {
+	// Source is where the manifest originated.
+	Source string
+	Err    error
+}
+
+func (e *ValidatorError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Source, e.Err)
+}
+
+func (e *ValidatorError) Unwrap() error { return e.Err }
+
+func validateGenerators(m *Manifest) error {
+	for _, generator := range m.GenerateManifests {
+		if err := generator.Validate(); err != nil {
+			return fmt.Errorf("%s: %w", generator.Name, err)
+		}
+	}
+	return nil
+}
+
+func validateMeta(m *Manifest) error {
+	return m.Meta.validate()
+}
@@ -0,0 +1,41 @@
+package manifest_test
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"strings"
+	"testing"
+)
+
+func TestRegisterValidator(t *testing.T) {
+	t.Cleanup(manifest.ClearValidators)
+	manifest.RegisterValidator(func(m *manifest.Manifest) error {
+		if len(m.Meta.Title) > 60 {
+			return fmt.Errorf("title must be at most 60 characters, got %d", len(m.Meta.Title))
+		}
+		return nil
+	})
+	withTitle := func(length int) []byte {
+		return []byte(`{"kind":"k","group":"g","version":"v","namespace":"ns","name":"n","meta":{"title":"` + strings.Repeat("a", length) + `"}}`)
+	}
+	if _, err := manifest.New(withTitle(60), "test"); err != nil {
+		t.Fatalf("unexpected err %s", err)
+	}
+	_, err := manifest.New(withTitle(61), "test")
+	var validatorErr *manifest.ValidatorError
+	if !errors.As(err, &validatorErr) {
+		t.Fatalf("expected validator error, got %v", err)
+	}
+	if validatorErr.Source != "test" || !strings.Contains(err.Error(), "at most 60 characters") {
+		t.Fatalf("expected error from registered validator, got %s", err)
+	}
+	manifest.ClearValidators()
+	if _, err := manifest.New(withTitle(61), "test"); err != nil {
+		t.Fatalf("expected cleared validator not to run, got %s", err)
+	}
+	// Built-in validation remains.
+	if _, err := manifest.New([]byte(`{"kind":"k","group":"g","version":"v","namespace":"ns","name":"n","meta":{"trimBodyIndent":-1}}`), "test"); !errors.As(err, &validatorErr) {
+		t.Fatalf("expected built-in validation to remain, got %v", err)
+	}
+}
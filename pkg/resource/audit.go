@@ -0,0 +1,53 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"html/template"
+	"strings"
+)
+
+// scriptMarkers are signs that content includes executable script.
+var scriptMarkers = []string{"<script", "javascript:"}
+
+// ContainsScript reports if content looks like it includes executable script.
+func ContainsScript(content string) bool {
+	lower := strings.ToLower(content)
+	for _, marker := range scriptMarkers {
+		if strings.Contains(lower, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithHTMLAudit returns a copy of the factory whose html template function
+// rejects content that looks like it includes a script. Templates fail to
+// render when it does. The copy shares handlers with the original but not the
+// instances they create.
+func (r *Factory) WithHTMLAudit() *Factory {
+	r.handlersMu.RLock()
+	defer r.handlersMu.RUnlock()
+	handlers := make(map[string][]*Handler, len(r.handlers))
+	for kgv, list := range r.handlers {
+		handlers[kgv] = append([]*Handler{}, list...)
+	}
+	return &Factory{
+		defaultSource:  r.defaultSource,
+		defaultDest:    r.defaultDest,
+		handlers:       handlers,
+		instances:      map[*manifest.Manifest]*Instance{},
+		highlightTheme: r.highlightTheme,
+		htmlAudit:      true,
+	}
+}
+
+// auditHTML escapes its arguments just as the builtin html function does,
+// failing if they look like they include a script.
+func auditHTML(args ...interface{}) (template.HTML, error) {
+	content := fmt.Sprint(args...)
+	if ContainsScript(content) {
+		return "", fmt.Errorf("html audit: %q looks like it includes a script", content)
+	}
+	return template.HTML(template.HTMLEscapeString(content)), nil
+}
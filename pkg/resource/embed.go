@@ -0,0 +1,59 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	"html/template"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// embedPath cleans a path relative to the root of the source filesystem and
+// rejects any that reach outside of it.
+func embedPath(target string) (string, error) {
+	clean := path.Clean(strings.TrimLeft(target, "/"))
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("embed %s: path traversal detected", target)
+	}
+	return clean, nil
+}
+
+// embedText returns the contents of a file in the source filesystem.
+func embedText(source billy.Filesystem, target string) (string, error) {
+	name, err := embedPath(target)
+	if err != nil {
+		return "", err
+	}
+	file, err := source.Open(name)
+	if err != nil {
+		return "", fmt.Errorf("embed %s: %w", target, err)
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return "", fmt.Errorf("embed %s: %w", target, err)
+	}
+	return string(data), nil
+}
+
+// embedFuncs include files from the source filesystem verbatim in templates.
+func embedFuncs(source billy.Filesystem) map[string]interface{} {
+	return map[string]interface{}{
+		"embed": func(target string) (template.HTML, error) {
+			content, err := embedText(source, target)
+			return template.HTML(content), err
+		},
+		"embedText": func(target string) (string, error) {
+			return embedText(source, target)
+		},
+		"embedCSS": func(target string) (template.CSS, error) {
+			content, err := embedText(source, target)
+			return template.CSS(content), err
+		},
+		"embedJS": func(target string) (template.JS, error) {
+			content, err := embedText(source, target)
+			return template.JS(content), err
+		},
+	}
+}
@@ -0,0 +1,45 @@
+package resource_test
+
+import (
+	"fmt"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"github.com/tkellen/aevitas/pkg/resource"
+)
+
+// exampleResource creates a resource for a post with a template that renders
+// its title.
+func exampleResource() *resource.Resource {
+	manifests, err := manifest.New([]byte(
+		`{"kind":"website","group":"content","version":"v1","namespace":"post","name":"hello","meta":{"live":true,"title":"Hello","hrefPrefix":"/posts","href":"hello.html"},"body":"<h1>{{ .Meta.Title }}</h1>"}`,
+	), "example")
+	if err != nil {
+		panic(err)
+	}
+	index := manifest.NewIndex()
+	if err := index.Insert(manifests...); err != nil {
+		panic(err)
+	}
+	if err := index.Collate(); err != nil {
+		panic(err)
+	}
+	r, err := resource.New(index, "website/content/v1/post/hello", resource.DefaultFactory(memfs.New(), memfs.New()))
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func ExampleResource_Href() {
+	fmt.Println(exampleResource().Href())
+	// Output: /posts/hello.html
+}
+
+func ExampleResource_Render() {
+	output, err := exampleResource().Render()
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(output)
+	// Output: <h1>Hello</h1>
+}
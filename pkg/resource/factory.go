@@ -6,15 +6,28 @@ import (
 	"github.com/tkellen/aevitas/internal/selector"
 	"github.com/tkellen/aevitas/pkg/manifest"
 	assetv1 "github.com/tkellen/aevitas/pkg/resource/v1/asset"
+	"html/template"
+	"sort"
 	"strings"
+	"sync"
 )
 
 // Handler provides support for instantiating resources of any type. When golang
 // supports generics this will likely go away.
 type Factory struct {
-	handlers      []*Handler
+	// handlers are keyed by the kind/group/version they instantiate.
+	handlers      map[string][]*Handler
+	handlersMu    sync.RWMutex
 	defaultSource billy.Filesystem
 	defaultDest   billy.Filesystem
+	// instances are memoized per manifest so every resource referencing the
+	// same manifest shares any state produced by rendering it.
+	instances   map[*manifest.Manifest]*Instance
+	instancesMu sync.Mutex
+	// highlightTheme is the default theme for highlighted code.
+	highlightTheme string
+	// htmlAudit replaces the html template function with auditHTML.
+	htmlAudit bool
 }
 
 // Handler represents a method of instantiating a specific resource type.
@@ -32,16 +45,24 @@ func (h *Handler) New(m *manifest.Manifest) (interface{}, error) {
 // NewFactory creates a registry
 func NewFactory(defaultSource billy.Filesystem, defaultDest billy.Filesystem) *Factory {
 	return &Factory{
-		defaultSource: defaultSource,
-		defaultDest:   defaultDest,
+		defaultSource:  defaultSource,
+		defaultDest:    defaultDest,
+		handlers:       map[string][]*Handler{},
+		instances:      map[*manifest.Manifest]*Instance{},
+		highlightTheme: defaultHighlightTheme,
 	}
 }
 
 func (r *Factory) String() string {
+	r.handlersMu.RLock()
+	defer r.handlersMu.RUnlock()
 	var details []string
-	for _, h := range r.handlers {
-		details = append(details, fmt.Sprintf("%s", h.selector))
+	for _, handlers := range r.handlers {
+		for _, h := range handlers {
+			details = append(details, fmt.Sprintf("%s", h.selector))
+		}
 	}
+	sort.Strings(details)
 	return strings.Join(details, "\n")
 }
 
@@ -50,7 +71,9 @@ func (r *Factory) Register(target string, fn func(m *manifest.Manifest) (interfa
 	if err != nil {
 		return err
 	}
-	r.handlers = append(r.handlers, &Handler{
+	r.handlersMu.Lock()
+	defer r.handlersMu.Unlock()
+	r.handlers[s.KGV] = append(r.handlers[s.KGV], &Handler{
 		selector: s,
 		// expose per-selector source customization?
 		source: r.defaultSource,
@@ -61,16 +84,43 @@ func (r *Factory) Register(target string, fn func(m *manifest.Manifest) (interfa
 }
 
 func (r *Factory) Handler(target *manifest.Manifest) (*Handler, error) {
-	var factory *Handler
-	for _, h := range r.handlers {
-		if h.selector.KGV == target.Selector.KGV {
-			factory = h
-		}
-	}
-	if factory == nil {
+	r.handlersMu.RLock()
+	handlers := r.handlers[target.Selector.KGV]
+	r.handlersMu.RUnlock()
+	if len(handlers) == 0 {
 		return nil, fmt.Errorf("%s: no registered factory", target.Selector)
 	}
-	return factory, nil
+	// The most recently registered handler takes precedence.
+	return handlers[len(handlers)-1], nil
+}
+
+// funcMap provides the template functions registered by the factory.
+func (r *Factory) funcMap() map[string]interface{} {
+	funcs := map[string]interface{}{
+		"highlight":          highlight,
+		"highlightWithTheme": highlightWithTheme,
+		"highlightCSS": func(theme string) template.CSS {
+			if theme == "" {
+				theme = r.highlightTheme
+			}
+			return highlightCSS(theme)
+		},
+		"paginate": paginate,
+	}
+	merge(funcs, embedFuncs(r.defaultSource))
+	if r.htmlAudit {
+		funcs["html"] = auditHTML
+	}
+	return funcs
+}
+
+// paginate splits resources into pages for templates.
+func paginate(items []*Resource, pageSize int, current int) *manifest.Paginator {
+	manifests := make([]*manifest.Manifest, len(items))
+	for idx, item := range items {
+		manifests[idx] = item.Manifest
+	}
+	return &manifest.Paginator{Items: manifests, PageSize: pageSize, CurrentPage: current}
 }
 
 func DefaultFactory(
@@ -93,8 +143,23 @@ func DefaultFactory(
 	factory.Register(fmt.Sprintf("%s/*/*", assetv1.KGVPng), func(m *manifest.Manifest) (interface{}, error) {
 		return assetv1.NewPng(m)
 	})
+	factory.Register(fmt.Sprintf("%s/*/*", assetv1.KGVAvif), func(m *manifest.Manifest) (interface{}, error) {
+		return assetv1.NewAvif(m)
+	})
+	factory.Register(fmt.Sprintf("%s/*/*", assetv1.KGVSvg), func(m *manifest.Manifest) (interface{}, error) {
+		return assetv1.NewSvg(m)
+	})
 	factory.Register(fmt.Sprintf("%s/*/*", assetv1.KGVMpeg), func(m *manifest.Manifest) (interface{}, error) {
 		return assetv1.NewMpeg(m)
 	})
+	factory.Register(fmt.Sprintf("%s/*/*", assetv1.KGVMp4), func(m *manifest.Manifest) (interface{}, error) {
+		return assetv1.NewMp4(m)
+	})
+	factory.Register(fmt.Sprintf("%s/*/*", assetv1.KGVCss), func(m *manifest.Manifest) (interface{}, error) {
+		return assetv1.NewCss(m)
+	})
+	factory.Register(fmt.Sprintf("%s/*/*", assetv1.KGVJavaScript), func(m *manifest.Manifest) (interface{}, error) {
+		return assetv1.NewJavaScript(m)
+	})
 	return factory
 }
@@ -0,0 +1,126 @@
+package resource_test
+
+import (
+	"fmt"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/tkellen/aevitas/internal/selector"
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"github.com/tkellen/aevitas/pkg/resource"
+	"sync"
+	"testing"
+)
+
+func returns(value string) func(*manifest.Manifest) (interface{}, error) {
+	return func(*manifest.Manifest) (interface{}, error) { return value, nil }
+}
+
+func TestFactory_Handler(t *testing.T) {
+	factory := resource.NewFactory(memfs.New(), memfs.New())
+	if err := factory.Register("invalid", returns("invalid")); err == nil {
+		t.Fatal("expected error registering invalid selector")
+	}
+	for _, registration := range []struct{ selector, value string }{
+		{"k/g/v1/*/*", "first"},
+		{"k/g/v2/*/*", "other"},
+		{"k/g/v1/*/*", "last"},
+	} {
+		if err := factory.Register(registration.selector, returns(registration.value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	table := map[string]struct {
+		selector    string
+		expected    string
+		expectedErr bool
+	}{
+		"most recent registration wins": {selector: "k/g/v1/ns/n", expected: "last"},
+		"matches by kind/group/version": {selector: "k/g/v2/other/n", expected: "other"},
+		"unregistered types error":      {selector: "k/g/v3/ns/n", expectedErr: true},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			m := &manifest.Manifest{Selector: selector.Must(test.selector)}
+			handler, err := factory.Handler(m)
+			if test.expectedErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			actual, _ := handler.New(m)
+			if actual != test.expected {
+				t.Fatalf("expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestFactory_ConcurrentAccess(t *testing.T) {
+	factory := resource.NewFactory(memfs.New(), memfs.New())
+	if err := factory.Register("k/g/v0/*/*", returns("")); err != nil {
+		t.Fatal(err)
+	}
+	m := &manifest.Manifest{Selector: selector.Must("k/g/v0/ns/n")}
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for idx := 0; idx < 100; idx++ {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			if idx%2 == 0 {
+				errs <- factory.Register(fmt.Sprintf("k/g/v%d/*/*", idx%10), returns(""))
+				return
+			}
+			_, err := factory.Handler(m)
+			errs <- err
+		}(idx)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if factory.String() == "" {
+		t.Fatal("expected registered handlers")
+	}
+}
+
+func BenchmarkFactory_Handler(b *testing.B) {
+	factory := resource.NewFactory(memfs.New(), memfs.New())
+	for idx := 0; idx < 20; idx++ {
+		if err := factory.Register(fmt.Sprintf("k/g/v%d/*/*", idx), returns("")); err != nil {
+			b.Fatal(err)
+		}
+	}
+	m := &manifest.Manifest{Selector: selector.Must("k/g/v19/ns/n")}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := factory.Handler(m); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFactory_HandlerParallel(b *testing.B) {
+	factory := resource.NewFactory(memfs.New(), memfs.New())
+	for idx := 0; idx < 20; idx++ {
+		if err := factory.Register(fmt.Sprintf("k/g/v%d/*/*", idx), returns("")); err != nil {
+			b.Fatal(err)
+		}
+	}
+	m := &manifest.Manifest{Selector: selector.Must("k/g/v19/ns/n")}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := factory.Handler(m); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
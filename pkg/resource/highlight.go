@@ -0,0 +1,57 @@
+package resource
+
+import (
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"html/template"
+	"strings"
+)
+
+// defaultHighlightTheme is used by highlightCSS when no theme is supplied
+// and a factory has not been configured with another.
+const defaultHighlightTheme = "monokai"
+
+// SetHighlightTheme controls the theme used when templates request the CSS
+// for highlighted code without naming one.
+func (r *Factory) SetHighlightTheme(name string) {
+	r.highlightTheme = name
+}
+
+// highlight annotates code with css classes, styled by highlightCSS.
+func highlight(code string, language string) template.HTML {
+	return highlightCode(code, language, styles.Get(defaultHighlightTheme), html.WithClasses(true))
+}
+
+// highlightWithTheme styles code inline using the supplied theme.
+func highlightWithTheme(code string, language string, theme string) template.HTML {
+	return highlightCode(code, language, styles.Get(theme))
+}
+
+// highlightCSS returns the styles for code annotated by highlight.
+func highlightCSS(theme string) template.CSS {
+	var css strings.Builder
+	if err := html.New(html.WithClasses(true)).WriteCSS(&css, styles.Get(theme)); err != nil {
+		return ""
+	}
+	return template.CSS(css.String())
+}
+
+// highlightCode falls back to escaped, unhighlighted code if the language
+// cannot be tokenized.
+func highlightCode(code string, language string, style *chroma.Style, options ...html.Option) template.HTML {
+	lexer := lexers.Get(language)
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, code)
+	if err != nil {
+		return template.HTML("<pre>" + template.HTMLEscapeString(code) + "</pre>")
+	}
+	var out strings.Builder
+	if err := html.New(options...).Format(&out, style, iterator); err != nil {
+		return template.HTML("<pre>" + template.HTMLEscapeString(code) + "</pre>")
+	}
+	return template.HTML(out.String())
+}
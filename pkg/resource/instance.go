@@ -12,6 +12,31 @@ type Asset interface {
 	Render(context.Context, billy.Filesystem, billy.Filesystem) error
 }
 
+// Page is implemented by instances that control if they are rendered as a
+// page. Instances that do not implement it are rendered as a page whenever
+// they have an href.
+type Page interface {
+	IsPage() bool
+}
+
+// integrity is implemented by assets that can compute the subresource
+// integrity hash of their output.
+type integrity interface {
+	Integrity(billy.Filesystem) (string, error)
+}
+
+// outputHref is implemented by assets whose output is not written to their
+// href, e.g. content addressable bundles.
+type outputHref interface {
+	OutputHref() string
+}
+
+// dominantColor is implemented by image assets that compute the dominant
+// color of their output while rendering.
+type dominantColor interface {
+	PlaceholderColor() string
+}
+
 type Instance struct {
 	Self    interface{}
 	AsAsset Asset
@@ -19,7 +44,48 @@ type Instance struct {
 	Dest    billy.Filesystem
 }
 
+// DominantColor returns the dominant color computed while rendering an image
+// asset. It is empty for resources that do not compute one.
+func (i *Instance) DominantColor() string {
+	if target, ok := i.Self.(dominantColor); ok {
+		return target.PlaceholderColor()
+	}
+	return ""
+}
+
+// IsPage determines if the instance should be rendered as a page.
+func (i *Instance) IsPage() bool {
+	if page, ok := i.Self.(Page); ok {
+		return page.IsPage()
+	}
+	return true
+}
+
+// Integrity returns the subresource integrity hash of the rendered output of
+// an asset, for use in the integrity attribute of link and script elements.
+func (i *Instance) Integrity() (string, error) {
+	target, ok := i.Self.(integrity)
+	if !ok {
+		return "", fmt.Errorf("%T does not support integrity", i.Self)
+	}
+	return target.Integrity(i.Dest)
+}
+
+// OutputHref returns the path an asset wrote its output to, or an empty
+// string if the instance always writes to its href.
+func (i *Instance) OutputHref() string {
+	if target, ok := i.Self.(outputHref); ok {
+		return target.OutputHref()
+	}
+	return ""
+}
+
 func newInstance(factory *Factory, m *manifest.Manifest) (*Instance, error) {
+	factory.instancesMu.Lock()
+	defer factory.instancesMu.Unlock()
+	if instance, ok := factory.instances[m]; ok {
+		return instance, nil
+	}
 	handler, handlerErr := factory.Handler(m)
 	if handlerErr != nil {
 		return nil, handlerErr
@@ -29,10 +95,12 @@ func newInstance(factory *Factory, m *manifest.Manifest) (*Instance, error) {
 		return nil, fmt.Errorf("instantiating: %w", newErr)
 	}
 	asset, _ := instantiated.(Asset)
-	return &Instance{
+	instance := &Instance{
 		Self:    instantiated,
 		AsAsset: asset,
 		Source:  handler.source,
 		Dest:    handler.dest,
-	}, nil
+	}
+	factory.instances[m] = instance
+	return instance, nil
 }
@@ -3,6 +3,7 @@ package resource
 import (
 	"encoding/hex"
 	"fmt"
+	json "github.com/json-iterator/go"
 	"github.com/lestrrat-go/strftime"
 	hash "github.com/minio/sha256-simd"
 	"github.com/tkellen/aevitas/internal/selector"
@@ -10,15 +11,20 @@ import (
 	"html/template"
 	"path"
 	"reflect"
+	"strings"
 )
 
 // Resource represents a manifest with all related manifests associated in a
 // manner that allows rendering.
 type Resource struct {
 	*manifest.Manifest
-	scope      *manifest.Manifest
-	titles     []string
-	hrefRoot   string
+	scope    *manifest.Manifest
+	titles   []string
+	hrefRoot string
+	// host is that of domain, the nearest resource, starting with this one,
+	// that declares one.
+	host       string
+	domain     *Resource
 	href       string
 	template   *Template
 	children   []*Resource
@@ -27,9 +33,33 @@ type Resource struct {
 	instance   *Instance
 	cacheID    string
 	associated map[string]interface{}
+	maxDepth   int
+	// renderWith overrides the templates of the manifest when it was
+	// resolved as a child that specifies its own.
+	renderWith manifest.RenderWith
 }
 
+// ErrMaxDepthExceeded is returned when the children of a resource are nested
+// more deeply than allowed. This protects against cyclic child relationships.
+type ErrMaxDepthExceeded struct {
+	ID    string
+	Depth int
+}
+
+func (e *ErrMaxDepthExceeded) Error() string {
+	return fmt.Sprintf("%s: exceeded max depth of %d", e.ID, e.Depth)
+}
+
+// New creates a resource for the target and recursively collects all of its
+// children with no limit on how deeply they may be nested.
 func New(index *manifest.Index, target string, factory *Factory) (*Resource, error) {
+	return NewWithDepth(index, target, factory, 0)
+}
+
+// NewWithDepth does just what New does but fails with ErrMaxDepthExceeded
+// when children are nested more than maxDepth levels deep. A maxDepth of 0
+// means unlimited.
+func NewWithDepth(index *manifest.Index, target string, factory *Factory, maxDepth int) (*Resource, error) {
 	selector, selectorErr := selector.New(target)
 	if selectorErr != nil {
 		return nil, selectorErr
@@ -42,7 +72,8 @@ func New(index *manifest.Index, target string, factory *Factory) (*Resource, err
 		Manifest: nil,
 		index:    index,
 		factory:  factory,
-	}).new(root, nil, "", "")
+		maxDepth: maxDepth,
+	}).new(root, nil, "", "", nil, 0)
 }
 
 func (r *Resource) newStub(self *manifest.Manifest, scope *manifest.Manifest) (*Resource, error) {
@@ -56,10 +87,13 @@ func (r *Resource) newStub(self *manifest.Manifest, scope *manifest.Manifest) (*
 		children:   []*Resource{},
 		titles:     r.titles,
 		hrefRoot:   r.hrefRoot,
+		host:       r.host,
+		domain:     r.domain,
 		index:      r.index,
 		factory:    r.factory,
 		instance:   instance,
 		associated: map[string]interface{}{},
+		maxDepth:   r.maxDepth,
 	}, nil
 }
 
@@ -68,7 +102,12 @@ func (r *Resource) new(
 	scope *manifest.Manifest,
 	titlePrefix string,
 	hrefPrefix string,
+	renderWith manifest.RenderWith,
+	depth int,
 ) (*Resource, error) {
+	if r.maxDepth > 0 && depth > r.maxDepth {
+		return nil, &ErrMaxDepthExceeded{ID: self.Selector.ID(), Depth: r.maxDepth}
+	}
 	parent, err := r.newStub(self, scope)
 	if err != nil {
 		return nil, err
@@ -79,6 +118,11 @@ func (r *Resource) new(
 	if hrefPrefix != "" {
 		parent.hrefRoot = path.Join(r.hrefRoot, hrefPrefix)
 	}
+	if self.Meta.Host != "" {
+		parent.host = self.Meta.Host
+		parent.domain = parent
+	}
+	parent.renderWith = renderWith
 	// Instantiate a template to give this resource the ability to be rendered.
 	template, templateErr := NewTemplate(parent)
 	if templateErr != nil {
@@ -92,10 +136,18 @@ func (r *Resource) new(
 	// Recursively collect all children of this resource.
 	for _, item := range self.Meta.Children {
 		var childGroup []*Resource
-		resolvedChildren, relationErr := item.Relation.Resolve(r.index)
+		resolvedChildren, relationErr := item.Relation.ResolveFor(r.index, self)
 		if relationErr != nil {
 			return nil, relationErr
 		}
+		titlePrefix, titleErr := parent.Date(item.TitlePrefix)
+		if titleErr != nil {
+			return nil, titleErr
+		}
+		hrefPrefix, hrefErr := parent.Date(item.HrefPrefix)
+		if hrefErr != nil {
+			return nil, hrefErr
+		}
 		for _, match := range resolvedChildren {
 			var scope *manifest.Manifest
 			// If there is a prefix associated with this child, the parent is
@@ -103,7 +155,7 @@ func (r *Resource) new(
 			if item.HrefPrefix != "" {
 				scope = parent.Manifest
 			}
-			child, err := parent.new(match, scope, item.TitlePrefix, item.HrefPrefix)
+			child, err := parent.new(match, scope, titlePrefix, hrefPrefix, item.Templates, depth+1)
 			if err != nil {
 				return nil, err
 			}
@@ -122,6 +174,14 @@ func (r *Resource) new(
 	return parent, nil
 }
 
+// templates returns the selectors of the templates used to render the resource.
+func (r *Resource) templates() manifest.RenderWith {
+	if len(r.renderWith) > 0 {
+		return r.renderWith
+	}
+	return r.Meta.RenderWith
+}
+
 // ID returns a unique identifier for the resource that computes a hash of
 // every dependent resource used during rendering. This allows fine-grained
 // cache busting on repeated renders.
@@ -153,13 +213,97 @@ func (r *Resource) Scope() *manifest.Manifest { return r.scope }
 // topic, for example, the topic contributes `/topic/name/` as a prefix.
 func (r *Resource) Href() string { return path.Join(r.hrefRoot, r.Manifest.Href()) }
 
+// Host returns the host of the nearest resource, starting with this one, that
+// declares one.
+func (r *Resource) Host() string { return r.host }
+
+// Domain returns the nearest resource, starting with this one, that declares a
+// host.
+func (r *Resource) Domain() (*Resource, error) {
+	if r.domain == nil {
+		return nil, fmt.Errorf("%s: no parent declares a host", r.Manifest)
+	}
+	return r.domain, nil
+}
+
+// HrefAbsolute returns the href of the resource on the host of its domain.
+func (r *Resource) HrefAbsolute() (string, error) {
+	if r.host == "" {
+		return "", fmt.Errorf("%s: no parent declares a host", r.Manifest)
+	}
+	return r.hrefOnHost(), nil
+}
+
+// hrefOnHost joins the host of the resource with its href.
+func (r *Resource) hrefOnHost() string {
+	return strings.TrimSuffix(r.host, "/") + path.Join("/", r.Href())
+}
+
+// CanonicalURL returns the absolute URL search engines should consider the
+// primary location of this resource.
+func (r *Resource) CanonicalURL() string {
+	if r.Meta.Canonical != "" {
+		return r.Manifest.CanonicalURL(r.host)
+	}
+	return r.hrefOnHost()
+}
+
 // HrefCanonical returns an un-scoped reference to the underlying resource.
 func (r *Resource) HrefCanonical() string { return r.Manifest.Href() }
 
 // Spec gives templates access to fields on a resource that are custom to a
-// specific type.
+// specific type. Types without a structured spec expose it as a map.
 func (r *Resource) Spec() (interface{}, error) {
-	return reflect.ValueOf(r.instance.Self).Elem().FieldByName("Spec").Interface(), nil
+	spec := reflect.ValueOf(r.instance.Self).Elem().FieldByName("Spec").Interface()
+	if raw, ok := spec.(json.RawMessage); ok {
+		fields := map[string]interface{}{}
+		if len(raw) == 0 {
+			return fields, nil
+		}
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return nil, fmt.Errorf("%s: spec: %w", r.Manifest, err)
+		}
+		return fields, nil
+	}
+	return spec, nil
+}
+
+// ResourceOf pairs a resource with its spec decoded as T.
+type ResourceOf[T any] struct {
+	*Resource
+	Spec T
+}
+
+// NewResourceOf decodes the spec of a resource as T.
+func NewResourceOf[T any](r *Resource) (*ResourceOf[T], error) {
+	spec, err := SpecOf[T](r)
+	if err != nil {
+		return nil, err
+	}
+	return &ResourceOf[T]{Resource: r, Spec: spec}, nil
+}
+
+// SpecOf decodes the spec of a resource as T, avoiding the type assertions
+// needed on the result of Spec.
+func SpecOf[T any](r *Resource) (T, error) {
+	var spec T
+	if len(r.Manifest.Spec) == 0 {
+		return spec, nil
+	}
+	if err := json.Unmarshal(r.Manifest.Spec, &spec); err != nil {
+		return spec, fmt.Errorf("%s: spec: %w", r.Manifest, err)
+	}
+	return spec, nil
+}
+
+// MustSpecOf is SpecOf for callers certain the spec decodes as T. It panics
+// if it does not.
+func MustSpecOf[T any](r *Resource) T {
+	spec, err := SpecOf[T](r)
+	if err != nil {
+		panic(err)
+	}
+	return spec
 }
 
 // Prev returns the previous entry (by publish date, then by selector name) for
@@ -197,6 +341,24 @@ func (r *Resource) YearsPastAndFuture() ([]*Resource, error) {
 	return matches, nil
 }
 
+// SameYear returns all resources that were published in the same year as this
+// resource. This is limited to resources that match the kind/group/version/
+// namespace of the resource.
+func (r *Resource) SameYear() ([]*Resource, error) {
+	var matches []*Resource
+	for _, match := range r.index.SameYear(r.Manifest) {
+		resource, err := r.newStub(match, nil)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, resource)
+	}
+	return matches, nil
+}
+
+// Children returns the resources directly beneath this one.
+func (r *Resource) Children() []*Resource { return r.children }
+
 // Flatten generates a flat array of resources by recursively collecting all
 // children from this resource down.
 func (r *Resource) Flatten() []*Resource {
@@ -213,7 +375,11 @@ func (r *Resource) Render() (template.HTML, error) {
 	if err != nil {
 		return "", err
 	}
-	return result, nil
+	processed, processErr := r.Manifest.PostProcess(string(result))
+	if processErr != nil {
+		return "", processErr
+	}
+	return template.HTML(processed), nil
 }
 
 // Date is a shitty abstraction that allows the publish date to be formatted for
@@ -1,5 +1,578 @@
 package resource_test
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	json "github.com/json-iterator/go"
+	"github.com/tkellen/aevitas/internal/testhelper"
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"github.com/tkellen/aevitas/pkg/resource"
+	assetv1 "github.com/tkellen/aevitas/pkg/resource/v1/asset"
+	"html/template"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newIndex(t *testing.T, docs ...string) *manifest.Index {
+	t.Helper()
+	var all []*manifest.Manifest
+	for _, doc := range docs {
+		manifests, err := manifest.New([]byte(doc), "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, manifests...)
+	}
+	return testhelper.MakeIndex(t, all...)
+}
+
+func newResource(t *testing.T, index *manifest.Index, target string) *resource.Resource {
+	t.Helper()
+	r, err := resource.New(index, target, testhelper.MakeFactory(memfs.New(), memfs.New()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestResource_SpecComputed(t *testing.T) {
+	index := newIndex(t, `{
+		"kind": "website", "group": "content", "version": "v1", "namespace": "author", "name": "tyler",
+		"meta": {"live": true, "href": "tyler.html"},
+		"body": "<p>{{ .Spec.fullName }}</p>",
+		"spec": {
+			"firstName": "Tyler",
+			"lastName": "Kellen",
+			"computed": [{"name": "fullName", "template": "{{ .firstName }} {{ .lastName }}"}]
+		}
+	}`)
+	output, err := newResource(t, index, "website/content/v1/author/tyler").Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(output), "<p>Tyler Kellen</p>") {
+		t.Fatalf("expected computed field in output, got %s", output)
+	}
+}
+
+func TestSpecOf(t *testing.T) {
+	index := newIndex(t, `{
+		"kind": "text", "group": "css", "version": "v1", "namespace": "style", "name": "site",
+		"meta": {"live": true, "href": "/site.css"},
+		"spec": {"files": ["base.css", "theme.css"], "minify": true}
+	}`, `{
+		"kind": "image", "group": "avif", "version": "v1", "namespace": "image", "name": "red",
+		"meta": {"live": true, "file": "red.jpg"},
+		"spec": {"widths": [50], "quality": 40, "speed": 8}
+	}`)
+	css := newResource(t, index, "text/css/v1/style/site")
+	var expectedCss assetv1.CssSpec
+	if err := json.Unmarshal(css.Manifest.Spec, &expectedCss); err != nil {
+		t.Fatal(err)
+	}
+	actualCss, err := resource.SpecOf[assetv1.CssSpec](css)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expectedCss, actualCss) {
+		t.Fatalf("expected %v, got %v", expectedCss, actualCss)
+	}
+	image := newResource(t, index, "image/avif/v1/image/red")
+	var expectedImage assetv1.AvifSpec
+	if err := json.Unmarshal(image.Manifest.Spec, &expectedImage); err != nil {
+		t.Fatal(err)
+	}
+	if actual := resource.MustSpecOf[assetv1.AvifSpec](image); !reflect.DeepEqual(expectedImage, actual) {
+		t.Fatalf("expected %v, got %v", expectedImage, actual)
+	}
+	typed, typedErr := resource.NewResourceOf[assetv1.CssSpec](css)
+	if typedErr != nil {
+		t.Fatal(typedErr)
+	}
+	if !typed.Spec.Minify || typed.Href() != "/site.css" {
+		t.Fatalf("expected typed resource, got %v", typed.Spec)
+	}
+	if _, err := resource.SpecOf[[]string](css); err == nil {
+		t.Fatal("expected decoding spec as the wrong type to fail")
+	}
+}
+
+func TestNewWithDepth(t *testing.T) {
+	var docs []string
+	for level := 0; level < 10; level++ {
+		docs = append(docs, fmt.Sprintf(`{
+			"kind": "website", "group": "content", "version": "v1", "namespace": "level", "name": "%d",
+			"meta": {"live": true, "children": [{"selector": "website/content/v1/level/%d"}]}
+		}`, level, level+1))
+	}
+	docs = append(docs, `{
+		"kind": "website", "group": "content", "version": "v1", "namespace": "level", "name": "10",
+		"meta": {"live": true}
+	}`)
+	index := newIndex(t, docs...)
+	factory := testhelper.MakeFactory(memfs.New(), memfs.New())
+	if _, err := resource.New(index, "website/content/v1/level/0", factory); err != nil {
+		t.Fatalf("expected unlimited depth to succeed, got %s", err)
+	}
+	_, err := resource.NewWithDepth(index, "website/content/v1/level/0", factory, 5)
+	var depthErr *resource.ErrMaxDepthExceeded
+	if !errors.As(err, &depthErr) {
+		t.Fatalf("expected max depth error, got %v", err)
+	}
+	if depthErr.ID != "website/content/v1/level/6" {
+		t.Fatalf("expected %s to exceed max depth, got %s", "website/content/v1/level/6", depthErr.ID)
+	}
+}
+
+func TestCanonicalTag(t *testing.T) {
+	index := newIndex(t, `{
+		"kind": "website", "group": "content", "version": "v1", "namespace": "domain", "name": "blog",
+		"meta": {
+			"live": true, "href": "/index.html", "host": "https://example.com",
+			"children": [{"selector": "website/content/v1/post/*", "hrefPrefix": "/blog"}]
+		}
+	}`, `{
+		"kind": "website", "group": "content", "version": "v1", "namespace": "post", "name": "computed",
+		"meta": {"live": true, "href": "computed.html"},
+		"body": "{{ canonicalTag .Resource }}"
+	}`, `{
+		"kind": "website", "group": "content", "version": "v1", "namespace": "post", "name": "path",
+		"meta": {"live": true, "href": "path.html", "canonical": "/elsewhere.html"},
+		"body": "{{ canonicalTag .Resource }}"
+	}`, `{
+		"kind": "website", "group": "content", "version": "v1", "namespace": "post", "name": "absolute",
+		"meta": {"live": true, "href": "absolute.html", "canonical": "https://other.com/absolute.html"},
+		"body": "{{ canonicalTag .Resource }}"
+	}`)
+	expected := map[string]string{
+		"website/content/v1/post/computed": `<link rel="canonical" href="https://example.com/blog/computed.html">`,
+		"website/content/v1/post/path":     `<link rel="canonical" href="https://example.com/elsewhere.html">`,
+		"website/content/v1/post/absolute": `<link rel="canonical" href="https://other.com/absolute.html">`,
+	}
+	children := newResource(t, index, "website/content/v1/domain/blog").Children()
+	if len(children) != len(expected) {
+		t.Fatalf("expected %d children, got %d", len(expected), len(children))
+	}
+	for _, child := range children {
+		output, err := child.Render()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(output) != expected[child.Selector.ID()] {
+			t.Fatalf("expected %s, got %s", expected[child.Selector.ID()], output)
+		}
+	}
+}
+
+func TestResource_ChildPrefixDate(t *testing.T) {
+	index := newIndex(t, `{
+		"kind": "website", "group": "content", "version": "v1", "namespace": "month", "name": "2024-03",
+		"meta": {
+			"live": true, "href": "/index.html", "title": "March", "publishAt": {"year": 2024, "month": 3, "day": 1},
+			"children": [{"selector": "website/content/v1/post/*", "hrefPrefix": "/%Y/%m", "titlePrefix": "%B %Y"}]
+		}
+	}`, `{
+		"kind": "website", "group": "content", "version": "v1", "namespace": "post", "name": "one",
+		"meta": {"live": true, "href": "one.html", "title": "One"}
+	}`)
+	children := newResource(t, index, "website/content/v1/month/2024-03").Children()
+	if len(children) != 1 {
+		t.Fatalf("expected 1 child, got %d", len(children))
+	}
+	testhelper.AssertHref(t, children[0], "/2024/03/one.html")
+	if expected := []string{"One", "March 2024"}; !reflect.DeepEqual(children[0].Titles(), expected) {
+		t.Fatalf("expected %v, got %v", expected, children[0].Titles())
+	}
+}
+
+func TestResource_IDWithDefaults(t *testing.T) {
+	index := newIndex(t, `{
+		"kind": "website", "group": "content", "version": "v1", "namespace": "post", "name": "one",
+		"meta": {"live": true, "href": "one.html", "title": "One"}
+	}`)
+	derived, err := index.WithDefaults("website/content/v1/post", &manifest.Meta{Author: "default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := newResource(t, index, "website/content/v1/post/one")
+	withDefaults := newResource(t, derived, "website/content/v1/post/one")
+	if original.ID() == withDefaults.ID() {
+		t.Fatalf("expected defaults to change the cache id %s", original.ID())
+	}
+}
+
+func TestResource_ChildTemplates(t *testing.T) {
+	index := newIndex(t, `{
+		"kind": "website", "group": "content", "version": "v1", "namespace": "domain", "name": "site",
+		"meta": {
+			"live": true, "href": "/index.html",
+			"children": [
+				{"selector": "website/content/v1/post/*", "templates": ["html/template/v1/layout/post"]},
+				{"selector": "website/content/v1/work/*", "templates": ["html/template/v1/layout/work"]}
+			]
+		}
+	}`, `{
+		"kind": "html", "group": "template", "version": "v1", "namespace": "layout", "name": "post",
+		"meta": {"live": true},
+		"body": "<article>{{ yield }}</article>"
+	}`, `{
+		"kind": "html", "group": "template", "version": "v1", "namespace": "layout", "name": "work",
+		"meta": {"live": true},
+		"body": "<figure>{{ yield }}</figure>"
+	}`, `{
+		"kind": "html", "group": "template", "version": "v1", "namespace": "layout", "name": "default",
+		"meta": {"live": true},
+		"body": "<div>{{ yield }}</div>"
+	}`, `{
+		"kind": "website", "group": "content", "version": "v1", "namespace": "post", "name": "one",
+		"meta": {"live": true, "href": "one.html", "renderWith": ["html/template/v1/layout/default"]},
+		"body": "post"
+	}`, `{
+		"kind": "website", "group": "content", "version": "v1", "namespace": "work", "name": "one",
+		"meta": {"live": true, "href": "work.html", "renderWith": ["html/template/v1/layout/default"]},
+		"body": "work"
+	}`)
+	expected := map[string]string{
+		"website/content/v1/post/one": "<article>post</article>",
+		"website/content/v1/work/one": "<figure>work</figure>",
+	}
+	children := newResource(t, index, "website/content/v1/domain/site").Children()
+	if len(children) != len(expected) {
+		t.Fatalf("expected %d children, got %d", len(expected), len(children))
+	}
+	for _, child := range children {
+		output, err := child.Render()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(output) != expected[child.Selector.ID()] {
+			t.Fatalf("expected %s, got %s", expected[child.Selector.ID()], output)
+		}
+	}
+	// Outside of the child relationship the manifest keeps its own templates.
+	output, err := newResource(t, index, "website/content/v1/post/one").Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != "<div>post</div>" {
+		t.Fatalf("expected <div>post</div>, got %s", output)
+	}
+}
+
+func TestResource_HrefAbsolute(t *testing.T) {
+	index := newIndex(t, `{
+		"kind": "website", "group": "content", "version": "v1", "namespace": "domain", "name": "blog",
+		"meta": {
+			"live": true, "href": "/index.html", "host": "https://example.com",
+			"children": [{"selector": "website/content/v1/post/*"}]
+		}
+	}`, `{
+		"kind": "website", "group": "content", "version": "v1", "namespace": "post", "name": "post",
+		"meta": {"live": true, "href": "post.html"},
+		"body": "{{ absoluteURL .Resource }}"
+	}`)
+	domain := newResource(t, index, "website/content/v1/domain/blog")
+	post := domain.Children()[0]
+	if actual, err := post.Domain(); err != nil || actual != domain {
+		t.Fatalf("expected %s, got %v (%v)", domain.Manifest, actual, err)
+	}
+	expected := "https://example.com/post.html"
+	if actual, err := post.HrefAbsolute(); err != nil || actual != expected {
+		t.Fatalf("expected %s, got %s (%v)", expected, actual, err)
+	}
+	if output, err := post.Render(); err != nil || string(output) != expected {
+		t.Fatalf("expected %s, got %s (%v)", expected, output, err)
+	}
+	orphan := newResource(t, index, "website/content/v1/post/post")
+	if _, err := orphan.HrefAbsolute(); err == nil {
+		t.Fatal("expected error for resource without a domain")
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	type testCase struct {
+		body     string
+		theme    string
+		contains []string
+		excludes []string
+	}
+	table := map[string]testCase{
+		"annotates code with classes": {
+			body:     `{{ highlight \"package main\\nfunc less(a, b int) bool { return a < b }\" \"go\" }}`,
+			contains: []string{`<span class="kn">package</span>`, `&lt;`},
+			excludes: []string{"a < b"},
+		},
+		"inlines styles of a theme": {
+			body:     `{{ highlightWithTheme \"package main\" \"go\" \"github\" }}`,
+			contains: []string{`style="`, "package"},
+			excludes: []string{`class="kn"`},
+		},
+		"defaults css to the factory theme": {
+			body:     `<style>{{ highlightCSS \"\" }}</style>`,
+			theme:    "github",
+			contains: []string{".kn {", "#ffffff"},
+		},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			index := newIndex(t, `{
+				"kind": "website", "group": "content", "version": "v1", "namespace": "page", "name": "code",
+				"meta": {"live": true, "href": "/code.html"},
+				"body": "`+test.body+`"
+			}`)
+			factory := testhelper.MakeFactory(memfs.New(), memfs.New())
+			if test.theme != "" {
+				factory.SetHighlightTheme(test.theme)
+			}
+			root, err := resource.New(index, "website/content/v1/page/code", factory)
+			if err != nil {
+				t.Fatal(err)
+			}
+			output, renderErr := root.Render()
+			if renderErr != nil {
+				t.Fatal(renderErr)
+			}
+			for _, expected := range test.contains {
+				if !strings.Contains(string(output), expected) {
+					t.Fatalf("expected %s in %s", expected, output)
+				}
+			}
+			for _, unexpected := range test.excludes {
+				if strings.Contains(string(output), unexpected) {
+					t.Fatalf("expected no %s in %s", unexpected, output)
+				}
+			}
+		})
+	}
+}
+
+func TestEmbed(t *testing.T) {
+	svg := `<svg xmlns="http://www.w3.org/2000/svg"><circle r="4"/></svg>`
+	source := memfs.New()
+	for name, content := range map[string]string{
+		"icons/dot.svg": svg,
+		"style.css":     "a{color:red}",
+		"script.js":     "var a = 1 < 2;",
+	} {
+		if err := util.WriteFile(source, name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	type testCase struct {
+		body     string
+		expected string
+		err      bool
+	}
+	table := map[string]testCase{
+		"embeds html verbatim": {
+			body:     `<p>{{ embed \"icons/dot.svg\" }}</p>`,
+			expected: "<p>" + svg + "</p>",
+		},
+		"escapes text": {
+			body:     `{{ embedText \"/icons/dot.svg\" }}`,
+			expected: template.HTMLEscapeString(svg),
+		},
+		"embeds css": {
+			body:     `<style>{{ embedCSS \"style.css\" }}</style>`,
+			expected: "<style>a{color:red}</style>",
+		},
+		"embeds javascript": {
+			body:     `<script>{{ embedJS \"script.js\" }}</script>`,
+			expected: "<script>var a = 1 < 2;</script>",
+		},
+		"rejects path traversal": {
+			body: `{{ embed \"../secret\" }}`,
+			err:  true,
+		},
+		"fails on missing files": {
+			body: `{{ embed \"missing.svg\" }}`,
+			err:  true,
+		},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			index := newIndex(t, `{
+				"kind": "website", "group": "content", "version": "v1", "namespace": "page", "name": "icon",
+				"meta": {"live": true, "href": "/icon.html"},
+				"body": "`+test.body+`"
+			}`)
+			root, err := resource.New(index, "website/content/v1/page/icon", testhelper.MakeFactory(source, memfs.New()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			output, renderErr := root.Render()
+			if test.err {
+				if renderErr == nil {
+					t.Fatalf("expected error, got %s", output)
+				}
+				return
+			}
+			if renderErr != nil {
+				t.Fatal(renderErr)
+			}
+			if string(output) != test.expected {
+				t.Fatalf("expected %s, got %s", test.expected, output)
+			}
+		})
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	docs := []string{`{
+		"kind": "website", "group": "content", "version": "v1", "namespace": "page", "name": "index",
+		"meta": {
+			"live": true, "href": "/index.html",
+			"imports": [{"name": "posts", "selector": "website/content/v1/post/*"}]
+		},
+		"body": "{{ $page := paginate posts 2 2 }}{{ len $page.PageItems }} of {{ $page.Pages }}{{ range $page.URLS \"/%d.html\" }} {{ . }}{{ end }}"
+	}`}
+	for idx := 0; idx < 5; idx++ {
+		docs = append(docs, fmt.Sprintf(`{
+			"kind": "website", "group": "content", "version": "v1", "namespace": "post", "name": "%d",
+			"meta": {"live": true}
+		}`, idx))
+	}
+	output, err := newResource(t, newIndex(t, docs...), "website/content/v1/page/index").Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "2 of 3 /1.html /2.html /3.html"
+	if string(output) != expected {
+		t.Fatalf("expected %s, got %s", expected, output)
+	}
+}
+
+func TestResource_Sitemap(t *testing.T) {
+	index := newIndex(t, `{
+		"kind": "website", "group": "content", "version": "v1", "namespace": "page", "name": "home",
+		"meta": {"live": true, "href": "/index.html", "priority": 1.0, "changeFreq": "weekly"},
+		"body": "{{ .SitemapPriority }} {{ .SitemapChangeFreq }}"
+	}`)
+	output, err := newResource(t, index, "website/content/v1/page/home").Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "1 weekly"; string(output) != expected {
+		t.Fatalf("expected %s, got %s", expected, output)
+	}
+}
+
+func TestResource_RenderPostProcess(t *testing.T) {
+	body := `<div>\n    <p>\n        {{ .Title }}\n    </p>\n</div>\n`
+	index := newIndex(t, `{
+		"kind": "website", "group": "content", "version": "v1", "namespace": "page", "name": "raw",
+		"meta": {"live": true, "href": "/raw.html", "title": "Hello"},
+		"body": "`+body+`"
+	}`, `{
+		"kind": "website", "group": "content", "version": "v1", "namespace": "page", "name": "minified",
+		"meta": {"live": true, "href": "/minified.html", "title": "Hello", "postProcess": ["html-minify"]},
+		"body": "`+body+`"
+	}`)
+	raw, err := newResource(t, index, "website/content/v1/page/raw").Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	minified, err := newResource(t, index, "website/content/v1/page/minified").Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(minified) >= len(raw) {
+		t.Fatalf("expected %q to be smaller than %q", minified, raw)
+	}
+	if expected := "<div><p>Hello</p></div>"; string(minified) != expected {
+		t.Fatalf("expected %s, got %s", expected, minified)
+	}
+}
+
+func TestCssTag(t *testing.T) {
+	index := newIndex(t, `{
+		"kind": "website", "group": "content", "version": "v1", "namespace": "page", "name": "home",
+		"meta": {
+			"live": true, "href": "/index.html",
+			"imports": [{"name": "stylesheet", "selector": "text/css/v1/style/site"}]
+		},
+		"body": "{{ cssTag stylesheet }}"
+	}`, `{
+		"kind": "text", "group": "css", "version": "v1", "namespace": "style", "name": "site",
+		"meta": {"live": true, "href": "/site.css"},
+		"spec": {"files": ["site.css"]}
+	}`)
+	source := memfs.New()
+	if err := util.WriteFile(source, "site.css", []byte("a { color: red; }"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	factory := testhelper.MakeFactory(source, memfs.New())
+	root, err := resource.New(index, "website/content/v1/page/home", factory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stylesheet, findErr := resource.New(index, "text/css/v1/style/site", factory)
+	if findErr != nil {
+		t.Fatal(findErr)
+	}
+	instance := stylesheet.Instance()
+	if instance.IsPage() {
+		t.Fatal("expected stylesheet to not be rendered as a page")
+	}
+	if err := instance.AsAsset.Render(context.Background(), instance.Source, instance.Dest); err != nil {
+		t.Fatal(err)
+	}
+	output, renderErr := root.Render()
+	if renderErr != nil {
+		t.Fatal(renderErr)
+	}
+	expected := `<link rel="stylesheet" href="/site.css" integrity="sha384-`
+	if !strings.HasPrefix(string(output), expected) {
+		t.Fatalf("expected output to start with %s, got %s", expected, output)
+	}
+}
+
+func TestScriptTag(t *testing.T) {
+	index := newIndex(t, `{
+		"kind": "website", "group": "content", "version": "v1", "namespace": "page", "name": "home",
+		"meta": {
+			"live": true, "href": "/index.html",
+			"imports": [{"name": "script", "selector": "text/javascript/v1/script/site"}]
+		},
+		"body": "{{ scriptTag script }}"
+	}`, `{
+		"kind": "text", "group": "javascript", "version": "v1", "namespace": "script", "name": "site",
+		"meta": {"live": true, "href": "/js/site.js"},
+		"spec": {"files": ["site.js"], "contentAddressable": true}
+	}`)
+	source := memfs.New()
+	if err := util.WriteFile(source, "site.js", []byte("console.log(1);"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	factory := testhelper.MakeFactory(source, memfs.New())
+	root, err := resource.New(index, "website/content/v1/page/home", factory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, findErr := resource.New(index, "text/javascript/v1/script/site", factory)
+	if findErr != nil {
+		t.Fatal(findErr)
+	}
+	instance := script.Instance()
+	if err := instance.AsAsset.Render(context.Background(), instance.Source, instance.Dest); err != nil {
+		t.Fatal(err)
+	}
+	output, renderErr := root.Render()
+	if renderErr != nil {
+		t.Fatal(renderErr)
+	}
+	expected := fmt.Sprintf(`<script src="%s" integrity="sha384-`, instance.OutputHref())
+	if !strings.HasPrefix(string(output), expected) || !strings.HasSuffix(instance.OutputHref(), "-bundle.js") {
+		t.Fatalf("expected output to start with %s, got %s", expected, output)
+	}
+}
+
 /*
 func testIndex(t *testing.T) *resource.RenderTree {
 	list, err := manifest.NewFromDirs([]string{"../../example/website","../../example/layouts"}, nil)
@@ -186,3 +759,108 @@ func TestResource_Href(t *testing.T) {
 	}
 }
 */
+
+func TestTemplate_ChainError(t *testing.T) {
+	layout := func(name string, body string, renderWith string) string {
+		return `{
+			"kind": "html", "group": "template", "version": "v1", "namespace": "layout", "name": "` + name + `",
+			"meta": {"live": true, "renderWith": [` + renderWith + `]},
+			"body": "` + body + `"
+		}`
+	}
+	table := map[string]struct {
+		middle   string
+		outer    string
+		expected []string
+	}{
+		"middle fails": {middle: "{{ missing }}", outer: "{{ yield }}", expected: []string{"html/template/v1/layout/middle"}},
+		"outer fails":  {middle: "{{ yield }}", outer: "{{ missing }}", expected: []string{"html/template/v1/layout/middle", "html/template/v1/layout/outer"}},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			index := newIndex(t, `{
+				"kind": "website", "group": "content", "version": "v1", "namespace": "page", "name": "index",
+				"meta": {"live": true, "renderWith": ["html/template/v1/layout/middle"]},
+				"body": "page"
+			}`,
+				layout("middle", test.middle, `"html/template/v1/layout/outer"`),
+				layout("outer", test.outer, ""),
+			)
+			_, err := newResource(t, index, "website/content/v1/page/index").Render()
+			var chainErr *resource.TemplateChainError
+			if !errors.As(err, &chainErr) {
+				t.Fatalf("expected a chain error, got %v", err)
+			}
+			if !reflect.DeepEqual(chainErr.Chain(), test.expected) {
+				t.Fatalf("expected chain %v, got %v", test.expected, chainErr.Chain())
+			}
+			if !strings.Contains(err.Error(), "(via renderWith[0] html/template/v1/layout/middle)") {
+				t.Fatalf("expected error to identify the middle template, got %s", err)
+			}
+		})
+	}
+}
+
+func TestTemplate_LazyImports(t *testing.T) {
+	index := newIndex(t, `{
+		"kind": "website", "group": "content", "version": "v1", "namespace": "page", "name": "index",
+		"meta": {"live": true, "imports": [{
+			"name": "posts",
+			"selector": "website/content/v1/post/*",
+			"matchExpression": [{"key": "publishAt", "operator": "Unsupported", "values": [2020]}]
+		}]},
+		"body": "{{ range posts }}{{ .Meta.Title }}{{ end }}"
+	}`, `{"kind": "website", "group": "content", "version": "v1", "namespace": "post", "name": "one", "meta": {"live": true}}`)
+	// Imports are not resolved until the resource is rendered.
+	r := newResource(t, index, "website/content/v1/page/index")
+	if _, err := r.Render(); err == nil || !strings.Contains(err.Error(), "Unsupported is not (yet) a supported operator") {
+		t.Fatalf("expected import resolution to fail when rendering, got %v", err)
+	}
+}
+
+func TestTemplate_Relations(t *testing.T) {
+	index := newIndex(t, `{
+		"kind": "website", "group": "content", "version": "v1", "namespace": "page", "name": "index",
+		"meta": {"live": true, "imports": [
+			{"name": "posts", "selector": "website/content/v1/post/*"},
+			{"name": "about", "selector": "website/content/v1/page/about"}
+		]},
+		"body": "{{ range relations }}{{ .Name }}:{{ len .Manifests }} {{ end }}"
+	}`,
+		`{"kind": "website", "group": "content", "version": "v1", "namespace": "post", "name": "one", "meta": {"live": true}}`,
+		`{"kind": "website", "group": "content", "version": "v1", "namespace": "post", "name": "two", "meta": {"live": true}}`,
+		`{"kind": "website", "group": "content", "version": "v1", "namespace": "page", "name": "about", "meta": {"live": true}}`,
+	)
+	r := newResource(t, index, "website/content/v1/page/index")
+	actual, err := r.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "posts:2 about:1 "; !strings.Contains(string(actual), expected) {
+		t.Fatalf("expected %s, got %s", expected, actual)
+	}
+}
+
+func TestInstance_DominantColor(t *testing.T) {
+	jpeg := &assetv1.Jpeg{}
+	jpeg.DominantColor = "#ff0000"
+	table := map[string]struct {
+		self     interface{}
+		expected string
+	}{
+		"nil":          {self: nil, expected: ""},
+		"not pointer":  {self: struct{ DominantColor string }{"#00ff00"}, expected: ""},
+		"not an image": {self: &assetv1.Css{}, expected: ""},
+		"image":        {self: jpeg, expected: "#ff0000"},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			instance := &resource.Instance{Self: test.self}
+			if actual := instance.DominantColor(); actual != test.expected {
+				t.Fatalf("expected %q, got %q", test.expected, actual)
+			}
+		})
+	}
+}
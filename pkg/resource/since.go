@@ -0,0 +1,75 @@
+package resource
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	day   = 24 * time.Hour
+	week  = 7 * day
+	month = 30 * day
+	year  = 365 * day
+)
+
+// since describes how long ago a time was in human-friendly terms.
+func since(t time.Time) string { return sinceFrom(t, time.Now()) }
+
+// until describes how far in the future a time is in human-friendly terms.
+func until(t time.Time) string { return untilFrom(t, time.Now()) }
+
+// sinceFrom does just what since does, relative to the supplied time.
+func sinceFrom(t time.Time, now time.Time) string {
+	d := now.Sub(t)
+	if d < 0 {
+		return untilFrom(t, now)
+	}
+	if d >= day && d < 2*day {
+		return "yesterday"
+	}
+	if amount := relative(d); amount != "" {
+		return amount + " ago"
+	}
+	return "just now"
+}
+
+// untilFrom does just what until does, relative to the supplied time.
+func untilFrom(t time.Time, now time.Time) string {
+	d := t.Sub(now)
+	if d < 0 {
+		return sinceFrom(t, now)
+	}
+	if d >= day && d < 2*day {
+		return "tomorrow"
+	}
+	if amount := relative(d); amount != "" {
+		return "in " + amount
+	}
+	return "just now"
+}
+
+// relative expresses a duration in the largest whole unit that fits it. It
+// returns an empty string for durations less than a minute.
+func relative(d time.Duration) string {
+	tiers := []struct {
+		unit time.Duration
+		name string
+	}{
+		{year, "year"},
+		{month, "month"},
+		{week, "week"},
+		{day, "day"},
+		{time.Hour, "hour"},
+		{time.Minute, "minute"},
+	}
+	for _, tier := range tiers {
+		if d >= tier.unit {
+			count := int(d / tier.unit)
+			if count == 1 {
+				return fmt.Sprintf("1 %s", tier.name)
+			}
+			return fmt.Sprintf("%d %ss", count, tier.name)
+		}
+	}
+	return ""
+}
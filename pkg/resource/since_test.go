@@ -0,0 +1,62 @@
+package resource
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSinceFrom(t *testing.T) {
+	now := time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)
+	table := map[string]struct {
+		ago      time.Duration
+		expected string
+	}{
+		"under a minute":     {59 * time.Second, "just now"},
+		"one minute":         {time.Minute, "1 minute ago"},
+		"minutes":            {2 * time.Minute, "2 minutes ago"},
+		"under an hour":      {59 * time.Minute, "59 minutes ago"},
+		"hours":              {3 * time.Hour, "3 hours ago"},
+		"under a day":        {23 * time.Hour, "23 hours ago"},
+		"one day":            {day, "yesterday"},
+		"under two days":     {47 * time.Hour, "yesterday"},
+		"days":               {2 * day, "2 days ago"},
+		"weeks":              {2 * week, "2 weeks ago"},
+		"under a month":      {29 * day, "4 weeks ago"},
+		"months":             {3 * month, "3 months ago"},
+		"under a year":       {364 * day, "12 months ago"},
+		"years":              {2 * year, "2 years ago"},
+		"future uses until":  {-3 * time.Hour, "in 3 hours"},
+		"future under a min": {-time.Second, "just now"},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			if actual := sinceFrom(now.Add(-test.ago), now); actual != test.expected {
+				t.Fatalf("expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestUntilFrom(t *testing.T) {
+	now := time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)
+	table := map[string]struct {
+		ahead    time.Duration
+		expected string
+	}{
+		"under a minute":  {30 * time.Second, "just now"},
+		"minutes":         {2 * time.Minute, "in 2 minutes"},
+		"one day":         {day, "tomorrow"},
+		"weeks":           {2 * week, "in 2 weeks"},
+		"years":           {3 * year, "in 3 years"},
+		"past uses since": {-2 * year, "2 years ago"},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			if actual := untilFrom(now.Add(test.ahead), now); actual != test.expected {
+				t.Fatalf("expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
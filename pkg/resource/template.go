@@ -7,6 +7,7 @@ import (
 	"github.com/tkellen/aevitas/pkg/manifest"
 	"html/template"
 	"strconv"
+	"sync"
 )
 
 // Template extends a Resource with additional context needed to fully body it
@@ -15,26 +16,23 @@ type Template struct {
 	*Resource
 	renderWith []*Template
 	id         string
+	// importFuncs are the template functions for the static imports of the
+	// manifest and importRelations what they resolved to, both built the
+	// first time the template is rendered.
+	importFuncs     map[string]interface{}
+	importRelations []*manifest.ResolvedRelation
+	importsErr      error
+	importsOnce     sync.Once
 }
 
 func NewTemplate(self *Resource) (*Template, error) {
 	var id bytes.Buffer
 	id.WriteString(self.Hash)
+	// Imports are among the relations of the manifest so changes to them are
+	// reflected here without resolving them.
 	id.WriteString(self.index.RelationsHash(self.Manifest))
 	template := &Template{Resource: self}
-	imports, importsErr := self.ResolveStaticImports(self.index)
-	if importsErr != nil {
-		return nil, importsErr
-	}
-	for _, imported := range imports {
-		for _, manifest := range imported.Manifests {
-			id.WriteString(manifest.Hash)
-		}
-	}
-	if err := template.mergeImports(template.associated, imports); err != nil {
-		return nil, err
-	}
-	renderWith, resolveErr := self.Meta.RenderWith.Resolve(self.index)
+	renderWith, resolveErr := self.templates().Resolve(self.index)
 	if resolveErr != nil {
 		return nil, resolveErr
 	}
@@ -62,19 +60,49 @@ func (t *Template) render(context *Template, yield template.HTML) (template.HTML
 	if yield, err = t.body(context, yield); err != nil {
 		return "", fmt.Errorf("%s: %w", context, err)
 	}
-	for _, tmpl := range t.renderWith {
+	for idx, tmpl := range t.renderWith {
+		chainErr := func(err error) error {
+			return &TemplateChainError{Context: context.String(), Index: idx, Template: tmpl.Selector.ID(), Err: err}
+		}
 		if yield, err = tmpl.body(context, yield); err != nil {
-			return "", fmt.Errorf("%s: %w", tmpl, err)
+			return "", chainErr(err)
 		}
-		for _, innerTmpl := range tmpl.renderWith {
+		for innerIdx, innerTmpl := range tmpl.renderWith {
 			if yield, err = innerTmpl.render(t, yield); err != nil {
-				return "", fmt.Errorf("%s: %w", innerTmpl, err)
+				return "", chainErr(&TemplateChainError{Context: tmpl.Selector.ID(), Index: innerIdx, Template: innerTmpl.Selector.ID(), Err: err})
 			}
 		}
 	}
 	return yield, nil
 }
 
+// TemplateChainError identifies the template of a renderWith chain that failed
+// to render.
+type TemplateChainError struct {
+	// Context is what was being rendered with the template.
+	Context string
+	// Index is the position of the template in the renderWith of Context.
+	Index    int
+	Template string
+	Err      error
+}
+
+func (e *TemplateChainError) Error() string {
+	return fmt.Sprintf("%s (via renderWith[%d] %s): %s", e.Context, e.Index, e.Template, e.Err)
+}
+
+func (e *TemplateChainError) Unwrap() error { return e.Err }
+
+// Chain lists the templates from the outermost to the one that failed.
+func (e *TemplateChainError) Chain() []string {
+	chain := []string{e.Template}
+	var inner *TemplateChainError
+	if errors.As(e.Err, &inner) {
+		chain = append(chain, inner.Chain()...)
+	}
+	return chain
+}
+
 func (t *Template) body(context interface{}, yield template.HTML) (template.HTML, error) {
 	var buf bytes.Buffer
 	if context == nil {
@@ -83,15 +111,33 @@ func (t *Template) body(context interface{}, yield template.HTML) (template.HTML
 	funcMap := map[string]interface{}{}
 	funcMap["yield"] = func() template.HTML { return yield }
 	funcMap["ordinal"] = ordinal
+	funcMap["since"] = since
+	funcMap["until"] = until
+	funcMap["canonicalTag"] = canonicalTag
+	funcMap["absoluteURL"] = absoluteURL
+	funcMap["cssTag"] = cssTag
+	funcMap["scriptTag"] = scriptTag
+	merge(funcMap, t.factory.funcMap())
+	// relations lists every named import so templates can tell which one
+	// produced a manifest. An import named relations takes precedence.
+	var relations []*manifest.ResolvedRelation
+	funcMap["relations"] = func() []*manifest.ResolvedRelation { return relations }
+	if err := t.resolveImports(); err != nil {
+		return "", err
+	}
+	merge(funcMap, t.importFuncs)
+	relations = append(relations, t.importRelations...)
 	merge(funcMap, t.associated)
 	if tmpl, ok := context.(*Template); ok {
 		imports, err := t.ResolveDynamicImports(t.index, tmpl.Manifest)
 		if err != nil {
 			return "", err
 		}
-		if err = tmpl.mergeImports(funcMap, imports); err != nil {
+		dynamic, err := tmpl.mergeImports(funcMap, imports)
+		if err != nil {
 			return "", err
 		}
+		relations = append(relations, dynamic...)
 	}
 	// This crazy hack makes template error messages a lot more readable.
 	tmpl, tmplErr := template.
@@ -110,10 +156,28 @@ func (t *Template) body(context interface{}, yield template.HTML) (template.HTML
 	return template.HTML(buf.String()), nil
 }
 
-func (t *Template) mergeImports(dest map[string]interface{}, imports []*manifest.Import) error {
+// resolveImports resolves the static imports of the template and builds the
+// template functions for them once.
+func (t *Template) resolveImports() error {
+	t.importsOnce.Do(func() {
+		imports, err := t.ResolveStaticImports(t.index)
+		if err != nil {
+			t.importsErr = err
+			return
+		}
+		t.importFuncs = map[string]interface{}{}
+		t.importRelations, t.importsErr = t.mergeImports(t.importFuncs, imports)
+	})
+	return t.importsErr
+}
+
+// mergeImports adds a template function to dest for each named import,
+// returning the manifests each resolved to.
+func (t *Template) mergeImports(dest map[string]interface{}, imports []*manifest.Import) ([]*manifest.ResolvedRelation, error) {
 	if dest == nil {
-		return errors.New("destination map must be supplied")
+		return nil, errors.New("destination map must be supplied")
 	}
+	var relations []*manifest.ResolvedRelation
 	for _, item := range imports {
 		if item.Name == "" {
 			continue
@@ -122,13 +186,14 @@ func (t *Template) mergeImports(dest map[string]interface{}, imports []*manifest
 		for _, item := range item.Manifests {
 			resource, err := t.newStub(item, nil)
 			if err != nil {
-				return err
+				return nil, err
 			}
 			resources = append(resources, resource)
 		}
 		dest[item.Name] = t.templateFn(item, resources)
+		relations = append(relations, &manifest.ResolvedRelation{Name: item.Name, Manifests: item.Manifests})
 	}
-	return nil
+	return relations, nil
 }
 
 func (t *Template) templateFn(config *manifest.Import, imports []*Resource) interface{} {
@@ -157,6 +222,47 @@ func (t *Template) templateFn(config *manifest.Import, imports []*Resource) inte
 	}
 }
 
+// absoluteURL provides the href of a resource on the host of its domain.
+func absoluteURL(r *Resource) (string, error) { return r.HrefAbsolute() }
+
+// canonicalTag produces a link element declaring the canonical URL of the
+// supplied resource.
+func canonicalTag(r *Resource) template.HTML {
+	return template.HTML(fmt.Sprintf(`<link rel="canonical" href="%s">`, template.HTMLEscapeString(r.CanonicalURL())))
+}
+
+// cssTag produces a link element referencing the stylesheet rendered by the
+// supplied resource.
+func cssTag(r *Resource) (template.HTML, error) {
+	integrity, err := r.Instance().Integrity()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", r, err)
+	}
+	return template.HTML(fmt.Sprintf(
+		`<link rel="stylesheet" href="%s" integrity="%s" crossorigin="anonymous">`,
+		template.HTMLEscapeString(r.HrefCanonical()),
+		template.HTMLEscapeString(integrity),
+	)), nil
+}
+
+// scriptTag produces a script element referencing the bundle rendered by the
+// supplied resource.
+func scriptTag(r *Resource) (template.HTML, error) {
+	integrity, err := r.Instance().Integrity()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", r, err)
+	}
+	src := r.Instance().OutputHref()
+	if src == "" {
+		src = r.HrefCanonical()
+	}
+	return template.HTML(fmt.Sprintf(
+		`<script src="%s" integrity="%s" crossorigin="anonymous"></script>`,
+		template.HTMLEscapeString(src),
+		template.HTMLEscapeString(integrity),
+	)), nil
+}
+
 // gross
 func merge(dest map[string]interface{}, source map[string]interface{}) {
 	for key, value := range source {
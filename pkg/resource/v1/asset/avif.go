@@ -0,0 +1,169 @@
+package asset
+
+import (
+	gobytes "bytes"
+	"context"
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	json "github.com/json-iterator/go"
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const KGVAvif = "image/avif/v1"
+
+// AvifSpec controls how jpeg or png sources are encoded as avif. Encoding
+// requires avifenc, from libavif, to be installed.
+type AvifSpec struct {
+	// Quality ranges from 0 to 100, zero uses the default of 60.
+	Quality int
+	// Speed ranges from 0 to 10, trading quality for speed. Zero uses the
+	// default of 6.
+	Speed int
+}
+
+func (s *AvifSpec) validate() error {
+	if s.Quality < 0 || s.Quality > 100 {
+		return fmt.Errorf("quality must be between 0 and 100")
+	}
+	if s.Speed < 0 || s.Speed > 10 {
+		return fmt.Errorf("speed must be between 0 and 10")
+	}
+	return nil
+}
+
+// args are the options of avifenc that encode as requested.
+func (s *AvifSpec) args() []string {
+	quality, speed := 60, 6
+	if s.Quality != 0 {
+		quality = s.Quality
+	}
+	if s.Speed != 0 {
+		speed = s.Speed
+	}
+	return []string{
+		"--qcolor", strconv.Itoa(quality),
+		"--qalpha", strconv.Itoa(quality),
+		"--speed", strconv.Itoa(speed),
+		"--yuv", "420",
+	}
+}
+
+// encodeAvif encodes img with avifenc. The image is passed to it as a png in
+// a temporary directory as avifenc only reads and writes files.
+func encodeAvif(ctx context.Context, w io.Writer, img image.Image, spec *AvifSpec) error {
+	avifenc, lookErr := exec.LookPath("avifenc")
+	if lookErr != nil {
+		return fmt.Errorf("avifenc is required to encode avif: %w", lookErr)
+	}
+	dir, tempErr := ioutil.TempDir("", "aevitas-avif")
+	if tempErr != nil {
+		return tempErr
+	}
+	defer os.RemoveAll(dir)
+	input := filepath.Join(dir, "input.png")
+	output := filepath.Join(dir, "output.avif")
+	var src gobytes.Buffer
+	if err := png.Encode(&src, img); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(input, src.Bytes(), 0600); err != nil {
+		return err
+	}
+	var stderr gobytes.Buffer
+	cmd := exec.CommandContext(ctx, avifenc, append(spec.args(), input, output)...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("avifenc: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	encoded, readErr := ioutil.ReadFile(output)
+	if readErr != nil {
+		return fmt.Errorf("avifenc: %w", readErr)
+	}
+	_, err := w.Write(encoded)
+	return err
+}
+
+type Avif struct {
+	*manifest.Manifest
+	Spec *imageSpec
+	Avif *AvifSpec
+	placeholder
+}
+
+func NewAvif(m *manifest.Manifest) (*Avif, error) {
+	spec, err := newImageSpec(m)
+	if err != nil {
+		return nil, err
+	}
+	var avifSpec AvifSpec
+	if err := json.Unmarshal(m.Spec, &avifSpec); err != nil {
+		return nil, err
+	}
+	if err := avifSpec.validate(); err != nil {
+		return nil, err
+	}
+	return &Avif{
+		Manifest: m,
+		Spec:     spec,
+		Avif:     &avifSpec,
+	}, nil
+}
+
+func (img *Avif) Render(ctx context.Context, source billy.Filesystem, dest billy.Filesystem) error {
+	scopedDest, scopeErr := dest.Chroot(img.Manifest.Meta.HrefPrefix)
+	if scopeErr != nil {
+		return scopeErr
+	}
+	current := img.current(scopedDest)
+	if current && !img.Spec.ExtractDominantColor {
+		return nil
+	}
+	src, readErr := reader(ctx, img.Manifest, source)
+	if readErr != nil {
+		return readErr
+	}
+	data, _, decodeErr := image.Decode(src)
+	src.Close()
+	if decodeErr != nil {
+		return decodeErr
+	}
+	if !current {
+		if err := img.Spec.render(ctx, func(width int) error {
+			return encodeTo(scopedDest, alternateName(width, "avif"), resize(data, width), func(w io.Writer, m image.Image) error {
+				return encodeAvif(ctx, w, m, img.Avif)
+			})
+		}); err != nil {
+			return err
+		}
+	}
+	// Avif output is not decoded, the color is that of the source resized to
+	// the first width instead.
+	return img.recordDominantColor(img.Spec, resize(data, img.Spec.Widths[0]))
+}
+
+func (img *Avif) current(fs billy.Filesystem) bool {
+	for _, width := range img.Spec.Widths {
+		if !exists(fs, alternateName(width, "avif")) {
+			return false
+		}
+	}
+	return true
+}
+
+// AvifHref is the path of a width of the image for use in the srcset of a
+// picture source element.
+func (img *Avif) AvifHref(width int) string { return avifHref(img.Manifest, width) }
+
+func avifHref(m *manifest.Manifest, width int) string {
+	return path.Join("/", m.Meta.HrefPrefix, alternateName(width, "avif"))
+}
@@ -0,0 +1,81 @@
+package asset
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	json "github.com/json-iterator/go"
+	"github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/minify/v2/css"
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"path/filepath"
+	"strings"
+)
+
+const KGVCss = "text/css/v1"
+
+// CssSpec describes the stylesheets that are combined to produce the output.
+type CssSpec struct {
+	// Files are concatenated in order from the source filesystem.
+	Files []string
+	// Minify removes comments and whitespace from the combined output.
+	Minify bool
+}
+
+func (s *CssSpec) validate() error {
+	if len(s.Files) == 0 {
+		return fmt.Errorf("files must be defined as an array")
+	}
+	return nil
+}
+
+type Css struct {
+	*manifest.Manifest
+	Spec *CssSpec
+}
+
+func NewCss(m *manifest.Manifest) (*Css, error) {
+	var spec CssSpec
+	if err := json.Unmarshal(m.Spec, &spec); err != nil {
+		return nil, err
+	}
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	if m.Href() == "" {
+		return nil, fmt.Errorf("href must be set")
+	}
+	return &Css{
+		Manifest: m,
+		Spec:     &spec,
+	}, nil
+}
+
+func (c *Css) Render(_ context.Context, source billy.Filesystem, dest billy.Filesystem) error {
+	sources, readErr := readSources(source, c.Spec.Files)
+	if readErr != nil {
+		return readErr
+	}
+	output := strings.Join(sources, "")
+	if c.Spec.Minify {
+		minifier := minify.New()
+		minifier.AddFunc("text/css", css.Minify)
+		var err error
+		if output, err = minifier.String("text/css", output); err != nil {
+			return err
+		}
+	}
+	if err := dest.MkdirAll(filepath.Dir(c.Href()), 0755); err != nil {
+		return err
+	}
+	return writeFile(dest, c.Href(), []byte(output))
+}
+
+// IsPage prevents the stylesheet written to the href from being replaced by
+// rendering the manifest as a page.
+func (c *Css) IsPage() bool { return false }
+
+// Integrity computes the subresource integrity hash of the rendered output.
+func (c *Css) Integrity(dest billy.Filesystem) (string, error) {
+	return integrity(dest, c.Href())
+}
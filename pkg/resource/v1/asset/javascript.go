@@ -0,0 +1,168 @@
+package asset
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	json "github.com/json-iterator/go"
+	hash "github.com/minio/sha256-simd"
+	"github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/minify/v2/js"
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"path"
+	"strings"
+)
+
+const KGVJavaScript = "text/javascript/v1"
+
+// JavaScriptSpec describes the scripts that are combined to produce a bundle.
+type JavaScriptSpec struct {
+	// Files are concatenated in order from the source filesystem.
+	Files []string
+	// Minify removes comments and whitespace from the combined output.
+	Minify bool
+	// Sourcemap writes a source map alongside the bundle and references it
+	// with a sourceMappingURL comment. Line mappings are only produced for
+	// unminified bundles as the minifier does not track positions.
+	Sourcemap bool
+	// ContentAddressable names the bundle {hash}-bundle.js, in the directory
+	// of the href, so it can be cached indefinitely.
+	ContentAddressable bool
+}
+
+func (s *JavaScriptSpec) validate() error {
+	if len(s.Files) == 0 {
+		return fmt.Errorf("files must be defined as an array")
+	}
+	return nil
+}
+
+type JavaScript struct {
+	*manifest.Manifest
+	Spec *JavaScriptSpec
+	// Output is the path the bundle was written to during rendering.
+	Output string
+}
+
+func NewJavaScript(m *manifest.Manifest) (*JavaScript, error) {
+	var spec JavaScriptSpec
+	if err := json.Unmarshal(m.Spec, &spec); err != nil {
+		return nil, err
+	}
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	if m.Href() == "" {
+		return nil, fmt.Errorf("href must be set")
+	}
+	return &JavaScript{
+		Manifest: m,
+		Spec:     &spec,
+	}, nil
+}
+
+func (j *JavaScript) Render(_ context.Context, source billy.Filesystem, dest billy.Filesystem) error {
+	sources, readErr := readSources(source, j.Spec.Files)
+	if readErr != nil {
+		return readErr
+	}
+	output := strings.Join(sources, "")
+	if j.Spec.Minify {
+		minifier := minify.New()
+		minifier.AddFunc("text/javascript", js.Minify)
+		var err error
+		if output, err = minifier.String("text/javascript", output); err != nil {
+			return err
+		}
+	}
+	target := j.Href()
+	if j.Spec.ContentAddressable {
+		digest := hash.Sum256([]byte(output))
+		target = path.Join(path.Dir(target), hex.EncodeToString(digest[:8])+"-bundle.js")
+	}
+	if err := dest.MkdirAll(path.Dir(target), 0755); err != nil {
+		return err
+	}
+	if j.Spec.Sourcemap {
+		sourcemap, mapErr := j.sourcemap(sources)
+		if mapErr != nil {
+			return mapErr
+		}
+		if err := writeFile(dest, target+".map", sourcemap); err != nil {
+			return err
+		}
+		output = strings.TrimSuffix(output, "\n") + "\n//# sourceMappingURL=" + path.Base(target) + ".map\n"
+	}
+	if err := writeFile(dest, target, []byte(output)); err != nil {
+		return err
+	}
+	j.Output = target
+	return nil
+}
+
+// sourcemap produces a version 3 source map for the bundle. Every line of an
+// unminified bundle is mapped to the start of the line it came from.
+func (j *JavaScript) sourcemap(sources []string) ([]byte, error) {
+	var mappings strings.Builder
+	if !j.Spec.Minify {
+		previousSource, previousLine := 0, 0
+		for index, content := range sources {
+			for line := 0; line < strings.Count(content, "\n"); line++ {
+				if mappings.Len() > 0 {
+					mappings.WriteString(";")
+				}
+				mappings.WriteString(vlq(0) + vlq(index-previousSource) + vlq(line-previousLine) + vlq(0))
+				previousSource, previousLine = index, line
+			}
+		}
+	}
+	return json.Marshal(map[string]interface{}{
+		"version":        3,
+		"file":           path.Base(j.Href()),
+		"sources":        j.Spec.Files,
+		"sourcesContent": sources,
+		"names":          []string{},
+		"mappings":       mappings.String(),
+	})
+}
+
+// OutputHref returns the path of the rendered bundle, which differs from the
+// href when the bundle is content addressable.
+func (j *JavaScript) OutputHref() string {
+	if j.Output != "" {
+		return j.Output
+	}
+	return j.Href()
+}
+
+// IsPage prevents the bundle written to the href from being replaced by
+// rendering the manifest as a page.
+func (j *JavaScript) IsPage() bool { return false }
+
+// Integrity computes the subresource integrity hash of the rendered output.
+func (j *JavaScript) Integrity(dest billy.Filesystem) (string, error) {
+	return integrity(dest, j.OutputHref())
+}
+
+// vlq encodes a value using the base64 variable length quantity format of
+// source map mappings.
+func vlq(value int) string {
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+	encoded := value << 1
+	if value < 0 {
+		encoded = (-value << 1) | 1
+	}
+	var out strings.Builder
+	for {
+		digit := encoded & 31
+		encoded >>= 5
+		if encoded > 0 {
+			digit |= 32
+		}
+		out.WriteByte(alphabet[digit])
+		if encoded == 0 {
+			return out.String()
+		}
+	}
+}
@@ -2,11 +2,11 @@ package asset
 
 import (
 	"context"
-	"github.com/disintegration/gift"
 	"github.com/go-git/go-billy/v5"
 	"github.com/pixiv/go-libjpeg/jpeg"
 	"github.com/tkellen/aevitas/pkg/manifest"
 	"image"
+	"io"
 	"strconv"
 )
 
@@ -15,6 +15,7 @@ const KGVJpeg = "asset/jpeg/v1"
 type Jpeg struct {
 	*manifest.Manifest
 	Spec *imageSpec
+	placeholder
 }
 
 func NewJpeg(m *manifest.Manifest) (*Jpeg, error) {
@@ -34,9 +35,9 @@ func (img *Jpeg) Render(ctx context.Context, source billy.Filesystem, dest billy
 		return scopeErr
 	}
 	if img.Spec.current(scopedDest) {
-		return nil
+		return img.extractDominantColor(img.Spec, scopedDest, strconv.Itoa(img.Spec.Widths[0]))
 	}
-	src, readErr := reader(img.Manifest, source)
+	src, readErr := reader(ctx, img.Manifest, source)
 	if readErr != nil {
 		return readErr
 	}
@@ -44,28 +45,24 @@ func (img *Jpeg) Render(ctx context.Context, source billy.Filesystem, dest billy
 	if decodeErr != nil {
 		return decodeErr
 	}
-	return img.Spec.render(ctx, func(width int) error {
-		return img.write(data, scopedDest, width)
-	})
+	if err := img.Spec.render(ctx, func(width int) error {
+		return img.write(ctx, data, scopedDest, width)
+	}); err != nil {
+		return err
+	}
+	return img.extractDominantColor(img.Spec, scopedDest, strconv.Itoa(img.Spec.Widths[0]))
 }
 
-func (img *Jpeg) write(src image.Image, fs billy.Filesystem, width int) error {
-	filePath := strconv.Itoa(width)
-	file, openErr := fs.Create(filePath)
-	if openErr != nil {
-		return openErr
-	}
-	defer file.Close()
-	g := gift.New(
-		gift.Resize(width, 0, gift.LanczosResampling),
-		gift.UnsharpMask(.25, 8, 0.065),
-	)
-	resized := image.NewRGBA(g.Bounds(src.Bounds()))
-	g.Draw(resized, src)
-	if err := jpeg.Encode(file, resized, &jpeg.EncoderOptions{Quality: 85}); err != nil {
-		file.Close()
-		fs.Remove(filePath)
+func (img *Jpeg) write(ctx context.Context, src image.Image, fs billy.Filesystem, width int) error {
+	resized := resize(src, width)
+	if err := encodeTo(fs, strconv.Itoa(width), resized, func(w io.Writer, img image.Image) error {
+		return jpeg.Encode(w, img, &jpeg.EncoderOptions{Quality: 85})
+	}); err != nil {
 		return err
 	}
-	return file.Close()
+	return img.Spec.writeAlternates(ctx, resized, fs, width)
 }
+
+// AvifHref is the path of a width of the image when avif is one of the
+// requested output formats.
+func (img *Jpeg) AvifHref(width int) string { return avifHref(img.Manifest, width) }
@@ -1,12 +1,21 @@
 package asset
 
 import (
+	gobytes "bytes"
 	"context"
+	"crypto/sha512"
+	"encoding/base64"
 	"fmt"
+	"github.com/disintegration/gift"
 	"github.com/go-git/go-billy/v5"
 	json "github.com/json-iterator/go"
+	"github.com/tkellen/aevitas/internal/safefs"
 	"github.com/tkellen/aevitas/pkg/manifest"
 	"golang.org/x/sync/errgroup"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
 	"io"
 	"io/ioutil"
 	"strconv"
@@ -15,6 +24,20 @@ import (
 
 type imageSpec struct {
 	Widths []int
+	// ExtractDominantColor enables computing the dominant color of the image
+	// for use as a placeholder background while it loads.
+	ExtractDominantColor bool
+	// OutputFormats are written alongside each width, named {width}.{format},
+	// for use as alternate sources of a picture element.
+	OutputFormats []string
+}
+
+// imageEncoders encode the formats that can be requested as additional
+// outputs of an image.
+var imageEncoders = map[string]func(context.Context, io.Writer, image.Image) error{
+	"avif": func(ctx context.Context, w io.Writer, img image.Image) error {
+		return encodeAvif(ctx, w, img, &AvifSpec{})
+	},
 }
 
 func newImageSpec(m *manifest.Manifest) (*imageSpec, error) {
@@ -33,6 +56,11 @@ func (s *imageSpec) validate() error {
 	if len(s.Widths) == 0 {
 		errs = append(errs, "widths must be defined as an array")
 	}
+	for _, format := range s.OutputFormats {
+		if _, ok := imageEncoders[format]; !ok {
+			errs = append(errs, fmt.Sprintf("unsupported output format %s", format))
+		}
+	}
 	if len(errs) > 0 {
 		return fmt.Errorf("%s", strings.Join(errs, "\n"))
 	}
@@ -40,14 +68,107 @@ func (s *imageSpec) validate() error {
 }
 
 func (s *imageSpec) current(fs billy.Filesystem) bool {
-	var widths []int
 	for _, width := range s.Widths {
-		if stat, _ := fs.Stat(strconv.Itoa(width)); stat != nil && stat.Size() != 0 {
-			continue
+		names := []string{strconv.Itoa(width)}
+		for _, format := range s.OutputFormats {
+			names = append(names, alternateName(width, format))
+		}
+		if !exists(fs, names...) {
+			return false
+		}
+	}
+	return true
+}
+
+// exists determines if every named file has been written.
+func exists(fs billy.Filesystem, names ...string) bool {
+	for _, name := range names {
+		if stat, _ := fs.Stat(name); stat == nil || stat.Size() == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// alternateName is the name of a width rendered in an additional format.
+func alternateName(width int, format string) string {
+	return fmt.Sprintf("%d.%s", width, format)
+}
+
+// writeAlternates writes an image that has been resized to a width in every
+// requested output format.
+func (s *imageSpec) writeAlternates(ctx context.Context, src image.Image, fs billy.Filesystem, width int) error {
+	for _, format := range s.OutputFormats {
+		encode := imageEncoders[format]
+		if err := encodeTo(fs, alternateName(width, format), src, func(w io.Writer, img image.Image) error {
+			return encode(ctx, w, img)
+		}); err != nil {
+			return err
 		}
-		widths = append(widths, width)
 	}
-	return len(widths) == 0
+	return nil
+}
+
+// encodeTo writes an encoded image to a file.
+func encodeTo(fs billy.Filesystem, name string, src image.Image, encode func(io.Writer, image.Image) error) error {
+	var encoded gobytes.Buffer
+	if err := encode(&encoded, src); err != nil {
+		return err
+	}
+	return writeFile(fs, name, encoded.Bytes())
+}
+
+// writeFile replaces the file at name with data the same way pages are
+// written. An interrupted render never leaves part of a file at name, which
+// would otherwise be taken as current by the next render.
+func writeFile(dest billy.Filesystem, name string, data []byte) error {
+	return safefs.WriteFile(dest, name, data, 0644)
+}
+
+// readSources reads files in order from the source filesystem so they can be
+// concatenated. Each ends with exactly one newline so the last line of a file
+// never runs into the first of the next.
+func readSources(source billy.Filesystem, names []string) ([]string, error) {
+	sources := make([]string, 0, len(names))
+	for _, name := range names {
+		file, openErr := source.Open(name)
+		if openErr != nil {
+			return nil, openErr
+		}
+		data, readErr := ioutil.ReadAll(file)
+		file.Close()
+		if readErr != nil {
+			return nil, fmt.Errorf("%s: %w", name, readErr)
+		}
+		sources = append(sources, strings.TrimSuffix(string(data), "\n")+"\n")
+	}
+	return sources, nil
+}
+
+// integrity computes the subresource integrity hash of a rendered file.
+func integrity(dest billy.Filesystem, name string) (string, error) {
+	file, openErr := dest.Open(name)
+	if openErr != nil {
+		return "", openErr
+	}
+	defer file.Close()
+	data, readErr := ioutil.ReadAll(file)
+	if readErr != nil {
+		return "", readErr
+	}
+	digest := sha512.Sum384(data)
+	return "sha384-" + base64.StdEncoding.EncodeToString(digest[:]), nil
+}
+
+// resize scales an image to a width, preserving its aspect ratio.
+func resize(src image.Image, width int) *image.RGBA {
+	g := gift.New(
+		gift.Resize(width, 0, gift.LanczosResampling),
+		gift.UnsharpMask(.25, 8, 0.065),
+	)
+	resized := image.NewRGBA(g.Bounds(src.Bounds()))
+	g.Draw(resized, src)
+	return resized
 }
 
 func (s *imageSpec) render(ctx context.Context, render func(int) error) error {
@@ -66,12 +187,98 @@ func (s *imageSpec) render(ctx context.Context, render func(int) error) error {
 	return eg.Wait()
 }
 
-func reader(m *manifest.Manifest, source billy.Filesystem) (io.ReadCloser, error) {
-	return source.Open(fmt.Sprintf("%s", m.Meta.File))
+// placeholder is embedded by image assets to record their dominant color.
+type placeholder struct {
+	// DominantColor is populated during rendering when the spec requests it.
+	DominantColor string
+}
+
+// PlaceholderColor returns the dominant color computed while rendering, if
+// any.
+func (p *placeholder) PlaceholderColor() string { return p.DominantColor }
+
+// extractDominantColor records the dominant color of the rendered image named
+// name when the spec requests it.
+func (p *placeholder) extractDominantColor(spec *imageSpec, fs billy.Filesystem, name string) error {
+	if !spec.ExtractDominantColor {
+		return nil
+	}
+	file, openErr := fs.Open(name)
+	if openErr != nil {
+		return openErr
+	}
+	defer file.Close()
+	img, _, decodeErr := image.Decode(file)
+	if decodeErr != nil {
+		return decodeErr
+	}
+	return p.recordDominantColor(spec, img)
+}
+
+// recordDominantColor does just what extractDominantColor does for an image
+// that has already been decoded.
+func (p *placeholder) recordDominantColor(spec *imageSpec, img image.Image) error {
+	if !spec.ExtractDominantColor {
+		return nil
+	}
+	color, err := dominantColor(img)
+	if err != nil {
+		return err
+	}
+	p.DominantColor = color
+	return nil
+}
+
+// dominantColor computes the dominant color of an image as a hex string. With
+// a single cluster, k-means quantization converges on the mean of every pixel,
+// so that is computed directly.
+func dominantColor(img image.Image) (string, error) {
+	var r, g, b, count uint64
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			pr, pg, pb, _ := img.At(x, y).RGBA()
+			r, g, b = r+uint64(pr), g+uint64(pg), b+uint64(pb)
+			count++
+		}
+	}
+	if count == 0 {
+		return "", fmt.Errorf("image has no pixels")
+	}
+	// Channels are 16-bit, shift down to 8-bit for hex encoding.
+	return fmt.Sprintf("#%02x%02x%02x", r/count>>8, g/count>>8, b/count>>8), nil
+}
+
+// reader opens the file of an asset, reading those with the file scheme from
+// source.
+func reader(ctx context.Context, m *manifest.Manifest, source billy.Filesystem) (io.ReadCloser, error) {
+	scheme, path, err := m.Meta.FileURI()
+	if err != nil {
+		return nil, err
+	}
+	if scheme == "file" {
+		return source.Open(path)
+	}
+	return manifest.OpenFile(ctx, scheme, path)
+}
+
+// decode reads the source of an image asset when it will be encoded in other
+// formats. It returns nil when no other formats are requested.
+func decode(ctx context.Context, m *manifest.Manifest, source billy.Filesystem, spec *imageSpec) (image.Image, error) {
+	if len(spec.OutputFormats) == 0 {
+		return nil, nil
+	}
+	reader, fetchErr := reader(ctx, m, source)
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+	defer reader.Close()
+	img, _, decodeErr := image.Decode(reader)
+	return img, decodeErr
 }
 
-func bytes(m *manifest.Manifest, source billy.Filesystem) ([]byte, error) {
-	reader, fetchErr := reader(m, source)
+func bytes(ctx context.Context, m *manifest.Manifest, source billy.Filesystem) ([]byte, error) {
+	reader, fetchErr := reader(ctx, m, source)
 	if fetchErr != nil {
 		return nil, fetchErr
 	}
@@ -1,13 +1,384 @@
 package asset_test
 
 import (
+	"context"
+	"errors"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
 	"github.com/pixiv/go-libjpeg/jpeg"
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"github.com/tkellen/aevitas/pkg/resource/v1/asset"
+	"image"
+	"image/color"
 	nativeJpeg "image/jpeg"
+	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 )
 
+// redJpeg creates a filesystem containing a solid red jpeg named red.jpg.
+func redJpeg(t *testing.T) billy.Filesystem {
+	source := memfs.New()
+	red := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	for x := 0; x < 100; x++ {
+		for y := 0; y < 100; y++ {
+			red.Set(x, y, color.RGBA{R: 255, A: 255})
+		}
+	}
+	file, createErr := source.Create("red.jpg")
+	if createErr != nil {
+		t.Fatal(createErr)
+	}
+	if err := nativeJpeg.Encode(file, red, &nativeJpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	return source
+}
+
+// fakeAvifenc puts a stand-in for avifenc on the path which writes the
+// signature of an avif image. It returns the file its arguments are written to.
+func fakeAvifenc(t *testing.T) string {
+	bin := t.TempDir()
+	script := "#!/bin/sh\necho \"$@\" > \"${0%/*}/args\"\nfor last; do :; done\nprintf '\\000\\000\\000\\030ftypavif' > \"$last\"\n"
+	if err := ioutil.WriteFile(filepath.Join(bin, "avifenc"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+	return filepath.Join(bin, "args")
+}
+
+// assertAvif fails unless the named file has the signature of an avif image.
+func assertAvif(t *testing.T, fs billy.Filesystem, name string) {
+	file, openErr := fs.Open(name)
+	if openErr != nil {
+		t.Fatal(openErr)
+	}
+	defer file.Close()
+	data, readErr := ioutil.ReadAll(file)
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	if len(data) < 12 || string(data[4:8]) != "ftyp" || string(data[8:12]) != "avif" {
+		t.Fatalf("expected %s to be avif, got % x", name, data[:12])
+	}
+}
+
+func TestJpeg_DominantColor(t *testing.T) {
+	source := redJpeg(t)
+	manifests, newErr := manifest.New([]byte(`{
+		"kind": "asset", "group": "jpeg", "version": "v1", "namespace": "image", "name": "red",
+		"meta": {"live": true, "file": "red.jpg"},
+		"spec": {"widths": [50], "extractDominantColor": true}
+	}`), "test")
+	if newErr != nil {
+		t.Fatal(newErr)
+	}
+	img, imgErr := asset.NewJpeg(manifests[0])
+	if imgErr != nil {
+		t.Fatal(imgErr)
+	}
+	if err := img.Render(context.Background(), source, memfs.New()); err != nil {
+		t.Fatal(err)
+	}
+	if len(img.DominantColor) != 7 {
+		t.Fatalf("expected hex color, got %q", img.DominantColor)
+	}
+	expected := [3]int64{0xff, 0x00, 0x00}
+	for idx := range expected {
+		actual, parseErr := strconv.ParseInt(img.DominantColor[1+idx*2:3+idx*2], 16, 64)
+		if parseErr != nil {
+			t.Fatal(parseErr)
+		}
+		if delta := actual - expected[idx]; delta > 8 || delta < -8 {
+			t.Fatalf("expected color near #ff0000, got %s", img.DominantColor)
+		}
+	}
+}
+
+func TestAvif_Render(t *testing.T) {
+	manifests, newErr := manifest.New([]byte(`{
+		"kind": "image", "group": "avif", "version": "v1", "namespace": "image", "name": "red",
+		"meta": {"live": true, "file": "red.jpg", "hrefPrefix": "/images/red"},
+		"spec": {"widths": [50], "quality": 50, "speed": 10, "extractDominantColor": true}
+	}`), "test")
+	if newErr != nil {
+		t.Fatal(newErr)
+	}
+	img, imgErr := asset.NewAvif(manifests[0])
+	if imgErr != nil {
+		t.Fatal(imgErr)
+	}
+	t.Run("without avifenc", func(t *testing.T) {
+		t.Setenv("PATH", t.TempDir())
+		err := img.Render(context.Background(), redJpeg(t), memfs.New())
+		if err == nil || !strings.Contains(err.Error(), "avifenc is required") {
+			t.Fatalf("expected missing avifenc to be reported, got %v", err)
+		}
+	})
+	t.Run("with avifenc", func(t *testing.T) {
+		args := fakeAvifenc(t)
+		dest := memfs.New()
+		if err := img.Render(context.Background(), redJpeg(t), dest); err != nil {
+			t.Fatal(err)
+		}
+		if expected := "/images/red/50.avif"; img.AvifHref(50) != expected {
+			t.Fatalf("expected %s, got %s", expected, img.AvifHref(50))
+		}
+		assertAvif(t, dest, img.AvifHref(50))
+		recorded, readErr := ioutil.ReadFile(args)
+		if readErr != nil {
+			t.Fatal(readErr)
+		}
+		if expected := "--qcolor 50 --qalpha 50 --speed 10 --yuv 420"; !strings.HasPrefix(string(recorded), expected) {
+			t.Fatalf("expected avifenc to be run with %s, got %s", expected, recorded)
+		}
+		if !strings.HasPrefix(img.DominantColor, "#f") {
+			t.Fatalf("expected color near #ff0000, got %q", img.DominantColor)
+		}
+	})
+}
+
+func TestJpeg_OutputFormats(t *testing.T) {
+	manifests, newErr := manifest.New([]byte(`{
+		"kind": "asset", "group": "jpeg", "version": "v1", "namespace": "image", "name": "red",
+		"meta": {"live": true, "file": "red.jpg", "hrefPrefix": "/images/red"},
+		"spec": {"widths": [50], "outputFormats": ["avif"]}
+	}`), "test")
+	if newErr != nil {
+		t.Fatal(newErr)
+	}
+	img, imgErr := asset.NewJpeg(manifests[0])
+	if imgErr != nil {
+		t.Fatal(imgErr)
+	}
+	fakeAvifenc(t)
+	dest := memfs.New()
+	if err := img.Render(context.Background(), redJpeg(t), dest); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dest.Stat("/images/red/50"); err != nil {
+		t.Fatal(err)
+	}
+	assertAvif(t, dest, img.AvifHref(50))
+}
+
+func TestCss_Render(t *testing.T) {
+	manifests, newErr := manifest.New([]byte(`{
+		"kind": "text", "group": "css", "version": "v1", "namespace": "style", "name": "site",
+		"meta": {"live": true, "href": "/css/site.css"},
+		"spec": {"files": ["css/base.css", "css/theme.css"], "minify": true}
+	}`), "test")
+	if newErr != nil {
+		t.Fatal(newErr)
+	}
+	stylesheet, cssErr := asset.NewCss(manifests[0])
+	if cssErr != nil {
+		t.Fatal(cssErr)
+	}
+	dest := memfs.New()
+	if err := stylesheet.Render(context.Background(), osfs.New("../../../../testdata"), dest); err != nil {
+		t.Fatal(err)
+	}
+	file, openErr := dest.Open("/css/site.css")
+	if openErr != nil {
+		t.Fatal(openErr)
+	}
+	defer file.Close()
+	output, readErr := ioutil.ReadAll(file)
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	expected := "body{margin:0;font-family:sans-serif}a{color:red}"
+	if string(output) != expected {
+		t.Fatalf("expected %s, got %s", expected, output)
+	}
+	integrity, integrityErr := stylesheet.Integrity(dest)
+	if integrityErr != nil {
+		t.Fatal(integrityErr)
+	}
+	if !strings.HasPrefix(integrity, "sha384-") {
+		t.Fatalf("expected sha384 integrity, got %s", integrity)
+	}
+}
+
+// failingFs creates files that fail partway through being written, as they
+// would if rendering were interrupted.
+type failingFs struct {
+	billy.Filesystem
+}
+
+func (fs *failingFs) OpenFile(filename string, flag int, perm os.FileMode) (billy.File, error) {
+	file, err := fs.Filesystem.OpenFile(filename, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &failingFile{File: file}, nil
+}
+
+type failingFile struct {
+	billy.File
+}
+
+func (f *failingFile) Write(p []byte) (int, error) {
+	n, _ := f.File.Write(p[:len(p)/2])
+	return n, errors.New("interrupted")
+}
+
+func TestCss_RenderInterrupted(t *testing.T) {
+	manifests, newErr := manifest.New([]byte(`{
+		"kind": "text", "group": "css", "version": "v1", "namespace": "style", "name": "site",
+		"meta": {"live": true, "href": "/css/site.css"},
+		"spec": {"files": ["css/base.css"]}
+	}`), "test")
+	if newErr != nil {
+		t.Fatal(newErr)
+	}
+	stylesheet, cssErr := asset.NewCss(manifests[0])
+	if cssErr != nil {
+		t.Fatal(cssErr)
+	}
+	dest := memfs.New()
+	if err := util.WriteFile(dest, "/css/site.css", []byte("previous"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := stylesheet.Render(context.Background(), osfs.New("../../../../testdata"), &failingFs{dest}); err == nil {
+		t.Fatal("expected error")
+	}
+	file, openErr := dest.Open("/css/site.css")
+	if openErr != nil {
+		t.Fatal(openErr)
+	}
+	defer file.Close()
+	output, readErr := ioutil.ReadAll(file)
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	if string(output) != "previous" {
+		t.Fatalf("expected previous output to be kept, got %s", output)
+	}
+	if _, err := dest.Stat("/css/site.css.tmp"); !os.IsNotExist(err) {
+		t.Fatalf("expected partial output to be removed, got %v", err)
+	}
+}
+
+// noRenameFs is a destination that cannot rename files.
+type noRenameFs struct {
+	billy.Filesystem
+}
+
+func (fs *noRenameFs) Rename(string, string) error { return billy.ErrNotSupported }
+
+func TestCss_RenderWithoutRename(t *testing.T) {
+	manifests, newErr := manifest.New([]byte(`{
+		"kind": "text", "group": "css", "version": "v1", "namespace": "style", "name": "site",
+		"meta": {"live": true, "href": "/css/site.css"},
+		"spec": {"files": ["css/base.css"]}
+	}`), "test")
+	if newErr != nil {
+		t.Fatal(newErr)
+	}
+	stylesheet, cssErr := asset.NewCss(manifests[0])
+	if cssErr != nil {
+		t.Fatal(cssErr)
+	}
+	dest := memfs.New()
+	if err := stylesheet.Render(context.Background(), osfs.New("../../../../testdata"), &noRenameFs{dest}); err != nil {
+		t.Fatal(err)
+	}
+	if stat, err := dest.Stat("/css/site.css"); err != nil || stat.Size() == 0 {
+		t.Fatalf("expected stylesheet to be written directly, got %v", err)
+	}
+	if _, err := dest.Stat("/css/site.css.tmp"); !os.IsNotExist(err) {
+		t.Fatalf("expected temporary file to be removed, got %v", err)
+	}
+}
+
+func TestJavaScript_Render(t *testing.T) {
+	type testCase struct {
+		spec     string
+		href     string
+		expected []string
+	}
+	table := map[string]testCase{
+		"concatenates and minifies": {
+			spec: `{"files": ["js/greet.js", "js/main.js"], "minify": true}`,
+			href: "/js/site.js",
+			expected: []string{
+				`function greet(name){`,
+				`"Hello, "+name`,
+				`greet("World")`,
+				`console.log(output)`,
+			},
+		},
+		"appends sourcemap comment": {
+			spec:     `{"files": ["js/greet.js", "js/main.js"], "sourcemap": true}`,
+			href:     "/js/site.js",
+			expected: []string{"// greet builds", "/* Entry point. */", "//# sourceMappingURL=site.js.map"},
+		},
+		"content addressable": {
+			spec:     `{"files": ["js/greet.js"], "minify": true, "contentAddressable": true}`,
+			expected: []string{"function greet(name){"},
+		},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			manifests, newErr := manifest.New([]byte(`{
+				"kind": "text", "group": "javascript", "version": "v1", "namespace": "script", "name": "site",
+				"meta": {"live": true, "href": "/js/site.js"},
+				"spec": `+test.spec+`
+			}`), "test")
+			if newErr != nil {
+				t.Fatal(newErr)
+			}
+			script, jsErr := asset.NewJavaScript(manifests[0])
+			if jsErr != nil {
+				t.Fatal(jsErr)
+			}
+			dest := memfs.New()
+			if err := script.Render(context.Background(), osfs.New("../../../../testdata"), dest); err != nil {
+				t.Fatal(err)
+			}
+			if test.href != "" && script.OutputHref() != test.href {
+				t.Fatalf("expected %s, got %s", test.href, script.OutputHref())
+			}
+			if test.href == "" && !strings.HasSuffix(script.OutputHref(), "-bundle.js") {
+				t.Fatalf("expected content addressable bundle, got %s", script.OutputHref())
+			}
+			file, openErr := dest.Open(script.OutputHref())
+			if openErr != nil {
+				t.Fatal(openErr)
+			}
+			defer file.Close()
+			output, readErr := ioutil.ReadAll(file)
+			if readErr != nil {
+				t.Fatal(readErr)
+			}
+			for _, token := range test.expected {
+				if !strings.Contains(string(output), token) {
+					t.Fatalf("expected %s in %s", token, output)
+				}
+			}
+			integrity, integrityErr := script.Integrity(dest)
+			if integrityErr != nil {
+				t.Fatal(integrityErr)
+			}
+			if !strings.HasPrefix(integrity, "sha384-") {
+				t.Fatalf("expected sha384 integrity, got %s", integrity)
+			}
+		})
+	}
+}
+
 func BenchmarkEncodeDecodeLibJpeg(b *testing.B) {
 	image, openErr := os.Open("../../../testdata/spec.jpg")
 	if openErr != nil {
@@ -47,3 +418,192 @@ func BenchmarkEncodeDecodeNativeJpeg(b *testing.B) {
 		b.Fatal(err)
 	}
 }
+
+func TestMpeg_RenderFileSchemes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/clip.mpg" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, "from http")
+	}))
+	defer server.Close()
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "render")
+	var requested string
+	previous := manifest.RegisterFileOpener("s3", func(ctx context.Context, path string) (io.ReadCloser, error) {
+		if ctx.Value(key{}) != "render" {
+			return nil, errors.New("expected the render context")
+		}
+		requested = path
+		return ioutil.NopCloser(strings.NewReader("from s3")), nil
+	})
+	t.Cleanup(func() { manifest.RegisterFileOpener("s3", previous) })
+	source := memfs.New()
+	if err := util.WriteFile(source, "clip.mpg", []byte("from file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	table := map[string]struct {
+		file     string
+		expected string
+	}{
+		"bare path": {file: "clip.mpg", expected: "from file"},
+		"file":      {file: "file:///clip.mpg", expected: "from file"},
+		"s3":        {file: "s3://bucket/clip.mpg", expected: "from s3"},
+		"http":      {file: server.URL + "/clip.mpg", expected: "from http"},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			manifests, newErr := manifest.New([]byte(`{
+				"kind": "asset", "group": "mpeg", "version": "v1", "namespace": "video", "name": "clip.mpg",
+				"meta": {"live": true, "file": "`+test.file+`"}
+			}`), "test")
+			if newErr != nil {
+				t.Fatal(newErr)
+			}
+			video, _ := asset.NewMpeg(manifests[0])
+			dest := memfs.New()
+			if err := video.Render(ctx, source, dest); err != nil {
+				t.Fatal(err)
+			}
+			file, openErr := dest.Open("clip.mpg")
+			if openErr != nil {
+				t.Fatal(openErr)
+			}
+			defer file.Close()
+			actual, _ := ioutil.ReadAll(file)
+			if string(actual) != test.expected {
+				t.Fatalf("expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+	if requested != "bucket/clip.mpg" {
+		t.Fatalf("expected s3 opener to be given bucket/clip.mpg, got %s", requested)
+	}
+}
+
+func TestSvg_Render(t *testing.T) {
+	const drawing = `<?xml version="1.0"?>
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:inkscape="http://www.inkscape.org/namespaces/inkscape" xmlns:sodipodi="http://sodipodi.sourceforge.net/DTD/sodipodi-0.dtd" inkscape:version="1.3">
+  <sodipodi:namedview id="view" inkscape:zoom="1"><inkscape:grid id="grid"/></sodipodi:namedview>
+  <g inkscape:label="Layer 1" id="layer"><rect width="10" height="10"/></g>
+</svg>`
+	table := map[string]struct {
+		source   string
+		spec     string
+		expected string
+		err      string
+	}{
+		"copied": {source: drawing, expected: drawing},
+		"cleaned": {source: drawing, spec: `{"clean": true}`, expected: `<?xml version="1.0"?>
+<svg xmlns="http://www.w3.org/2000/svg">
+  
+  <g id="layer"><rect width="10" height="10"/></g>
+</svg>`},
+		"malformed": {source: `<svg><g></svg>`, err: "element <g> closed by </svg>"},
+		"not svg":   {source: `<html></html>`, err: `expected an svg element, found "html"`},
+	}
+	for name, test := range table {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			source := memfs.New()
+			if err := util.WriteFile(source, "drawing.svg", []byte(test.source), 0644); err != nil {
+				t.Fatal(err)
+			}
+			spec := test.spec
+			if spec == "" {
+				spec = "{}"
+			}
+			manifests, newErr := manifest.New([]byte(`{
+				"kind": "image", "group": "svg", "version": "v1", "namespace": "site", "name": "drawing.svg",
+				"meta": {"live": true, "file": "drawing.svg"},
+				"spec": `+spec+`
+			}`), "test")
+			if newErr != nil {
+				t.Fatal(newErr)
+			}
+			img, imgErr := asset.NewSvg(manifests[0])
+			if imgErr != nil {
+				t.Fatal(imgErr)
+			}
+			dest := memfs.New()
+			err := img.Render(context.Background(), source, dest)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %s, got %v", test.err, err)
+				}
+				if _, statErr := dest.Stat("drawing.svg"); statErr == nil {
+					t.Fatal("expected nothing to be written")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			file, openErr := dest.Open("drawing.svg")
+			if openErr != nil {
+				t.Fatal(openErr)
+			}
+			defer file.Close()
+			actual, _ := ioutil.ReadAll(file)
+			if string(actual) != test.expected {
+				t.Fatalf("expected %s, got %s", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestMp4_Render(t *testing.T) {
+	source := memfs.New()
+	if err := util.WriteFile(source, "clip.mp4", []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	manifests, newErr := manifest.New([]byte(`{
+		"kind": "video", "group": "mp4", "version": "v1", "namespace": "site", "name": "clip.mp4",
+		"meta": {"live": true, "file": "clip.mp4"},
+		"spec": {"posterAt": 1.5}
+	}`), "test")
+	if newErr != nil {
+		t.Fatal(newErr)
+	}
+	video, videoErr := asset.NewMp4(manifests[0])
+	if videoErr != nil {
+		t.Fatal(videoErr)
+	}
+	// A stand-in for ffmpeg writes its arguments as the poster frame.
+	bin := t.TempDir()
+	script := "#!/bin/sh\necho \"$@\"\n"
+	if err := ioutil.WriteFile(filepath.Join(bin, "ffmpeg"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Run("without ffmpeg", func(t *testing.T) {
+		t.Setenv("PATH", t.TempDir())
+		dest := memfs.New()
+		err := video.Render(context.Background(), source, dest)
+		if err == nil || !strings.Contains(err.Error(), "ffmpeg is required") {
+			t.Fatalf("expected missing ffmpeg to be reported, got %v", err)
+		}
+		if _, statErr := dest.Stat("clip.mp4"); statErr == nil {
+			t.Fatal("expected nothing to be written")
+		}
+	})
+	t.Run("with ffmpeg", func(t *testing.T) {
+		t.Setenv("PATH", bin)
+		dest := memfs.New()
+		if err := video.Render(context.Background(), source, dest); err != nil {
+			t.Fatal(err)
+		}
+		for name, expected := range map[string]string{"clip.mp4": "video", "clip.jpg": "-ss 1.5 -i"} {
+			file, openErr := dest.Open(name)
+			if openErr != nil {
+				t.Fatal(openErr)
+			}
+			actual, _ := ioutil.ReadAll(file)
+			file.Close()
+			if !strings.Contains(string(actual), expected) {
+				t.Fatalf("expected %s to contain %s, got %s", name, expected, actual)
+			}
+		}
+	})
+}
@@ -0,0 +1,126 @@
+package asset
+
+import (
+	gobytes "bytes"
+	"context"
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	json "github.com/json-iterator/go"
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const KGVMp4 = "video/mp4/v1"
+
+// VideoSpec controls the poster frame extracted from a video. The title,
+// description and href of the video are those of its manifest.
+type VideoSpec struct {
+	// PosterAt is the offset, in seconds, of the frame used as the poster.
+	PosterAt float64
+}
+
+func (s *VideoSpec) validate() error {
+	if s.PosterAt < 0 {
+		return fmt.Errorf("posterAt must not be negative")
+	}
+	return nil
+}
+
+type Mp4 struct {
+	*manifest.Manifest
+	Spec *VideoSpec
+}
+
+func NewMp4(m *manifest.Manifest) (*Mp4, error) {
+	var spec VideoSpec
+	if len(m.Spec) > 0 {
+		if err := json.Unmarshal(m.Spec, &spec); err != nil {
+			return nil, err
+		}
+	}
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	return &Mp4{
+		Manifest: m,
+		Spec:     &spec,
+	}, nil
+}
+
+// PosterName is the path, relative to the href prefix, of the poster frame
+// written beside the video.
+func (v *Mp4) PosterName() string {
+	name := v.Selector.Name
+	return strings.TrimSuffix(name, filepath.Ext(name)) + ".jpg"
+}
+
+func (v *Mp4) Render(ctx context.Context, source billy.Filesystem, dest billy.Filesystem) error {
+	scopedDest, scopeErr := dest.Chroot(v.Meta.HrefPrefix)
+	if scopeErr != nil {
+		return scopeErr
+	}
+	filePath := v.Selector.Name
+	if exists(scopedDest, filePath, v.PosterName()) {
+		return nil
+	}
+	ffmpeg, lookErr := exec.LookPath("ffmpeg")
+	if lookErr != nil {
+		return fmt.Errorf("%s: ffmpeg is required to extract the poster frame: %w", v.Selector.ID(), lookErr)
+	}
+	if err := scopedDest.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	src, readErr := bytes(ctx, v.Manifest, source)
+	if readErr != nil {
+		return readErr
+	}
+	poster, posterErr := v.extractPoster(ctx, ffmpeg, src)
+	if posterErr != nil {
+		return fmt.Errorf("%s: %w", v.Selector.ID(), posterErr)
+	}
+	if err := writeFile(scopedDest, filePath, src); err != nil {
+		return err
+	}
+	return writeFile(scopedDest, v.PosterName(), poster)
+}
+
+// extractPoster encodes the frame at PosterAt as a jpeg. The video is written
+// to a temporary file as ffmpeg must be able to seek within it.
+func (v *Mp4) extractPoster(ctx context.Context, ffmpeg string, src []byte) ([]byte, error) {
+	temp, createErr := ioutil.TempFile("", "aevitas-*.mp4")
+	if createErr != nil {
+		return nil, createErr
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(src); err != nil {
+		temp.Close()
+		return nil, err
+	}
+	if err := temp.Close(); err != nil {
+		return nil, err
+	}
+	var stdout, stderr gobytes.Buffer
+	cmd := exec.CommandContext(ctx, ffmpeg,
+		"-loglevel", "error",
+		"-ss", strconv.FormatFloat(v.Spec.PosterAt, 'f', -1, 64),
+		"-i", temp.Name(),
+		"-frames:v", "1",
+		"-f", "image2",
+		"-c:v", "mjpeg",
+		"pipe:1",
+	)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("ffmpeg: no frame at %gs", v.Spec.PosterAt)
+	}
+	return stdout.Bytes(), nil
+}
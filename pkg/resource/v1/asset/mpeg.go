@@ -32,14 +32,9 @@ func (m *Mpeg) Render(ctx context.Context, source billy.Filesystem, dest billy.F
 	if err := scopedDest.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
 		return err
 	}
-	src, readErr := bytes(m.Manifest, source)
+	src, readErr := bytes(ctx, m.Manifest, source)
 	if readErr != nil {
 		return readErr
 	}
-	file, openErr := scopedDest.Create(filePath)
-	if openErr != nil {
-		return openErr
-	}
-	file.Write(src)
-	return file.Close()
+	return writeFile(scopedDest, filePath, src)
 }
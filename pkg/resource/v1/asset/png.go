@@ -12,6 +12,7 @@ const KGVPng = "asset/png/v1"
 type Png struct {
 	*manifest.Manifest
 	Spec *imageSpec
+	placeholder
 }
 
 func NewPng(m *manifest.Manifest) (*Png, error) {
@@ -31,25 +32,34 @@ func (img *Png) Render(ctx context.Context, source billy.Filesystem, dest billy.
 		return scopeErr
 	}
 	if img.Spec.current(scopedDest) {
-		return nil
+		return img.extractDominantColor(img.Spec, scopedDest, strconv.Itoa(img.Spec.Widths[0]))
 	}
-	data, readErr := bytes(img.Manifest, source)
+	data, readErr := bytes(ctx, img.Manifest, source)
 	if readErr != nil {
 		return readErr
 	}
-	return img.Spec.render(ctx, func(width int) error {
-		return img.write(data, scopedDest, width)
-	})
-}
-
-func (img *Png) write(src []byte, fs billy.Filesystem, width int) error {
-	filePath := strconv.Itoa(width)
-	file, openErr := fs.Create(filePath)
-	if openErr != nil {
-		return openErr
+	decoded, decodeErr := decode(ctx, img.Manifest, source, img.Spec)
+	if decodeErr != nil {
+		return decodeErr
 	}
-	if _, err := file.Write(src); err != nil {
+	if err := img.Spec.render(ctx, func(width int) error {
+		if err := img.write(data, scopedDest, width); err != nil {
+			return err
+		}
+		if decoded == nil {
+			return nil
+		}
+		return img.Spec.writeAlternates(ctx, resize(decoded, width), scopedDest, width)
+	}); err != nil {
 		return err
 	}
-	return file.Close()
+	return img.extractDominantColor(img.Spec, scopedDest, strconv.Itoa(img.Spec.Widths[0]))
 }
+
+func (img *Png) write(src []byte, fs billy.Filesystem, width int) error {
+	return writeFile(fs, strconv.Itoa(width), src)
+}
+
+// AvifHref is the path of a width of the image when avif is one of the
+// requested output formats.
+func (img *Png) AvifHref(width int) string { return avifHref(img.Manifest, width) }
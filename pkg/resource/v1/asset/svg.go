@@ -0,0 +1,130 @@
+package asset
+
+import (
+	gobytes "bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	json "github.com/json-iterator/go"
+	"github.com/tkellen/aevitas/pkg/manifest"
+	"io"
+	"path/filepath"
+	"regexp"
+)
+
+const KGVSvg = "image/svg/v1"
+
+// SvgSpec controls how svg files are written.
+type SvgSpec struct {
+	// Clean removes the elements and attributes Inkscape and Sodipodi add to
+	// record the state of the editor.
+	Clean bool
+}
+
+// editorPrefixes are the namespace prefixes of editor state removed by Clean.
+var editorPrefixes = map[string]bool{"inkscape": true, "sodipodi": true}
+
+// editorAttr matches an attribute in, or declaring, an editor namespace.
+var editorAttr = regexp.MustCompile(`\s+(?:xmlns:(?:inkscape|sodipodi)|(?:inkscape|sodipodi):[\w.-]+)\s*=\s*(?:"[^"]*"|'[^']*')`)
+
+type Svg struct {
+	*manifest.Manifest
+	Spec *SvgSpec
+}
+
+func NewSvg(m *manifest.Manifest) (*Svg, error) {
+	var spec SvgSpec
+	if len(m.Spec) > 0 {
+		if err := json.Unmarshal(m.Spec, &spec); err != nil {
+			return nil, err
+		}
+	}
+	return &Svg{
+		Manifest: m,
+		Spec:     &spec,
+	}, nil
+}
+
+func (img *Svg) Render(ctx context.Context, source billy.Filesystem, dest billy.Filesystem) error {
+	scopedDest, scopeErr := dest.Chroot(img.Meta.HrefPrefix)
+	if scopeErr != nil {
+		return scopeErr
+	}
+	filePath := img.Selector.Name
+	if exists(scopedDest, filePath) {
+		return nil
+	}
+	src, readErr := bytes(ctx, img.Manifest, source)
+	if readErr != nil {
+		return readErr
+	}
+	output, cleanErr := cleanSvg(src, img.Spec.Clean)
+	if cleanErr != nil {
+		return fmt.Errorf("%s: %w", img.Selector.ID(), cleanErr)
+	}
+	if err := scopedDest.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	return writeFile(scopedDest, filePath, output)
+}
+
+// cleanSvg ensures src is well-formed xml, removing editor state from it when
+// requested. Everything else is copied byte for byte.
+func cleanSvg(src []byte, clean bool) ([]byte, error) {
+	decoder := xml.NewDecoder(gobytes.NewReader(src))
+	var output gobytes.Buffer
+	var root string
+	// skip counts how deeply nested the decoder is within an editor element.
+	skip := 0
+	for {
+		start := decoder.InputOffset()
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		raw := src[start:decoder.InputOffset()]
+		switch t := token.(type) {
+		case xml.StartElement:
+			if root == "" {
+				root = t.Name.Local
+			}
+			if clean && (skip > 0 || editorPrefixes[prefixOf(raw)]) {
+				skip++
+				continue
+			}
+			if clean {
+				raw = editorAttr.ReplaceAll(raw, nil)
+			}
+		case xml.EndElement:
+			if skip > 0 {
+				skip--
+				continue
+			}
+		default:
+			if skip > 0 {
+				continue
+			}
+		}
+		output.Write(raw)
+	}
+	if root != "svg" {
+		return nil, fmt.Errorf("expected an svg element, found %q", root)
+	}
+	return output.Bytes(), nil
+}
+
+// prefixOf finds the namespace prefix of the raw start tag of an element.
+func prefixOf(tag []byte) string {
+	name := tag[1:]
+	if end := gobytes.IndexAny(name, " \t\r\n/>"); end != -1 {
+		name = name[:end]
+	}
+	if colon := gobytes.IndexByte(name, ':'); colon != -1 {
+		return string(name[:colon])
+	}
+	return ""
+}
@@ -0,0 +1,5 @@
+// greet builds a friendly message.
+function greet(name) {
+  var message = "Hello, " + name;
+  return message;
+}
@@ -0,0 +1,3 @@
+/* Entry point. */
+var output = greet("World");
+console.log(output);